@@ -6,6 +6,23 @@ mongosh <<EOF
 
 db = db.getSiblingDB('flatlist')
 db.createCollection('users')
-db.users.createIndex({ email: 1}, { unique: true})
+db.users.createIndex({ email: 1}, { name: 'email_case_insensitive', unique: true, collation: { locale: 'en', strength: 2}})
+db.users.createIndex({ 'identities.provider': 1, 'identities.subject': 1}, { unique: true, sparse: true})
+db.createCollection('oidcLogins')
+db.createCollection('dataExports')
+db.createCollection('loginAttempts')
+db.users.createIndex({ roles: 1}, { sparse: true})
+db.createCollection('auditLog')
+db.auditLog.createIndex({ targetId: 1, createdAt: -1})
+db.auditLog.createIndex({ actorId: 1, createdAt: -1})
+db.createCollection('oauthClients')
+db.oauthClients.createIndex({ ownerId: 1, createdAt: -1})
+db.createCollection('oauthConsents')
+db.oauthConsents.createIndex({ userId: 1, clientId: 1}, { unique: true})
+db.oauthConsents.createIndex({ clientId: 1})
+db.createCollection('oauthCodes')
+db.createCollection('oauthTokens')
+db.oauthTokens.createIndex({ userId: 1, clientId: 1})
+db.oauthTokens.createIndex({ clientId: 1})
 
 EOF
\ No newline at end of file
@@ -0,0 +1,117 @@
+package mailer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// apiMailerTimeout limits how long the email api has to accept an email
+const apiMailerTimeout = 30 * time.Second
+
+// APIError is returned when the email api responds to an email with an error status
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("email api responded with %d: %s", e.StatusCode, e.Message)
+}
+
+// temporary checks if the email api could accept the email if it was sent again later
+func (e *APIError) temporary() bool {
+	return e.StatusCode == http.StatusRequestTimeout ||
+		e.StatusCode == http.StatusTooManyRequests ||
+		e.StatusCode >= 500
+}
+
+// apiMailer sends emails with an email provider's http api (like SES or SendGrid), which is
+// often faster and more reliable than smtp. Emails are posted as json (see apiEmail),
+// with the api key as a bearer token, and any 2xx response means that the email was accepted.
+type apiMailer struct {
+	endpoint string
+	apiKey   string
+	client   *http.Client
+}
+
+// apiEmail is the body of the request that sends an email
+type apiEmail struct {
+	From        string          `json:"from"`
+	To          []string        `json:"to"`
+	Subject     string          `json:"subject"`
+	HTML        string          `json:"html"`
+	Text        string          `json:"text"`
+	Attachments []apiAttachment `json:"attachments,omitempty"`
+}
+
+type apiAttachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"contentType"`
+	Content     []byte `json:"content"` // base64 in json
+	// Disposition is inline for images that the html shows by their content id, or attachment
+	Disposition string `json:"disposition"`
+	ContentID   string `json:"contentId,omitempty"`
+}
+
+// NewAPIMailer creates a Mailer that sends emails to the endpoint of an email api
+func NewAPIMailer(endpoint, apiKey string) Mailer {
+	return &apiMailer{
+		endpoint: endpoint,
+		apiKey:   apiKey,
+		client:   &http.Client{Timeout: apiMailerTimeout},
+	}
+}
+
+func (m *apiMailer) send(msg *message) error {
+	email := apiEmail{
+		From:    msg.From,
+		To:      []string{msg.To},
+		Subject: msg.Subject,
+		HTML:    msg.HTML,
+		Text:    msg.Text,
+	}
+	for _, a := range msg.Attachments {
+		attachment := apiAttachment{
+			Filename:    a.Filename,
+			ContentType: attachmentContentType(a),
+			Content:     a.Content,
+			Disposition: "attachment",
+		}
+		if a.Inline {
+			attachment.Disposition = "inline"
+			attachment.ContentID = a.Filename
+		}
+		email.Attachments = append(email.Attachments, attachment)
+	}
+
+	body, err := json.Marshal(email)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, m.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+m.apiKey)
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		// only the start of the response is kept, since it is only used in logs and job errors
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(message))}
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package mailer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+)
+
+// messagesDir is the directory in the templates directory that holds a message catalog for each locale
+const messagesDir = "messages"
+
+// defaultLocale is the language of the default version of every email. Its catalog
+// should have every message, since it is used for messages that other catalogs are missing.
+const defaultLocale = "en"
+
+// catalogs holds the messages of each locale, which are read from messages/<locale>.json.
+// A catalog is a json object of message ids and messages (eg. {"signoff": "Thanks!"}), and
+// messages are text templates that are filled in with the data that t is given (see funcs).
+type catalogs map[string]map[string]*texttemplate.Template
+
+// loadCatalogs reads every message catalog in the templates directory, which doesn't need to have any
+func loadCatalogs(dir string) (catalogs, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, messagesDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	c := make(catalogs)
+	for _, path := range paths {
+		locale := strings.TrimSuffix(filepath.Base(path), ".json")
+		if !localePattern.MatchString(locale) {
+			return nil, fmt.Errorf("message catalog %s is not named after a locale (eg. fr.json or fr-CA.json)", path)
+		}
+
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		var messages map[string]string
+		if err := json.Unmarshal(b, &messages); err != nil {
+			return nil, fmt.Errorf("invalid message catalog %s: %w", path, err)
+		}
+
+		c[locale] = make(map[string]*texttemplate.Template, len(messages))
+		for id, msg := range messages {
+			tmpl, err := texttemplate.New(id).Option("missingkey=zero").Parse(msg)
+			if err != nil {
+				return nil, fmt.Errorf("invalid message %q in %s: %w", id, path, err)
+			}
+			c[locale][id] = tmpl
+		}
+	}
+
+	if len(c) > 0 && c[defaultLocale] == nil {
+		return nil, fmt.Errorf("there is no message catalog for the default locale (%s.json) in %s", defaultLocale, filepath.Join(dir, messagesDir))
+	}
+
+	return c, nil
+}
+
+// locales returns the locales that have a catalog, other than the default locale
+func (c catalogs) locales() []string {
+	locales := make([]string, 0, len(c))
+	for locale := range c {
+		if locale != defaultLocale {
+			locales = append(locales, locale)
+		}
+	}
+
+	return locales
+}
+
+// funcs returns the template functions for emails in a locale. t returns a message from
+// the first catalog in the locale's chain that has it (see localeChain), filled in with
+// data if it is given (eg. {{t "greeting" .}}), and fails if no catalog has it.
+func (c catalogs) funcs(locale string) map[string]any {
+	chain := localeChain(locale)
+
+	return map[string]any{
+		"t": func(id string, data ...any) (string, error) {
+			for _, l := range chain {
+				msg, ok := c[l][id]
+				if !ok {
+					continue
+				}
+
+				var d any
+				if len(data) > 0 {
+					d = data[0]
+				}
+
+				var b strings.Builder
+				if err := msg.Execute(&b, d); err != nil {
+					return "", err
+				}
+				return b.String(), nil
+			}
+
+			return "", fmt.Errorf("there is no message %q for locale %q", id, locale)
+		},
+	}
+}
+
+// localeChain returns the locales whose messages are used for a locale, in order:
+// the locale (eg. fr-CA), its language (eg. fr), and then the default locale
+func localeChain(locale string) []string {
+	var chain []string
+	if locale != "" && locale != defaultLocale {
+		chain = append(chain, locale)
+		if language, _, found := strings.Cut(locale, "-"); found && language != defaultLocale {
+			chain = append(chain, language)
+		}
+	}
+
+	return append(chain, defaultLocale)
+}
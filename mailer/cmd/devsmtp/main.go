@@ -0,0 +1,39 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/ricxi/flat-list/mailer/devsmtp"
+)
+
+// devsmtp captures the emails that the mailer sends while developing, instead of needing
+// a real smtp server. Run the mailer with HOST=127.0.0.1 and PORT=1025 (the username and
+// password can be anything), and read the emails at http://127.0.0.1:8025.
+func main() {
+	smtpAddr := getEnv("DEVSMTP_SMTP_ADDR", "127.0.0.1:1025")
+	httpAddr := getEnv("DEVSMTP_HTTP_ADDR", "127.0.0.1:8025")
+
+	s := devsmtp.NewServer()
+
+	go func() {
+		log.Println("starting devsmtp smtp server on", smtpAddr)
+		if err := s.ListenAndServe(smtpAddr); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
+	log.Println("starting devsmtp http server on", httpAddr)
+	if err := http.ListenAndServe(httpAddr, s.Handler()); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+
+	return fallback
+}
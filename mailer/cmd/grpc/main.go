@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net"
-	"strconv"
+	"os"
+	"time"
 
+	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/ricxi/flat-list/mailer"
 	"github.com/ricxi/flat-list/mailer/pb"
 	"github.com/ricxi/flat-list/shared/config"
@@ -12,22 +15,57 @@ import (
 )
 
 func main() {
-	envs, err := config.LoadEnvs("HOST", "PORT", "USERNAME", "PASSWORD", "EMAIL_TEMPLATES", "GRPC_PORT")
+	envs, err := config.LoadEnvs("EMAIL_TEMPLATES", "GRPC_PORT")
 	if err != nil {
 		log.Fatal(err)
 	}
-	smtpPort, err := strconv.Atoi(envs["PORT"])
+
+	lis, err := net.Listen("tcp", ":"+envs["GRPC_PORT"])
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	lis, err := net.Listen("tcp", ":"+envs["GRPC_PORT"])
+	// emails are sent with smtp unless MAIL_TRANSPORT chooses another transport
+	m, err := mailer.NewTransport(os.Getenv("MAIL_TRANSPORT"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	templates, err := mailer.LoadTemplates(envs["EMAIL_TEMPLATES"])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if os.Getenv("WATCH_TEMPLATES") == "true" {
+		go templates.Watch(context.Background(), 2*time.Second)
+	}
+
+	// emails are queued in postgres and sent in the background if there is a database,
+	// and otherwise sent straight away. The suppression list is only kept in memory without one.
+	var opts []mailer.ServiceOption
+	suppressions := mailer.NewMemorySuppressionList()
+	if dsn := os.Getenv("DATABASE_URL"); dsn != "" {
+		db, err := mailer.Connect(dsn)
+		if err != nil {
+			log.Fatalln("problem connecting to postgres: ", err)
+		}
+		defer db.Close()
+
+		suppressions = mailer.NewPostgresSuppressionList(db)
+
+		// queued emails are checked again when they are sent, in case their address was suppressed since
+		queue := mailer.NewQueue(mailer.NewPostgresJobStore(db), mailer.NewSuppressingMailer(m, suppressions))
+		go queue.Run(context.Background())
+		opts = append(opts, mailer.WithQueue(queue))
+	}
+	opts = append(opts, mailer.WithSuppressionList(suppressions))
+
+	// templates can have stricter limits in their schemas than these
+	limits, err := mailer.RateLimitsFromEnv()
 	if err != nil {
 		log.Fatal(err)
 	}
+	opts = append(opts, mailer.WithRateLimits(limits))
 
-	m := mailer.NewMailer(envs["USERNAME"], envs["PASSWORD"], envs["HOST"], smtpPort)
-	s := mailer.NewService(m, envs["EMAIL_TEMPLATES"])
+	s := mailer.NewService(m, templates, opts...)
 	srv := mailer.NewGrpcServer(s)
 
 	grpcServer := grpc.NewServer()
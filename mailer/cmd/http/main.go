@@ -1,36 +1,100 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
-	"strconv"
+	"os"
+	"time"
 
+	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/ricxi/flat-list/mailer"
 	"github.com/ricxi/flat-list/shared/config"
+	res "github.com/ricxi/flat-list/shared/response"
 )
 
 func main() {
-	envs, err := config.LoadEnvs("HOST", "PORT", "USERNAME", "PASSWORD", "EMAIL_TEMPLATES", "HTTP_PORT")
+	envs, err := config.LoadEnvs("EMAIL_TEMPLATES", "HTTP_PORT")
 	if err != nil {
 		log.Fatal(err)
 	}
-	smtpPort, err := strconv.Atoi(envs["PORT"])
+
+	// emails are sent with smtp unless MAIL_TRANSPORT chooses another transport
+	m, err := mailer.NewTransport(os.Getenv("MAIL_TRANSPORT"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	templates, err := mailer.LoadTemplates(envs["EMAIL_TEMPLATES"])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if os.Getenv("WATCH_TEMPLATES") == "true" {
+		go templates.Watch(context.Background(), 2*time.Second)
+	}
+
+	// emails are queued in postgres and sent in the background if there is a database,
+	// and otherwise sent straight away. The suppression list is only kept in memory without one.
+	var opts []mailer.ServiceOption
+	suppressions := mailer.NewMemorySuppressionList()
+	if dsn := os.Getenv("DATABASE_URL"); dsn != "" {
+		db, err := mailer.Connect(dsn)
+		if err != nil {
+			log.Fatalln("problem connecting to postgres: ", err)
+		}
+		defer db.Close()
+
+		suppressions = mailer.NewPostgresSuppressionList(db)
+
+		// queued emails are checked again when they are sent, in case their address was suppressed since
+		queue := mailer.NewQueue(mailer.NewPostgresJobStore(db), mailer.NewSuppressingMailer(m, suppressions))
+		go queue.Run(context.Background())
+		opts = append(opts, mailer.WithQueue(queue))
+	}
+	opts = append(opts, mailer.WithSuppressionList(suppressions))
+
+	// templates can have stricter limits in their schemas than these
+	limits, err := mailer.RateLimitsFromEnv()
 	if err != nil {
 		log.Fatal(err)
 	}
+	opts = append(opts, mailer.WithRateLimits(limits))
 
-	m := mailer.NewMailer(envs["USERNAME"], envs["PASSWORD"], envs["HOST"], smtpPort)
-	mailerService := mailer.NewService(m, envs["EMAIL_TEMPLATES"])
+	mailerService := mailer.NewService(m, templates, opts...)
 
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/v1/mailer/activate", mailer.HandleSendActivationEmail(mailerService))
+	mux.HandleFunc("/v1/mailer/emailchange", mailer.HandleSendEmailChangeEmail(mailerService))
+	mux.HandleFunc("/v1/mailer/dataexport", mailer.HandleSendDataExportEmail(mailerService))
+	mux.HandleFunc("/v1/mailer/lockout", mailer.HandleSendLockoutEmail(mailerService))
+	mux.HandleFunc("/v1/mailer/send", mailer.HandleSendTemplatedEmail(mailerService))
+	mux.HandleFunc("/v1/mailer/templates", mailer.HandleListTemplates(mailerService))
+	mux.Handle("/v1/mailer/jobs/", http.StripPrefix("/v1/mailer/jobs/", mailer.HandleGetJob(mailerService)))
+	mux.Handle("/v1/mailer/preview/", http.StripPrefix("/v1/mailer/preview/", mailer.HandlePreviewTemplate(mailerService)))
+	// the webhook is only served if there is a secret for email providers to send with their notifications
+	if secret := os.Getenv("MAIL_WEBHOOK_SECRET"); secret != "" {
+		mux.HandleFunc("/v1/mailer/webhooks/bounces", mailer.HandleBounceWebhook(mailerService, secret))
+	}
 
 	srv := &http.Server{
-		Handler: mux,
+		Handler: res.RequestID(mux),
 		Addr:    ":" + envs["HTTP_PORT"],
 	}
 
+	// the admin api is served on its own port, and only if there is a secret for admins to send
+	if adminPort, secret := os.Getenv("ADMIN_PORT"), os.Getenv("ADMIN_SECRET"); adminPort != "" && secret != "" {
+		adminSrv := &http.Server{
+			Handler: mailer.NewAdminHandler(mailerService, secret),
+			Addr:    ":" + adminPort,
+		}
+		go func() {
+			log.Println("starting http mailer admin server on port", adminSrv.Addr)
+			if err := adminSrv.ListenAndServe(); err != nil {
+				log.Println(err)
+			}
+		}()
+	}
+
 	log.Println("starting http mailer server on port", srv.Addr)
 
 	if err := srv.ListenAndServe(); err != nil {
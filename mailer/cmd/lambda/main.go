@@ -3,7 +3,7 @@ package main
 import (
 	"context"
 	"log"
-	"strconv"
+	"os"
 	"time"
 
 	"github.com/aws/aws-lambda-go/lambda"
@@ -15,17 +15,22 @@ import (
 // the mailer into a lambda function
 // ! untested
 func main() {
-	envs, err := config.LoadEnvs("HOST", "PORT", "USERNAME", "PASSWORD", "EMAIL_TEMPLATES", "GRPC_PORT")
+	envs, err := config.LoadEnvs("EMAIL_TEMPLATES", "GRPC_PORT")
 	if err != nil {
 		log.Fatal(err)
 	}
-	smtpPort, err := strconv.Atoi(envs["PORT"])
+
+	// emails are sent with smtp unless MAIL_TRANSPORT chooses another transport
+	m, err := mailer.NewTransport(os.Getenv("MAIL_TRANSPORT"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	templates, err := mailer.LoadTemplates(envs["EMAIL_TEMPLATES"])
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	m := mailer.NewMailer(envs["USERNAME"], envs["PASSWORD"], envs["HOST"], smtpPort)
-	mailerService := mailer.NewService(m, envs["EMAIL_TEMPLATES"])
+	mailerService := mailer.NewService(m, templates)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
@@ -12,9 +12,12 @@ type EmailActivationData struct {
 }
 
 type ActivationEmailData struct {
-	From           string `json:"from"`
-	To             string `json:"to"`
-	Subject        string `json:"subject"`
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Subject replaces the subject of the email's template if it is not empty
+	Subject string `json:"subject"`
+	// Locale is a language tag, such as en or fr-CA, that the email is translated to if it can be
+	Locale         string `json:"locale"`
 	ActivationData `json:"activationData"`
 }
 
@@ -22,3 +25,31 @@ type ActivationData struct {
 	Name      string `json:"name"`
 	Hyperlink string `json:"HyperLink"`
 }
+
+// EmailChangeEmailData stores information needed to ask a user
+// to confirm the new address they want to change their email to
+type EmailChangeEmailData struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Locale is a language tag, such as en or fr-CA, that the email is translated to if it can be
+	Locale          string `json:"locale"`
+	EmailChangeData `json:"emailChangeData"`
+}
+
+type EmailChangeData struct {
+	Name string `json:"name"`
+	// Hyperlink is the link that confirms the new address
+	Hyperlink string `json:"hyperlink"`
+}
+
+// TemplatedEmailData stores information needed to send
+// any template that has a schema (see SendTemplatedEmail)
+type TemplatedEmailData struct {
+	From     string         `json:"from"`
+	To       string         `json:"to"`
+	Template string         `json:"template"`
+	Locale   string         `json:"locale"`
+	Data     map[string]any `json:"data"`
+	// Attachments are sent with the email, and inline ones can be shown in it (see Attachment)
+	Attachments []Attachment `json:"attachments"`
+}
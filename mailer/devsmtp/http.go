@@ -0,0 +1,128 @@
+package devsmtp
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+// Handler serves the captured emails:
+//
+//	GET    /                        a web page that lists them
+//	GET    /api/messages            every email, newest first (?to= only returns emails sent to an address)
+//	DELETE /api/messages            deletes every email
+//	GET    /api/messages/{id}       an email, with its text and html
+//	GET    /api/messages/{id}/html  the html of an email, to view it
+//	GET    /api/messages/{id}/raw   an email as it was received
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleIndex)
+	mux.HandleFunc("/api/messages", s.handleMessages)
+	mux.HandleFunc("/api/messages/", s.handleMessage)
+
+	return mux
+}
+
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	indexTemplate.Execute(w, s.Messages())
+}
+
+func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		messages := s.Messages()
+		if to := r.URL.Query().Get("to"); to != "" {
+			sentTo := SentTo(to)
+			filtered := make([]*Message, 0, len(messages))
+			for _, msg := range messages {
+				if sentTo(msg) {
+					filtered = append(filtered, msg)
+				}
+			}
+			messages = filtered
+		}
+		sendJSON(w, map[string]any{"messages": messages}, http.StatusOK)
+
+	case http.MethodDelete:
+		s.Clear()
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		sendJSON(w, map[string]any{"error": "method not allowed"}, http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleMessage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendJSON(w, map[string]any{"error": "method not allowed"}, http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, view, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/messages/"), "/")
+	msg, ok := s.Message(id)
+	if !ok {
+		sendJSON(w, map[string]any{"error": "message not found"}, http.StatusNotFound)
+		return
+	}
+
+	switch view {
+	case "":
+		sendJSON(w, map[string]any{"message": msg}, http.StatusOK)
+	case "html":
+		// the email is shown in a sandbox, since it could have come from anywhere
+		w.Header().Set("Content-Security-Policy", "sandbox")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(msg.HTML))
+	case "raw":
+		w.Header().Set("Content-Type", "message/rfc822")
+		w.Write([]byte(msg.Raw))
+	default:
+		sendJSON(w, map[string]any{"error": "message not found"}, http.StatusNotFound)
+	}
+}
+
+func sendJSON(w http.ResponseWriter, v any, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(v)
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!doctype html>
+<html>
+<head>
+    <meta charset="utf-8" />
+    <title>devsmtp</title>
+    <style>
+        body { font-family: sans-serif; margin: 2rem; }
+        table { border-collapse: collapse; width: 100%; }
+        th, td { border-bottom: 1px solid #ddd; padding: 0.5rem; text-align: left; }
+    </style>
+</head>
+<body>
+    <h1>Captured emails</h1>
+    {{if .}}
+    <table>
+        <tr><th>Received</th><th>From</th><th>To</th><th>Subject</th><th></th></tr>
+        {{range .}}
+        <tr>
+            <td>{{.ReceivedAt.Format "15:04:05"}}</td>
+            <td>{{.From}}</td>
+            <td>{{range $i, $to := .To}}{{if $i}}, {{end}}{{$to}}{{end}}</td>
+            <td><a href="/api/messages/{{.ID}}/html">{{.Subject}}</a></td>
+            <td><a href="/api/messages/{{.ID}}">json</a> <a href="/api/messages/{{.ID}}/raw">raw</a></td>
+        </tr>
+        {{end}}
+    </table>
+    {{else}}
+    <p>No emails yet.</p>
+    {{end}}
+</body>
+</html>
+`))
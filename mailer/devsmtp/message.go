@@ -0,0 +1,220 @@
+package devsmtp
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// Message is a captured email
+type Message struct {
+	ID          string       `json:"id"`
+	From        string       `json:"from"`
+	To          []string     `json:"to"`
+	Subject     string       `json:"subject"`
+	ReceivedAt  time.Time    `json:"receivedAt"`
+	Text        string       `json:"text"`
+	HTML        string       `json:"html"`
+	Attachments []Attachment `json:"attachments"`
+	// Raw is the whole email, as it was received
+	Raw string `json:"-"`
+}
+
+// Attachment describes a file that was attached to a captured email
+type Attachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"contentType"`
+	ContentID   string `json:"contentId,omitempty"`
+	Size        int    `json:"size"`
+}
+
+// capture parses and keeps an email. Emails that can't be parsed are still kept, without their parts.
+func (s *Server) capture(sess session, raw []byte) *Message {
+	msg := &Message{
+		From:        sess.from,
+		To:          sess.to,
+		ReceivedAt:  time.Now().UTC(),
+		Attachments: []Attachment{},
+		Raw:         string(raw),
+	}
+
+	if parsed, err := mail.ReadMessage(bytes.NewReader(raw)); err == nil {
+		decoder := new(mime.WordDecoder)
+		if subject, err := decoder.DecodeHeader(parsed.Header.Get("Subject")); err == nil {
+			msg.Subject = subject
+		}
+		readPart(msg, parsed.Header, parsed.Body)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg.ID = s.nextMessageID()
+	s.messages = append(s.messages, msg)
+	close(s.changed)
+	s.changed = make(chan struct{})
+
+	return msg
+}
+
+// header is the part of a mime header that readPart needs, which both mail.Header and textproto.MIMEHeader have
+type header interface {
+	Get(key string) string
+}
+
+// readPart finds the text and html bodies of an email, and its attachments, in a part of it
+func readPart(msg *Message, h header, body io.Reader) {
+	mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextRawPart()
+			if err != nil {
+				return
+			}
+			readPart(msg, part.Header, part)
+		}
+	}
+
+	content, err := io.ReadAll(decodeTransfer(h.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return
+	}
+
+	disposition, dispositionParams, _ := mime.ParseMediaType(h.Get("Content-Disposition"))
+	contentID := strings.Trim(h.Get("Content-ID"), "<>")
+	if disposition == "attachment" || disposition == "inline" || contentID != "" {
+		filename := dispositionParams["filename"]
+		if filename == "" {
+			filename = params["name"]
+		}
+		msg.Attachments = append(msg.Attachments, Attachment{
+			Filename:    filename,
+			ContentType: mediaType,
+			ContentID:   contentID,
+			Size:        len(content),
+		})
+		return
+	}
+
+	switch mediaType {
+	case "text/plain":
+		if msg.Text == "" {
+			msg.Text = string(content)
+		}
+	case "text/html":
+		if msg.HTML == "" {
+			msg.HTML = string(content)
+		}
+	}
+}
+
+func decodeTransfer(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(encoding) {
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, &newlineSkipper{r: body})
+	}
+
+	return body
+}
+
+// newlineSkipper removes the line breaks from base64, which the decoder doesn't expect
+type newlineSkipper struct {
+	r io.Reader
+}
+
+func (n *newlineSkipper) Read(p []byte) (int, error) {
+	count, err := n.r.Read(p)
+	kept := 0
+	for _, b := range p[:count] {
+		if b != '\r' && b != '\n' {
+			p[kept] = b
+			kept++
+		}
+	}
+
+	return kept, err
+}
+
+// Messages returns every captured email, newest first
+func (s *Server) Messages() []*Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	messages := make([]*Message, 0, len(s.messages))
+	for i := len(s.messages) - 1; i >= 0; i-- {
+		messages = append(messages, s.messages[i])
+	}
+
+	return messages
+}
+
+// Message returns a captured email by its id
+func (s *Server) Message(id string) (*Message, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, msg := range s.messages {
+		if msg.ID == id {
+			return msg, true
+		}
+	}
+
+	return nil, false
+}
+
+// Clear deletes every captured email
+func (s *Server) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messages = nil
+}
+
+// WaitFor waits until an email that matches is captured (or was already captured),
+// and returns the newest one. It returns the context's error if none is captured in time.
+func (s *Server) WaitFor(ctx context.Context, match func(*Message) bool) (*Message, error) {
+	for {
+		s.mu.Lock()
+		changed := s.changed
+		for i := len(s.messages) - 1; i >= 0; i-- {
+			if match(s.messages[i]) {
+				msg := s.messages[i]
+				s.mu.Unlock()
+				return msg, nil
+			}
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// SentTo matches emails that were sent to an address, for WaitFor
+func SentTo(address string) func(*Message) bool {
+	return func(msg *Message) bool {
+		for _, to := range msg.To {
+			if strings.EqualFold(to, address) {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,189 @@
+// Package devsmtp is an smtp server that captures every email that it receives instead of
+// delivering it, so that the mailer can be developed and tested without a real smtp server.
+// The captured emails can be read with an http api and a small web page (see Handler).
+//
+// It is only meant for development, so it accepts any credentials and never uses tls.
+package devsmtp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxMessageSize is the largest email that the server accepts,
+// which is bigger than the mailer's limit for attachments
+const maxMessageSize = 32 << 20
+
+// Server is an smtp server that keeps the emails that it receives in memory
+type Server struct {
+	hostname string
+
+	mu       sync.Mutex
+	messages []*Message
+	// changed is closed and replaced every time an email is received, for WaitFor
+	changed chan struct{}
+	nextID  int
+}
+
+func NewServer() *Server {
+	return &Server{
+		hostname: "devsmtp.localhost",
+		changed:  make(chan struct{}),
+	}
+}
+
+// ListenAndServe listens on the tcp address (eg. 127.0.0.1:1025) and then calls Serve
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(l)
+}
+
+// Serve accepts connections on the listener until it is closed, and handles each one in its own goroutine
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		go s.handleConn(conn)
+	}
+}
+
+// session is the state of the email that a client is sending
+type session struct {
+	from string
+	to   []string
+}
+
+func (s *Server) handleConn(conn net.Conn) {
+	defer conn.Close()
+
+	tc := textproto.NewConn(conn)
+	reply := func(code int, msg string) error {
+		return tc.PrintfLine("%d %s", code, msg)
+	}
+
+	if err := reply(220, s.hostname+" ESMTP devsmtp"); err != nil {
+		return
+	}
+
+	var sess session
+	for {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Minute))
+		line, err := tc.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			// auth and starttls are not advertised, so clients send emails without them
+			err = tc.PrintfLine("250-%s\r\n250-SIZE %d\r\n250-8BITMIME\r\n250 SMTPUTF8", s.hostname, maxMessageSize)
+		case "HELO":
+			err = reply(250, s.hostname)
+		case "AUTH":
+			// any credentials are accepted, for clients that always authenticate
+			err = reply(235, "2.7.0 Authentication successful")
+		case "MAIL":
+			sess = session{from: parsePath(arg, "FROM:")}
+			err = reply(250, "2.1.0 OK")
+		case "RCPT":
+			to := parsePath(arg, "TO:")
+			if to == "" {
+				err = reply(501, "5.1.3 Bad recipient address syntax")
+				break
+			}
+			sess.to = append(sess.to, to)
+			err = reply(250, "2.1.5 OK")
+		case "DATA":
+			if len(sess.to) == 0 {
+				err = reply(503, "5.5.1 Need RCPT command first")
+				break
+			}
+			if err = reply(354, "Start mail input; end with <CRLF>.<CRLF>"); err != nil {
+				return
+			}
+			err = s.receive(tc, sess, reply)
+			sess = session{}
+		case "RSET":
+			sess = session{}
+			err = reply(250, "2.0.0 OK")
+		case "NOOP":
+			err = reply(250, "2.0.0 OK")
+		case "VRFY":
+			err = reply(252, "2.5.0 Cannot VRFY user, but will accept message")
+		case "QUIT":
+			reply(221, "2.0.0 Bye")
+			return
+		default:
+			err = reply(502, "5.5.2 Command not recognized")
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// receive reads the email after a DATA command and captures it
+func (s *Server) receive(tc *textproto.Conn, sess session, reply func(int, string) error) error {
+	dr := tc.DotReader()
+
+	// one byte more than the limit is read, to know if the email is too big
+	raw, err := io.ReadAll(io.LimitReader(dr, maxMessageSize+1))
+	if err != nil {
+		return err
+	}
+	if len(raw) > maxMessageSize {
+		// the rest of the email must still be read before replying
+		if _, err := io.Copy(io.Discard, dr); err != nil {
+			return err
+		}
+		return reply(552, "5.3.4 Message too big")
+	}
+
+	// the dot reader turns line endings into \n, so they are put back as they were sent
+	raw = bytes.ReplaceAll(raw, []byte("\n"), []byte("\r\n"))
+
+	msg := s.capture(sess, raw)
+	log.Printf("captured email %s from %s to %s: %s", msg.ID, msg.From, strings.Join(msg.To, ", "), msg.Subject)
+
+	return reply(250, "2.0.0 OK: queued as "+msg.ID)
+}
+
+// parsePath reads the address from the argument of MAIL or RCPT (eg. FROM:<someone@example.com> SIZE=100)
+func parsePath(arg, prefix string) string {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return ""
+	}
+
+	path := strings.TrimSpace(arg[len(prefix):])
+	if i := strings.IndexByte(path, ' '); i >= 0 {
+		path = path[:i]
+	}
+
+	return strings.TrimSuffix(strings.TrimPrefix(path, "<"), ">")
+}
+
+// nextMessageID must be called with the lock held
+func (s *Server) nextMessageID() string {
+	s.nextID++
+	return fmt.Sprint(s.nextID)
+}
@@ -0,0 +1,166 @@
+package devsmtp
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testEmail = "From: theteam@flatlist.com\r\n" +
+	"To: michaelscott@dundermifflin.com\r\n" +
+	"Subject: =?UTF-8?q?Activate_your_account_=E2=9C=93?=\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=inner\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain; charset=UTF-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"Activate your account at http://localhost:5173/activate?token=3Dabc\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html; charset=UTF-8\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"PHA+QWN0aXZhdGUgeW91ciBhY2NvdW50PC9wPg==\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: application/pdf; name=tasks.pdf\r\n" +
+	"Content-Disposition: attachment; filename=tasks.pdf\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"dGl0bGUK\r\n" +
+	"--outer--\r\n"
+
+func startServer(t *testing.T) (*Server, string) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	s := NewServer()
+	go s.Serve(l)
+
+	return s, l.Addr().String()
+}
+
+func TestServer(t *testing.T) {
+	s, addr := startServer(t)
+
+	err := smtp.SendMail(addr, nil, "theteam@flatlist.com", []string{"michaelscott@dundermifflin.com"}, []byte(testEmail))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	msg, err := s.WaitFor(ctx, SentTo("MichaelScott@dundermifflin.com"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "1", msg.ID)
+	assert.Equal(t, "theteam@flatlist.com", msg.From)
+	assert.Equal(t, []string{"michaelscott@dundermifflin.com"}, msg.To)
+	assert.Equal(t, "Activate your account ✓", msg.Subject)
+	assert.Equal(t, "Activate your account at http://localhost:5173/activate?token=abc", msg.Text)
+	assert.Equal(t, "<p>Activate your account</p>", msg.HTML)
+	assert.Equal(t, []Attachment{{Filename: "tasks.pdf", ContentType: "application/pdf", Size: 6}}, msg.Attachments)
+	assert.Equal(t, testEmail, msg.Raw)
+
+	t.Run("ErrorNoMatch", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		_, err := s.WaitFor(ctx, SentTo("dwightschrute@dundermifflin.com"))
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("ErrorNoRecipient", func(t *testing.T) {
+		c, err := smtp.Dial(addr)
+		require.NoError(t, err)
+		defer c.Close()
+
+		require.NoError(t, c.Mail("theteam@flatlist.com"))
+		_, err = c.Data()
+		assert.ErrorContains(t, err, "Need RCPT command first")
+	})
+}
+
+func TestHandler(t *testing.T) {
+	s, addr := startServer(t)
+
+	for _, to := range []string{"michaelscott@dundermifflin.com", "dwightschrute@dundermifflin.com"} {
+		err := smtp.SendMail(addr, nil, "theteam@flatlist.com", []string{to}, []byte(testEmail))
+		require.NoError(t, err)
+	}
+
+	h := s.Handler()
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(method, target, nil))
+		return rr
+	}
+
+	t.Run("ListMessages", func(t *testing.T) {
+		rr := serve(http.MethodGet, "/api/messages?to=dwightschrute@dundermifflin.com")
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var body struct {
+			Messages []Message `json:"messages"`
+		}
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&body))
+		require.Len(t, body.Messages, 1)
+		assert.Equal(t, "2", body.Messages[0].ID)
+	})
+
+	t.Run("GetMessage", func(t *testing.T) {
+		rr := serve(http.MethodGet, "/api/messages/1")
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var body struct {
+			Message Message `json:"message"`
+		}
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&body))
+		assert.Equal(t, "Activate your account ✓", body.Message.Subject)
+	})
+
+	t.Run("GetHTML", func(t *testing.T) {
+		rr := serve(http.MethodGet, "/api/messages/1/html")
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "sandbox", rr.Header().Get("Content-Security-Policy"))
+		assert.Equal(t, "<p>Activate your account</p>", rr.Body.String())
+	})
+
+	t.Run("GetRaw", func(t *testing.T) {
+		rr := serve(http.MethodGet, "/api/messages/1/raw")
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, testEmail, rr.Body.String())
+	})
+
+	t.Run("Index", func(t *testing.T) {
+		rr := serve(http.MethodGet, "/")
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, 2, strings.Count(rr.Body.String(), "Activate your account ✓"))
+	})
+
+	t.Run("ErrorMessageNotFound", func(t *testing.T) {
+		rr := serve(http.MethodGet, "/api/messages/42")
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	t.Run("ClearMessages", func(t *testing.T) {
+		rr := serve(http.MethodDelete, "/api/messages")
+		require.Equal(t, http.StatusNoContent, rr.Code)
+		assert.Empty(t, s.Messages())
+	})
+}
@@ -2,10 +2,16 @@ package mailer
 
 import (
 	"context"
+	"errors"
+
+	"github.com/ricxi/flat-list/shared/validation"
 
 	"github.com/ricxi/flat-list/mailer/pb"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type GrpcServer struct {
@@ -23,19 +29,177 @@ func NewGrpcServer(mailerService *Service) GrpcServer {
 // services to send an activation email to a user.
 func (gs GrpcServer) SendActivationEmail(ctx context.Context, r *pb.EmailRequest) (*pb.Response, error) {
 	data := ActivationEmailData{
-		From:    r.From,
-		To:      r.To,
-		Subject: activationEmailSubject,
+		From:   r.From,
+		To:     r.To,
+		Locale: r.GetLocale(),
 		ActivationData: ActivationData{
 			Name:      r.ActivationData.Name,
 			Hyperlink: r.ActivationData.Hyperlink,
 		},
 	}
-	if err := gs.mailerService.sendActivationEmail(data); err != nil {
-		return nil, status.Error(codes.InvalidArgument, err.Error())
+	jobID, err := gs.mailerService.sendActivationEmail(data)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return &pb.Response{
+		Status: "success",
+		JobId:  jobID,
+	}, nil
+}
+
+// SendEmailChangeEmail is a grpc implementation that can be called by other
+// services to ask a user to confirm a new email address.
+func (gs GrpcServer) SendEmailChangeEmail(ctx context.Context, r *pb.EmailChangeRequest) (*pb.Response, error) {
+	data := EmailChangeEmailData{
+		From:   r.GetFrom(),
+		To:     r.GetTo(),
+		Locale: r.GetLocale(),
+		EmailChangeData: EmailChangeData{
+			Name:      r.GetEmailChangeData().GetName(),
+			Hyperlink: r.GetEmailChangeData().GetHyperlink(),
+		},
+	}
+	jobID, err := gs.mailerService.sendEmailChangeEmail(data)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return &pb.Response{
+		Status: "success",
+		JobId:  jobID,
+	}, nil
+}
+
+// SendDataExportEmail is a grpc implementation that can be called by other
+// services to send a user a link to download their exported data.
+func (gs GrpcServer) SendDataExportEmail(ctx context.Context, r *pb.EmailRequest) (*pb.Response, error) {
+	data := ActivationEmailData{
+		From:   r.From,
+		To:     r.To,
+		Locale: r.GetLocale(),
+		ActivationData: ActivationData{
+			Name:      r.ActivationData.GetName(),
+			Hyperlink: r.ActivationData.GetHyperlink(),
+		},
+	}
+	jobID, err := gs.mailerService.sendDataExportEmail(data)
+	if err != nil {
+		return nil, grpcError(err)
 	}
 
 	return &pb.Response{
 		Status: "success",
+		JobId:  jobID,
 	}, nil
 }
+
+// SendLockoutEmail is a grpc implementation that can be called by other services
+// to let a user know that their account was locked after too many failed logins.
+func (gs GrpcServer) SendLockoutEmail(ctx context.Context, r *pb.EmailRequest) (*pb.Response, error) {
+	data := ActivationEmailData{
+		From:   r.From,
+		To:     r.To,
+		Locale: r.GetLocale(),
+		ActivationData: ActivationData{
+			Name:      r.ActivationData.GetName(),
+			Hyperlink: r.ActivationData.GetHyperlink(),
+		},
+	}
+	jobID, err := gs.mailerService.sendLockoutEmail(data)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return &pb.Response{
+		Status: "success",
+		JobId:  jobID,
+	}, nil
+}
+
+// SendTemplatedEmail is a grpc implementation that can be called by other services
+// to send any template that has a schema, with the data that the template needs.
+func (gs GrpcServer) SendTemplatedEmail(ctx context.Context, r *pb.TemplatedEmailRequest) (*pb.Response, error) {
+	data := TemplatedEmailData{
+		From:     r.GetFrom(),
+		To:       r.GetTo(),
+		Template: r.GetTemplate(),
+		Locale:   r.GetLocale(),
+		Data:     r.GetData().AsMap(),
+	}
+	for _, a := range r.GetAttachments() {
+		data.Attachments = append(data.Attachments, Attachment{
+			Filename:    a.GetFilename(),
+			ContentType: a.GetContentType(),
+			Content:     a.GetContent(),
+			Inline:      a.GetInline(),
+		})
+	}
+	jobID, err := gs.mailerService.sendTemplatedEmail(data)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return &pb.Response{
+		Status: "success",
+		JobId:  jobID,
+	}, nil
+}
+
+// GetJob is a grpc implementation that can be called by other services
+// to check on an email that was queued, with the job id that it was sent with.
+func (gs GrpcServer) GetJob(ctx context.Context, r *pb.JobRequest) (*pb.Job, error) {
+	job, err := gs.mailerService.getJob(r.GetId())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return &pb.Job{
+		Id:        job.ID,
+		Status:    job.Status,
+		Attempts:  int32(job.Attempts),
+		LastError: job.LastError,
+		RunAt:     timestamppb.New(job.RunAt),
+		CreatedAt: timestamppb.New(job.CreatedAt),
+		UpdatedAt: timestamppb.New(job.UpdatedAt),
+	}, nil
+}
+
+// grpcError converts an error from the service to a grpc status,
+// with the same meaning as the status code that the http handlers send
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, ErrTemplateNotFound), errors.Is(err, ErrJobNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, ErrRecipientSuppressed):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, ErrRateLimited):
+		return rateLimitedError(err)
+	case isUnavailable(err):
+		return status.Error(codes.Unavailable, err.Error())
+	}
+
+	if _, ok := validation.As(err); ok {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return status.Error(codes.Internal, err.Error())
+}
+
+// rateLimitedError converts a rate limit error to a ResourceExhausted status, with a
+// RetryInfo detail that tells the client how long to wait, like the Retry-After header does
+func rateLimitedError(err error) error {
+	st := status.New(codes.ResourceExhausted, err.Error())
+
+	var rlErr *RateLimitError
+	if !errors.As(err, &rlErr) {
+		return st.Err()
+	}
+
+	withRetry, detailErr := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(rlErr.RetryAfter)})
+	if detailErr != nil {
+		return st.Err()
+	}
+
+	return withRetry.Err()
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/ricxi/flat-list/mailer/pb"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/test/bufconn"
@@ -21,7 +22,7 @@ func TestGrpcServer_SendActivationEmail(t *testing.T) {
 				mailer: &mockMailer{
 					err: nil,
 				},
-				emailTemplatesDir: "./templates",
+				templates: mustLoadTemplates(t, "./templates"),
 			},
 		}
 
@@ -48,7 +49,7 @@ func TestGrpcServer_SendActivationEmail(t *testing.T) {
 				mailer: &mockMailer{
 					err: nil,
 				},
-				emailTemplatesDir: "./templates",
+				templates: mustLoadTemplates(t, "./templates"),
 			},
 		}
 
@@ -69,6 +70,26 @@ func TestGrpcServer_SendActivationEmail(t *testing.T) {
 	})
 }
 
+func TestGrpcServer_SendEmailChangeEmail(t *testing.T) {
+	m := &mockMailer{}
+	srv := NewGrpcServer(NewService(m, mustLoadTemplates(t, "./templates")))
+
+	req := pb.EmailChangeRequest{
+		From: "theteam@flatlist.com",
+		To:   "michaelscott@dundermifflin.com",
+		EmailChangeData: &pb.EmailChangeData{
+			Name:      "Michael",
+			Hyperlink: "http://localhost:5173/email/confirm?token=clickme",
+		},
+	}
+
+	res, err := srv.SendEmailChangeEmail(context.Background(), &req)
+	require.NoError(t, err)
+	assert.Equal(t, "success", res.Status)
+	assert.Equal(t, "michaelscott@dundermifflin.com", m.msg.To)
+	assert.Contains(t, m.out, `<a href="http://localhost:5173/email/confirm?token=clickme">Confirm your email address</a>`)
+}
+
 type cleanupFunc func(testing.TB)
 
 // setup creates a grpc server and client
@@ -117,7 +138,7 @@ func TestGrpcServerConn_SendActivationEmail(t *testing.T) {
 			mailer: &mockMailer{
 				err: nil,
 			},
-			emailTemplatesDir: "./templates",
+			templates: mustLoadTemplates(t, "./templates"),
 		}
 		c, cleanup := setup(t, &svc)
 		defer cleanup(t)
@@ -145,7 +166,7 @@ func TestGrpcServerConn_SendActivationEmail(t *testing.T) {
 			mailer: &mockMailer{
 				err: nil,
 			},
-			emailTemplatesDir: "./templates",
+			templates: mustLoadTemplates(t, "./templates"),
 		}
 		c, cleanup := setup(t, &svc)
 		defer cleanup(t)
@@ -1,27 +1,334 @@
 package mailer
 
 import (
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
+	"math"
+	"net"
 	"net/http"
+	"net/textproto"
+	"strconv"
 
 	res "github.com/ricxi/flat-list/shared/response"
 )
 
+// errorClassifier maps the errors that the service returns to status codes;
+// validation errors are already sent as 422 by the classifier
+var errorClassifier = res.NewErrorClassifier().
+	Map(http.StatusNotFound, ErrTemplateNotFound, ErrJobNotFound, ErrSuppressionNotFound).
+	Map(http.StatusConflict, ErrRecipientSuppressed).
+	Map(http.StatusTooManyRequests, ErrRateLimited).
+	MapFunc(http.StatusServiceUnavailable, isUnavailable)
+
+// isUnavailable checks if an email could not be sent because the smtp server (or email api)
+// was unreachable, or it replied with a transient (4xx) error
+func isUnavailable(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.temporary()
+	}
+
+	var smtpErr *textproto.Error
+	return errors.As(err, &smtpErr) && smtpErr.Code >= 400 && smtpErr.Code < 500
+}
+
+// sendSendError sends an error from sending an email, which tells the client when to
+// try again if the email was rate limited
+func sendSendError(w http.ResponseWriter, r *http.Request, err error) {
+	var rlErr *RateLimitError
+	if errors.As(err, &rlErr) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(rlErr.RetryAfter.Seconds()))))
+	}
+
+	errorClassifier.SendError(w, r, err)
+}
+
+// sendAccepted responds to a request to send an email. Queued emails are only accepted,
+// so their job id is sent with 202, for the status of the email to be checked with.
+func sendAccepted(w http.ResponseWriter, jobID string) {
+	if jobID == "" {
+		res.SendJSON(w, map[string]any{"success": true}, http.StatusOK, nil)
+		return
+	}
+
+	res.SendJSON(w, map[string]any{"success": true, "jobId": jobID}, http.StatusAccepted, nil)
+}
+
 func HandleSendActivationEmail(mailerService *Service) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var data ActivationEmailData
 
 		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-			res.SendInternalServerErrorAsJSON(w, err.Error())
+			res.SendError(w, r, "invalid request body", http.StatusBadRequest)
 			return
 		}
 
-		if err := mailerService.sendActivationEmail(data); err != nil {
-			res.SendErrorJSON(w, err.Error(), http.StatusBadRequest)
+		jobID, err := mailerService.sendActivationEmail(data)
+		if err != nil {
+			sendSendError(w, r, err)
 			return
 		}
 
-		res.SendJSON(w, map[string]any{"success": true}, http.StatusOK, nil)
+		sendAccepted(w, jobID)
 		// res.SendSuccessJSON(w, nil, http.StatusOK, nil) // update this after next update for package
 	}
 }
+
+func HandleSendEmailChangeEmail(mailerService *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var data EmailChangeEmailData
+
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			res.SendError(w, r, "invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		jobID, err := mailerService.sendEmailChangeEmail(data)
+		if err != nil {
+			sendSendError(w, r, err)
+			return
+		}
+
+		sendAccepted(w, jobID)
+	}
+}
+
+func HandleSendDataExportEmail(mailerService *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var data ActivationEmailData
+
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			res.SendError(w, r, "invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		jobID, err := mailerService.sendDataExportEmail(data)
+		if err != nil {
+			sendSendError(w, r, err)
+			return
+		}
+
+		sendAccepted(w, jobID)
+	}
+}
+
+func HandleSendLockoutEmail(mailerService *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var data ActivationEmailData
+
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			res.SendError(w, r, "invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		jobID, err := mailerService.sendLockoutEmail(data)
+		if err != nil {
+			sendSendError(w, r, err)
+			return
+		}
+
+		sendAccepted(w, jobID)
+	}
+}
+
+func HandleSendTemplatedEmail(mailerService *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var data TemplatedEmailData
+
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			res.SendError(w, r, "invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		jobID, err := mailerService.sendTemplatedEmail(data)
+		if err != nil {
+			sendSendError(w, r, err)
+			return
+		}
+
+		sendAccepted(w, jobID)
+	}
+}
+
+func HandleListTemplates(mailerService *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		res.SendJSON(w, map[string]any{"templates": mailerService.listTemplates()}, http.StatusOK, nil)
+	}
+}
+
+// HandlePreviewTemplate renders a template with the sample data of its schema as html,
+// so that it can be viewed in a browser. It is mounted under a prefix that is stripped,
+// so the rest of the path is the name of the template (eg. /v1/mailer/preview/reminder?locale=fr).
+// The plain text version is sent instead with ?format=text.
+func HandlePreviewTemplate(mailerService *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			res.SendError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		email, err := mailerService.previewTemplate(r.URL.Path, r.URL.Query().Get("locale"))
+		if err != nil {
+			errorClassifier.SendError(w, r, err)
+			return
+		}
+
+		contentType, body := "text/html; charset=utf-8", email.HTML
+		if r.URL.Query().Get("format") == "text" {
+			contentType, body = "text/plain; charset=utf-8", email.Text
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("X-Email-Subject", email.Subject)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(body))
+	}
+}
+
+// HandleGetJob returns the status of a queued email. It is mounted under a prefix that
+// is stripped, so the rest of the path is the id of the job (eg. /v1/mailer/jobs/{id}).
+func HandleGetJob(mailerService *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			res.SendError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		job, err := mailerService.getJob(r.URL.Path)
+		if err != nil {
+			errorClassifier.SendError(w, r, err)
+			return
+		}
+
+		res.SendJSON(w, map[string]any{"job": job}, http.StatusOK, nil)
+	}
+}
+
+// AdminSecretHeader is the header that admins send the admin api's secret in
+const AdminSecretHeader = "X-Admin-Secret"
+
+// NewAdminHandler returns a handler for the admin api (the suppression list), which must be served
+// on its own port rather than with the send endpoints, so it is not exposed to everything that sends emails.
+// Requests must also have the admin secret in the X-Admin-Secret header.
+func NewAdminHandler(mailerService *Service, secret string) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/v1/mailer/suppressions/", http.StripPrefix("/v1/mailer/suppressions/", HandleSuppressions(mailerService)))
+
+	return res.RequestID(RequireAdminSecret(secret)(mux))
+}
+
+// RequireAdminSecret only lets through requests that have the admin secret in
+// the X-Admin-Secret header. Every request is refused if the secret is empty.
+func RequireAdminSecret(secret string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if secret == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get(AdminSecretHeader)), []byte(secret)) != 1 {
+				res.SendError(w, r, "invalid admin secret", http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// HandleSuppressions lets admins inspect and change the suppression list. It is mounted under
+// a prefix that is stripped, so the rest of the path is an address (eg. /v1/mailer/suppressions/{email}):
+//
+//	GET    /          the suppression list, newest first (?reason= only returns one reason)
+//	POST   /          suppresses an address manually ({"email": "...", "detail": "..."})
+//	GET    /{email}   why an address is suppressed
+//	DELETE /{email}   lets emails be sent to an address again
+func HandleSuppressions(mailerService *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		email := r.URL.Path
+
+		switch {
+		case email == "" && r.Method == http.MethodGet:
+			suppressions, err := mailerService.listSuppressions(r.URL.Query().Get("reason"))
+			if err != nil {
+				errorClassifier.SendError(w, r, err)
+				return
+			}
+
+			res.SendJSON(w, map[string]any{"suppressions": suppressions}, http.StatusOK, nil)
+
+		case email == "" && r.Method == http.MethodPost:
+			var data struct {
+				Email  string `json:"email"`
+				Detail string `json:"detail"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+				res.SendError(w, r, "invalid request body", http.StatusBadRequest)
+				return
+			}
+
+			suppression, err := mailerService.suppress(data.Email, SuppressionManual, data.Detail)
+			if err != nil {
+				errorClassifier.SendError(w, r, err)
+				return
+			}
+
+			res.SendJSON(w, map[string]any{"suppression": suppression}, http.StatusCreated, nil)
+
+		case email != "" && r.Method == http.MethodGet:
+			suppression, err := mailerService.getSuppression(email)
+			if err != nil {
+				errorClassifier.SendError(w, r, err)
+				return
+			}
+
+			res.SendJSON(w, map[string]any{"suppression": suppression}, http.StatusOK, nil)
+
+		case email != "" && r.Method == http.MethodDelete:
+			if err := mailerService.removeSuppression(email); err != nil {
+				errorClassifier.SendError(w, r, err)
+				return
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			res.SendError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// HandleBounceWebhook receives the bounces and complaints that an email provider notifies
+// the mailer of, and suppresses their addresses. The provider must send the shared secret
+// in the X-Webhook-Secret header, since anyone could otherwise stop emails to any address.
+func HandleBounceWebhook(mailerService *Service, secret string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			res.SendError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if secret == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Webhook-Secret")), []byte(secret)) != 1 {
+			res.SendError(w, r, "invalid webhook secret", http.StatusUnauthorized)
+			return
+		}
+
+		var data struct {
+			Events []BounceEvent `json:"events"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			res.SendError(w, r, "invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		suppressed, err := mailerService.handleBounceEvents(data.Events)
+		if err != nil {
+			errorClassifier.SendError(w, r, err)
+			return
+		}
+
+		res.SendJSON(w, map[string]any{"success": true, "suppressed": suppressed}, http.StatusOK, nil)
+	}
+}
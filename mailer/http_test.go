@@ -1,11 +1,15 @@
 package mailer
 
 import (
+	"errors"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/textproto"
 	"strings"
 	"testing"
 
+	res "github.com/ricxi/flat-list/shared/response"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -15,7 +19,7 @@ func TestHandleSendActivationEmail(t *testing.T) {
 			mailer: &mockMailer{
 				err: nil,
 			},
-			emailTemplatesDir: "./templates",
+			templates: mustLoadTemplates(t, "./templates"),
 		}
 		h := HandleSendActivationEmail(&service)
 
@@ -47,7 +51,7 @@ func TestHandleSendActivationEmail(t *testing.T) {
 			mailer: &mockMailer{
 				err: nil,
 			},
-			emailTemplatesDir: "./templates",
+			templates: mustLoadTemplates(t, "./templates"),
 		}
 		h := HandleSendActivationEmail(&service)
 
@@ -67,9 +71,62 @@ func TestHandleSendActivationEmail(t *testing.T) {
 
 		h.ServeHTTP(rr, r)
 
-		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
 
-		expected := `{"error":"missing field is required: to"}`
+		expected := `{"success":false,"error":"missing field is required: to","fields":[{"field":"to","code":"required","message":"missing field is required: to"}]}`
 		assert.JSONEq(t, expected, rr.Body.String())
 	})
+	body := `
+	{
+		"from":    "theteam@flatlist.com",
+		"to":      "MichaelScott@dundermifflin.com",
+		"subject": "test",
+		"activationData": {
+			"name":      "Michael",
+			"hyperlink": "http://127.0.01:6000/clickme"
+		}
+	}`
+
+	sendErrors := []struct {
+		name          string
+		err           error
+		expStatusCode int
+		expError      string
+	}{
+		{
+			name:          "ErrorHiddenInternal",
+			err:           &textproto.Error{Code: 535, Msg: "5.7.8 Username and Password not accepted"},
+			expStatusCode: http.StatusInternalServerError,
+			expError:      "an internal server error has occurred",
+		},
+		{
+			name:          "ErrorSMTPUnavailable",
+			err:           &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")},
+			expStatusCode: http.StatusServiceUnavailable,
+			expError:      "the service is temporarily unavailable, try again later",
+		},
+	}
+
+	for _, tt := range sendErrors {
+		t.Run(tt.name, func(t *testing.T) {
+			service := Service{
+				mailer: &mockMailer{
+					err: tt.err,
+				},
+				templates: mustLoadTemplates(t, "./templates"),
+			}
+			h := res.RequestID(HandleSendActivationEmail(&service))
+
+			rr := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+			r.Header.Set(res.RequestIDHeader, "test-request-id")
+
+			h.ServeHTTP(rr, r)
+
+			assert.Equal(t, tt.expStatusCode, rr.Code)
+
+			expected := `{"success":false,"error":"` + tt.expError + `","requestId":"test-request-id"}`
+			assert.JSONEq(t, expected, rr.Body.String())
+		})
+	}
 }
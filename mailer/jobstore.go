@@ -0,0 +1,179 @@
+package mailer
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// PostgresJobStore stores the queue in the mail_jobs and mail_dead_letters tables (see migrations/mailer)
+type PostgresJobStore struct {
+	db *sql.DB
+}
+
+func NewPostgresJobStore(db *sql.DB) PostgresJobStore {
+	return PostgresJobStore{db: db}
+}
+
+// Connect opens a connection to the database that the queue is stored in.
+// The pgx driver must be imported by the caller.
+func Connect(connStr string) (*sql.DB, error) {
+	db, err := sql.Open("pgx", connStr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Ping(); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func (s PostgresJobStore) insertJob(ctx context.Context, job *Job) error {
+	msg, err := json.Marshal(job.msg)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO mail_jobs (id, status, message, run_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+
+	_, err = s.db.ExecContext(ctx, query, job.ID, job.Status, msg, job.RunAt, job.CreatedAt, job.UpdatedAt)
+
+	return err
+}
+
+// claimJob skips jobs that other workers have locked, so that each job is only taken by one of them
+func (s PostgresJobStore) claimJob(ctx context.Context, lease time.Duration) (*Job, error) {
+	query := `UPDATE mail_jobs
+		SET status = 'sending', attempts = attempts + 1, locked_until = now() + make_interval(secs => $1), updated_at = now()
+		WHERE id = (
+			SELECT id FROM mail_jobs
+			WHERE (status = 'queued' AND run_at <= now()) OR (status = 'sending' AND locked_until < now())
+			ORDER BY run_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, status, message, attempts, COALESCE(last_error, ''), run_at, created_at, updated_at`
+
+	var (
+		job Job
+		msg []byte
+	)
+	err := s.db.QueryRowContext(ctx, query, lease.Seconds()).Scan(
+		&job.ID, &job.Status, &msg, &job.Attempts, &job.LastError, &job.RunAt, &job.CreatedAt, &job.UpdatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(msg, &job.msg); err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+func (s PostgresJobStore) markJobSent(ctx context.Context, id string) error {
+	query := `UPDATE mail_jobs SET status = 'sent', locked_until = NULL, last_error = NULL, updated_at = now()
+		WHERE id = $1`
+
+	return s.execJob(ctx, query, id)
+}
+
+func (s PostgresJobStore) retryJob(ctx context.Context, id string, runAt time.Time, lastErr string) error {
+	query := `UPDATE mail_jobs SET status = 'queued', run_at = $2, last_error = $3, locked_until = NULL, updated_at = now()
+		WHERE id = $1`
+
+	return s.execJob(ctx, query, id, runAt, lastErr)
+}
+
+// deadLetterJob moves the job in a transaction, so that it is never in both tables or neither
+func (s PostgresJobStore) deadLetterJob(ctx context.Context, id string, lastErr string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `WITH job AS (DELETE FROM mail_jobs WHERE id = $1 RETURNING id, message, attempts, created_at)
+		INSERT INTO mail_dead_letters (id, message, attempts, last_error, created_at)
+		SELECT id, message, attempts, $2, created_at FROM job`
+
+	result, err := tx.ExecContext(ctx, query, id, lastErr)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrJobNotFound
+	}
+
+	return tx.Commit()
+}
+
+// getJob looks for a job in the queue, and then in the dead-letter table
+func (s PostgresJobStore) getJob(ctx context.Context, id string) (*Job, error) {
+	var job Job
+
+	query := `SELECT id, status, attempts, COALESCE(last_error, ''), run_at, created_at, updated_at
+		FROM mail_jobs WHERE id = $1`
+	err := s.db.QueryRowContext(ctx, query, id).Scan(
+		&job.ID, &job.Status, &job.Attempts, &job.LastError, &job.RunAt, &job.CreatedAt, &job.UpdatedAt,
+	)
+	if err == nil {
+		return &job, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	query = `SELECT id, attempts, last_error, failed_at, created_at, failed_at
+		FROM mail_dead_letters WHERE id = $1`
+	err = s.db.QueryRowContext(ctx, query, id).Scan(
+		&job.ID, &job.Attempts, &job.LastError, &job.RunAt, &job.CreatedAt, &job.UpdatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrJobNotFound
+		}
+		return nil, err
+	}
+	job.Status = JobFailed
+
+	return &job, nil
+}
+
+func (s PostgresJobStore) deleteSentJobs(ctx context.Context, before time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM mail_jobs WHERE status = 'sent' AND updated_at < $1", before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// execJob runs a query that updates a job, and returns ErrJobNotFound if there is no job with the id
+func (s PostgresJobStore) execJob(ctx context.Context, query string, id string, args ...any) error {
+	result, err := s.db.ExecContext(ctx, query, append([]any{id}, args...)...)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrJobNotFound
+	}
+
+	return nil
+}
@@ -18,7 +18,7 @@ func SendActivationEmail(mailerService *Service) lambdaHandler {
 			return events.APIGatewayProxyResponse{}, err
 		}
 
-		if err := mailerService.sendActivationEmail(data); err != nil {
+		if _, err := mailerService.sendActivationEmail(data); err != nil {
 			return events.APIGatewayProxyResponse{}, err
 		}
 
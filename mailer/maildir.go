@@ -0,0 +1,55 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// maildirMailer saves emails to a maildir instead of sending them, so that they can be
+// read with an email client (eg. mutt -f <dir>) while developing, without an smtp server.
+// Each email is a complete mime message, like it would be sent.
+type maildirMailer struct {
+	dir string
+}
+
+// NewMaildirMailer creates a Mailer that saves emails to a maildir, which is created if it doesn't exist
+func NewMaildirMailer(dir string) (Mailer, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, err
+		}
+	}
+
+	return &maildirMailer{dir: dir}, nil
+}
+
+// send writes the email to tmp/ and then moves it to new/, so that
+// email clients never see an email that is only partly written
+func (m *maildirMailer) send(msg *message) error {
+	id, err := newJobID()
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d.%s.flatlist", time.Now().UnixNano(), id)
+	tmpPath := filepath.Join(m.dir, "tmp", name)
+
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	if _, err := newGomailMessage(msg, msg.To).WriteTo(f); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, filepath.Join(m.dir, "new", name))
+}
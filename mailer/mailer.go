@@ -1,12 +1,38 @@
 package mailer
 
 import (
+	"io"
+	"mime"
+	"path/filepath"
+
 	"gopkg.in/gomail.v2"
 )
 
 // Mailer defines methods for receiving data to send emails.
 type Mailer interface {
-	send(from, to, subject, body string) error
+	send(msg *message) error
+}
+
+// message is an email that is ready to be sent. Every email has a html body
+// and a plain text alternative, and they are sent as multipart/alternative
+// so that email clients can show whichever one they support.
+type message struct {
+	From        string       `json:"from"`
+	To          string       `json:"to"`
+	Subject     string       `json:"subject"`
+	HTML        string       `json:"html"`
+	Text        string       `json:"text"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+}
+
+// Attachment is a file that is sent with an email. Inline attachments are
+// images that the html body shows with their filename as a content id
+// (eg. <img src="cid:logo.png">), instead of being listed as attachments.
+type Attachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"contentType"`
+	Content     []byte `json:"content"` // base64 in json
+	Inline      bool   `json:"inline"`
 }
 
 // mailer implements the gomail package to send emails
@@ -24,22 +50,61 @@ func NewMailer(username, password, host string, port int) Mailer {
 
 // send is a wrapper for SendMultiple.
 // It sends an email to a single recipient
-func (m *mailer) send(from, to, subject, body string) error {
-	return m.sendMultiple(from, subject, body, to)
+func (m *mailer) send(msg *message) error {
+	return m.sendMultiple(msg, msg.To)
 }
 
 // sendMultiple sends an email to one or more recipients
-func (m *mailer) sendMultiple(from, subject, body string, recipients ...string) error {
-	msg := gomail.NewMessage()
-
-	msg.SetHeader("From", from)
-	msg.SetHeader("To", recipients...)
-	msg.SetHeader("Subject", subject)
-	msg.SetBody("text/html", body)
-
-	if err := m.dialer.DialAndSend(msg); err != nil {
+func (m *mailer) sendMultiple(msg *message, recipients ...string) error {
+	if err := m.dialer.DialAndSend(newGomailMessage(msg, recipients...)); err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// newGomailMessage builds the mime message of an email. The plain text body
+// comes first, since email clients show the last alternative that they support.
+func newGomailMessage(msg *message, recipients ...string) *gomail.Message {
+	gm := gomail.NewMessage()
+
+	gm.SetHeader("From", msg.From)
+	gm.SetHeader("To", recipients...)
+	gm.SetHeader("Subject", msg.Subject)
+	gm.SetBody("text/plain", msg.Text)
+	gm.AddAlternative("text/html", msg.HTML)
+
+	for _, a := range msg.Attachments {
+		// gomail reads attachments from files unless it is given a copy func
+		content := a.Content
+		settings := []gomail.FileSetting{
+			gomail.SetCopyFunc(func(w io.Writer) error {
+				_, err := w.Write(content)
+				return err
+			}),
+			gomail.SetHeader(map[string][]string{"Content-Type": {attachmentContentType(a)}}),
+		}
+
+		if a.Inline {
+			// the content id of an embedded file is its filename
+			gm.Embed(a.Filename, settings...)
+		} else {
+			gm.Attach(a.Filename, settings...)
+		}
+	}
+
+	return gm
+}
+
+// attachmentContentType guesses the content type of an attachment from its filename if it has none
+func attachmentContentType(a Attachment) string {
+	if a.ContentType != "" {
+		return a.ContentType
+	}
+
+	if contentType := mime.TypeByExtension(filepath.Ext(a.Filename)); contentType != "" {
+		return contentType
+	}
+
+	return "application/octet-stream"
+}
@@ -0,0 +1,45 @@
+package mailer
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewGomailMessage(t *testing.T) {
+	msg := &message{
+		From:    "theteam@flatlist.com",
+		To:      "michaelscott@dundermifflin.com",
+		Subject: "Your tasks",
+		HTML:    `<p>Hello</p><img src="cid:logo.png">`,
+		Text:    "Hello\n",
+		Attachments: []Attachment{
+			{Filename: "logo.png", Content: []byte("png"), Inline: true},
+			{Filename: "tasks.pdf", Content: []byte("title\n")},
+			{Filename: "notes", Content: []byte("notes")},
+		},
+	}
+
+	var b bytes.Buffer
+	_, err := newGomailMessage(msg, msg.To).WriteTo(&b)
+	require.NoError(t, err)
+	raw := b.String()
+
+	assert.Contains(t, raw, "Content-Type: multipart/alternative")
+	// the plain text body comes before the html, which email clients prefer if they can show it
+	textAt := strings.Index(raw, "Content-Type: text/plain")
+	htmlAt := strings.Index(raw, "Content-Type: text/html")
+	require.NotEqual(t, -1, textAt)
+	require.NotEqual(t, -1, htmlAt)
+	assert.Less(t, textAt, htmlAt)
+
+	assert.Contains(t, raw, "Content-Type: multipart/related")
+	assert.Contains(t, raw, "Content-ID: <logo.png>")
+	assert.Contains(t, raw, "Content-Type: image/png")
+	assert.Contains(t, raw, `Content-Disposition: attachment; filename="tasks.pdf"`)
+	assert.Contains(t, raw, "Content-Type: application/pdf")
+	assert.Contains(t, raw, "Content-Type: application/octet-stream")
+}
@@ -23,6 +23,11 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type MailerClient interface {
 	SendActivationEmail(ctx context.Context, in *EmailRequest, opts ...grpc.CallOption) (*Response, error)
+	SendEmailChangeEmail(ctx context.Context, in *EmailChangeRequest, opts ...grpc.CallOption) (*Response, error)
+	SendDataExportEmail(ctx context.Context, in *EmailRequest, opts ...grpc.CallOption) (*Response, error)
+	SendLockoutEmail(ctx context.Context, in *EmailRequest, opts ...grpc.CallOption) (*Response, error)
+	SendTemplatedEmail(ctx context.Context, in *TemplatedEmailRequest, opts ...grpc.CallOption) (*Response, error)
+	GetJob(ctx context.Context, in *JobRequest, opts ...grpc.CallOption) (*Job, error)
 }
 
 type mailerClient struct {
@@ -42,11 +47,61 @@ func (c *mailerClient) SendActivationEmail(ctx context.Context, in *EmailRequest
 	return out, nil
 }
 
+func (c *mailerClient) SendEmailChangeEmail(ctx context.Context, in *EmailChangeRequest, opts ...grpc.CallOption) (*Response, error) {
+	out := new(Response)
+	err := c.cc.Invoke(ctx, "/pb.Mailer/SendEmailChangeEmail", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mailerClient) SendDataExportEmail(ctx context.Context, in *EmailRequest, opts ...grpc.CallOption) (*Response, error) {
+	out := new(Response)
+	err := c.cc.Invoke(ctx, "/pb.Mailer/SendDataExportEmail", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mailerClient) SendLockoutEmail(ctx context.Context, in *EmailRequest, opts ...grpc.CallOption) (*Response, error) {
+	out := new(Response)
+	err := c.cc.Invoke(ctx, "/pb.Mailer/SendLockoutEmail", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mailerClient) SendTemplatedEmail(ctx context.Context, in *TemplatedEmailRequest, opts ...grpc.CallOption) (*Response, error) {
+	out := new(Response)
+	err := c.cc.Invoke(ctx, "/pb.Mailer/SendTemplatedEmail", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mailerClient) GetJob(ctx context.Context, in *JobRequest, opts ...grpc.CallOption) (*Job, error) {
+	out := new(Job)
+	err := c.cc.Invoke(ctx, "/pb.Mailer/GetJob", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // MailerServer is the server API for Mailer service.
 // All implementations must embed UnimplementedMailerServer
 // for forward compatibility
 type MailerServer interface {
 	SendActivationEmail(context.Context, *EmailRequest) (*Response, error)
+	SendEmailChangeEmail(context.Context, *EmailChangeRequest) (*Response, error)
+	SendDataExportEmail(context.Context, *EmailRequest) (*Response, error)
+	SendLockoutEmail(context.Context, *EmailRequest) (*Response, error)
+	SendTemplatedEmail(context.Context, *TemplatedEmailRequest) (*Response, error)
+	GetJob(context.Context, *JobRequest) (*Job, error)
 	mustEmbedUnimplementedMailerServer()
 }
 
@@ -57,6 +112,21 @@ type UnimplementedMailerServer struct {
 func (UnimplementedMailerServer) SendActivationEmail(context.Context, *EmailRequest) (*Response, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method SendActivationEmail not implemented")
 }
+func (UnimplementedMailerServer) SendEmailChangeEmail(context.Context, *EmailChangeRequest) (*Response, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SendEmailChangeEmail not implemented")
+}
+func (UnimplementedMailerServer) SendDataExportEmail(context.Context, *EmailRequest) (*Response, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SendDataExportEmail not implemented")
+}
+func (UnimplementedMailerServer) SendLockoutEmail(context.Context, *EmailRequest) (*Response, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SendLockoutEmail not implemented")
+}
+func (UnimplementedMailerServer) SendTemplatedEmail(context.Context, *TemplatedEmailRequest) (*Response, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SendTemplatedEmail not implemented")
+}
+func (UnimplementedMailerServer) GetJob(context.Context, *JobRequest) (*Job, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetJob not implemented")
+}
 func (UnimplementedMailerServer) mustEmbedUnimplementedMailerServer() {}
 
 // UnsafeMailerServer may be embedded to opt out of forward compatibility for this service.
@@ -88,6 +158,96 @@ func _Mailer_SendActivationEmail_Handler(srv interface{}, ctx context.Context, d
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Mailer_SendEmailChangeEmail_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EmailChangeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MailerServer).SendEmailChangeEmail(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Mailer/SendEmailChangeEmail",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MailerServer).SendEmailChangeEmail(ctx, req.(*EmailChangeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Mailer_SendDataExportEmail_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EmailRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MailerServer).SendDataExportEmail(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Mailer/SendDataExportEmail",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MailerServer).SendDataExportEmail(ctx, req.(*EmailRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Mailer_SendLockoutEmail_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EmailRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MailerServer).SendLockoutEmail(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Mailer/SendLockoutEmail",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MailerServer).SendLockoutEmail(ctx, req.(*EmailRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Mailer_SendTemplatedEmail_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TemplatedEmailRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MailerServer).SendTemplatedEmail(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Mailer/SendTemplatedEmail",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MailerServer).SendTemplatedEmail(ctx, req.(*TemplatedEmailRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Mailer_GetJob_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(JobRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MailerServer).GetJob(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Mailer/GetJob",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MailerServer).GetJob(ctx, req.(*JobRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Mailer_ServiceDesc is the grpc.ServiceDesc for Mailer service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -99,6 +259,26 @@ var Mailer_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "SendActivationEmail",
 			Handler:    _Mailer_SendActivationEmail_Handler,
 		},
+		{
+			MethodName: "SendEmailChangeEmail",
+			Handler:    _Mailer_SendEmailChangeEmail_Handler,
+		},
+		{
+			MethodName: "SendDataExportEmail",
+			Handler:    _Mailer_SendDataExportEmail_Handler,
+		},
+		{
+			MethodName: "SendLockoutEmail",
+			Handler:    _Mailer_SendLockoutEmail_Handler,
+		},
+		{
+			MethodName: "SendTemplatedEmail",
+			Handler:    _Mailer_SendTemplatedEmail_Handler,
+		},
+		{
+			MethodName: "GetJob",
+			Handler:    _Mailer_GetJob_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "pb/mailer.proto",
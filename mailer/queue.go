@@ -0,0 +1,311 @@
+package mailer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	mathrand "math/rand"
+	"net/http"
+	"net/textproto"
+	"sync"
+	"time"
+)
+
+var ErrJobNotFound = errors.New("mail job not found")
+
+// the statuses of a job
+const (
+	// JobQueued jobs are waiting to be sent, or to be tried again after failing
+	JobQueued = "queued"
+	// JobSending jobs have been taken by a worker
+	JobSending = "sending"
+	JobSent    = "sent"
+	// JobFailed jobs failed permanently, or ran out of attempts, and were dead-lettered
+	JobFailed = "failed"
+)
+
+const (
+	defaultWorkers      = 4
+	defaultMaxAttempts  = 8
+	defaultBaseBackoff  = 10 * time.Second
+	defaultMaxBackoff   = time.Hour
+	defaultPollInterval = time.Second
+	// jobLease is how long a worker has to send an email before another worker can take it,
+	// in case the first one stopped. It is longer than the smtp client takes to time out.
+	jobLease = 5 * time.Minute
+	// sentJobRetention is how long the status of sent jobs can be looked up for
+	sentJobRetention = 7 * 24 * time.Hour
+	// storeTimeout limits how long the queue waits for the store, since the service's methods have no context
+	storeTimeout = 10 * time.Second
+)
+
+// Job is an email in the queue, and is returned to check on it by its id
+type Job struct {
+	ID        string    `json:"id"`
+	Status    string    `json:"status"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"lastError,omitempty"`
+	RunAt     time.Time `json:"runAt"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	msg       *message
+}
+
+// JobStore stores the queue so that emails are not lost if the mailer stops
+type JobStore interface {
+	insertJob(ctx context.Context, job *Job) error
+	// claimJob takes the job that has been due for the longest and marks it as sending until
+	// the lease is over. It returns nil if no jobs are due. Its attempts include this one.
+	claimJob(ctx context.Context, lease time.Duration) (*Job, error)
+	markJobSent(ctx context.Context, id string) error
+	retryJob(ctx context.Context, id string, runAt time.Time, lastErr string) error
+	// deadLetterJob moves a job to the dead-letter table
+	deadLetterJob(ctx context.Context, id string, lastErr string) error
+	getJob(ctx context.Context, id string) (*Job, error)
+	deleteSentJobs(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Queue accepts emails and sends them in the background, so that callers don't wait
+// on (or fail because of) the smtp server. Emails that fail to send are tried again with
+// exponential backoff, and moved to the dead-letter table if they fail permanently.
+// An email may be sent twice if the mailer stops after sending it but before recording it.
+type Queue struct {
+	store  JobStore
+	mailer Mailer
+	// wake lets a worker know that a job was queued, instead of waiting to poll
+	wake chan struct{}
+
+	workers      int
+	maxAttempts  int
+	baseBackoff  time.Duration
+	maxBackoff   time.Duration
+	pollInterval time.Duration
+}
+
+type QueueOption func(*Queue)
+
+// WithWorkers sets how many emails are sent at the same time
+func WithWorkers(workers int) QueueOption {
+	return func(q *Queue) {
+		q.workers = workers
+	}
+}
+
+// WithMaxAttempts sets how many times an email is tried before it is dead-lettered
+func WithMaxAttempts(maxAttempts int) QueueOption {
+	return func(q *Queue) {
+		q.maxAttempts = maxAttempts
+	}
+}
+
+// WithBackoff sets how long to wait before trying an email again the first time,
+// which doubles after every attempt up to max
+func WithBackoff(base, max time.Duration) QueueOption {
+	return func(q *Queue) {
+		q.baseBackoff = base
+		q.maxBackoff = max
+	}
+}
+
+// WithPollInterval sets how often workers check for jobs that are due
+func WithPollInterval(interval time.Duration) QueueOption {
+	return func(q *Queue) {
+		q.pollInterval = interval
+	}
+}
+
+func NewQueue(store JobStore, mailer Mailer, opts ...QueueOption) *Queue {
+	q := &Queue{
+		store:        store,
+		mailer:       mailer,
+		wake:         make(chan struct{}, 1),
+		workers:      defaultWorkers,
+		maxAttempts:  defaultMaxAttempts,
+		baseBackoff:  defaultBaseBackoff,
+		maxBackoff:   defaultMaxBackoff,
+		pollInterval: defaultPollInterval,
+	}
+
+	for _, opt := range opts {
+		opt(q)
+	}
+
+	return q
+}
+
+// Run starts the workers, which send emails until the context is cancelled.
+// It also deletes sent jobs once they are no longer worth looking up.
+func (q *Queue) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	for i := 0; i < q.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx)
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		q.cleanup(ctx, time.Hour)
+	}()
+
+	wg.Wait()
+}
+
+// enqueue stores an email to be sent, and returns the id of its job
+func (q *Queue) enqueue(msg *message) (string, error) {
+	id, err := newJobID()
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	now := time.Now().UTC()
+	job := &Job{ID: id, Status: JobQueued, RunAt: now, CreatedAt: now, UpdatedAt: now, msg: msg}
+	if err := q.store.insertJob(ctx, job); err != nil {
+		return "", err
+	}
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+
+	return id, nil
+}
+
+// job returns a job by its id
+func (q *Queue) job(id string) (*Job, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	return q.store.getJob(ctx, id)
+}
+
+func (q *Queue) work(ctx context.Context) {
+	for {
+		job, err := q.store.claimJob(ctx, jobLease)
+		if err != nil && ctx.Err() == nil {
+			log.Println("problem taking a mail job:", err)
+		}
+
+		if job == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-q.wake:
+			case <-time.After(q.pollInterval):
+			}
+			continue
+		}
+
+		q.process(job)
+	}
+}
+
+// process sends a job's email and records the result. It doesn't use the worker's context,
+// so that the result of an email that was being sent when the mailer stopped is still recorded.
+func (q *Queue) process(job *Job) {
+	sendErr := q.mailer.send(job.msg)
+
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	var err error
+	switch {
+	case sendErr == nil:
+		err = q.store.markJobSent(ctx, job.ID)
+	case isPermanent(sendErr) || job.Attempts >= q.maxAttempts:
+		log.Printf("dead-lettering mail job %s after %d attempts: %v", job.ID, job.Attempts, sendErr)
+		err = q.store.deadLetterJob(ctx, job.ID, sendErr.Error())
+	default:
+		err = q.store.retryJob(ctx, job.ID, time.Now().Add(q.backoff(job.Attempts)), sendErr.Error())
+	}
+
+	if err != nil {
+		// the job is tried again when its lease is over
+		log.Printf("problem recording the result of mail job %s: %v", job.ID, err)
+	}
+}
+
+// backoff returns how long to wait before the next attempt, which doubles after every attempt.
+// It is randomised by up to half so that emails that failed together are not all tried together.
+func (q *Queue) backoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	backoff := q.maxBackoff
+	if attempts < 32 {
+		if d := q.baseBackoff << (attempts - 1); d > 0 && d < q.maxBackoff {
+			backoff = d
+		}
+	}
+
+	return backoff/2 + time.Duration(mathrand.Int63n(int64(backoff/2)+1))
+}
+
+func (q *Queue) cleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deletedCount, err := q.store.deleteSentJobs(ctx, time.Now().Add(-sentJobRetention))
+			if err != nil {
+				log.Println("problem deleting sent mail jobs:", err)
+				continue
+			}
+			if deletedCount > 0 {
+				log.Println("deleted", deletedCount, "sent mail jobs")
+			}
+		}
+	}
+}
+
+// isPermanent checks if an email can never be sent, because the smtp server (or email api)
+// rejected it with a permanent error, such as for an address that doesn't exist.
+// Authentication errors are not permanent, since they are fixed by the mailer's configuration.
+// Emails to suppressed addresses are never sent either, unless they are removed from the list.
+func isPermanent(err error) bool {
+	if errors.Is(err, ErrRecipientSuppressed) {
+		return true
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return !apiErr.temporary() &&
+			apiErr.StatusCode != http.StatusUnauthorized && apiErr.StatusCode != http.StatusForbidden
+	}
+
+	var smtpErr *textproto.Error
+	if !errors.As(err, &smtpErr) || smtpErr.Code < 500 {
+		return false
+	}
+
+	switch smtpErr.Code {
+	case 530, 534, 535:
+		return false
+	}
+
+	return true
+}
+
+func newJobID() (string, error) {
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(idBytes), nil
+}
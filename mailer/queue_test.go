@@ -0,0 +1,339 @@
+package mailer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ricxi/flat-list/mailer/pb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// mockJobStore is a JobStore that keeps the queue in memory
+type mockJobStore struct {
+	mu          sync.Mutex
+	jobs        map[string]*Job
+	lockedUntil map[string]time.Time
+	deadLetters map[string]*Job
+}
+
+func newMockJobStore() *mockJobStore {
+	return &mockJobStore{
+		jobs:        make(map[string]*Job),
+		lockedUntil: make(map[string]time.Time),
+		deadLetters: make(map[string]*Job),
+	}
+}
+
+func (s *mockJobStore) insertJob(ctx context.Context, job *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := *job
+	s.jobs[job.ID] = &stored
+	return nil
+}
+
+func (s *mockJobStore) claimJob(ctx context.Context, lease time.Duration) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var due []*Job
+	for _, job := range s.jobs {
+		if (job.Status == JobQueued && !job.RunAt.After(now)) ||
+			(job.Status == JobSending && s.lockedUntil[job.ID].Before(now)) {
+			due = append(due, job)
+		}
+	}
+	if len(due) == 0 {
+		return nil, nil
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].RunAt.Before(due[j].RunAt) })
+
+	job := due[0]
+	job.Status = JobSending
+	job.Attempts++
+	s.lockedUntil[job.ID] = now.Add(lease)
+
+	claimed := *job
+	return &claimed, nil
+}
+
+func (s *mockJobStore) markJobSent(ctx context.Context, id string) error {
+	return s.update(id, func(job *Job) {
+		job.Status = JobSent
+		job.LastError = ""
+	})
+}
+
+func (s *mockJobStore) retryJob(ctx context.Context, id string, runAt time.Time, lastErr string) error {
+	return s.update(id, func(job *Job) {
+		job.Status = JobQueued
+		job.RunAt = runAt
+		job.LastError = lastErr
+	})
+}
+
+func (s *mockJobStore) deadLetterJob(ctx context.Context, id string, lastErr string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return ErrJobNotFound
+	}
+	delete(s.jobs, id)
+
+	job.Status = JobFailed
+	job.LastError = lastErr
+	s.deadLetters[id] = job
+	return nil
+}
+
+func (s *mockJobStore) getJob(ctx context.Context, id string) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		if job, ok = s.deadLetters[id]; !ok {
+			return nil, ErrJobNotFound
+		}
+	}
+
+	found := *job
+	return &found, nil
+}
+
+func (s *mockJobStore) deleteSentJobs(ctx context.Context, before time.Time) (int64, error) {
+	return 0, nil
+}
+
+func (s *mockJobStore) update(id string, fn func(job *Job)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return ErrJobNotFound
+	}
+	fn(job)
+	job.UpdatedAt = time.Now()
+	return nil
+}
+
+// flakyMailer fails with each of its errors in turn before it sends emails
+type flakyMailer struct {
+	mu   sync.Mutex
+	errs []error
+	sent []*message
+}
+
+func (m *flakyMailer) send(msg *message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.errs) > 0 {
+		err := m.errs[0]
+		m.errs = m.errs[1:]
+		return err
+	}
+
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func (m *flakyMailer) sentCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.sent)
+}
+
+func TestQueue(t *testing.T) {
+	data := ActivationEmailData{
+		To:             "michaelscott@dundermifflin.com",
+		From:           "theteam@flatlist.com",
+		Subject:        "Please activate your account",
+		ActivationData: ActivationData{Name: "Michael", Hyperlink: "http://localhost:5000/clickme"},
+	}
+
+	testCases := []struct {
+		name             string
+		errs             []error
+		expectedStatus   string
+		expectedAttempts int
+		expectedSent     int
+	}{
+		{
+			name:             "Sent",
+			expectedStatus:   JobSent,
+			expectedAttempts: 1,
+			expectedSent:     1,
+		},
+		{
+			name:             "SentAfterTransientErrors",
+			errs:             []error{&textproto.Error{Code: 421, Msg: "try again later"}, errors.New("connection reset")},
+			expectedStatus:   JobSent,
+			expectedAttempts: 3,
+			expectedSent:     1,
+		},
+		{
+			name:             "DeadLetteredAfterPermanentError",
+			errs:             []error{&textproto.Error{Code: 550, Msg: "no such user"}},
+			expectedStatus:   JobFailed,
+			expectedAttempts: 1,
+		},
+		{
+			name: "DeadLetteredAfterMaxAttempts",
+			errs: []error{
+				&textproto.Error{Code: 535, Msg: "authentication failed"},
+				&textproto.Error{Code: 535, Msg: "authentication failed"},
+				&textproto.Error{Code: 535, Msg: "authentication failed"},
+			},
+			expectedStatus:   JobFailed,
+			expectedAttempts: 3,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMockJobStore()
+			m := &flakyMailer{errs: tt.errs}
+			queue := NewQueue(store, m,
+				WithWorkers(2),
+				WithMaxAttempts(3),
+				WithBackoff(time.Millisecond, 2*time.Millisecond),
+				WithPollInterval(time.Millisecond),
+			)
+			s := NewService(m, mustLoadTemplates(t, "./templates"), WithQueue(queue))
+
+			jobID, err := s.sendActivationEmail(data)
+			require.NoError(t, err)
+			require.NotEmpty(t, jobID)
+
+			// the email is only queued until the workers run
+			job, err := s.getJob(jobID)
+			require.NoError(t, err)
+			assert.Equal(t, JobQueued, job.Status)
+			assert.Zero(t, m.sentCount())
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				queue.Run(ctx)
+				close(done)
+			}()
+
+			assert.Eventually(t, func() bool {
+				job, err := s.getJob(jobID)
+				return err == nil && job.Status == tt.expectedStatus
+			}, time.Second, time.Millisecond)
+
+			cancel()
+			<-done
+
+			job, err = s.getJob(jobID)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedAttempts, job.Attempts)
+			assert.Equal(t, tt.expectedSent, m.sentCount())
+			if tt.expectedStatus == JobFailed {
+				assert.Contains(t, store.deadLetters, jobID)
+				assert.NotEmpty(t, job.LastError)
+			}
+		})
+	}
+}
+
+func TestQueueBackoff(t *testing.T) {
+	q := NewQueue(newMockJobStore(), &flakyMailer{}, WithBackoff(10*time.Second, time.Hour))
+
+	testCases := []struct {
+		attempts int
+		max      time.Duration
+	}{
+		{attempts: 1, max: 10 * time.Second},
+		{attempts: 2, max: 20 * time.Second},
+		{attempts: 4, max: 80 * time.Second},
+		{attempts: 20, max: time.Hour},
+		{attempts: 100, max: time.Hour},
+	}
+
+	for _, tt := range testCases {
+		for i := 0; i < 10; i++ {
+			backoff := q.backoff(tt.attempts)
+			assert.GreaterOrEqual(t, backoff, tt.max/2, tt.attempts)
+			assert.LessOrEqual(t, backoff, tt.max, tt.attempts)
+		}
+	}
+}
+
+func TestHandleGetJob(t *testing.T) {
+	queue := NewQueue(newMockJobStore(), &flakyMailer{})
+	s := NewService(&mockMailer{}, mustLoadTemplates(t, "./templates"), WithQueue(queue))
+
+	rr := httptest.NewRecorder()
+	body := `
+	{
+		"from":    "theteam@flatlist.com",
+		"to":      "michaelscott@dundermifflin.com",
+		"subject": "Your account has been temporarily locked",
+		"activationData": {
+			"hyperlink": "http://localhost:5173/login"
+		}
+	}`
+	r := httptest.NewRequest(http.MethodPost, "/v1/mailer/lockout", strings.NewReader(body))
+	HandleSendLockoutEmail(s).ServeHTTP(rr, r)
+
+	require.Equal(t, http.StatusAccepted, rr.Code)
+	var accepted struct {
+		JobID string `json:"jobId"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &accepted))
+	require.NotEmpty(t, accepted.JobID)
+
+	h := http.StripPrefix("/v1/mailer/jobs/", HandleGetJob(s))
+
+	t.Run("Success", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/mailer/jobs/"+accepted.JobID, nil))
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var body struct {
+			Job Job `json:"job"`
+		}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+		assert.Equal(t, accepted.JobID, body.Job.ID)
+		assert.Equal(t, JobQueued, body.Job.Status)
+	})
+
+	t.Run("ErrorNotFound", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/mailer/jobs/missing", nil))
+
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	t.Run("GrpcSuccess", func(t *testing.T) {
+		job, err := NewGrpcServer(s).GetJob(context.Background(), &pb.JobRequest{Id: accepted.JobID})
+		require.NoError(t, err)
+		assert.Equal(t, JobQueued, job.GetStatus())
+	})
+
+	t.Run("GrpcErrorNotFound", func(t *testing.T) {
+		_, err := NewGrpcServer(s).GetJob(context.Background(), &pb.JobRequest{Id: "missing"})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+}
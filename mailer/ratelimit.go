@@ -0,0 +1,227 @@
+package mailer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned instead of sending an email when too many have been sent
+var ErrRateLimited = errors.New("too many emails have been sent")
+
+// the scopes of the rate limits, which are checked in this order
+const (
+	limitRecipient = "recipient"
+	limitSender    = "sender"
+	limitGlobal    = "global"
+)
+
+// maxBuckets is how many buckets the rate limiter keeps before it forgets the full ones,
+// which are the same as buckets that don't exist
+const maxBuckets = 10000
+
+// RateLimitError is returned when an email would go over a rate limit. It wraps ErrRateLimited.
+type RateLimitError struct {
+	// Scope is the limit that was reached: recipient, sender or global
+	Scope string
+	// RetryAfter is how long it is until the email can be sent
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s: the %s limit was reached, try again in %s", ErrRateLimited, e.Scope, e.RetryAfter.Round(time.Second))
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return ErrRateLimited
+}
+
+// Limit is a token bucket that lets Count emails be sent at once, and then refills at Count per Per.
+// It is written as count/duration (eg. 3/1h for 3 emails an hour), and the zero Limit is unlimited.
+type Limit struct {
+	Count int
+	Per   time.Duration
+}
+
+// ParseLimit reads a limit written as count/duration, such as 3/1h or 100/1m
+func ParseLimit(s string) (Limit, error) {
+	count, per, found := strings.Cut(s, "/")
+	if !found {
+		return Limit{}, fmt.Errorf("invalid rate limit %q (it must be count/duration, such as 3/1h)", s)
+	}
+
+	var l Limit
+	var err error
+	if l.Count, err = strconv.Atoi(count); err != nil || l.Count < 1 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: the count must be a positive number", s)
+	}
+	if l.Per, err = time.ParseDuration(per); err != nil || l.Per <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: the duration must be positive, such as 1h or 30s", s)
+	}
+
+	return l, nil
+}
+
+func (l Limit) String() string {
+	if l.Count == 0 {
+		return "unlimited"
+	}
+
+	return fmt.Sprintf("%d/%s", l.Count, l.Per)
+}
+
+func (l *Limit) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	limit, err := ParseLimit(s)
+	if err != nil {
+		return err
+	}
+	*l = limit
+
+	return nil
+}
+
+// RateLimits are the limits that the service sends emails within.
+// The recipient and sender limits can be changed for a template in its schema.
+type RateLimits struct {
+	// Recipient limits the emails sent to each address, so that nobody can be flooded with emails
+	Recipient Limit `json:"recipient"`
+	// Sender limits the emails sent from each address
+	Sender Limit `json:"sender"`
+	// Global limits every email together, to stay within the quota of the smtp server (or email api)
+	Global Limit `json:"global"`
+}
+
+// RateLimitsFromEnv reads the rate limits from MAIL_RATE_LIMIT_RECIPIENT, MAIL_RATE_LIMIT_SENDER
+// and MAIL_RATE_LIMIT_GLOBAL (eg. 5/1h), which are unlimited if they are not set
+func RateLimitsFromEnv() (RateLimits, error) {
+	var limits RateLimits
+
+	for env, limit := range map[string]*Limit{
+		"MAIL_RATE_LIMIT_RECIPIENT": &limits.Recipient,
+		"MAIL_RATE_LIMIT_SENDER":    &limits.Sender,
+		"MAIL_RATE_LIMIT_GLOBAL":    &limits.Global,
+	} {
+		s := os.Getenv(env)
+		if s == "" {
+			continue
+		}
+
+		l, err := ParseLimit(s)
+		if err != nil {
+			return RateLimits{}, fmt.Errorf("%s: %w", env, err)
+		}
+		*limit = l
+	}
+
+	return limits, nil
+}
+
+// rateLimiter keeps a token bucket for every recipient, sender and the mailer as a whole.
+// Buckets start full, so they only need to be kept once an email is sent.
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+type bucket struct {
+	limit   Limit
+	tokens  float64
+	updated time.Time
+}
+
+// bucketKey is a bucket that an email takes a token from
+type bucketKey struct {
+	scope string
+	key   string
+	limit Limit
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// take removes a token from every bucket, or returns a RateLimitError for the first
+// bucket that is empty without taking any, so that rejected emails don't use up other limits
+func (l *rateLimiter) take(keys ...bucketKey) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+
+	buckets := make([]*bucket, 0, len(keys))
+	for _, k := range keys {
+		if k.limit.Count == 0 {
+			continue
+		}
+
+		id := k.scope + ":" + k.key
+		b, ok := l.buckets[id]
+		if !ok {
+			b = &bucket{tokens: float64(k.limit.Count), updated: now}
+			l.buckets[id] = b
+		}
+		// the limit is updated in case the template was reloaded with a different one
+		b.limit = k.limit
+		b.refill(now)
+
+		if b.tokens < 1 {
+			return &RateLimitError{Scope: k.scope, RetryAfter: b.wait()}
+		}
+		buckets = append(buckets, b)
+	}
+
+	for _, b := range buckets {
+		b.tokens--
+	}
+
+	if len(l.buckets) > maxBuckets {
+		l.prune(now)
+	}
+
+	return nil
+}
+
+// prune forgets the buckets that have refilled
+func (l *rateLimiter) prune(now time.Time) {
+	for id, b := range l.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.limit.Count) {
+			delete(l.buckets, id)
+		}
+	}
+}
+
+func (b *bucket) refill(now time.Time) {
+	elapsed := now.Sub(b.updated)
+	b.updated = now
+	if elapsed <= 0 {
+		return
+	}
+
+	b.tokens = math.Min(float64(b.limit.Count), b.tokens+elapsed.Seconds()*b.rate())
+}
+
+// wait returns how long it is until the bucket has a token
+func (b *bucket) wait() time.Duration {
+	return time.Duration((1 - b.tokens) / b.rate() * float64(time.Second))
+}
+
+// rate is how many tokens are added to the bucket every second
+func (b *bucket) rate() float64 {
+	return float64(b.limit.Count) / b.limit.Per.Seconds()
+}
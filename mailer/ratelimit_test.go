@@ -0,0 +1,185 @@
+package mailer
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ricxi/flat-list/mailer/pb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestParseLimit(t *testing.T) {
+	testCases := []struct {
+		s         string
+		expected  Limit
+		errString string
+	}{
+		{s: "3/1h", expected: Limit{Count: 3, Per: time.Hour}},
+		{s: "100/30s", expected: Limit{Count: 100, Per: 30 * time.Second}},
+		{s: "3", errString: "it must be count/duration"},
+		{s: "0/1h", errString: "the count must be a positive number"},
+		{s: "3/hour", errString: "the duration must be positive"},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.s, func(t *testing.T) {
+			limit, err := ParseLimit(tt.s)
+			if tt.errString != "" {
+				assert.ErrorContains(t, err, tt.errString)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, limit)
+		})
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2023, 5, 1, 9, 0, 0, 0, time.UTC)
+	l := newRateLimiter()
+	l.now = func() time.Time { return now }
+
+	recipient := bucketKey{scope: limitRecipient, key: "michaelscott@dundermifflin.com", limit: Limit{Count: 2, Per: time.Hour}}
+	global := bucketKey{scope: limitGlobal, limit: Limit{Count: 3, Per: time.Minute}}
+
+	// the bucket starts full, so a burst of emails can be sent
+	require.NoError(t, l.take(recipient, global))
+	require.NoError(t, l.take(recipient, global))
+
+	err := l.take(recipient, global)
+	var rlErr *RateLimitError
+	require.ErrorAs(t, err, &rlErr)
+	assert.ErrorIs(t, err, ErrRateLimited)
+	assert.Equal(t, limitRecipient, rlErr.Scope)
+	assert.Equal(t, 30*time.Minute, rlErr.RetryAfter)
+
+	// the rejected email didn't take a token from the global bucket
+	other := bucketKey{scope: limitRecipient, key: "dwightschrute@dundermifflin.com", limit: recipient.limit}
+	require.NoError(t, l.take(other, global))
+
+	err = l.take(other, global)
+	require.ErrorAs(t, err, &rlErr)
+	assert.Equal(t, limitGlobal, rlErr.Scope)
+	assert.Equal(t, 20*time.Second, rlErr.RetryAfter)
+
+	// a token is added to the recipient's bucket every half hour
+	now = now.Add(30 * time.Minute)
+	require.NoError(t, l.take(recipient, global))
+	assert.Error(t, l.take(recipient, global))
+
+	t.Run("Unlimited", func(t *testing.T) {
+		for i := 0; i < 10; i++ {
+			require.NoError(t, l.take(bucketKey{scope: limitSender, key: "theteam@flatlist.com"}))
+		}
+	})
+
+	t.Run("Prune", func(t *testing.T) {
+		now = now.Add(2 * time.Hour)
+		l.prune(now)
+		assert.Empty(t, l.buckets)
+	})
+}
+
+func TestServiceRateLimits(t *testing.T) {
+	data := ActivationEmailData{
+		To:             "michaelscott@dundermifflin.com",
+		From:           "theteam@flatlist.com",
+		ActivationData: ActivationData{Name: "Michael", Hyperlink: "http://localhost:5000/clickme"},
+	}
+
+	t.Run("TemplateLimit", func(t *testing.T) {
+		s := NewService(&mockMailer{}, mustLoadTemplates(t, "./templates"))
+
+		// useractivation.schema.json allows 3 activation emails an hour for each recipient
+		for i := 0; i < 3; i++ {
+			_, err := s.sendActivationEmail(data)
+			require.NoError(t, err)
+		}
+		_, err := s.sendActivationEmail(data)
+		assert.ErrorIs(t, err, ErrRateLimited)
+
+		// the template's limit only counts its own emails
+		_, err = s.sendDataExportEmail(data)
+		assert.NoError(t, err)
+	})
+
+	t.Run("GlobalLimit", func(t *testing.T) {
+		s := NewService(&mockMailer{}, mustLoadTemplates(t, "./templates"),
+			WithRateLimits(RateLimits{Global: Limit{Count: 1, Per: time.Hour}}),
+		)
+
+		_, err := s.sendLockoutEmail(data)
+		require.NoError(t, err)
+
+		_, err = s.sendEmailChangeEmail(EmailChangeEmailData{
+			From:            data.From,
+			To:              "dwightschrute@dundermifflin.com",
+			EmailChangeData: EmailChangeData{Name: "Dwight", Hyperlink: data.Hyperlink},
+		})
+		var rlErr *RateLimitError
+		require.ErrorAs(t, err, &rlErr)
+		assert.Equal(t, limitGlobal, rlErr.Scope)
+	})
+
+	t.Run("SuppressedNotCounted", func(t *testing.T) {
+		s := NewService(&mockMailer{}, mustLoadTemplates(t, "./templates"),
+			WithRateLimits(RateLimits{Global: Limit{Count: 1, Per: time.Hour}}),
+		)
+		_, err := s.suppress("dwightschrute@dundermifflin.com", SuppressionManual, "")
+		require.NoError(t, err)
+
+		suppressed := data
+		suppressed.To = "dwightschrute@dundermifflin.com"
+		_, err = s.sendLockoutEmail(suppressed)
+		require.ErrorIs(t, err, ErrRecipientSuppressed)
+
+		_, err = s.sendLockoutEmail(data)
+		assert.NoError(t, err)
+	})
+
+	t.Run("HTTPTooManyRequests", func(t *testing.T) {
+		s := NewService(&mockMailer{}, mustLoadTemplates(t, "./templates"),
+			WithRateLimits(RateLimits{Sender: Limit{Count: 1, Per: time.Minute}}),
+		)
+
+		body := `{"from": "theteam@flatlist.com", "to": "michaelscott@dundermifflin.com", "activationData": {"hyperlink": "http://localhost:5173/login"}}`
+		var rr *httptest.ResponseRecorder
+		for i := 0; i < 2; i++ {
+			rr = httptest.NewRecorder()
+			HandleSendLockoutEmail(s).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/mailer/lockout", strings.NewReader(body)))
+		}
+
+		assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+		assert.Equal(t, "60", rr.Header().Get("Retry-After"))
+	})
+
+	t.Run("GrpcResourceExhausted", func(t *testing.T) {
+		srv := NewGrpcServer(NewService(&mockMailer{}, mustLoadTemplates(t, "./templates")))
+
+		var err error
+		for i := 0; i < 4; i++ {
+			_, err = srv.SendActivationEmail(context.Background(), &pb.EmailRequest{
+				From:           data.From,
+				To:             data.To,
+				ActivationData: &pb.ActivationData{Name: "Michael", Hyperlink: "http://localhost:5000/clickme"},
+			})
+		}
+
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+		details := status.Convert(err).Details()
+		require.Len(t, details, 1)
+		retryInfo, ok := details[0].(*errdetails.RetryInfo)
+		require.True(t, ok)
+		assert.Positive(t, retryInfo.RetryDelay.AsDuration())
+	})
+}
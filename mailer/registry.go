@@ -0,0 +1,498 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+)
+
+// the directories in the templates directory whose templates are shared by every email
+var sharedTemplateDirs = []string{"layouts", "partials"}
+
+// TemplateRegistry parses every email template in a directory once, so that
+// broken templates are found when the mailer starts instead of when an email is sent.
+//
+// The directory holds a <name>.html template for each email, and optionally a
+// <name>.schema.json (see templateSchema) and a <name>.txt plain text version, which
+// is otherwise made from the html. Templates in layouts/ and partials/ can be
+// used by every email (eg. {{template "layout" .}}), and a directory named after a
+// locale (eg. fr/ or fr-CA/) can hold versions of emails in that locale.
+//
+// Emails can also be translated with the message catalogs in messages/ (see catalogs),
+// by using {{t "id"}} instead of writing their text. Every email has a version for
+// each locale with a catalog, unless it has its own version in that locale.
+type TemplateRegistry struct {
+	dir string
+
+	mu          sync.RWMutex
+	templates   map[string]*emailTemplate
+	fingerprint string
+}
+
+// emailTemplate is a parsed email and its schema
+type emailTemplate struct {
+	schema *templateSchema // nil if the email has no schema
+	// subject has the schema's subject for each locale in html, and is empty if there is no schema
+	subject map[string]*texttemplate.Template
+	// html has the parsed template for each locale that the email has
+	// a version for, and the default version under the empty locale
+	html map[string]*template.Template
+	// text has the plain text versions, which are optional for each locale
+	text map[string]*texttemplate.Template
+}
+
+// renderedEmail is an email that has been filled in with data
+type renderedEmail struct {
+	Subject string `json:"subject"`
+	HTML    string `json:"html"`
+	Text    string `json:"text"`
+}
+
+// TemplateInfo describes an email template, which is returned when listing them
+type TemplateInfo struct {
+	Name      string   `json:"name"`
+	Locales   []string `json:"locales"`
+	HasSchema bool     `json:"hasSchema"`
+}
+
+// LoadTemplates parses every template in a directory, and renders each one that has
+// a schema with sample data to check that it works. It fails if any template is broken.
+func LoadTemplates(dir string) (*TemplateRegistry, error) {
+	r := &TemplateRegistry{dir: dir}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Watch reloads the templates whenever a file in the directory changes, until the context
+// is done. It checks for changes every interval. If the new templates are broken, the
+// error is logged and the last templates that worked are kept.
+func (r *TemplateRegistry) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			fingerprint, err := fingerprintDir(r.dir)
+			if err != nil {
+				log.Println("unable to check email templates for changes:", err)
+				continue
+			}
+
+			r.mu.RLock()
+			changed := fingerprint != r.fingerprint
+			r.mu.RUnlock()
+
+			if !changed {
+				continue
+			}
+
+			if err := r.load(); err != nil {
+				log.Println("keeping the last email templates that worked:", err)
+				// the broken templates are not loaded again until they change
+				r.mu.Lock()
+				r.fingerprint = fingerprint
+				r.mu.Unlock()
+				continue
+			}
+			log.Println("reloaded email templates from", r.dir)
+		}
+	}
+}
+
+// load parses every template in the directory and replaces the templates in the registry
+func (r *TemplateRegistry) load() error {
+	fingerprint, err := fingerprintDir(r.dir)
+	if err != nil {
+		return err
+	}
+
+	cats, err := loadCatalogs(r.dir)
+	if err != nil {
+		return err
+	}
+
+	base, err := parseSharedTemplates(r.dir, cats.funcs(""))
+	if err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		return err
+	}
+
+	templates := make(map[string]*emailTemplate)
+	var localeDirs []string
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			if localePattern.MatchString(entry.Name()) && !isSharedTemplateDir(entry.Name()) {
+				localeDirs = append(localeDirs, entry.Name())
+			}
+			continue
+		}
+
+		name, ok := templateName(entry.Name())
+		if !ok {
+			continue
+		}
+
+		html, err := parseEmailTemplate(base, filepath.Join(r.dir, entry.Name()), name, cats.funcs(""))
+		if err != nil {
+			return err
+		}
+
+		et := &emailTemplate{
+			subject: make(map[string]*texttemplate.Template),
+			html:    map[string]*template.Template{"": html},
+			text:    make(map[string]*texttemplate.Template),
+		}
+		if err := et.parseText(filepath.Join(r.dir, name+".txt"), "", cats.funcs("")); err != nil {
+			return err
+		}
+		if et.schema, err = loadTemplateSchema(r.dir, name); err != nil && err != ErrTemplateNotFound {
+			return err
+		}
+
+		templates[name] = et
+	}
+
+	for _, locale := range localeDirs {
+		localeEntries, err := os.ReadDir(filepath.Join(r.dir, locale))
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range localeEntries {
+			name, ok := templateName(entry.Name())
+			if entry.IsDir() || !ok {
+				continue
+			}
+
+			et, ok := templates[name]
+			if !ok {
+				return fmt.Errorf("template %s/%s has no default version in %s", locale, entry.Name(), r.dir)
+			}
+
+			html, err := parseEmailTemplate(base, filepath.Join(r.dir, locale, entry.Name()), name, cats.funcs(locale))
+			if err != nil {
+				return err
+			}
+			et.html[locale] = html
+
+			if err := et.parseText(filepath.Join(r.dir, locale, name+".txt"), locale, cats.funcs(locale)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for name, et := range templates {
+		if err := et.translate(cats); err != nil {
+			return fmt.Errorf("unable to translate template %s: %w", name, err)
+		}
+
+		if et.schema == nil {
+			continue
+		}
+		for locale := range et.html {
+			subject, err := texttemplate.New("subject").Option("missingkey=zero").Funcs(cats.funcs(locale)).Parse(et.schema.Subject)
+			if err != nil {
+				return fmt.Errorf("invalid subject for template %s: %w", name, err)
+			}
+			et.subject[locale] = subject
+		}
+	}
+
+	// templates are rendered with sample data so that mistakes that
+	// are only found when they are executed also stop them from loading
+	var errs []string
+	for name, et := range templates {
+		if et.schema == nil {
+			continue
+		}
+		for locale := range et.html {
+			if _, err := et.render(locale, et.schema.sample()); err != nil {
+				errs = append(errs, fmt.Sprintf("%s (locale %q): %v", name, locale, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("invalid email templates: %s", strings.Join(errs, "; "))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.templates = templates
+	r.fingerprint = fingerprint
+
+	return nil
+}
+
+// schema returns the schema of a template, which is needed to send it with sendTemplatedEmail
+func (r *TemplateRegistry) schema(name string) (*templateSchema, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	et, ok := r.templates[name]
+	if !ok || et.schema == nil {
+		return nil, ErrTemplateNotFound
+	}
+
+	return et.schema, nil
+}
+
+// render fills in a template with data, using the version for the locale, or
+// for its language, if there is one. The subject is empty if it has no schema.
+func (r *TemplateRegistry) render(name, locale string, data map[string]any) (*renderedEmail, error) {
+	r.mu.RLock()
+	et, ok := r.templates[name]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, ErrTemplateNotFound
+	}
+
+	return et.render(localeFallback(locale, et.html), data)
+}
+
+// preview renders a template with the sample data of its schema
+func (r *TemplateRegistry) preview(name, locale string) (*renderedEmail, error) {
+	r.mu.RLock()
+	et, ok := r.templates[name]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, ErrTemplateNotFound
+	}
+
+	data := map[string]any{}
+	if et.schema != nil {
+		data = et.schema.sample()
+	}
+
+	return et.render(localeFallback(locale, et.html), data)
+}
+
+// list describes every template, sorted by name
+func (r *TemplateRegistry) list() []TemplateInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	infos := make([]TemplateInfo, 0, len(r.templates))
+	for name, et := range r.templates {
+		locales := make([]string, 0, len(et.html)-1)
+		for locale := range et.html {
+			if locale != "" {
+				locales = append(locales, locale)
+			}
+		}
+		sort.Strings(locales)
+
+		infos = append(infos, TemplateInfo{Name: name, Locales: locales, HasSchema: et.schema != nil})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+
+	return infos
+}
+
+func (et *emailTemplate) render(locale string, data map[string]any) (*renderedEmail, error) {
+	var email renderedEmail
+
+	htmlBody := new(bytes.Buffer)
+	if err := et.html[locale].Execute(htmlBody, data); err != nil {
+		return nil, err
+	}
+	email.HTML = htmlBody.String()
+
+	// the text version is only used for the same locale as the html, since
+	// one made from the html is better than one in a different language
+	if text, ok := et.text[locale]; ok {
+		var textBody strings.Builder
+		if err := text.Execute(&textBody, data); err != nil {
+			return nil, err
+		}
+		email.Text = textBody.String()
+	} else {
+		email.Text = htmlToText(email.HTML)
+	}
+
+	// subjects are not html, so they are filled in with text/template to not be escaped
+	if subjectTmpl, ok := et.subject[locale]; ok {
+		var subject strings.Builder
+		if err := subjectTmpl.Execute(&subject, data); err != nil {
+			return nil, err
+		}
+		email.Subject = subject.String()
+	}
+
+	return &email, nil
+}
+
+// translate adds a version of the email for every locale that has a message catalog
+// and no version of its own, which is the default version with that locale's messages
+func (et *emailTemplate) translate(cats catalogs) error {
+	for _, locale := range cats.locales() {
+		if _, ok := et.html[locale]; ok {
+			continue
+		}
+
+		// the default version can be cloned since it hasn't been executed yet
+		html, err := et.html[""].Clone()
+		if err != nil {
+			return err
+		}
+		et.html[locale] = html.Funcs(cats.funcs(locale))
+
+		if text, ok := et.text[""]; ok {
+			if text, err = text.Clone(); err != nil {
+				return err
+			}
+			et.text[locale] = text.Funcs(cats.funcs(locale))
+		}
+	}
+
+	return nil
+}
+
+// parseText parses the plain text version of an email for a locale, if it has one
+func (et *emailTemplate) parseText(path, locale string, funcs map[string]any) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	text, err := texttemplate.New(filepath.Base(path)).Option("missingkey=zero").Funcs(funcs).Parse(string(b))
+	if err != nil {
+		return fmt.Errorf("invalid email template %s: %w", path, err)
+	}
+	et.text[locale] = text
+
+	return nil
+}
+
+// localeFallback returns the locale (eg. fr-CA), or its language (eg. fr), if
+// there is a version of the email for it, or the empty locale of the default version
+func localeFallback(locale string, versions map[string]*template.Template) string {
+	if locale == "" {
+		return ""
+	}
+
+	if _, ok := versions[locale]; ok {
+		return locale
+	}
+
+	if language, _, found := strings.Cut(locale, "-"); found {
+		if _, ok := versions[language]; ok {
+			return language
+		}
+	}
+
+	return ""
+}
+
+// parseSharedTemplates parses the layouts and partials that every email can use
+func parseSharedTemplates(dir string, funcs map[string]any) (*template.Template, error) {
+	base := template.New("").Funcs(funcs)
+
+	for _, sharedDir := range sharedTemplateDirs {
+		paths, err := filepath.Glob(filepath.Join(dir, sharedDir, "*.html"))
+		if err != nil {
+			return nil, err
+		}
+		if len(paths) == 0 {
+			continue
+		}
+
+		if base, err = base.ParseFiles(paths...); err != nil {
+			return nil, fmt.Errorf("invalid shared email template: %w", err)
+		}
+	}
+
+	return base, nil
+}
+
+// parseEmailTemplate parses an email on top of a copy of the shared templates,
+// so that emails can each define the same blocks (eg. "content") for a layout.
+// The shared templates use the same functions as the email, so they are in its locale too.
+func parseEmailTemplate(base *template.Template, path, name string, funcs map[string]any) (*template.Template, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := base.Clone()
+	if err != nil {
+		return nil, err
+	}
+
+	if t, err = t.Funcs(funcs).New(name).Parse(string(b)); err != nil {
+		return nil, fmt.Errorf("invalid email template %s: %w", path, err)
+	}
+
+	return t, nil
+}
+
+// templateName returns the name of an email from its file name,
+// ignoring files that are not emails (eg. testdata's golden files)
+func templateName(fileName string) (string, bool) {
+	name := strings.TrimSuffix(fileName, ".html")
+	if name == fileName || !templateNamePattern.MatchString(name) {
+		return "", false
+	}
+
+	return name, true
+}
+
+func isSharedTemplateDir(name string) bool {
+	for _, sharedDir := range sharedTemplateDirs {
+		if name == sharedDir {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fingerprintDir summarises the name, size and modification time of every file
+// in a directory, so that Watch can tell when any of them has changed
+func fingerprintDir(dir string) (string, error) {
+	var b strings.Builder
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&b, "%s:%d:%d\n", path, info.Size(), info.ModTime().UnixNano())
+
+		return nil
+	})
+
+	return b.String(), err
+}
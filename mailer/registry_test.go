@@ -0,0 +1,270 @@
+package mailer
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTemplateFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		// files are replaced in one step, so that Watch never sees one half written
+		tmp := path + ".tmp"
+		require.NoError(t, os.WriteFile(tmp, []byte(contents), 0o644))
+		require.NoError(t, os.Rename(tmp, path))
+	}
+}
+
+func TestLoadTemplates(t *testing.T) {
+	testCases := []struct {
+		name      string
+		files     map[string]string
+		errString string
+	}{
+		{
+			name: "Success",
+			files: map[string]string{
+				"layouts/base.html":    `{{define "layout"}}<html>{{template "content" .}}</html>{{end}}`,
+				"hello.html":           `{{template "layout" .}}{{define "content"}}Hello {{.name}}{{end}}`,
+				"hello.schema.json":    `{"subject": "Hi", "fields": {"name": {"type": "string"}}}`,
+				"fr/hello.html":        `{{template "layout" .}}{{define "content"}}Bonjour {{.name}}{{end}}`,
+				"noschema.html":        `<p>no schema</p>`,
+				"notatemplate.txt":     `ignored`,
+				"partials/footer.html": `{{define "footer"}}bye{{end}}`,
+			},
+		},
+		{
+			name:      "ErrorInvalidSyntax",
+			files:     map[string]string{"hello.html": `Hello {{.name`},
+			errString: "invalid email template",
+		},
+		{
+			name: "ErrorMissingLayout",
+			files: map[string]string{
+				"hello.html":        `{{template "layout" .}}`,
+				"hello.schema.json": `{"subject": "Hi", "fields": {}}`,
+			},
+			errString: `no such template "layout"`,
+		},
+		{
+			name: "ErrorLocaleWithoutDefault",
+			files: map[string]string{
+				"fr/hello.html": `Bonjour`,
+			},
+			errString: "has no default version",
+		},
+		{
+			name: "ErrorInvalidSubject",
+			files: map[string]string{
+				"hello.html":        `Hello`,
+				"hello.schema.json": `{"subject": "{{.name", "fields": {}}`,
+			},
+			errString: "invalid subject for template hello",
+		},
+		{
+			name: "ErrorInvalidLimit",
+			files: map[string]string{
+				"hello.html":        `Hello`,
+				"hello.schema.json": `{"subject": "Hi", "fields": {}, "limits": {"recipient": "3 an hour"}}`,
+			},
+			errString: `invalid rate limit "3 an hour"`,
+		},
+		{
+			name: "ErrorMissingMessage",
+			files: map[string]string{
+				"messages/en.json":  `{"greeting": "Hello"}`,
+				"hello.html":        `{{t "farewell"}}`,
+				"hello.schema.json": `{"subject": "Hi", "fields": {}}`,
+			},
+			errString: `there is no message "farewell" for locale ""`,
+		},
+		{
+			name: "ErrorNoDefaultCatalog",
+			files: map[string]string{
+				"messages/fr.json": `{"greeting": "Bonjour"}`,
+				"hello.html":       `Hello`,
+			},
+			errString: "there is no message catalog for the default locale (en.json)",
+		},
+		{
+			name: "ErrorCatalogNotNamedAfterLocale",
+			files: map[string]string{
+				"messages/en.json":     `{}`,
+				"messages/french.json": `{}`,
+				"hello.html":           `Hello`,
+			},
+			errString: "is not named after a locale",
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTemplateFiles(t, dir, tt.files)
+
+			templates, err := LoadTemplates(dir)
+			if tt.errString != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errString)
+				return
+			}
+			require.NoError(t, err)
+
+			assert.Equal(t, []TemplateInfo{
+				{Name: "hello", Locales: []string{"fr"}, HasSchema: true},
+				{Name: "noschema", Locales: []string{}, HasSchema: false},
+			}, templates.list())
+		})
+	}
+}
+
+func TestTemplateRegistryTranslate(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplateFiles(t, dir, map[string]string{
+		"messages/en.json":  `{"greeting": "Hello {{.name}}", "subject": "Your tasks", "signoff": "Thanks!"}`,
+		"messages/fr.json":  `{"greeting": "Bonjour {{.name}}", "subject": "Vos tâches"}`,
+		"hello.html":        `<p>{{t "greeting" .}}</p><p>{{t "signoff"}}</p>`,
+		"hello.txt":         `{{t "greeting" .}}`,
+		"hello.schema.json": `{"subject": "{{t \"subject\"}}", "fields": {"name": {"type": "string", "default": "user"}}}`,
+		// the version in fr-CA still uses the catalogs for messages that it doesn't write itself
+		"fr-CA/hello.html": `<p>Salut {{.name}}</p><p>{{t "signoff"}}</p>`,
+	})
+
+	templates, err := LoadTemplates(dir)
+	require.NoError(t, err)
+
+	assert.Equal(t, []TemplateInfo{{Name: "hello", Locales: []string{"fr", "fr-CA"}, HasSchema: true}}, templates.list())
+
+	testCases := []struct {
+		locale  string
+		subject string
+		html    string
+		text    string
+	}{
+		// messages that the fr catalog doesn't have come from the default catalog
+		{locale: "fr", subject: "Vos tâches", html: "<p>Bonjour Michael</p><p>Thanks!</p>", text: "Bonjour Michael"},
+		{locale: "fr-BE", subject: "Vos tâches", html: "<p>Bonjour Michael</p><p>Thanks!</p>", text: "Bonjour Michael"},
+		{locale: "fr-CA", subject: "Vos tâches", html: "<p>Salut Michael</p><p>Thanks!</p>", text: "Salut Michael\n\nThanks!\n"},
+		{locale: "de", subject: "Your tasks", html: "<p>Hello Michael</p><p>Thanks!</p>", text: "Hello Michael"},
+		{locale: "", subject: "Your tasks", html: "<p>Hello Michael</p><p>Thanks!</p>", text: "Hello Michael"},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.locale, func(t *testing.T) {
+			email, err := templates.render("hello", tt.locale, map[string]any{"name": "Michael"})
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.subject, email.Subject)
+			assert.Equal(t, tt.html, email.HTML)
+			assert.Equal(t, tt.text, email.Text)
+		})
+	}
+}
+
+func TestLocaleChain(t *testing.T) {
+	assert.Equal(t, []string{"fr-CA", "fr", "en"}, localeChain("fr-CA"))
+	assert.Equal(t, []string{"fr", "en"}, localeChain("fr"))
+	assert.Equal(t, []string{"en-GB", "en"}, localeChain("en-GB"))
+	assert.Equal(t, []string{"en"}, localeChain("en"))
+	assert.Equal(t, []string{"en"}, localeChain(""))
+}
+
+func TestTemplateRegistryRender(t *testing.T) {
+	templates := mustLoadTemplates(t, "./templates")
+
+	email, err := templates.render("lockout", "", map[string]any{"name": "Michael", "hyperlink": "https://flatlist.com/login"})
+	require.NoError(t, err)
+
+	// the layout and the partial are used by every email
+	assert.Contains(t, email.HTML, "<!doctype html>")
+	assert.Contains(t, email.HTML, "Hello Michael,")
+	assert.Contains(t, email.HTML, `<a href="https://flatlist.com/login">Log in</a>`)
+	assert.Contains(t, email.HTML, "<p>Thanks!</p>")
+	assert.NotEmpty(t, email.Subject)
+
+	_, err = templates.render("missing", "", nil)
+	assert.ErrorIs(t, err, ErrTemplateNotFound)
+}
+
+func TestTemplateRegistryWatch(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplateFiles(t, dir, map[string]string{"hello.html": `Hello`})
+
+	templates, err := LoadTemplates(dir)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go templates.Watch(ctx, 10*time.Millisecond)
+
+	rendered := func() string {
+		email, err := templates.render("hello", "", nil)
+		require.NoError(t, err)
+		return email.HTML
+	}
+
+	writeTemplateFiles(t, dir, map[string]string{"hello.html": `Hello again`})
+	assert.Eventually(t, func() bool { return rendered() == "Hello again" }, time.Second, 10*time.Millisecond)
+
+	// the last templates that worked are kept when the new ones are broken
+	writeTemplateFiles(t, dir, map[string]string{"hello.html": `Hello {{.name`, "other.html": `Other`})
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, "Hello again", rendered())
+}
+
+func TestHandlePreviewTemplate(t *testing.T) {
+	service := NewService(&mockMailer{}, mustLoadTemplates(t, "./testdata"))
+	h := http.StripPrefix("/v1/mailer/preview/", HandlePreviewTemplate(service))
+
+	t.Run("Success", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/v1/mailer/preview/reminder?locale=fr-CA", nil)
+
+		h.ServeHTTP(rr, r)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "text/html; charset=utf-8", rr.Header().Get("Content-Type"))
+		assert.NotEmpty(t, rr.Header().Get("X-Email-Subject"))
+		assert.Contains(t, rr.Body.String(), "Bonjour")
+	})
+
+	t.Run("SuccessText", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/v1/mailer/preview/reminder?format=text", nil)
+
+		h.ServeHTTP(rr, r)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "text/plain; charset=utf-8", rr.Header().Get("Content-Type"))
+		assert.Contains(t, rr.Body.String(), "Hello user,\n")
+	})
+
+	t.Run("ErrorNotFound", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/v1/mailer/preview/missing", nil)
+
+		h.ServeHTTP(rr, r)
+
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	t.Run("ErrorMethodNotAllowed", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/v1/mailer/preview/reminder", nil)
+
+		h.ServeHTTP(rr, r)
+
+		assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	})
+}
@@ -0,0 +1,244 @@
+package mailer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/ricxi/flat-list/shared/validation"
+)
+
+// the types that a field in a template's schema can have
+const (
+	fieldString  = "string"
+	fieldURL     = "url"
+	fieldEmail   = "email"
+	fieldNumber  = "number"
+	fieldBoolean = "boolean"
+	fieldList    = "list"
+	fieldObject  = "object"
+)
+
+// templateSchema describes the data that a template is rendered with, and is stored
+// next to the template as <template>.schema.json. Only templates with a schema can
+// be sent with SendTemplatedEmail, since it is the only check on the data.
+type templateSchema struct {
+	// Subject is the subject line of the email, which can use the data like
+	// the template does (eg. "{{.name}}, your tasks for today")
+	Subject string                 `json:"subject"`
+	Fields  map[string]fieldSchema `json:"fields"`
+	// Limits replaces the service's recipient or sender rate limits for the template
+	// (eg. {"recipient": "3/1h"}), which then only count emails of the template
+	Limits *templateLimits `json:"limits"`
+}
+
+// templateLimits are the rate limits of a template, which are the service's if they are not set
+type templateLimits struct {
+	Recipient Limit `json:"recipient"`
+	Sender    Limit `json:"sender"`
+}
+
+// fieldSchema describes one field of a template's data
+type fieldSchema struct {
+	Type     string `json:"type"`
+	Required bool   `json:"required"`
+	// Default is used when the field is missing, instead of it being required
+	Default any `json:"default"`
+	// Example is used in the sample data that the template is previewed and checked with
+	Example any `json:"example"`
+	// Items describes the items of a list
+	Items *fieldSchema `json:"items"`
+	// Fields describes the fields of an object
+	Fields map[string]fieldSchema `json:"fields"`
+}
+
+// loadTemplateSchema reads the schema of a template from the templates directory
+func loadTemplateSchema(dir, tmplName string) (*templateSchema, error) {
+	b, err := os.ReadFile(filepath.Join(dir, tmplName+".schema.json"))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrTemplateNotFound
+		}
+		return nil, err
+	}
+
+	var schema templateSchema
+	if err := json.Unmarshal(b, &schema); err != nil {
+		return nil, fmt.Errorf("invalid schema for template %s: %w", tmplName, err)
+	}
+
+	return &schema, nil
+}
+
+// validate checks data against the schema, and returns a copy of it with
+// the defaults of missing fields. It returns a validation.Error with every
+// field that is missing, unknown or the wrong type, named by its path (eg. data.tasks[0].title).
+func (ts *templateSchema) validate(data map[string]any) (map[string]any, error) {
+	var vErr validation.Error
+	validated := validateObject(&vErr, "data", ts.Fields, data)
+
+	if err := vErr.Err(); err != nil {
+		return nil, err
+	}
+
+	return validated, nil
+}
+
+func validateObject(vErr *validation.Error, path string, fields map[string]fieldSchema, data map[string]any) map[string]any {
+	validated := make(map[string]any, len(fields))
+
+	// fields are checked in order so that errors are always in the same order
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		field := fields[name]
+		value, ok := data[name]
+		if !ok || value == nil {
+			if field.Default != nil {
+				validated[name] = field.Default
+			} else if field.Required {
+				vErr.Required(path + "." + name)
+			}
+			continue
+		}
+
+		validated[name] = validateField(vErr, path+"."+name, field, value)
+	}
+
+	unknown := make([]string, 0)
+	for name := range data {
+		if _, ok := fields[name]; !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		vErr.Add(path+"."+name, "unknown_field", fmt.Sprintf("%s.%s is not a field of this template", path, name))
+	}
+
+	return validated
+}
+
+func validateField(vErr *validation.Error, path string, field fieldSchema, value any) any {
+	invalid := func() any {
+		vErr.Add(path, "invalid_type", fmt.Sprintf("%s must be a %s", path, field.Type))
+		return nil
+	}
+
+	switch field.Type {
+	case fieldString:
+		if _, ok := value.(string); !ok {
+			return invalid()
+		}
+
+	case fieldURL:
+		s, ok := value.(string)
+		if !ok {
+			return invalid()
+		}
+		if u, err := url.Parse(s); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return invalid()
+		}
+
+	case fieldEmail:
+		s, ok := value.(string)
+		if !ok {
+			return invalid()
+		}
+		if _, err := mail.ParseAddress(s); err != nil {
+			return invalid()
+		}
+
+	case fieldNumber:
+		if _, ok := value.(float64); !ok {
+			return invalid()
+		}
+
+	case fieldBoolean:
+		if _, ok := value.(bool); !ok {
+			return invalid()
+		}
+
+	case fieldList:
+		items, ok := value.([]any)
+		if !ok {
+			return invalid()
+		}
+		if field.Items == nil {
+			return items
+		}
+		validated := make([]any, 0, len(items))
+		for i, item := range items {
+			validated = append(validated, validateField(vErr, fmt.Sprintf("%s[%d]", path, i), *field.Items, item))
+		}
+		return validated
+
+	case fieldObject:
+		object, ok := value.(map[string]any)
+		if !ok {
+			return invalid()
+		}
+		return validateObject(vErr, path, field.Fields, object)
+
+	default:
+		vErr.Add(path, "unknown_type", fmt.Sprintf("%s has an unknown type %q in the template's schema", path, field.Type))
+		return nil
+	}
+
+	return value
+}
+
+// sample creates data that matches the schema, which is used to check that a template
+// can be rendered when it is loaded, and to preview it. Every field is included,
+// so that the parts of the template that are only shown for some data are checked too.
+func (ts *templateSchema) sample() map[string]any {
+	return sampleObject(ts.Fields)
+}
+
+func sampleObject(fields map[string]fieldSchema) map[string]any {
+	sample := make(map[string]any, len(fields))
+	for name, field := range fields {
+		sample[name] = sampleField(name, field)
+	}
+
+	return sample
+}
+
+func sampleField(name string, field fieldSchema) any {
+	if field.Example != nil {
+		return field.Example
+	}
+	if field.Default != nil {
+		return field.Default
+	}
+
+	switch field.Type {
+	case fieldURL:
+		return "https://example.com/" + name
+	case fieldEmail:
+		return "someone@example.com"
+	case fieldNumber:
+		return 1.0
+	case fieldBoolean:
+		return true
+	case fieldList:
+		if field.Items == nil {
+			return []any{}
+		}
+		return []any{sampleField(name, *field.Items)}
+	case fieldObject:
+		return sampleObject(field.Fields)
+	default:
+		return "example " + name
+	}
+}
@@ -1,51 +1,133 @@
 package mailer
 
 import (
-	"bytes"
+	"context"
 	"errors"
 	"fmt"
-	"html/template"
-	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/ricxi/flat-list/shared/validation"
 )
 
-var ErrMissingField = errors.New("missing field is required")
+var ErrMissingField = validation.ErrMissingField
+var ErrTemplateNotFound = errors.New("email template not found")
 
-const (
-	// Subject line for the user activation email
-	activationEmailSubject string = "Please activate your account"
-)
+// templateNamePattern matches the names of templates, which are the names of their files without .html
+var templateNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// localePattern matches language tags such as en, fr-CA or zh-Hant-TW (RFC 5646)
+var localePattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// maxAttachmentsSize is the most that the attachments of an email can add up to, since
+// most email providers reject emails over 25 MB, and base64 makes attachments a third bigger
+const maxAttachmentsSize = 15 << 20
 
 // Service defines methods that receive email data inputs,
 // and prepares and validates those inputs before calling
 // methods from the Mailer type to send that data out in an email.
 // It can be implemented by any infrastructure to send emails.
 type Service struct {
-	mailer            Mailer
-	emailTemplatesDir string // this cannot be empty
+	mailer    Mailer
+	templates *TemplateRegistry // this cannot be nil
+	queue     *Queue            // emails are sent straight away if this is nil
+	// suppressions are the addresses that emails are not sent to (NewService always sets this)
+	suppressions SuppressionList
+	limits       RateLimits
+	limiter      *rateLimiter // emails are not rate limited if this is nil
+}
+
+type ServiceOption func(*Service)
+
+// WithQueue queues emails to be sent in the background instead of sending them straight away.
+// They are still validated and rendered straight away, so that bad requests are rejected.
+func WithQueue(queue *Queue) ServiceOption {
+	return func(s *Service) {
+		s.queue = queue
+	}
+}
+
+// WithSuppressionList checks emails against a suppression list that is kept somewhere
+// other than memory, so that it is shared with other instances of the mailer
+func WithSuppressionList(list SuppressionList) ServiceOption {
+	return func(s *Service) {
+		s.suppressions = list
+	}
 }
 
-func NewService(mailer Mailer, emailTemplatesDir string) *Service {
-	return &Service{
-		mailer:            mailer,
-		emailTemplatesDir: emailTemplatesDir,
+// WithRateLimits limits how many emails are sent to each recipient, from each sender and
+// altogether (see RateLimits). Emails over a limit are rejected with a RateLimitError.
+func WithRateLimits(limits RateLimits) ServiceOption {
+	return func(s *Service) {
+		s.limits = limits
 	}
 }
 
+func NewService(mailer Mailer, templates *TemplateRegistry, opts ...ServiceOption) *Service {
+	s := &Service{
+		mailer:       mailer,
+		templates:    templates,
+		suppressions: NewMemorySuppressionList(),
+		limiter:      newRateLimiter(),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
 // sendActivationEmail validates email data, then generates all the
 // necessary templates and inputs necessary, before sending an
 // activation email to a user.
-// TODO: Pull validation into its own function/struct?
-func (s *Service) sendActivationEmail(data ActivationEmailData) error {
+func (s *Service) sendActivationEmail(data ActivationEmailData) (string, error) {
+	return s.sendHyperlinkEmail("useractivation", data)
+}
+
+// sendEmailChangeEmail sends an email with a confirmation link to the new
+// address a user wants to use, which must be followed before it replaces their old one.
+func (s *Service) sendEmailChangeEmail(data EmailChangeEmailData) (string, error) {
+	return s.sendHyperlinkEmail("emailchange", ActivationEmailData{
+		From:   data.From,
+		To:     data.To,
+		Locale: data.Locale,
+		ActivationData: ActivationData{
+			Name:      data.Name,
+			Hyperlink: data.Hyperlink,
+		},
+	})
+}
+
+// sendDataExportEmail sends a user a link to download the data they exported.
+func (s *Service) sendDataExportEmail(data ActivationEmailData) (string, error) {
+	return s.sendHyperlinkEmail("dataexport", data)
+}
+
+// sendLockoutEmail tells a user that their account was temporarily locked
+// after too many failed login attempts.
+func (s *Service) sendLockoutEmail(data ActivationEmailData) (string, error) {
+	return s.sendHyperlinkEmail("lockout", data)
+}
+
+// sendHyperlinkEmail validates email data, then fills in the given
+// template with the recipient's name and a hyperlink before sending it.
+// The email is translated to the data's locale, and has the subject of the
+// template in that locale unless the data has one.
+// It returns a validation.Error with every missing field, or the id of the
+// email's job if it was queued.
+func (s *Service) sendHyperlinkEmail(tmplName string, data ActivationEmailData) (string, error) {
+	var vErr validation.Error
 	if data.From == "" {
-		return fmt.Errorf("%w: from", ErrMissingField)
+		vErr.Required("from")
 	}
 
 	if data.To == "" {
-		return fmt.Errorf("%w: to", ErrMissingField)
+		vErr.Required("to")
 	}
 
-	if data.Subject == "" {
-		return fmt.Errorf("%w: subject", ErrMissingField)
+	if data.Locale != "" && !localePattern.MatchString(data.Locale) {
+		vErr.Add("locale", "invalid_locale", "locale must be a language tag, such as en or fr-CA")
 	}
 
 	if data.Name == "" {
@@ -54,19 +136,171 @@ func (s *Service) sendActivationEmail(data ActivationEmailData) error {
 	}
 
 	if data.Hyperlink == "" {
-		return fmt.Errorf("%w: activationHyperlink", ErrMissingField)
+		vErr.Required("activationHyperlink")
+	}
+
+	if err := vErr.Err(); err != nil {
+		return "", err
+	}
+
+	tmplData := map[string]any{"name": data.Name, "hyperlink": data.Hyperlink}
+	email, err := s.templates.render(tmplName, data.Locale, tmplData)
+	if err != nil {
+		return "", err
+	}
+
+	subject := data.Subject
+	if subject == "" {
+		subject = email.Subject
+	}
+
+	return s.deliver(tmplName, &message{
+		From:    data.From,
+		To:      data.To,
+		Subject: subject,
+		HTML:    email.HTML,
+		Text:    email.Text,
+	})
+}
+
+// sendTemplatedEmail sends any template that has a schema, after checking the data against it,
+// so that new emails don't need their own methods. The subject comes from the schema.
+// The version of the template for the locale, or for its language, is used if there is one.
+func (s *Service) sendTemplatedEmail(data TemplatedEmailData) (string, error) {
+	var vErr validation.Error
+	if data.From == "" {
+		vErr.Required("from")
+	}
+
+	if data.To == "" {
+		vErr.Required("to")
+	}
+
+	if data.Template == "" {
+		vErr.Required("template")
+	}
+
+	if data.Locale != "" && !localePattern.MatchString(data.Locale) {
+		vErr.Add("locale", "invalid_locale", "locale must be a language tag, such as en or fr-CA")
+	}
+
+	validateAttachments(&vErr, data.Attachments)
+
+	if err := vErr.Err(); err != nil {
+		return "", err
 	}
 
-	activationEmailTmpl := filepath.Join(s.emailTemplatesDir, "useractivation.html")
-	t, err := template.ParseFiles(activationEmailTmpl)
+	schema, err := s.templates.schema(data.Template)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	htmlBody := new(bytes.Buffer)
-	if err := t.Execute(htmlBody, data.ActivationData); err != nil {
-		return err
+	tmplData, err := schema.validate(data.Data)
+	if err != nil {
+		return "", err
 	}
 
-	return s.mailer.send(data.From, data.To, data.Subject, htmlBody.String())
+	email, err := s.templates.render(data.Template, data.Locale, tmplData)
+	if err != nil {
+		return "", err
+	}
+
+	return s.deliver(data.Template, &message{
+		From:        data.From,
+		To:          data.To,
+		Subject:     email.Subject,
+		HTML:        email.HTML,
+		Text:        email.Text,
+		Attachments: data.Attachments,
+	})
+}
+
+// deliver queues an email if the service has a queue, and otherwise sends it straight away.
+// It returns the id of the email's job, which is empty if it was not queued,
+// ErrRecipientSuppressed if the recipient is on the suppression list, or a
+// RateLimitError if the email would go over one of the template's rate limits.
+func (s *Service) deliver(tmplName string, msg *message) (string, error) {
+	if s.suppressions != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		defer cancel()
+
+		if err := checkSuppressed(ctx, s.suppressions, msg.To); err != nil {
+			return "", err
+		}
+	}
+
+	if err := s.takeRateLimits(tmplName, msg); err != nil {
+		return "", err
+	}
+
+	if s.queue != nil {
+		return s.queue.enqueue(msg)
+	}
+
+	return "", s.mailer.send(msg)
+}
+
+// takeRateLimits counts an email against the rate limits of its template. A template with its own
+// recipient or sender limit has its own buckets, so its emails don't use up those of other templates.
+func (s *Service) takeRateLimits(tmplName string, msg *message) error {
+	if s.limiter == nil {
+		return nil
+	}
+
+	recipient := bucketKey{scope: limitRecipient, key: normalizeAddress(msg.To), limit: s.limits.Recipient}
+	sender := bucketKey{scope: limitSender, key: normalizeAddress(msg.From), limit: s.limits.Sender}
+	if schema, err := s.templates.schema(tmplName); err == nil && schema.Limits != nil {
+		if schema.Limits.Recipient.Count > 0 {
+			recipient.key, recipient.limit = tmplName+":"+recipient.key, schema.Limits.Recipient
+		}
+		if schema.Limits.Sender.Count > 0 {
+			sender.key, sender.limit = tmplName+":"+sender.key, schema.Limits.Sender
+		}
+	}
+
+	return s.limiter.take(recipient, sender, bucketKey{scope: limitGlobal, limit: s.limits.Global})
+}
+
+// getJob returns the status of a queued email
+func (s *Service) getJob(id string) (*Job, error) {
+	if s.queue == nil || id == "" {
+		return nil, ErrJobNotFound
+	}
+
+	return s.queue.job(id)
+}
+
+// validateAttachments checks that every attachment has a plain filename, which inline images are
+// referred to by, and content, and that they are not too big together to be sent in an email
+func validateAttachments(vErr *validation.Error, attachments []Attachment) {
+	size := 0
+	for i, a := range attachments {
+		field := fmt.Sprintf("attachments[%d]", i)
+
+		if a.Filename == "" {
+			vErr.Required(field + ".filename")
+		} else if strings.ContainsAny(a.Filename, `/\<>"`) {
+			vErr.Add(field+".filename", "invalid_filename", field+".filename must not contain /, \\, <, > or \"")
+		}
+
+		if len(a.Content) == 0 {
+			vErr.Required(field + ".content")
+		}
+
+		size += len(a.Content)
+	}
+
+	if size > maxAttachmentsSize {
+		vErr.Add("attachments", "too_large", fmt.Sprintf("attachments must be %d MB or less in total", maxAttachmentsSize/(1<<20)))
+	}
+}
+
+// previewTemplate renders a template with sample data, so that it can be checked without sending it
+func (s *Service) previewTemplate(tmplName, locale string) (*renderedEmail, error) {
+	return s.templates.preview(tmplName, locale)
+}
+
+// listTemplates describes every template that the mailer can send
+func (s *Service) listTemplates() []TemplateInfo {
+	return s.templates.list()
 }
@@ -5,17 +5,22 @@ import (
 	"os"
 	"testing"
 
+	"github.com/ricxi/flat-list/shared/validation"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 type mockMailer struct {
-	out string
-	err error
+	out     string
+	subject string
+	msg     *message
+	err     error
 }
 
-func (m *mockMailer) send(from, to, subject, body string) error {
-	m.out = body
+func (m *mockMailer) send(msg *message) error {
+	m.out = msg.HTML
+	m.subject = msg.Subject
+	m.msg = msg
 	return m.err
 }
 
@@ -42,7 +47,7 @@ func TestServiceSendActivationEmail(t *testing.T) {
 				mailer: &mockMailer{
 					err: nil,
 				},
-				emailTemplatesDir: "./templates",
+				templates: mustLoadTemplates(t, "./templates"),
 			},
 			args: args{
 				data: ActivationEmailData{
@@ -61,7 +66,7 @@ func TestServiceSendActivationEmail(t *testing.T) {
 		},
 		{
 			name:    "MissingToField",
-			service: Service{mailer: nil, emailTemplatesDir: ""},
+			service: Service{mailer: nil},
 			args: args{
 				data: ActivationEmailData{
 					From:    "theteam@flatlist.com",
@@ -78,7 +83,7 @@ func TestServiceSendActivationEmail(t *testing.T) {
 		},
 		{
 			name:    "MissingFromField",
-			service: Service{mailer: nil, emailTemplatesDir: ""},
+			service: Service{mailer: nil},
 			args: args{
 				data: ActivationEmailData{
 					To:      "michaelscott@dundermifflin.com",
@@ -94,12 +99,13 @@ func TestServiceSendActivationEmail(t *testing.T) {
 			},
 		},
 		{
-			name:    "MissingSubjectField",
-			service: Service{mailer: nil, emailTemplatesDir: ""},
+			name:    "InvalidLocaleField",
+			service: Service{mailer: nil},
 			args: args{
 				data: ActivationEmailData{
-					From: "theteam@flatlist.com",
-					To:   "michaelscott@dundermifflin.com",
+					From:   "theteam@flatlist.com",
+					To:     "michaelscott@dundermifflin.com",
+					Locale: "not a locale",
 					ActivationData: ActivationData{
 						Name:      "Michael",
 						Hyperlink: "http://localhost:5000/clickme",
@@ -107,12 +113,12 @@ func TestServiceSendActivationEmail(t *testing.T) {
 				},
 			},
 			expected: expected{
-				errString: "missing field is required: subject",
+				errString: "locale must be a language tag, such as en or fr-CA",
 			},
 		},
 		{
 			name:    "MissingActivationHyperLinkField",
-			service: Service{mailer: nil, emailTemplatesDir: ""},
+			service: Service{mailer: nil},
 			args: args{
 				data: ActivationEmailData{
 					From:    "theteam@flatlist.com",
@@ -131,7 +137,7 @@ func TestServiceSendActivationEmail(t *testing.T) {
 
 	for _, tt := range testCases {
 		t.Run(tt.name, func(t *testing.T) {
-			err := tt.service.sendActivationEmail(tt.args.data)
+			_, err := tt.service.sendActivationEmail(tt.args.data)
 			if err != nil {
 				assert.NotEmpty(t, err)
 				assert.EqualError(t, err, tt.expected.errString)
@@ -154,8 +160,8 @@ func TestServiceSendActivationEmailContents(t *testing.T) {
 		// mockMailerDest is used to get the email body
 		mockMailerDst := mockMailer{}
 		service := &Service{
-			emailTemplatesDir: inputEmailTmpl,
-			mailer:            &mockMailerDst,
+			templates: mustLoadTemplates(t, inputEmailTmpl),
+			mailer:    &mockMailerDst,
 		}
 
 		data := ActivationEmailData{
@@ -168,7 +174,7 @@ func TestServiceSendActivationEmailContents(t *testing.T) {
 			},
 		}
 
-		err := service.sendActivationEmail(data)
+		_, err := service.sendActivationEmail(data)
 		assert.NoError(t, err)
 
 		expected, err := os.ReadFile(goldenFile)
@@ -188,8 +194,8 @@ func TestServiceSendActivationEmailContents(t *testing.T) {
 
 		mockMailerDst := mockMailer{}
 		service := &Service{
-			emailTemplatesDir: inputEmailTmpl,
-			mailer:            &mockMailerDst,
+			templates: mustLoadTemplates(t, inputEmailTmpl),
+			mailer:    &mockMailerDst,
 		}
 
 		data := ActivationEmailData{
@@ -202,7 +208,7 @@ func TestServiceSendActivationEmailContents(t *testing.T) {
 			},
 		}
 
-		err := service.sendActivationEmail(data)
+		_, err := service.sendActivationEmail(data)
 		assert.NoError(t, err)
 
 		expected, err := os.ReadFile(goldenFile)
@@ -214,3 +220,298 @@ func TestServiceSendActivationEmailContents(t *testing.T) {
 		// assert.Equal(t, expected, []byte(mockMailerDst.out))
 	})
 }
+
+func TestServiceSendActivationEmailLocale(t *testing.T) {
+	templates := mustLoadTemplates(t, "./templates")
+
+	testCases := []struct {
+		name    string
+		locale  string
+		subject string
+		content string
+	}{
+		{name: "French", locale: "fr", subject: "Veuillez activer votre compte", content: "<p>Bonjour Michael,</p>"},
+		{name: "FallsBackToLanguage", locale: "fr-CA", subject: "Veuillez activer votre compte", content: "Activer votre compte</a>"},
+		{name: "FallsBackToDefault", locale: "de-DE", subject: "Please activate your account", content: "Activate your account</a>"},
+		{name: "Default", locale: "", subject: "Please activate your account", content: "<p>Hello Michael,</p>"},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			// every case has its own service, since activation emails are rate limited for each recipient
+			m := &mockMailer{}
+			s := NewService(m, templates)
+
+			_, err := s.sendActivationEmail(ActivationEmailData{
+				To:     "michaelscott@dundermifflin.com",
+				From:   "theteam@flatlist.com",
+				Locale: tt.locale,
+				ActivationData: ActivationData{
+					Name:      "Michael",
+					Hyperlink: "http://localhost:5000/clickme",
+				},
+			})
+			require.NoError(t, err)
+
+			// the subject comes from the template when the request doesn't have one
+			assert.Equal(t, tt.subject, m.subject)
+			assert.Contains(t, m.out, tt.content)
+		})
+	}
+}
+
+func TestServiceSendEmailChangeEmail(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		mockMailerDst := mockMailer{}
+		service := &Service{
+			templates: mustLoadTemplates(t, "./templates"),
+			mailer:    &mockMailerDst,
+		}
+
+		data := EmailChangeEmailData{
+			To:   "michaelscott@dundermifflin.com",
+			From: "theteam@flatlist.com",
+			EmailChangeData: EmailChangeData{
+				Name:      "Michael",
+				Hyperlink: "http://localhost:5000/clickme",
+			},
+		}
+
+		_, err := service.sendEmailChangeEmail(data)
+		require.NoError(t, err)
+
+		assert.Contains(t, mockMailerDst.out, "Hello Michael,")
+		assert.Contains(t, mockMailerDst.out, `<a href="http://localhost:5000/clickme">Confirm your email address</a>`)
+	})
+
+	t.Run("MissingHyperlinkField", func(t *testing.T) {
+		service := &Service{mailer: nil}
+
+		data := EmailChangeEmailData{
+			To:   "michaelscott@dundermifflin.com",
+			From: "theteam@flatlist.com",
+			EmailChangeData: EmailChangeData{
+				Name: "Michael",
+			},
+		}
+
+		_, err := service.sendEmailChangeEmail(data)
+		assert.EqualError(t, err, "missing field is required: activationHyperlink")
+	})
+}
+
+func TestServiceSendDataExportEmail(t *testing.T) {
+	mockMailerDst := mockMailer{}
+	service := &Service{
+		templates: mustLoadTemplates(t, "./templates"),
+		mailer:    &mockMailerDst,
+	}
+
+	data := ActivationEmailData{
+		To:      "michaelscott@dundermifflin.com",
+		From:    "theteam@flatlist.com",
+		Subject: "Your data export is ready",
+		ActivationData: ActivationData{
+			Name:      "Michael",
+			Hyperlink: "http://localhost:5000/download",
+		},
+	}
+
+	_, err := service.sendDataExportEmail(data)
+	require.NoError(t, err)
+
+	assert.Contains(t, mockMailerDst.out, `<a href="http://localhost:5000/download">Download your data</a>`)
+}
+
+func TestServiceSendLockoutEmail(t *testing.T) {
+	mockMailerDst := mockMailer{}
+	service := &Service{
+		templates: mustLoadTemplates(t, "./templates"),
+		mailer:    &mockMailerDst,
+	}
+
+	data := ActivationEmailData{
+		To:      "michaelscott@dundermifflin.com",
+		From:    "theteam@flatlist.com",
+		Subject: "Your account has been temporarily locked",
+		ActivationData: ActivationData{
+			Name:      "Michael",
+			Hyperlink: "http://localhost:5173/login",
+		},
+	}
+
+	_, err := service.sendLockoutEmail(data)
+	require.NoError(t, err)
+
+	assert.Contains(t, mockMailerDst.out, "too many failed attempts")
+}
+
+func TestServiceSendTemplatedEmail(t *testing.T) {
+	tasks := []any{
+		map[string]any{"title": "Sell paper", "link": "https://flatlist.com/tasks/1", "overdue": true},
+		map[string]any{"title": "Plan party", "link": "https://flatlist.com/tasks/2"},
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		m := &mockMailer{}
+		s := NewService(m, mustLoadTemplates(t, "./testdata"))
+
+		_, err := s.sendTemplatedEmail(TemplatedEmailData{
+			From:     "theteam@flatlist.com",
+			To:       "michaelscott@dundermifflin.com",
+			Template: "reminder",
+			Data:     map[string]any{"name": "Michael", "tasks": tasks},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "Michael, you have 2 tasks due", m.subject)
+		assert.Contains(t, m.out, "<p>Hello Michael,</p>")
+		assert.Contains(t, m.out, `<a href="https://flatlist.com/tasks/1">Sell paper</a> (overdue)`)
+		// the text version comes from reminder.txt
+		assert.Equal(t, "Hello Michael,\n\n- Sell paper (overdue): https://flatlist.com/tasks/1\n- Plan party: https://flatlist.com/tasks/2\n", m.msg.Text)
+	})
+
+	t.Run("SuccessWithAttachments", func(t *testing.T) {
+		m := &mockMailer{}
+		s := NewService(m, mustLoadTemplates(t, "./testdata"))
+
+		attachments := []Attachment{
+			{Filename: "logo.png", Content: []byte("png"), Inline: true},
+			{Filename: "tasks.csv", ContentType: "text/csv", Content: []byte("title\nSell paper\n")},
+		}
+		_, err := s.sendTemplatedEmail(TemplatedEmailData{
+			From:        "theteam@flatlist.com",
+			To:          "michaelscott@dundermifflin.com",
+			Template:    "reminder",
+			Data:        map[string]any{"tasks": tasks},
+			Attachments: attachments,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, attachments, m.msg.Attachments)
+	})
+
+	t.Run("SuccessLocaleFallsBackToLanguage", func(t *testing.T) {
+		m := &mockMailer{}
+		s := NewService(m, mustLoadTemplates(t, "./testdata"))
+
+		_, err := s.sendTemplatedEmail(TemplatedEmailData{
+			From:     "theteam@flatlist.com",
+			To:       "michaelscott@dundermifflin.com",
+			Template: "reminder",
+			Locale:   "fr-CA",
+			Data:     map[string]any{"tasks": tasks},
+		})
+		require.NoError(t, err)
+		assert.Contains(t, m.out, "<p>Bonjour user,</p>")
+		// there is no fr/reminder.txt, so the text version is made from the html instead of using the english one
+		assert.Contains(t, m.msg.Text, "Bonjour user,\n\n- Sell paper (https://flatlist.com/tasks/1) (en retard)\n")
+	})
+
+	t.Run("FailInvalidAttachments", func(t *testing.T) {
+		s := NewService(&mockMailer{}, mustLoadTemplates(t, "./testdata"))
+
+		_, err := s.sendTemplatedEmail(TemplatedEmailData{
+			From:     "theteam@flatlist.com",
+			To:       "michaelscott@dundermifflin.com",
+			Template: "reminder",
+			Data:     map[string]any{"tasks": tasks},
+			Attachments: []Attachment{
+				{Filename: "../logo.png", Content: []byte("png")},
+				{Filename: "big.bin", Content: make([]byte, maxAttachmentsSize)},
+				{},
+			},
+		})
+		assert.EqualError(t, err, `attachments[0].filename must not contain /, \, <, > or "; `+
+			"missing field is required: attachments[2].filename; missing field is required: attachments[2].content; "+
+			"attachments must be 15 MB or less in total")
+	})
+
+	t.Run("FailInvalidData", func(t *testing.T) {
+		s := NewService(&mockMailer{}, mustLoadTemplates(t, "./testdata"))
+
+		_, err := s.sendTemplatedEmail(TemplatedEmailData{
+			From:     "theteam@flatlist.com",
+			To:       "michaelscott@dundermifflin.com",
+			Template: "reminder",
+			Data: map[string]any{
+				"name":  42.0,
+				"tasks": []any{map[string]any{"title": "Sell paper", "link": "javascript:alert(1)"}},
+				"extra": true,
+			},
+		})
+		assert.ErrorIs(t, err, validation.ErrInvalidField)
+		assert.EqualError(t, err, "data.name must be a string; data.tasks[0].link must be a url; "+
+			"data.extra is not a field of this template")
+	})
+
+	t.Run("FailTemplateNotFound", func(t *testing.T) {
+		s := NewService(&mockMailer{}, mustLoadTemplates(t, "./testdata"))
+
+		for _, tmplName := range []string{"missing", "../templates/useractivation", "useractivation"} {
+			_, err := s.sendTemplatedEmail(TemplatedEmailData{
+				From:     "theteam@flatlist.com",
+				To:       "michaelscott@dundermifflin.com",
+				Template: tmplName,
+			})
+			assert.ErrorIs(t, err, ErrTemplateNotFound, tmplName)
+		}
+	})
+
+	t.Run("FailMissingFields", func(t *testing.T) {
+		s := NewService(&mockMailer{}, mustLoadTemplates(t, "./testdata"))
+
+		_, err := s.sendTemplatedEmail(TemplatedEmailData{Locale: "not a locale"})
+		assert.EqualError(t, err, "missing field is required: from; missing field is required: to; "+
+			"missing field is required: template; locale must be a language tag, such as en or fr-CA")
+	})
+}
+
+// every template that is shipped must have a schema that it can be rendered with
+func TestTemplatesHaveSchemas(t *testing.T) {
+	m := &mockMailer{}
+	s := NewService(m, mustLoadTemplates(t, "./templates"))
+
+	for _, tmplName := range []string{"useractivation", "emailchange", "dataexport", "lockout"} {
+		_, err := s.sendTemplatedEmail(TemplatedEmailData{
+			From:     "theteam@flatlist.com",
+			To:       "michaelscott@dundermifflin.com",
+			Template: tmplName,
+			Data:     map[string]any{"hyperlink": "https://flatlist.com/link"},
+		})
+		require.NoError(t, err, tmplName)
+		assert.Contains(t, m.out, `href="https://flatlist.com/link"`, tmplName)
+		assert.NotEmpty(t, m.subject, tmplName)
+	}
+}
+
+func mustLoadTemplates(t *testing.T, dir string) *TemplateRegistry {
+	t.Helper()
+
+	templates, err := LoadTemplates(dir)
+	require.NoError(t, err)
+
+	return templates
+}
+
+func TestServiceSendDigestEmail(t *testing.T) {
+	m := &mockMailer{}
+	s := NewService(m, mustLoadTemplates(t, "./templates"))
+
+	_, err := s.sendTemplatedEmail(TemplatedEmailData{
+		From:     "theteam@flatlist.com",
+		To:       "michaelscott@dundermifflin.com",
+		Template: "digest",
+		Locale:   "fr",
+		Data: map[string]any{
+			"name":      "Michael",
+			"date":      "2023-05-01",
+			"overdue":   []any{map[string]any{"title": "File taxes", "dueDate": "2023-04-28"}},
+			"hyperlink": "http://localhost:5173/tasks",
+		},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "Vos tâches du 2023-05-01", m.subject)
+	assert.Contains(t, m.out, "File taxes <small>(à faire pour le 2023-04-28)</small>")
+	// there is no heading for a list without tasks
+	assert.NotContains(t, m.out, "aujourd&#39;hui</h3>")
+}
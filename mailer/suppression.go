@@ -0,0 +1,266 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ricxi/flat-list/shared/validation"
+)
+
+// ErrRecipientSuppressed is returned instead of sending an email to an address on the suppression list
+var ErrRecipientSuppressed = errors.New("recipient is on the suppression list")
+var ErrSuppressionNotFound = errors.New("suppression not found")
+
+// the reasons that an address can be suppressed
+const (
+	// SuppressionBounce addresses hard-bounced, so they don't exist or can never receive email
+	SuppressionBounce = "bounce"
+	// SuppressionComplaint addresses marked an email as spam
+	SuppressionComplaint = "complaint"
+	// SuppressionManual addresses were added by an admin
+	SuppressionManual = "manual"
+)
+
+// Suppression is an address that emails are no longer sent to
+type Suppression struct {
+	Email  string `json:"email"`
+	Reason string `json:"reason"`
+	// Detail is what the email provider said about the bounce or complaint, or a note from an admin
+	Detail    string    `json:"detail,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// SuppressionList stores the addresses that emails must not be sent to. Addresses
+// are stored in lowercase (see normalizeAddress), so that they match however they are written.
+type SuppressionList interface {
+	// suppress adds an address to the list, or replaces the reason that it is on the list
+	suppress(ctx context.Context, s Suppression) error
+	getSuppression(ctx context.Context, email string) (*Suppression, error)
+	// listSuppressions returns every suppression, newest first, or only those with a reason if it is not empty
+	listSuppressions(ctx context.Context, reason string) ([]Suppression, error)
+	removeSuppression(ctx context.Context, email string) error
+}
+
+// normalizeAddress is the form that addresses are stored and looked up in
+func normalizeAddress(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func isSuppressionReason(reason string) bool {
+	switch reason {
+	case SuppressionBounce, SuppressionComplaint, SuppressionManual:
+		return true
+	}
+
+	return false
+}
+
+// checkSuppressed returns ErrRecipientSuppressed, with the reason, if an address is on the list
+func checkSuppressed(ctx context.Context, list SuppressionList, email string) error {
+	s, err := list.getSuppression(ctx, normalizeAddress(email))
+	if err != nil {
+		if errors.Is(err, ErrSuppressionNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	return fmt.Errorf("%w: %s (%s)", ErrRecipientSuppressed, s.Email, s.Reason)
+}
+
+// suppressingMailer checks every email against the suppression list right before it is sent
+type suppressingMailer struct {
+	Mailer
+	list SuppressionList
+}
+
+// NewSuppressingMailer wraps a Mailer so that it never sends to an address on the suppression
+// list, which includes emails that were queued before their address was added to it.
+// It returns ErrRecipientSuppressed instead, which the queue doesn't try again.
+func NewSuppressingMailer(m Mailer, list SuppressionList) Mailer {
+	return &suppressingMailer{Mailer: m, list: list}
+}
+
+func (m *suppressingMailer) send(msg *message) error {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	if err := checkSuppressed(ctx, m.list, msg.To); err != nil {
+		return err
+	}
+
+	return m.Mailer.send(msg)
+}
+
+// memorySuppressionList keeps the suppression list in memory, for when the mailer has no database
+type memorySuppressionList struct {
+	mu           sync.Mutex
+	suppressions map[string]Suppression
+}
+
+// NewMemorySuppressionList creates a suppression list that is lost when the mailer stops
+func NewMemorySuppressionList() SuppressionList {
+	return &memorySuppressionList{suppressions: make(map[string]Suppression)}
+}
+
+func (l *memorySuppressionList) suppress(ctx context.Context, s Suppression) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.suppressions[s.Email] = s
+
+	return nil
+}
+
+func (l *memorySuppressionList) getSuppression(ctx context.Context, email string) (*Suppression, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s, ok := l.suppressions[email]
+	if !ok {
+		return nil, ErrSuppressionNotFound
+	}
+
+	return &s, nil
+}
+
+func (l *memorySuppressionList) listSuppressions(ctx context.Context, reason string) ([]Suppression, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	suppressions := make([]Suppression, 0, len(l.suppressions))
+	for _, s := range l.suppressions {
+		if reason == "" || s.Reason == reason {
+			suppressions = append(suppressions, s)
+		}
+	}
+	sort.Slice(suppressions, func(i, j int) bool {
+		return suppressions[i].CreatedAt.After(suppressions[j].CreatedAt)
+	})
+
+	return suppressions, nil
+}
+
+func (l *memorySuppressionList) removeSuppression(ctx context.Context, email string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.suppressions[email]; !ok {
+		return ErrSuppressionNotFound
+	}
+	delete(l.suppressions, email)
+
+	return nil
+}
+
+// BounceEvent is a bounce or complaint that an email provider notified the mailer of
+type BounceEvent struct {
+	// Type is bounce or complaint
+	Type  string `json:"type"`
+	Email string `json:"email"`
+	// BounceType is permanent or transient; transient bounces, such as for a full
+	// inbox, are ignored, since later emails to the address can still be delivered
+	BounceType string `json:"bounceType"`
+	Detail     string `json:"detail"`
+}
+
+// suppress adds an address to the suppression list
+func (s *Service) suppress(email, reason, detail string) (*Suppression, error) {
+	var vErr validation.Error
+	if strings.TrimSpace(email) == "" {
+		vErr.Required("email")
+	}
+
+	if !isSuppressionReason(reason) {
+		vErr.Add("reason", "invalid_reason", "reason must be bounce, complaint or manual")
+	}
+
+	if err := vErr.Err(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	suppression := Suppression{
+		Email:     normalizeAddress(email),
+		Reason:    reason,
+		Detail:    detail,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := s.suppressions.suppress(ctx, suppression); err != nil {
+		return nil, err
+	}
+
+	return &suppression, nil
+}
+
+// handleBounceEvents suppresses the addresses of hard bounces and complaints.
+// It returns how many addresses were suppressed, and checks every event
+// before suppressing any, so that a bad notification changes nothing.
+func (s *Service) handleBounceEvents(events []BounceEvent) (int, error) {
+	var vErr validation.Error
+	for i, e := range events {
+		field := fmt.Sprintf("events[%d]", i)
+
+		if e.Type != SuppressionBounce && e.Type != SuppressionComplaint {
+			vErr.Add(field+".type", "invalid_type", field+".type must be bounce or complaint")
+		}
+
+		if strings.TrimSpace(e.Email) == "" {
+			vErr.Required(field + ".email")
+		}
+	}
+
+	if err := vErr.Err(); err != nil {
+		return 0, err
+	}
+
+	suppressed := 0
+	for _, e := range events {
+		if e.Type == SuppressionBounce && e.BounceType == "transient" {
+			continue
+		}
+
+		if _, err := s.suppress(e.Email, e.Type, e.Detail); err != nil {
+			return suppressed, err
+		}
+		suppressed++
+	}
+
+	return suppressed, nil
+}
+
+func (s *Service) getSuppression(email string) (*Suppression, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	return s.suppressions.getSuppression(ctx, normalizeAddress(email))
+}
+
+// listSuppressions returns the suppression list, or only the addresses suppressed for a reason
+func (s *Service) listSuppressions(reason string) ([]Suppression, error) {
+	if reason != "" && !isSuppressionReason(reason) {
+		var vErr validation.Error
+		vErr.Add("reason", "invalid_reason", "reason must be bounce, complaint or manual")
+		return nil, vErr.Err()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	return s.suppressions.listSuppressions(ctx, reason)
+}
+
+// removeSuppression lets emails be sent to an address again, such as after a user fixed their inbox
+func (s *Service) removeSuppression(email string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	return s.suppressions.removeSuppression(ctx, normalizeAddress(email))
+}
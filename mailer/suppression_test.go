@@ -0,0 +1,254 @@
+package mailer
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ricxi/flat-list/mailer/pb"
+	"github.com/ricxi/flat-list/shared/validation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var suppressionTestData = ActivationEmailData{
+	To:             "michaelscott@dundermifflin.com",
+	From:           "theteam@flatlist.com",
+	ActivationData: ActivationData{Name: "Michael", Hyperlink: "http://localhost:5000/clickme"},
+}
+
+func TestServiceSuppression(t *testing.T) {
+	m := &mockMailer{}
+	s := NewService(m, mustLoadTemplates(t, "./templates"))
+
+	_, err := s.suppress(" MichaelScott@DunderMifflin.com", SuppressionManual, "asked to stop")
+	require.NoError(t, err)
+
+	_, err = s.sendActivationEmail(suppressionTestData)
+	assert.ErrorIs(t, err, ErrRecipientSuppressed)
+	assert.Nil(t, m.msg)
+
+	t.Run("GrpcFailedPrecondition", func(t *testing.T) {
+		_, err := NewGrpcServer(s).SendActivationEmail(context.Background(), &pb.EmailRequest{
+			From:           suppressionTestData.From,
+			To:             suppressionTestData.To,
+			ActivationData: &pb.ActivationData{Name: "Michael", Hyperlink: "http://localhost:5000/clickme"},
+		})
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	})
+
+	require.NoError(t, s.removeSuppression("michaelscott@dundermifflin.com"))
+
+	_, err = s.sendActivationEmail(suppressionTestData)
+	require.NoError(t, err)
+	assert.Equal(t, suppressionTestData.To, m.msg.To)
+
+	t.Run("ErrorInvalidReason", func(t *testing.T) {
+		_, err := s.suppress("dwightschrute@dundermifflin.com", "unsubscribe", "")
+		_, ok := validation.As(err)
+		assert.True(t, ok)
+	})
+
+	t.Run("ErrorNotFound", func(t *testing.T) {
+		assert.ErrorIs(t, s.removeSuppression("dwightschrute@dundermifflin.com"), ErrSuppressionNotFound)
+	})
+}
+
+func TestQueueSuppressedRecipient(t *testing.T) {
+	store := newMockJobStore()
+	suppressions := NewMemorySuppressionList()
+	m := &flakyMailer{}
+	queue := NewQueue(store, NewSuppressingMailer(m, suppressions),
+		WithBackoff(time.Millisecond, 2*time.Millisecond),
+		WithPollInterval(time.Millisecond),
+	)
+	s := NewService(m, mustLoadTemplates(t, "./templates"), WithQueue(queue), WithSuppressionList(suppressions))
+
+	jobID, err := s.sendActivationEmail(suppressionTestData)
+	require.NoError(t, err)
+
+	// the address bounces after the email was queued, but before it was sent
+	_, err = s.handleBounceEvents([]BounceEvent{{Type: SuppressionBounce, Email: suppressionTestData.To, BounceType: "permanent"}})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		queue.Run(ctx)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool {
+		job, err := s.getJob(jobID)
+		return err == nil && job.Status == JobFailed
+	}, time.Second, time.Millisecond)
+
+	cancel()
+	<-done
+
+	job, err := s.getJob(jobID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, job.Attempts)
+	assert.Contains(t, job.LastError, ErrRecipientSuppressed.Error())
+	assert.Zero(t, m.sentCount())
+}
+
+func TestHandleBounceWebhook(t *testing.T) {
+	const secret = "shh"
+
+	testCases := []struct {
+		name               string
+		secret             string
+		body               string
+		expectedStatusCode int
+		expectedSuppressed []string
+	}{
+		{
+			name:   "Success",
+			secret: secret,
+			body: `{"events": [
+				{"type": "bounce", "email": "michaelscott@dundermifflin.com", "bounceType": "permanent", "detail": "550 no such user"},
+				{"type": "bounce", "email": "dwightschrute@dundermifflin.com", "bounceType": "transient", "detail": "452 mailbox full"},
+				{"type": "complaint", "email": "JimHalpert@dundermifflin.com"}
+			]}`,
+			expectedStatusCode: http.StatusOK,
+			expectedSuppressed: []string{"michaelscott@dundermifflin.com", "jimhalpert@dundermifflin.com"},
+		},
+		{
+			name:               "ErrorWrongSecret",
+			secret:             "guess",
+			body:               `{"events": [{"type": "bounce", "email": "michaelscott@dundermifflin.com"}]}`,
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:   "ErrorInvalidEvent",
+			secret: secret,
+			body: `{"events": [
+				{"type": "bounce", "email": "michaelscott@dundermifflin.com"},
+				{"type": "delivery", "email": "dwightschrute@dundermifflin.com"}
+			]}`,
+			expectedStatusCode: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewService(&mockMailer{}, mustLoadTemplates(t, "./templates"))
+
+			rr := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/v1/mailer/webhooks/bounces", strings.NewReader(tt.body))
+			r.Header.Set("X-Webhook-Secret", tt.secret)
+			HandleBounceWebhook(s, secret).ServeHTTP(rr, r)
+
+			assert.Equal(t, tt.expectedStatusCode, rr.Code)
+
+			suppressions, err := s.listSuppressions("")
+			require.NoError(t, err)
+			emails := make([]string, 0, len(suppressions))
+			for _, suppression := range suppressions {
+				emails = append(emails, suppression.Email)
+			}
+			assert.ElementsMatch(t, tt.expectedSuppressed, emails)
+		})
+	}
+}
+
+func TestHandleSuppressions(t *testing.T) {
+	s := NewService(&mockMailer{}, mustLoadTemplates(t, "./templates"))
+	_, err := s.suppress("jimhalpert@dundermifflin.com", SuppressionComplaint, "")
+	require.NoError(t, err)
+
+	h := http.StripPrefix("/v1/mailer/suppressions/", HandleSuppressions(s))
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return rr
+	}
+
+	t.Run("Add", func(t *testing.T) {
+		rr := serve(http.MethodPost, "/v1/mailer/suppressions/", `{"email": "MichaelScott@dundermifflin.com", "detail": "asked to stop"}`)
+		require.Equal(t, http.StatusCreated, rr.Code)
+
+		var body struct {
+			Suppression Suppression `json:"suppression"`
+		}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+		assert.Equal(t, "michaelscott@dundermifflin.com", body.Suppression.Email)
+		assert.Equal(t, SuppressionManual, body.Suppression.Reason)
+	})
+
+	t.Run("SendConflict", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		body := `{"from": "theteam@flatlist.com", "to": "michaelscott@dundermifflin.com", "activationData": {"hyperlink": "http://localhost:5173/login"}}`
+		HandleSendLockoutEmail(s).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/mailer/lockout", strings.NewReader(body)))
+
+		assert.Equal(t, http.StatusConflict, rr.Code)
+	})
+
+	t.Run("List", func(t *testing.T) {
+		rr := serve(http.MethodGet, "/v1/mailer/suppressions/?reason=manual", "")
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var body struct {
+			Suppressions []Suppression `json:"suppressions"`
+		}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+		require.Len(t, body.Suppressions, 1)
+		assert.Equal(t, "michaelscott@dundermifflin.com", body.Suppressions[0].Email)
+	})
+
+	t.Run("Get", func(t *testing.T) {
+		rr := serve(http.MethodGet, "/v1/mailer/suppressions/jimhalpert@dundermifflin.com", "")
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, rr.Body.String(), `"reason":"complaint"`)
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		rr := serve(http.MethodDelete, "/v1/mailer/suppressions/michaelscott@dundermifflin.com", "")
+		require.Equal(t, http.StatusNoContent, rr.Code)
+
+		rr = serve(http.MethodGet, "/v1/mailer/suppressions/michaelscott@dundermifflin.com", "")
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	t.Run("ErrorInvalidReason", func(t *testing.T) {
+		rr := serve(http.MethodGet, "/v1/mailer/suppressions/?reason=unsubscribe", "")
+		assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	})
+}
+
+func TestNewAdminHandler(t *testing.T) {
+	s := NewService(&mockMailer{}, mustLoadTemplates(t, "./templates"))
+
+	tests := []struct {
+		name               string
+		secret             string
+		header             string
+		expectedStatusCode int
+	}{
+		{name: "Success", secret: "admin_secret", header: "admin_secret", expectedStatusCode: http.StatusOK},
+		{name: "ErrorMissingSecret", secret: "admin_secret", expectedStatusCode: http.StatusUnauthorized},
+		{name: "ErrorWrongSecret", secret: "admin_secret", header: "not_the_secret", expectedStatusCode: http.StatusUnauthorized},
+		{name: "ErrorNoSecretConfigured", expectedStatusCode: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/v1/mailer/suppressions/", nil)
+			if tt.header != "" {
+				r.Header.Set(AdminSecretHeader, tt.header)
+			}
+			rr := httptest.NewRecorder()
+
+			NewAdminHandler(s, tt.secret).ServeHTTP(rr, r)
+
+			assert.Equal(t, tt.expectedStatusCode, rr.Code)
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package mailer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// PostgresSuppressionList stores the suppression list in the mail_suppressions table (see migrations/mailer)
+type PostgresSuppressionList struct {
+	db *sql.DB
+}
+
+func NewPostgresSuppressionList(db *sql.DB) PostgresSuppressionList {
+	return PostgresSuppressionList{db: db}
+}
+
+func (l PostgresSuppressionList) suppress(ctx context.Context, s Suppression) error {
+	query := `INSERT INTO mail_suppressions (email, reason, detail, created_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (email) DO UPDATE SET reason = EXCLUDED.reason, detail = EXCLUDED.detail, created_at = EXCLUDED.created_at`
+
+	_, err := l.db.ExecContext(ctx, query, s.Email, s.Reason, s.Detail, s.CreatedAt)
+	return err
+}
+
+func (l PostgresSuppressionList) getSuppression(ctx context.Context, email string) (*Suppression, error) {
+	var s Suppression
+
+	query := `SELECT email, reason, detail, created_at FROM mail_suppressions WHERE email = $1`
+	err := l.db.QueryRowContext(ctx, query, email).Scan(&s.Email, &s.Reason, &s.Detail, &s.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrSuppressionNotFound
+		}
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+func (l PostgresSuppressionList) listSuppressions(ctx context.Context, reason string) ([]Suppression, error) {
+	query := `SELECT email, reason, detail, created_at FROM mail_suppressions
+		WHERE $1 = '' OR reason = $1 ORDER BY created_at DESC`
+
+	rows, err := l.db.QueryContext(ctx, query, reason)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suppressions := []Suppression{}
+	for rows.Next() {
+		var s Suppression
+		if err := rows.Scan(&s.Email, &s.Reason, &s.Detail, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		suppressions = append(suppressions, s)
+	}
+
+	return suppressions, rows.Err()
+}
+
+func (l PostgresSuppressionList) removeSuppression(ctx context.Context, email string) error {
+	result, err := l.db.ExecContext(ctx, "DELETE FROM mail_suppressions WHERE email = $1", email)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrSuppressionNotFound
+	}
+
+	return nil
+}
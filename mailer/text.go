@@ -0,0 +1,138 @@
+package mailer
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// htmlToText converts the html body of an email to plain text, for the
+// templates that don't have a .txt version. Each paragraph (or other block)
+// is put on its own line, links are followed by their url, and list items
+// start with a dash, so that the text reads like the html looks.
+func htmlToText(body string) string {
+	var (
+		b    strings.Builder
+		tz   = html.NewTokenizer(strings.NewReader(body))
+		skip int      // the depth of the elements whose text is not shown (eg. <style>)
+		href []string // the url of each link that is open
+	)
+
+	for {
+		switch tz.Next() {
+		case html.ErrorToken:
+			return tidyText(b.String())
+
+		case html.TextToken:
+			if skip == 0 {
+				b.WriteString(collapseSpace(string(tz.Text())))
+			}
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tag := atom.Lookup(tagName(tz))
+			switch {
+			case isHiddenTag(tag):
+				skip++
+			case tag == atom.Br:
+				b.WriteString("\n")
+			case tag == atom.Li:
+				b.WriteString("\n- ")
+			case tag == atom.A:
+				href = append(href, attr(tz, "href"))
+			case tag == atom.Img:
+				if alt := attr(tz, "alt"); alt != "" {
+					b.WriteString(alt)
+				}
+			case isBlockTag(tag):
+				b.WriteString("\n\n")
+			}
+
+		case html.EndTagToken:
+			tag := atom.Lookup(tagName(tz))
+			switch {
+			case isHiddenTag(tag):
+				if skip > 0 {
+					skip--
+				}
+			case tag == atom.A && len(href) > 0:
+				link := href[len(href)-1]
+				href = href[:len(href)-1]
+				// mailto and cid links don't mean anything in plain text
+				if strings.HasPrefix(link, "http://") || strings.HasPrefix(link, "https://") {
+					b.WriteString(" (" + link + ")")
+				}
+			case isBlockTag(tag):
+				b.WriteString("\n\n")
+			}
+		}
+	}
+}
+
+func tagName(tz *html.Tokenizer) []byte {
+	name, _ := tz.TagName()
+	return name
+}
+
+// attr reads an attribute of the current tag, which must be done before its name is read again
+func attr(tz *html.Tokenizer, key string) string {
+	for {
+		k, v, more := tz.TagAttr()
+		if string(k) == key {
+			return string(v)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+func isHiddenTag(tag atom.Atom) bool {
+	return tag == atom.Head || tag == atom.Style || tag == atom.Script || tag == atom.Title
+}
+
+func isBlockTag(tag atom.Atom) bool {
+	switch tag {
+	case atom.P, atom.Div, atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6,
+		atom.Ul, atom.Ol, atom.Table, atom.Tr, atom.Blockquote, atom.Hr:
+		return true
+	}
+	return false
+}
+
+// collapseSpace replaces each run of whitespace with a single space, like a browser does
+func collapseSpace(s string) string {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		if s == "" {
+			return ""
+		}
+		return " "
+	}
+
+	collapsed := strings.Join(fields, " ")
+	if strings.TrimLeft(s, " \t\r\n") != s {
+		collapsed = " " + collapsed
+	}
+	if strings.TrimRight(s, " \t\r\n") != s {
+		collapsed += " "
+	}
+
+	return collapsed
+}
+
+// tidyText tidies the spaces in each line, and leaves at most one blank line between blocks
+func tidyText(s string) string {
+	lines := strings.Split(s, "\n")
+	tidied := make([]string, 0, len(lines))
+
+	for _, line := range lines {
+		line = strings.Join(strings.Fields(line), " ")
+		if line == "" && (len(tidied) == 0 || tidied[len(tidied)-1] == "") {
+			continue
+		}
+		tidied = append(tidied, line)
+	}
+
+	return strings.TrimSpace(strings.Join(tidied, "\n")) + "\n"
+}
@@ -0,0 +1,42 @@
+package mailer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTMLToText(t *testing.T) {
+	testCases := []struct {
+		name     string
+		html     string
+		expected string
+	}{
+		{
+			name:     "Paragraphs",
+			html:     "<p>Hello   Michael,</p>\n    <p>Your tasks\n are due.</p>",
+			expected: "Hello Michael,\n\nYour tasks are due.\n",
+		},
+		{
+			name:     "Links",
+			html:     `<p>Please click on this <a href="https://flatlist.com/activate">link</a>, or <a href="mailto:help@flatlist.com">email us</a>.</p>`,
+			expected: "Please click on this link (https://flatlist.com/activate), or email us.\n",
+		},
+		{
+			name:     "Lists",
+			html:     "<p>Tasks:</p><ul><li>Sell paper</li>\n<li>Plan <b>party</b></li></ul><p>Thanks!</p>",
+			expected: "Tasks:\n\n- Sell paper\n- Plan party\n\nThanks!\n",
+		},
+		{
+			name:     "HiddenElementsAndImages",
+			html:     `<!doctype html><html><head><title>Email</title><style>p { color: red; }</style></head><body><img src="cid:logo.png" alt="Flat List"><br>Hi &amp; bye</body></html>`,
+			expected: "Flat List\nHi & bye\n",
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, htmlToText(tt.html))
+		})
+	}
+}
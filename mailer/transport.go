@@ -0,0 +1,77 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/ricxi/flat-list/shared/config"
+)
+
+// the transports that emails can be sent with, which are chosen with MAIL_TRANSPORT
+const (
+	TransportSMTP    = "smtp"
+	TransportAPI     = "api"
+	TransportMaildir = "maildir"
+	TransportLog     = "log"
+)
+
+// NewTransport creates the Mailer for a transport, with the settings
+// that it needs from environment variables:
+//
+//	smtp (the default): HOST, PORT, USERNAME, PASSWORD
+//	api: MAIL_API_URL, MAIL_API_KEY
+//	maildir: MAILDIR
+//	log: none
+func NewTransport(transport string) (Mailer, error) {
+	switch transport {
+	case TransportSMTP, "":
+		envs, err := config.LoadEnvs("HOST", "PORT", "USERNAME", "PASSWORD")
+		if err != nil {
+			return nil, err
+		}
+		port, err := strconv.Atoi(envs["PORT"])
+		if err != nil {
+			return nil, err
+		}
+
+		return NewMailer(envs["USERNAME"], envs["PASSWORD"], envs["HOST"], port), nil
+
+	case TransportAPI:
+		envs, err := config.LoadEnvs("MAIL_API_URL", "MAIL_API_KEY")
+		if err != nil {
+			return nil, err
+		}
+
+		return NewAPIMailer(envs["MAIL_API_URL"], envs["MAIL_API_KEY"]), nil
+
+	case TransportMaildir:
+		envs, err := config.LoadEnvs("MAILDIR")
+		if err != nil {
+			return nil, err
+		}
+
+		return NewMaildirMailer(envs["MAILDIR"])
+
+	case TransportLog:
+		return NewLogMailer(log.Default()), nil
+	}
+
+	return nil, fmt.Errorf("unknown mail transport %q (it must be %s, %s, %s or %s)",
+		transport, TransportSMTP, TransportAPI, TransportMaildir, TransportLog)
+}
+
+// logMailer only logs emails, which is useful for running the mailer without sending anything
+type logMailer struct {
+	logger *log.Logger
+}
+
+// NewLogMailer creates a Mailer that logs the plain text version of emails instead of sending them
+func NewLogMailer(logger *log.Logger) Mailer {
+	return &logMailer{logger: logger}
+}
+
+func (m *logMailer) send(msg *message) error {
+	m.logger.Printf("email from %s to %s: %s (%d attachments)\n%s", msg.From, msg.To, msg.Subject, len(msg.Attachments), msg.Text)
+	return nil
+}
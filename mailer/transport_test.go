@@ -0,0 +1,174 @@
+package mailer
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestMessage() *message {
+	return &message{
+		From:    "theteam@flatlist.com",
+		To:      "michaelscott@dundermifflin.com",
+		Subject: "Your tasks",
+		HTML:    `<p>Hello</p><img src="cid:logo.png">`,
+		Text:    "Hello\n",
+		Attachments: []Attachment{
+			{Filename: "logo.png", Content: []byte("png"), Inline: true},
+			{Filename: "tasks.pdf", Content: []byte("title\n")},
+		},
+	}
+}
+
+func TestAPIMailer(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		var received apiEmail
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, http.MethodPost, r.Method)
+			assert.Equal(t, "Bearer test-key", r.Header.Get("Authorization"))
+			assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+			w.WriteHeader(http.StatusAccepted)
+		}))
+		defer srv.Close()
+
+		err := NewAPIMailer(srv.URL, "test-key").send(newTestMessage())
+		require.NoError(t, err)
+
+		assert.Equal(t, apiEmail{
+			From:    "theteam@flatlist.com",
+			To:      []string{"michaelscott@dundermifflin.com"},
+			Subject: "Your tasks",
+			HTML:    `<p>Hello</p><img src="cid:logo.png">`,
+			Text:    "Hello\n",
+			Attachments: []apiAttachment{
+				{Filename: "logo.png", ContentType: "image/png", Content: []byte("png"), Disposition: "inline", ContentID: "logo.png"},
+				{Filename: "tasks.pdf", ContentType: "application/pdf", Content: []byte("title\n"), Disposition: "attachment"},
+			},
+		}, received)
+	})
+
+	testCases := []struct {
+		name            string
+		statusCode      int
+		expectedErr     string
+		isUnavailable   bool
+		isPermanentFail bool
+	}{
+		{
+			name:            "ErrorRejected",
+			statusCode:      http.StatusBadRequest,
+			expectedErr:     "email api responded with 400: the api said no",
+			isPermanentFail: true,
+		},
+		{
+			name:        "ErrorUnauthorized",
+			statusCode:  http.StatusUnauthorized,
+			expectedErr: "email api responded with 401: the api said no",
+		},
+		{
+			name:          "ErrorRateLimited",
+			statusCode:    http.StatusTooManyRequests,
+			expectedErr:   "email api responded with 429: the api said no",
+			isUnavailable: true,
+		},
+		{
+			name:          "ErrorServerError",
+			statusCode:    http.StatusBadGateway,
+			expectedErr:   "email api responded with 502: the api said no",
+			isUnavailable: true,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.statusCode)
+				w.Write([]byte("the api said no\n"))
+			}))
+			defer srv.Close()
+
+			err := NewAPIMailer(srv.URL, "test-key").send(newTestMessage())
+			assert.EqualError(t, err, tt.expectedErr)
+			assert.Equal(t, tt.isUnavailable, isUnavailable(err))
+			assert.Equal(t, tt.isPermanentFail, isPermanent(err))
+		})
+	}
+}
+
+func TestMaildirMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "maildir")
+	m, err := NewMaildirMailer(dir)
+	require.NoError(t, err)
+
+	require.NoError(t, m.send(newTestMessage()))
+	require.NoError(t, m.send(newTestMessage()))
+
+	emails, err := filepath.Glob(filepath.Join(dir, "new", "*"))
+	require.NoError(t, err)
+	require.Len(t, emails, 2)
+
+	tmp, err := os.ReadDir(filepath.Join(dir, "tmp"))
+	require.NoError(t, err)
+	assert.Empty(t, tmp)
+
+	email, err := os.ReadFile(emails[0])
+	require.NoError(t, err)
+	assert.Contains(t, string(email), "To: michaelscott@dundermifflin.com")
+	assert.Contains(t, string(email), "Subject: Your tasks")
+	assert.Contains(t, string(email), "Content-Type: multipart/alternative")
+}
+
+func TestLogMailer(t *testing.T) {
+	var b bytes.Buffer
+	m := NewLogMailer(log.New(&b, "", 0))
+
+	require.NoError(t, m.send(newTestMessage()))
+
+	assert.Equal(t, "email from theteam@flatlist.com to michaelscott@dundermifflin.com: Your tasks (2 attachments)\nHello\n", b.String())
+}
+
+func TestNewTransport(t *testing.T) {
+	t.Run("Maildir", func(t *testing.T) {
+		t.Setenv("MAILDIR", t.TempDir())
+
+		m, err := NewTransport(TransportMaildir)
+		require.NoError(t, err)
+		assert.IsType(t, &maildirMailer{}, m)
+	})
+
+	t.Run("API", func(t *testing.T) {
+		t.Setenv("MAIL_API_URL", "http://localhost:5010/v1/send")
+		t.Setenv("MAIL_API_KEY", "test-key")
+
+		m, err := NewTransport(TransportAPI)
+		require.NoError(t, err)
+		assert.IsType(t, &apiMailer{}, m)
+	})
+
+	t.Run("Log", func(t *testing.T) {
+		m, err := NewTransport(TransportLog)
+		require.NoError(t, err)
+		assert.IsType(t, &logMailer{}, m)
+	})
+
+	t.Run("ErrorMissingSettings", func(t *testing.T) {
+		t.Setenv("MAIL_API_URL", "")
+
+		_, err := NewTransport(TransportAPI)
+		assert.Error(t, err)
+	})
+
+	t.Run("ErrorUnknownTransport", func(t *testing.T) {
+		_, err := NewTransport("carrier-pigeon")
+		assert.EqualError(t, err, `unknown mail transport "carrier-pigeon" (it must be smtp, api, maildir or log)`)
+	})
+}
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS mail_dead_letters;
+DROP TABLE IF EXISTS mail_jobs;
@@ -0,0 +1,29 @@
+-- every email that the mailer accepts is queued here until it is sent
+CREATE TABLE IF NOT EXISTS mail_jobs (
+    id text PRIMARY KEY,
+    -- queued, sending or sent
+    status text NOT NULL DEFAULT 'queued',
+    -- the rendered email, including its attachments
+    message jsonb NOT NULL,
+    attempts integer NOT NULL DEFAULT 0,
+    last_error text,
+    -- when the job is next tried
+    run_at timestamptz NOT NULL DEFAULT now(),
+    -- a job that is still sending after this was left by a worker that stopped, and is tried again
+    locked_until timestamptz,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    updated_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS mail_jobs_run_at_idx ON mail_jobs (run_at) WHERE status <> 'sent';
+CREATE INDEX IF NOT EXISTS mail_jobs_sent_idx ON mail_jobs (updated_at) WHERE status = 'sent';
+
+-- jobs are moved here when they fail permanently, or run out of attempts
+CREATE TABLE IF NOT EXISTS mail_dead_letters (
+    id text PRIMARY KEY,
+    message jsonb NOT NULL,
+    attempts integer NOT NULL,
+    last_error text NOT NULL,
+    created_at timestamptz NOT NULL,
+    failed_at timestamptz NOT NULL DEFAULT now()
+);
@@ -0,0 +1,11 @@
+-- addresses that emails are not sent to, because they bounced, complained, or were added by an admin
+CREATE TABLE IF NOT EXISTS mail_suppressions (
+    -- stored in lowercase
+    email text PRIMARY KEY,
+    -- bounce, complaint or manual
+    reason text NOT NULL,
+    detail text NOT NULL DEFAULT '',
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS mail_suppressions_reason_idx ON mail_suppressions (reason, created_at);
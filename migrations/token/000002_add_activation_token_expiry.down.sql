@@ -0,0 +1,11 @@
+-- hashed tokens cannot be reversed, so every token is invalid after this
+DROP INDEX IF EXISTS activation_tokens_expires_at_idx;
+DROP INDEX IF EXISTS activation_tokens_user_id_idx;
+
+ALTER TABLE activation_tokens
+    DROP COLUMN IF EXISTS used_at,
+    DROP COLUMN IF EXISTS expires_at,
+    DROP COLUMN IF EXISTS created_at;
+
+DELETE FROM activation_tokens;
+ALTER TABLE activation_tokens RENAME COLUMN token_hash TO token;
@@ -0,0 +1,11 @@
+-- tokens are stored as a sha-256 hash, so existing tokens are hashed in place
+ALTER TABLE activation_tokens RENAME COLUMN token TO token_hash;
+UPDATE activation_tokens SET token_hash = encode(sha256(convert_to(token_hash, 'UTF8')), 'hex');
+
+ALTER TABLE activation_tokens
+    ADD COLUMN created_at timestamptz NOT NULL DEFAULT now(),
+    ADD COLUMN expires_at timestamptz NOT NULL DEFAULT now() + interval '24 hours',
+    ADD COLUMN used_at timestamptz;
+
+CREATE INDEX IF NOT EXISTS activation_tokens_user_id_idx ON activation_tokens (user_id);
+CREATE INDEX IF NOT EXISTS activation_tokens_expires_at_idx ON activation_tokens (expires_at);
@@ -0,0 +1,15 @@
+-- only a sha-256 hash of each token is stored, like activation tokens
+CREATE TABLE IF NOT EXISTS personal_access_tokens (
+    id text PRIMARY KEY,
+    user_id text NOT NULL,
+    name text NOT NULL,
+    token_hash text NOT NULL UNIQUE,
+    -- scopes are separated by spaces, like the scope of an oauth token
+    scopes text NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    expires_at timestamptz NOT NULL,
+    last_used_at timestamptz,
+    UNIQUE (user_id, name)
+);
+
+CREATE INDEX IF NOT EXISTS personal_access_tokens_expires_at_idx ON personal_access_tokens (expires_at);
@@ -0,0 +1,5 @@
+-- email change tokens would be accepted as activation tokens without their purpose
+DELETE FROM activation_tokens WHERE purpose <> 'activation';
+
+ALTER TABLE activation_tokens
+    DROP COLUMN IF EXISTS purpose;
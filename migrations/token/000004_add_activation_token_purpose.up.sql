@@ -0,0 +1,4 @@
+-- tokens are created for a purpose (see TokenPurpose in token.proto),
+-- and can only be used for the purpose that they were created for
+ALTER TABLE activation_tokens
+    ADD COLUMN purpose text NOT NULL DEFAULT 'activation';
@@ -9,6 +9,11 @@ load_env_file() {
     export $(grep -v '^#' "$env_file" | xargs)
 }
 
+# the task service's internal api is only called by the user service, with a shared
+# secret; these are used if they are not set in task.env and user.env
+export INTERNAL_SECRET="${INTERNAL_SECRET:-dev-internal-secret}"
+internal_port=5009
+
 cleanup() {
     echo -e "\ncleaning up..."
     sleep 2
@@ -30,7 +35,10 @@ trap cleanup SIGINT
 ) &
 (
     # start the mailer service
+    # its queue is stored in the token service's postgres container, so this waits for it to start
     load_env_file mailer.env &&
+    sleep 5 &&
+    ./dev_scripts/db/run_mailerdb_migrations.sh tokendb-instance 2>>errors.log &&
     cd mailer &&
     go run ./cmd/grpc
     # go run ./cmd/http
@@ -45,6 +53,8 @@ trap cleanup SIGINT
 (
     # start the user service
     load_env_file user.env &&
+    export TASK_INTERNAL_URL="${TASK_INTERNAL_URL:-http://localhost:$internal_port}" &&
+    export TASK_INTERNAL_SECRET="${TASK_INTERNAL_SECRET:-$INTERNAL_SECRET}" &&
     ./dev_scripts/db/run_flatlistdb.sh flatlistdb-instance 2>>errors.log
     cd user &&
     go run ./cmd/http
@@ -52,6 +62,7 @@ trap cleanup SIGINT
 (
     # start the task service
     load_env_file task.env &&
+    export INTERNAL_PORT="${INTERNAL_PORT:-$internal_port}" &&
     # this depends on the same database as the user service, which should already be running
     sleep 5 # I'll write a more sustainable solution later than sleeping
     cd task &&
@@ -0,0 +1,144 @@
+// Package authz stores who made a request and checks what they are allowed to do.
+package authz
+
+import (
+	"context"
+	"net/http"
+
+	res "github.com/ricxi/flat-list/shared/response"
+)
+
+// RoleAdmin is the role of users who can manage other users' accounts
+const RoleAdmin = "admin"
+
+// the scopes that a personal access token can be limited to
+const (
+	ScopeTasksRead  = "tasks:read"
+	ScopeTasksWrite = "tasks:write"
+)
+
+// Scopes are every scope that a personal access token can have
+var Scopes = []string{ScopeTasksRead, ScopeTasksWrite}
+
+// PersonalAccessTokenPrefix starts every personal access token,
+// so that they can be told apart from jwts and found if they are leaked
+const PersonalAccessTokenPrefix = "flpat_"
+
+// OAuthAccessTokenPrefix starts every access token that is issued to
+// a third-party app, which is limited to its scopes in the same way
+const OAuthAccessTokenPrefix = "float_"
+
+// Principal is the user that a request was authenticated as
+type Principal struct {
+	UserID string   `json:"userId"`
+	Roles  []string `json:"roles,omitempty"`
+	// ImpersonatorID is the id of the admin who is acting as
+	// the user, and is empty if the user made the request
+	ImpersonatorID string `json:"impersonatorId,omitempty"`
+	// TokenID is the id of the personal access token or oauth access
+	// token that the request was made with, which can only be used within its Scopes
+	TokenID string   `json:"tokenId,omitempty"`
+	Scopes  []string `json:"scopes,omitempty"`
+	// ClientID is the id of the third-party app that
+	// the request was made by, if it has an oauth access token
+	ClientID string `json:"clientId,omitempty"`
+}
+
+// HasRole checks if the principal has any of the roles
+func (p *Principal) HasRole(roles ...string) bool {
+	for _, role := range roles {
+		for _, r := range p.Roles {
+			if r == role {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// HasScope checks if the principal can be used within a scope,
+// which is always true for users who logged in instead of using a token
+func (p *Principal) HasScope(scope string) bool {
+	if p.TokenID == "" {
+		return true
+	}
+
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Impersonated checks if an admin is acting as the user
+func (p *Principal) Impersonated() bool {
+	return p.ImpersonatorID != ""
+}
+
+type principalKey struct{}
+
+// WithPrincipal stores the principal of a request in its context,
+// which should be done by the service's authentication middleware
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal that was stored with WithPrincipal
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
+
+// RequireRole only lets requests through if their principal has any of the
+// roles. It must be used after the middleware that authenticates requests;
+// a request without a principal is sent a 401, and one without the roles a 403.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, ok := FromContext(r.Context())
+			if !ok {
+				res.SendError(w, r, "unable to authorize user", http.StatusUnauthorized)
+				return
+			}
+
+			if !p.HasRole(roles...) {
+				res.SendError(w, r, "user does not have permission to access this resource", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireScope only lets requests made with a personal access token through
+// if it has the scope. Requests without a principal are left to the middleware
+// that authenticates them, so it must be used after it.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if p, ok := FromContext(r.Context()); ok && !p.HasScope(scope) {
+				res.SendError(w, r, "token does not have the "+scope+" scope", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// DenyImpersonation stops admins who are acting as a user from reaching
+// routes that only the user should use, such as changing their password
+func DenyImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p, ok := FromContext(r.Context()); ok && p.Impersonated() {
+			res.SendError(w, r, "this action is not allowed while impersonating a user", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,111 @@
+package authz_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ricxi/flat-list/shared/authz"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequireRole(t *testing.T) {
+	tests := []struct {
+		name          string
+		principal     *authz.Principal
+		expStatusCode int
+	}{
+		{
+			name:          "Admin",
+			principal:     &authz.Principal{UserID: "6448958b96118a48b722fd15", Roles: []string{"support", authz.RoleAdmin}},
+			expStatusCode: http.StatusOK,
+		},
+		{
+			name:          "FailNoRole",
+			principal:     &authz.Principal{UserID: "6448958b96118a48b722fd15"},
+			expStatusCode: http.StatusForbidden,
+		},
+		{
+			name:          "FailNotAuthenticated",
+			expStatusCode: http.StatusUnauthorized,
+		},
+	}
+
+	h := authz.RequireRole(authz.RoleAdmin)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/v1/user/admin/users", nil)
+			if tt.principal != nil {
+				r = r.WithContext(authz.WithPrincipal(r.Context(), tt.principal))
+			}
+			rr := httptest.NewRecorder()
+
+			h.ServeHTTP(rr, r)
+
+			assert.Equal(t, tt.expStatusCode, rr.Code)
+		})
+	}
+}
+
+func TestDenyImpersonation(t *testing.T) {
+	h := authz.DenyImpersonation(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/user/me/password", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r.WithContext(authz.WithPrincipal(r.Context(), &authz.Principal{UserID: "6448958b96118a48b722fd15"})))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	impersonated := &authz.Principal{UserID: "6448958b96118a48b722fd15", ImpersonatorID: "6448958b96118a48b722fd16"}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, r.WithContext(authz.WithPrincipal(r.Context(), impersonated)))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+}
+
+func TestRequireScope(t *testing.T) {
+	tests := []struct {
+		name          string
+		principal     *authz.Principal
+		expStatusCode int
+	}{
+		{
+			name:          "LoggedIn",
+			principal:     &authz.Principal{UserID: "6448958b96118a48b722fd15"},
+			expStatusCode: http.StatusOK,
+		},
+		{
+			name:          "TokenWithScope",
+			principal:     &authz.Principal{UserID: "6448958b96118a48b722fd15", TokenID: "a1b2", Scopes: []string{authz.ScopeTasksRead, authz.ScopeTasksWrite}},
+			expStatusCode: http.StatusOK,
+		},
+		{
+			name:          "FailTokenWithoutScope",
+			principal:     &authz.Principal{UserID: "6448958b96118a48b722fd15", TokenID: "a1b2", Scopes: []string{authz.ScopeTasksRead}},
+			expStatusCode: http.StatusForbidden,
+		},
+		{
+			name:          "FailTokenWithoutScopes",
+			principal:     &authz.Principal{UserID: "6448958b96118a48b722fd15", TokenID: "a1b2"},
+			expStatusCode: http.StatusForbidden,
+		},
+	}
+
+	h := authz.RequireScope(authz.ScopeTasksWrite)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/v1/task", nil)
+			rr := httptest.NewRecorder()
+
+			h.ServeHTTP(rr, r.WithContext(authz.WithPrincipal(r.Context(), tt.principal)))
+
+			assert.Equal(t, tt.expStatusCode, rr.Code)
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package response
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/ricxi/flat-list/shared/validation"
+)
+
+// internalErrorMessage is sent instead of the text of errors that are not
+// meant for clients, which can contain details about the infrastructure
+const internalErrorMessage = "an internal server error has occurred"
+
+// unavailableMessage is sent when a dependency of a service, such as its
+// database or another service, can't be reached or took too long to respond
+const unavailableMessage = "the service is temporarily unavailable, try again later"
+
+type errorRule struct {
+	statusCode int
+	match      func(err error) bool
+}
+
+// ErrorClassifier maps the errors that a service returns to HTTP status codes.
+// Errors are matched against the rules in the order that they were added.
+// Validation errors are always sent with a 422 status code, and any error
+// that does not match a rule is treated as an internal server error.
+type ErrorClassifier struct {
+	rules []errorRule
+}
+
+func NewErrorClassifier() *ErrorClassifier {
+	return &ErrorClassifier{}
+}
+
+// Map sends errors that wrap any of the targets with a status code
+func (c *ErrorClassifier) Map(statusCode int, targets ...error) *ErrorClassifier {
+	for _, target := range targets {
+		target := target
+		c.rules = append(c.rules, errorRule{
+			statusCode: statusCode,
+			match:      func(err error) bool { return errors.Is(err, target) },
+		})
+	}
+
+	return c
+}
+
+// MapFunc sends errors that match with a status code, which is used for errors
+// that can't be compared with errors.Is, such as the errors of a database driver
+func (c *ErrorClassifier) MapFunc(statusCode int, match func(err error) bool) *ErrorClassifier {
+	c.rules = append(c.rules, errorRule{statusCode: statusCode, match: match})
+	return c
+}
+
+// StatusCode returns the status code that an error is sent with
+func (c *ErrorClassifier) StatusCode(err error) int {
+	if _, ok := validation.As(err); ok {
+		return http.StatusUnprocessableEntity
+	}
+
+	for _, rule := range c.rules {
+		if rule.match(err) {
+			return rule.statusCode
+		}
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusServiceUnavailable
+	}
+
+	return http.StatusInternalServerError
+}
+
+// SendError sends an error with the status code that it is mapped to, as a
+// problem if the client asked for one (see SendError). The text of server
+// errors is logged with the request's id instead of being sent, and the id
+// is sent so that it can be matched to the logs when it is reported.
+func (c *ErrorClassifier) SendError(w http.ResponseWriter, r *http.Request, err error) {
+	if vErr, ok := validation.As(err); ok {
+		SendValidationError(w, r, vErr)
+		return
+	}
+
+	statusCode := c.StatusCode(err)
+	if statusCode < http.StatusInternalServerError {
+		SendError(w, r, err.Error(), statusCode)
+		return
+	}
+
+	requestID := RequestIDFromContext(r.Context())
+	log.Printf("request %s: %s %s: %v", requestID, r.Method, r.URL.Path, err)
+
+	message := internalErrorMessage
+	if statusCode == http.StatusServiceUnavailable {
+		message = unavailableMessage
+	}
+
+	if negotiateProblem(w, r) {
+		p := NewProblem(statusCode, message)
+		p.Extensions = map[string]any{"requestId": requestID}
+		SendProblem(w, r, p)
+		return
+	}
+
+	payload := Payload{
+		"success":   false,
+		"error":     message,
+		"requestId": requestID,
+	}
+
+	SendJSON(w, payload, statusCode, nil)
+}
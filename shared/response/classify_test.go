@@ -0,0 +1,129 @@
+package response_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/ricxi/flat-list/shared/response"
+	"github.com/ricxi/flat-list/shared/validation"
+	"github.com/stretchr/testify/assert"
+)
+
+var errTaskNotFound = errors.New("task not found")
+var errDuplicateUser = errors.New("user already exists")
+var errDatabaseDown = errors.New("connection refused by db.internal:27017")
+
+func TestErrorClassifier(t *testing.T) {
+	classifier := response.NewErrorClassifier().
+		Map(http.StatusNotFound, errTaskNotFound).
+		Map(http.StatusConflict, errDuplicateUser).
+		MapFunc(http.StatusServiceUnavailable, func(err error) bool { return errors.Is(err, errDatabaseDown) })
+
+	tests := []struct {
+		name          string
+		err           error
+		expStatusCode int
+		expBody       string
+		expLogged     bool
+	}{
+		{
+			name:          "WrappedSentinel",
+			err:           fmt.Errorf("%w by id", errTaskNotFound),
+			expStatusCode: http.StatusNotFound,
+			expBody:       `{"success":false,"error":"task not found by id"}`,
+		},
+		{
+			name:          "Conflict",
+			err:           errDuplicateUser,
+			expStatusCode: http.StatusConflict,
+			expBody:       `{"success":false,"error":"user already exists"}`,
+		},
+		{
+			name:          "ValidationError",
+			err:           validation.Required("name"),
+			expStatusCode: http.StatusUnprocessableEntity,
+			expBody:       `{"success":false,"error":"missing field is required: name","fields":[{"field":"name","code":"required","message":"missing field is required: name"}]}`,
+		},
+		{
+			name:          "Unavailable",
+			err:           errDatabaseDown,
+			expStatusCode: http.StatusServiceUnavailable,
+			expBody:       `{"success":false,"error":"the service is temporarily unavailable, try again later","requestId":"req-123"}`,
+			expLogged:     true,
+		},
+		{
+			name:          "DeadlineExceeded",
+			err:           fmt.Errorf("finding user: %w", context.DeadlineExceeded),
+			expStatusCode: http.StatusServiceUnavailable,
+			expBody:       `{"success":false,"error":"the service is temporarily unavailable, try again later","requestId":"req-123"}`,
+			expLogged:     true,
+		},
+		{
+			name:          "UnknownErrorIsHidden",
+			err:           errors.New("bcrypt: hashedSecret too short"),
+			expStatusCode: http.StatusInternalServerError,
+			expBody:       `{"success":false,"error":"an internal server error has occurred","requestId":"req-123"}`,
+			expLogged:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			log.SetOutput(&logs)
+			defer log.SetOutput(os.Stderr)
+
+			h := response.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				classifier.SendError(w, r, tt.err)
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/v1/task/1", nil)
+			r.Header.Set(response.RequestIDHeader, "req-123")
+			rr := httptest.NewRecorder()
+
+			h.ServeHTTP(rr, r)
+
+			assert.Equal(t, tt.expStatusCode, rr.Code)
+			assert.Equal(t, "req-123", rr.Header().Get(response.RequestIDHeader))
+			assert.JSONEq(t, tt.expBody, rr.Body.String())
+
+			if tt.expLogged {
+				assert.Contains(t, logs.String(), "request req-123: GET /v1/task/1: "+tt.err.Error())
+			} else {
+				assert.Empty(t, logs.String())
+			}
+		})
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var requestID string
+	h := response.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID = response.RequestIDFromContext(r.Context())
+	}))
+
+	t.Run("Generated", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		assert.Len(t, requestID, 32)
+		assert.Equal(t, requestID, rr.Header().Get(response.RequestIDHeader))
+	})
+
+	t.Run("InvalidHeaderIsReplaced", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(response.RequestIDHeader, "bad id\nwith a new line")
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+
+		assert.Len(t, requestID, 32)
+		assert.Equal(t, requestID, rr.Header().Get(response.RequestIDHeader))
+	})
+}
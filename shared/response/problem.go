@@ -0,0 +1,163 @@
+package response
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ricxi/flat-list/shared/validation"
+)
+
+// ProblemContentType is the media type of problem details (RFC 7807)
+const ProblemContentType = "application/problem+json"
+
+// Problem is an error response in the problem details format (RFC 7807).
+// Extensions are sent as extra members next to the standard ones,
+// but they can't replace any of the standard members.
+type Problem struct {
+	Type       string
+	Title      string
+	Status     int
+	Detail     string
+	Instance   string
+	Extensions map[string]any
+}
+
+// NewProblem creates a problem without a type, which is identified by its status code
+func NewProblem(statusCode int, detail string) *Problem {
+	return &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(statusCode),
+		Status: statusCode,
+		Detail: detail,
+	}
+}
+
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	members := make(map[string]any, len(p.Extensions)+5)
+	for k, v := range p.Extensions {
+		members[k] = v
+	}
+
+	members["type"] = p.Type
+	members["title"] = p.Title
+	members["status"] = p.Status
+	if p.Detail != "" {
+		members["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		members["instance"] = p.Instance
+	}
+
+	return json.Marshal(members)
+}
+
+// SendProblem sends a problem with the path of the request as its instance,
+// unless it already has one, and the id of the request if it has one.
+func SendProblem(w http.ResponseWriter, r *http.Request, p *Problem) {
+	if p.Instance == "" {
+		p.Instance = r.URL.Path
+	}
+
+	if requestID, ok := r.Context().Value(requestIDKey{}).(string); ok {
+		if p.Extensions == nil {
+			p.Extensions = make(map[string]any)
+		}
+		p.Extensions["requestId"] = requestID
+	}
+
+	body, err := json.Marshal(p)
+	if err != nil {
+		SendInternalServerErrorAsJSON(w, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(p.Status)
+	w.Write(append(body, '\n'))
+}
+
+// SendError sends an error as a problem if the client asked for one in
+// its Accept header, or with SendErrorJSON if it did not.
+func SendError(w http.ResponseWriter, r *http.Request, message string, statusCode int) {
+	if !negotiateProblem(w, r) {
+		SendErrorJSON(w, message, statusCode)
+		return
+	}
+
+	SendProblem(w, r, NewProblem(statusCode, message))
+}
+
+// SendValidationError sends a validation error as a problem with a fields
+// member if the client asked for one in its Accept header, or with
+// SendValidationErrorJSON if it did not.
+func SendValidationError(w http.ResponseWriter, r *http.Request, err *validation.Error) {
+	if !negotiateProblem(w, r) {
+		SendValidationErrorJSON(w, err)
+		return
+	}
+
+	p := NewProblem(http.StatusUnprocessableEntity, err.Error())
+	p.Extensions = map[string]any{"fields": err.Fields}
+
+	SendProblem(w, r, p)
+}
+
+// negotiateProblem checks if an error should be sent as a problem,
+// and tells caches that the answer depends on the Accept header
+func negotiateProblem(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Add("Vary", "Accept")
+	return AcceptsProblem(r)
+}
+
+// AcceptsProblem checks if a request's Accept header asks for problem details.
+// The client has to name application/problem+json, with a quality that is not
+// lower than the one it gives to application/json, so that clients which accept
+// anything (ie. */*) are still sent the {success,error} body that they expect.
+func AcceptsProblem(r *http.Request) bool {
+	var problemQ, jsonQ float64
+	jsonSpecificity := 0
+
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			mediaType, q := parseMediaRange(mediaRange)
+
+			switch mediaType {
+			case ProblemContentType:
+				problemQ = q
+			case "application/json":
+				jsonQ, jsonSpecificity = q, 3
+			case "application/*":
+				if jsonSpecificity < 2 {
+					jsonQ, jsonSpecificity = q, 2
+				}
+			case "*/*":
+				if jsonSpecificity < 1 {
+					jsonQ, jsonSpecificity = q, 1
+				}
+			}
+		}
+	}
+
+	return problemQ > 0 && problemQ >= jsonQ
+}
+
+// parseMediaRange returns the media type of a media range in an
+// Accept header and its quality, which is 1 if it is not given
+func parseMediaRange(mediaRange string) (string, float64) {
+	params := strings.Split(mediaRange, ";")
+	mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+
+	q := 1.0
+	for _, param := range params[1:] {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if strings.EqualFold(strings.TrimSpace(name), "q") {
+			if v, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				q = v
+			}
+		}
+	}
+
+	return mediaType, q
+}
@@ -0,0 +1,118 @@
+package response_test
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/ricxi/flat-list/shared/response"
+	"github.com/ricxi/flat-list/shared/validation"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcceptsProblem(t *testing.T) {
+	tests := []struct {
+		name   string
+		accept string
+		exp    bool
+	}{
+		{name: "NoHeader", accept: "", exp: false},
+		{name: "Anything", accept: "*/*", exp: false},
+		{name: "JSON", accept: "application/json", exp: false},
+		{name: "Problem", accept: "application/problem+json", exp: true},
+		{name: "ProblemPreferred", accept: "application/json;q=0.9, application/problem+json", exp: true},
+		{name: "JSONPreferred", accept: "application/problem+json;q=0.5, application/json", exp: false},
+		{name: "ProblemOverWildcard", accept: "application/problem+json, */*;q=0.1", exp: true},
+		{name: "ProblemRefused", accept: "application/problem+json;q=0", exp: false},
+		{name: "CaseAndSpaces", accept: " Application/Problem+JSON ; Q=0.8 , text/html", exp: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+
+			assert.Equal(t, tt.exp, response.AcceptsProblem(r))
+		})
+	}
+}
+
+func TestSendError(t *testing.T) {
+	t.Run("Envelope", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/v1/task/123", nil)
+		rr := httptest.NewRecorder()
+
+		response.SendError(rr, r, "task not found", http.StatusNotFound)
+
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+		assert.Equal(t, "Accept", rr.Header().Get("Vary"))
+		assert.JSONEq(t, `{"success":false,"error":"task not found"}`, rr.Body.String())
+	})
+
+	t.Run("Problem", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/v1/task/123", nil)
+		r.Header.Set("Accept", response.ProblemContentType)
+		r.Header.Set(response.RequestIDHeader, "test-request-id")
+		rr := httptest.NewRecorder()
+
+		response.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			response.SendError(w, r, "task not found", http.StatusNotFound)
+		})).ServeHTTP(rr, r)
+
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		assert.Equal(t, response.ProblemContentType, rr.Header().Get("Content-Type"))
+
+		expected := `{"type":"about:blank","title":"Not Found","status":404,"detail":"task not found","instance":"/v1/task/123","requestId":"test-request-id"}`
+		assert.JSONEq(t, expected, rr.Body.String())
+	})
+
+	t.Run("ValidationProblem", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/v1/user/register", nil)
+		r.Header.Set("Accept", response.ProblemContentType)
+		rr := httptest.NewRecorder()
+
+		response.SendValidationError(rr, r, validation.Required("email"))
+
+		assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+		expected := `{"type":"about:blank","title":"Unprocessable Entity","status":422,"detail":"missing field is required: email","instance":"/v1/user/register","fields":[{"field":"email","code":"required","message":"missing field is required: email"}]}`
+		assert.JSONEq(t, expected, rr.Body.String())
+	})
+
+	t.Run("ClassifiedServerProblem", func(t *testing.T) {
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(os.Stderr)
+
+		r := httptest.NewRequest(http.MethodGet, "/v1/task/123", nil)
+		r.Header.Set("Accept", response.ProblemContentType)
+		r.Header.Set(response.RequestIDHeader, "test-request-id")
+		rr := httptest.NewRecorder()
+
+		response.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			response.NewErrorClassifier().SendError(w, r, errors.New("connection refused by db.internal:27017"))
+		})).ServeHTTP(rr, r)
+
+		assert.Equal(t, http.StatusInternalServerError, rr.Code)
+
+		expected := `{"type":"about:blank","title":"Internal Server Error","status":500,"detail":"an internal server error has occurred","instance":"/v1/task/123","requestId":"test-request-id"}`
+		assert.JSONEq(t, expected, rr.Body.String())
+	})
+}
+
+func TestProblemExtensionsCannotReplaceMembers(t *testing.T) {
+	p := response.NewProblem(http.StatusConflict, "user already exists")
+	p.Extensions = map[string]any{"status": 200, "email": "michaelscott@dundermifflin.com"}
+
+	b, err := p.MarshalJSON()
+	assert.NoError(t, err)
+
+	expected := `{"type":"about:blank","title":"Conflict","status":409,"detail":"user already exists","email":"michaelscott@dundermifflin.com"}`
+	assert.JSONEq(t, expected, string(b))
+}
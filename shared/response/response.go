@@ -5,6 +5,8 @@ import (
 	"bytes"
 	"encoding/json"
 	"net/http"
+
+	"github.com/ricxi/flat-list/shared/validation"
 )
 
 // Payload is a map alias type, which is used to represent JSON response data.
@@ -94,3 +96,26 @@ func SendErrorJSON(w http.ResponseWriter, message string, statusCode int) {
 
 	SendJSON(w, payload, statusCode, nil)
 }
+
+// SendValidationErrorJSON sends a 422 response with the error of every
+// field that is not valid, so that clients can show which fields failed.
+func SendValidationErrorJSON(w http.ResponseWriter, err *validation.Error) {
+	payload := Payload{
+		"success": false,
+		"error":   err.Error(),
+		"fields":  err.Fields,
+	}
+
+	SendJSON(w, payload, http.StatusUnprocessableEntity, nil)
+}
+
+// SendErrJSON sends a validation error with SendValidationErrorJSON, and
+// sends any other error with SendErrorJSON and the given status code.
+func SendErrJSON(w http.ResponseWriter, err error, statusCode int) {
+	if vErr, ok := validation.As(err); ok {
+		SendValidationErrorJSON(w, vErr)
+		return
+	}
+
+	SendErrorJSON(w, err.Error(), statusCode)
+}
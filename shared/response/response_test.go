@@ -1,11 +1,14 @@
 package response_test
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/ricxi/flat-list/shared/response"
+	"github.com/ricxi/flat-list/shared/validation"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -305,3 +308,47 @@ func TestSendErrorJSON(t *testing.T) {
 		}
 	})
 }
+
+func TestSendErrJSON(t *testing.T) {
+	t.Run("ValidationError", func(t *testing.T) {
+		assert := assert.New(t)
+		rr := httptest.NewRecorder()
+
+		var vErr validation.Error
+		vErr.Required("name")
+		vErr.Add("dueDate", "invalid_date", "dueDate must be a date")
+		err := fmt.Errorf("creating task: %w", vErr.Err())
+
+		expected := `{
+			"success": false,
+			"error": "missing field is required: name; dueDate must be a date",
+			"fields": [
+				{"field": "name", "code": "required", "message": "missing field is required: name"},
+				{"field": "dueDate", "code": "invalid_date", "message": "dueDate must be a date"}
+			]
+		}`
+
+		response.SendErrJSON(rr, err, http.StatusBadRequest)
+
+		assert.Equal(http.StatusUnprocessableEntity, rr.Code)
+
+		if assert.NotEmpty(rr.Body) {
+			assert.JSONEq(expected, rr.Body.String())
+		}
+	})
+
+	t.Run("OtherError", func(t *testing.T) {
+		assert := assert.New(t)
+		rr := httptest.NewRecorder()
+
+		expected := `{"error":"task not found", "success":false}`
+
+		response.SendErrJSON(rr, errors.New("task not found"), http.StatusBadRequest)
+
+		assert.Equal(http.StatusBadRequest, rr.Code)
+
+		if assert.NotEmpty(rr.Body) {
+			assert.JSONEq(expected, rr.Body.String())
+		}
+	})
+}
@@ -0,0 +1,88 @@
+// Package validation provides an error type that describes every field of
+// a request that is not valid, so that clients can show which fields failed.
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrMissingField = errors.New("missing field is required")
+var ErrInvalidField = errors.New("invalid field")
+
+// CodeRequired is the code of a field that is missing
+const CodeRequired = "required"
+
+// FieldError describes why the value of a field is not valid. The code is meant
+// to be read by clients, and the message is meant to be read by users.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error collects the errors of every field that is not valid. It matches
+// ErrInvalidField with errors.Is, and ErrMissingField if a field is missing.
+type Error struct {
+	Fields []FieldError
+}
+
+// Required returns an Error for a single missing field
+func Required(field string) *Error {
+	e := &Error{}
+	e.Required(field)
+	return e
+}
+
+// Add adds an error for a field
+func (e *Error) Add(field, code, message string) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Code: code, Message: message})
+}
+
+// Required adds an error for a field that is missing
+func (e *Error) Required(field string) {
+	e.Add(field, CodeRequired, fmt.Sprintf("%s: %s", ErrMissingField, field))
+}
+
+// Err returns nil if no field errors were added, so that
+// an Error can be built up and returned in one step
+func (e *Error) Err() error {
+	if e == nil || len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e *Error) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		messages[i] = f.Message
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrInvalidField:
+		return true
+	case ErrMissingField:
+		for _, f := range e.Fields {
+			if f.Code == CodeRequired {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// As returns the Error in err's chain, if there is one
+func As(err error) (*Error, bool) {
+	var vErr *Error
+	if errors.As(err, &vErr) {
+		return vErr, true
+	}
+	return nil, false
+}
@@ -0,0 +1,48 @@
+package validation_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ricxi/flat-list/shared/validation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestError(t *testing.T) {
+	t.Run("NoFields", func(t *testing.T) {
+		var vErr validation.Error
+		assert.NoError(t, vErr.Err())
+	})
+
+	t.Run("MissingField", func(t *testing.T) {
+		err := fmt.Errorf("creating task: %w", validation.Required("name"))
+
+		assert.EqualError(t, err, "creating task: missing field is required: name")
+		assert.ErrorIs(t, err, validation.ErrMissingField)
+		assert.ErrorIs(t, err, validation.ErrInvalidField)
+
+		vErr, ok := validation.As(err)
+		require.True(t, ok)
+		assert.Equal(t, []validation.FieldError{
+			{Field: "name", Code: validation.CodeRequired, Message: "missing field is required: name"},
+		}, vErr.Fields)
+	})
+
+	t.Run("InvalidFields", func(t *testing.T) {
+		var vErr validation.Error
+		vErr.Add("password", "too_short", "password must be at least 12 characters long")
+		vErr.Add("email", "invalid_email", "email is not a valid email address")
+		err := vErr.Err()
+
+		assert.EqualError(t, err, "password must be at least 12 characters long; email is not a valid email address")
+		assert.ErrorIs(t, err, validation.ErrInvalidField)
+		assert.False(t, errors.Is(err, validation.ErrMissingField))
+	})
+
+	t.Run("NotAValidationError", func(t *testing.T) {
+		_, ok := validation.As(errors.New("user not found"))
+		assert.False(t, ok)
+	})
+}
@@ -14,6 +14,8 @@ func main() {
 		"MONGODB_URI",
 		"MONGODB_NAME",
 		"AUTH_ENDPOINT",
+		"INTERNAL_PORT",
+		"INTERNAL_SECRET",
 	)
 	if err != nil {
 		log.Fatal(err)
@@ -35,6 +37,17 @@ func main() {
 		Handler: h,
 	}
 
+	// the internal server is used by the other services and should not be exposed publicly
+	internalSrv := task.Server{
+		Port:    envs["INTERNAL_PORT"],
+		Handler: task.NewInternalHTTPHandler(s, envs["INTERNAL_SECRET"]),
+	}
+	go func() {
+		if err := internalSrv.Run(); err != nil {
+			log.Println(err)
+		}
+	}()
+
 	if err := srv.Run(); err != nil {
 		log.Println(err)
 	}
@@ -15,6 +15,7 @@ type NewTaskDocument struct {
 	Details   string             `bson:"details,omitempty"`
 	Priority  string             `bson:"priority,omitempty"`
 	Category  string             `bson:"category,omitempty"`
+	DueDate   string             `bson:"dueDate,omitempty"`
 	CreatedAt *time.Time         `bson:"createdAt,omitempty"`
 	UpdatedAt *time.Time         `bson:"updatedAt,omitempty"`
 }
@@ -23,7 +24,8 @@ type NewTaskDocument struct {
 // a repository layer that implements mongo
 // to communicate with the mongo API.
 // The 'omitempty' tag is used to prevent an empty
-// field value from whiping a document during an update.
+// field value from whiping a document during an update,
+// except for the due date, which is unset when it is empty.
 type TaskDocument struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty"`
 	UserID    primitive.ObjectID `bson:"userId,omitempty"`
@@ -31,6 +33,7 @@ type TaskDocument struct {
 	Details   string             `bson:"details,omitempty"`
 	Priority  string             `bson:"priority,omitempty"`
 	Category  string             `bson:"category,omitempty"`
+	DueDate   string             `bson:"dueDate,omitempty"`
 	CreatedAt *time.Time         `bson:"createdAt,omitempty"`
 	UpdatedAt *time.Time         `bson:"updatedAt,omitempty"`
 }
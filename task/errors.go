@@ -1,6 +1,10 @@
 package task
 
-import "errors"
+import (
+	"errors"
 
-var ErrMissingField = errors.New("missing field is required")
+	"github.com/ricxi/flat-list/shared/validation"
+)
+
+var ErrMissingField = validation.ErrMissingField
 var ErrTaskNotFound = errors.New("task not found")
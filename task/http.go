@@ -1,11 +1,16 @@
 package task
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/ricxi/flat-list/shared/authz"
 	req "github.com/ricxi/flat-list/shared/request"
 	res "github.com/ricxi/flat-list/shared/response"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
 )
 
 func NewHTTPHandler(service Service, middlewares ...func(http.Handler) http.Handler) http.Handler {
@@ -14,13 +19,15 @@ func NewHTTPHandler(service Service, middlewares ...func(http.Handler) http.Hand
 	}
 
 	r := chi.NewMux()
+	r.Use(res.RequestID)
 	r.Use(middlewares...)
 
 	r.Route("/v1/task", func(r chi.Router) {
-		r.Post("/", h.handleCreateTask)
-		r.Get("/{id}", h.handleGetTask)
-		r.Put("/", h.handleUpdateTask)
-		r.Delete("/{id}", h.handleDeleteTask)
+		// requests made with a personal access token need the scope for each route
+		r.With(authz.RequireScope(authz.ScopeTasksWrite)).Post("/", h.handleCreateTask)
+		r.With(authz.RequireScope(authz.ScopeTasksRead)).Get("/{id}", h.handleGetTask)
+		r.With(authz.RequireScope(authz.ScopeTasksWrite)).Put("/", h.handleUpdateTask)
+		r.With(authz.RequireScope(authz.ScopeTasksWrite)).Delete("/{id}", h.handleDeleteTask)
 	})
 
 	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
@@ -31,28 +38,67 @@ func NewHTTPHandler(service Service, middlewares ...func(http.Handler) http.Hand
 	return r
 }
 
+// NewInternalHTTPHandler returns a handler for the routes that are only called
+// by the other services, so it should not be exposed outside of their network.
+// The services must also send the shared secret (see RequireInternalSecret).
+func NewInternalHTTPHandler(service Service, secret string) http.Handler {
+	h := &httpHandler{
+		service: service,
+	}
+
+	r := chi.NewMux()
+	r.Use(res.RequestID)
+	r.Use(RequireInternalSecret(secret))
+
+	r.Route("/v1/internal/task", func(r chi.Router) {
+		r.Get("/user/{userId}", h.handleGetUserTasks)
+		r.Get("/user/{userId}/due", h.handleGetDueUserTasks)
+		r.Get("/user/{userId}/count", h.handleCountUserTasks)
+		r.Delete("/user/{userId}", h.handleDeleteUserTasks)
+	})
+
+	return r
+}
+
 type httpHandler struct {
 	service Service
 }
 
+// errorClassifier maps the errors that the service returns to status codes
+var errorClassifier = res.NewErrorClassifier().
+	// an id that is not an object id can't belong to any task
+	Map(http.StatusNotFound, ErrTaskNotFound, primitive.ErrInvalidHex).
+	MapFunc(http.StatusServiceUnavailable, isUnavailable)
+
+// isUnavailable checks if an error was caused by the
+// database being unreachable or taking too long to respond
+func isUnavailable(err error) bool {
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) {
+		return true
+	}
+
+	var selectionErr topology.ServerSelectionError
+	return errors.As(err, &selectionErr)
+}
+
 func (h *httpHandler) handleCreateTask(w http.ResponseWriter, r *http.Request) {
 	var newTask NewTask // it doesn't need its date fields yet, but should I really create an entirely new data type for this?
 
 	if err := req.ParseJSON(r, &newTask); err != nil {
-		res.SendErrorJSON(w, err.Error(), http.StatusBadRequest)
+		res.SendError(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	userID, err := getUserIDFromCtx(r.Context())
 	if err != nil {
-		res.SendErrorJSON(w, err.Error(), http.StatusBadRequest)
+		res.SendError(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
 	newTask.UserID = userID
 
 	taskID, err := h.service.createTask(r.Context(), &newTask)
 	if err != nil {
-		res.SendErrorJSON(w, err.Error(), http.StatusBadRequest)
+		errorClassifier.SendError(w, r, err)
 		return
 	}
 
@@ -62,14 +108,19 @@ func (h *httpHandler) handleCreateTask(w http.ResponseWriter, r *http.Request) {
 func (h *httpHandler) handleGetTask(w http.ResponseWriter, r *http.Request) {
 	taskID := chi.URLParam(r, "id")
 	if taskID == "" {
-		res.SendErrorJSON(w, "missing url param id", http.StatusBadRequest)
+		res.SendError(w, r, "missing url param id", http.StatusBadRequest)
 		return
 	}
 
-	task, err := h.service.getTaskByID(r.Context(), taskID)
+	userID, err := getUserIDFromCtx(r.Context())
 	if err != nil {
-		// check for ErrTaskNotFound and return a status not found
-		res.SendErrorJSON(w, err.Error(), http.StatusBadRequest)
+		res.SendError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	task, err := h.service.getTaskByID(r.Context(), userID, taskID)
+	if err != nil {
+		errorClassifier.SendError(w, r, err)
 		return
 	}
 
@@ -81,19 +132,20 @@ func (h *httpHandler) handleUpdateTask(w http.ResponseWriter, r *http.Request) {
 
 	userID, err := getUserIDFromCtx(r.Context())
 	if err != nil {
-		res.SendErrorJSON(w, err.Error(), http.StatusBadRequest)
+		res.SendError(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
-	task.UserID = userID
 
 	if err := req.ParseJSON(r, &task); err != nil {
-		res.SendErrorJSON(w, err.Error(), http.StatusBadRequest)
+		res.SendError(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
+	// the user is set after the body is parsed, so that it can't be changed to another user's id
+	task.UserID = userID
 
 	updatedTask, err := h.service.updateTask(r.Context(), &task)
 	if err != nil {
-		res.SendErrorJSON(w, err.Error(), http.StatusBadRequest)
+		errorClassifier.SendError(w, r, err)
 		return
 	}
 
@@ -103,12 +155,18 @@ func (h *httpHandler) handleUpdateTask(w http.ResponseWriter, r *http.Request) {
 func (h *httpHandler) handleDeleteTask(w http.ResponseWriter, r *http.Request) {
 	taskID := chi.URLParam(r, "id")
 	if taskID == "" {
-		res.SendErrorJSON(w, "missing url param id", http.StatusBadRequest)
+		res.SendError(w, r, "missing url param id", http.StatusBadRequest)
 		return
 	}
 
-	if err := h.service.deleteTask(r.Context(), taskID); err != nil {
-		res.SendErrorJSON(w, err.Error(), http.StatusBadRequest)
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		res.SendError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.deleteTask(r.Context(), userID, taskID); err != nil {
+		errorClassifier.SendError(w, r, err)
 		return
 	}
 
@@ -118,3 +176,73 @@ func (h *httpHandler) handleDeleteTask(w http.ResponseWriter, r *http.Request) {
 
 	res.SendJSON(w, &body, http.StatusOK, nil)
 }
+
+// handleGetUserTasks returns all the tasks of a user who is exporting their data
+func (h *httpHandler) handleGetUserTasks(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userId")
+	if userID == "" {
+		res.SendError(w, r, "missing url param userId", http.StatusBadRequest)
+		return
+	}
+
+	tasks, err := h.service.getTasksByUserID(r.Context(), userID)
+	if err != nil {
+		errorClassifier.SendError(w, r, err)
+		return
+	}
+
+	res.SendSuccessJSON(w, res.Payload{"tasks": tasks}, http.StatusOK, nil)
+}
+
+// handleCountUserTasks returns how many tasks a user has, so that the
+// user service can tell if their data export is too large to wait for
+func (h *httpHandler) handleCountUserTasks(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userId")
+	if userID == "" {
+		res.SendError(w, r, "missing url param userId", http.StatusBadRequest)
+		return
+	}
+
+	count, err := h.service.countTasksByUserID(r.Context(), userID)
+	if err != nil {
+		errorClassifier.SendError(w, r, err)
+		return
+	}
+
+	res.SendSuccessJSON(w, res.Payload{"count": count}, http.StatusOK, nil)
+}
+
+// handleGetDueUserTasks returns the tasks of a user that are due on or before
+// a date (eg. ?date=2023-05-01), which are sent to them in a daily digest
+func (h *httpHandler) handleGetDueUserTasks(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userId")
+	if userID == "" {
+		res.SendError(w, r, "missing url param userId", http.StatusBadRequest)
+		return
+	}
+
+	tasks, err := h.service.getDueTasksByUserID(r.Context(), userID, r.URL.Query().Get("date"))
+	if err != nil {
+		errorClassifier.SendError(w, r, err)
+		return
+	}
+
+	res.SendSuccessJSON(w, res.Payload{"tasks": tasks}, http.StatusOK, nil)
+}
+
+// handleDeleteUserTasks deletes all the tasks of a user who is deleting their account
+func (h *httpHandler) handleDeleteUserTasks(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userId")
+	if userID == "" {
+		res.SendError(w, r, "missing url param userId", http.StatusBadRequest)
+		return
+	}
+
+	deletedCount, err := h.service.deleteTasksByUserID(r.Context(), userID)
+	if err != nil {
+		errorClassifier.SendError(w, r, err)
+		return
+	}
+
+	res.SendSuccessJSON(w, res.Payload{"deletedCount": deletedCount}, http.StatusOK, nil)
+}
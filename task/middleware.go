@@ -3,15 +3,36 @@ package task
 import (
 	"bytes"
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/ricxi/flat-list/shared/authz"
 	res "github.com/ricxi/flat-list/shared/response"
 )
 
+// InternalSecretHeader is the header that the other services send the internal api's shared secret in
+const InternalSecretHeader = "X-Internal-Secret"
+
+// RequireInternalSecret only lets through requests that have the shared secret in
+// the X-Internal-Secret header, so that the internal api can't be called by anyone
+// that can reach it. Every request is refused if the secret is empty.
+func RequireInternalSecret(secret string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if secret == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get(InternalSecretHeader)), []byte(secret)) != 1 {
+				res.SendError(w, r, "invalid internal secret", http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 type Middleware struct {
 	AuthEndpoint string
 }
@@ -20,42 +41,44 @@ func (m *Middleware) Authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		token, err := getAuthToken(r)
 		if err != nil {
-			res.SendErrorJSON(w, err.Error(), http.StatusUnauthorized)
+			res.SendError(w, r, err.Error(), http.StatusUnauthorized)
 			return
 		}
 
 		reqBody := new(bytes.Buffer)
 		if err := json.NewEncoder(reqBody).Encode(map[string]string{"token": token}); err != nil {
-			res.SendErrorJSON(w, err.Error(), http.StatusInternalServerError)
+			res.SendError(w, r, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
 		req, err := http.NewRequest(http.MethodPost, m.AuthEndpoint, reqBody)
 		req.Header.Set("Content-Type", "application/json")
 		if err != nil {
-			res.SendErrorJSON(w, err.Error(), http.StatusInternalServerError)
+			res.SendError(w, r, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
 		client := &http.Client{Timeout: 10 * time.Second}
 		resp, err := client.Do(req)
 		if err != nil {
-			res.SendErrorJSON(w, err.Error(), http.StatusUnauthorized)
+			res.SendError(w, r, err.Error(), http.StatusUnauthorized)
 			return
 		}
 
 		if resp.StatusCode != http.StatusOK {
-			res.SendErrorJSON(w, "unable to authorize user", http.StatusUnauthorized)
+			res.SendError(w, r, "unable to authorize user", http.StatusUnauthorized)
 			return
 		}
 
-		userID, err := getUserID(resp)
+		principal, err := getPrincipal(resp)
 		if err != nil {
-			res.SendErrorJSON(w, "unable to authorize user", http.StatusUnauthorized)
+			res.SendError(w, r, "unable to authorize user", http.StatusUnauthorized)
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), UserIDCtxKey, userID)
+		// the principal is stored so that routes can be limited to some roles
+		ctx := context.WithValue(r.Context(), UserIDCtxKey, principal.UserID)
+		ctx = authz.WithPrincipal(ctx, principal)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -77,24 +100,17 @@ func getAuthToken(r *http.Request) (string, error) {
 	return slicedAuthHeader[1], nil
 }
 
-// getUserID obtains the user id in the response body
-func getUserID(resp *http.Response) (string, error) {
-	// authData := make(map[string]string)
-	authData := struct {
-		UserID string `json:"userId"`
-	}{}
+// getPrincipal obtains the user id and roles in the response body
+func getPrincipal(resp *http.Response) (*authz.Principal, error) {
+	var principal authz.Principal
 	defer resp.Body.Close()
-	if err := json.NewDecoder(resp.Body).Decode(&authData); err != nil {
-		return "", err
+	if err := json.NewDecoder(resp.Body).Decode(&principal); err != nil {
+		return nil, err
 	}
 
-	// userID, ok := authData["userId"]
-	// if !ok || userID == "" {
-	// 	return "", errors.New("invalid or missing user id")
-	// }
-	if authData.UserID == "" {
-		return "", errors.New("invalid or missing user id")
+	if principal.UserID == "" {
+		return nil, errors.New("invalid or missing user id")
 	}
 
-	return authData.UserID, nil
+	return &principal, nil
 }
@@ -5,6 +5,7 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/ricxi/flat-list/shared/authz"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -151,3 +152,45 @@ func TestMiddlewareAuthenticate(t *testing.T) {
 		assert.JSONEq(expected, rr.Body.String())
 	})
 }
+
+func TestMiddlewareAuthenticateRoles(t *testing.T) {
+	adminOnly := authz.RequireRole(authz.RoleAdmin)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name          string
+		authResponse  string
+		expStatusCode int
+	}{
+		{
+			name:          "Admin",
+			authResponse:  `{"success":true,"userId":"507f191e810c19729de860ea","roles":["admin"]}`,
+			expStatusCode: http.StatusOK,
+		},
+		{
+			name:          "FailNotAdmin",
+			authResponse:  `{"success":true,"userId":"507f191e810c19729de860ea","roles":[]}`,
+			expStatusCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(tt.authResponse))
+			}))
+			defer ts.Close()
+
+			m := &Middleware{AuthEndpoint: ts.URL}
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Authorization", "Bearer jwttoken")
+			rr := httptest.NewRecorder()
+
+			m.Authenticate(adminOnly).ServeHTTP(rr, r)
+
+			assert.Equal(t, tt.expStatusCode, rr.Code)
+		})
+	}
+}
@@ -9,44 +9,79 @@ import (
 var _ Repository = &mockRepository{}
 
 type mockRepository struct {
-	taskID string
-	task   *Task
-	err    error
+	taskID       string
+	task         *Task
+	tasks        []Task
+	deletedCount int64
+	err          error
 }
 
 func (m *mockRepository) createTask(ctx context.Context, task *NewTask) (string, error) {
 	return m.taskID, m.err
 }
 
-func (m *mockRepository) getTaskByID(ctx context.Context, id string) (*Task, error) {
+func (m *mockRepository) getTaskByID(ctx context.Context, userID, id string) (*Task, error) {
 	if _, err := primitive.ObjectIDFromHex(id); err != nil {
 		return nil, err
 	}
+	if !m.owns(userID) {
+		return nil, ErrTaskNotFound
+	}
 	return m.task, m.err
 }
 
 func (m *mockRepository) updateTask(ctx context.Context, task *Task) (*Task, error) {
+	if !m.owns(task.UserID) {
+		return nil, ErrTaskNotFound
+	}
 	return m.task, m.err
 }
 
-func (m *mockRepository) deleteTaskByID(ctx context.Context, id string) error {
+func (m *mockRepository) deleteTaskByID(ctx context.Context, userID, id string) error {
+	if !m.owns(userID) {
+		return ErrTaskNotFound
+	}
 	return m.err
 }
 
+// owns is like filtering by the user in the repository: if
+// m.task is set, it can only be found by the user it belongs to
+func (m *mockRepository) owns(userID string) bool {
+	return m.task == nil || m.task.UserID == userID
+}
+
+func (m *mockRepository) deleteTasksByUserID(ctx context.Context, userID string) (int64, error) {
+	return m.deletedCount, m.err
+}
+
+func (m *mockRepository) getTasksByUserID(ctx context.Context, userID string) ([]Task, error) {
+	return m.tasks, m.err
+}
+
+func (m *mockRepository) countTasksByUserID(ctx context.Context, userID string) (int64, error) {
+	return int64(len(m.tasks)), m.err
+}
+
+func (m *mockRepository) getDueTasksByUserID(ctx context.Context, userID, date string) ([]Task, error) {
+	return m.tasks, m.err
+}
+
 var _ Service = &mockService{}
 
 // mockService is used by the http handler
 type mockService struct {
-	taskID string
-	task   *Task
-	err    error
+	taskID       string
+	task         *Task
+	tasks        []Task
+	deletedCount int64
+	err          error
 }
 
 func (m *mockService) createTask(ctx context.Context, task *NewTask) (string, error) {
 	return m.taskID, m.err
 }
 
-func (m *mockService) getTaskByID(ctx context.Context, id string) (*Task, error) {
+func (m *mockService) getTaskByID(ctx context.Context, userID, id string) (*Task, error) {
 	return m.task, m.err
 }
 
@@ -55,6 +90,22 @@ func (m *mockService) updateTask(ctx context.Context, task *Task) (*Task, error)
 	return m.task, m.err
 }
 
-func (m *mockService) deleteTask(ctx context.Context, id string) error {
+func (m *mockService) deleteTask(ctx context.Context, userID, id string) error {
 	return m.err
 }
+
+func (m *mockService) deleteTasksByUserID(ctx context.Context, userID string) (int64, error) {
+	return m.deletedCount, m.err
+}
+
+func (m *mockService) getTasksByUserID(ctx context.Context, userID string) ([]Task, error) {
+	return m.tasks, m.err
+}
+
+func (m *mockService) countTasksByUserID(ctx context.Context, userID string) (int64, error) {
+	return int64(len(m.tasks)), m.err
+}
+
+func (m *mockService) getDueTasksByUserID(ctx context.Context, userID, date string) ([]Task, error) {
+	return m.tasks, m.err
+}
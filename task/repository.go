@@ -14,9 +14,13 @@ import (
 
 type Repository interface {
 	createTask(ctx context.Context, task *NewTask) (string, error)
-	getTaskByID(ctx context.Context, id string) (*Task, error)
+	getTaskByID(ctx context.Context, userID, id string) (*Task, error)
 	updateTask(ctx context.Context, task *Task) (*Task, error)
-	deleteTaskByID(ctx context.Context, id string) error
+	deleteTaskByID(ctx context.Context, userID, id string) error
+	deleteTasksByUserID(ctx context.Context, userID string) (int64, error)
+	getTasksByUserID(ctx context.Context, userID string) ([]Task, error)
+	countTasksByUserID(ctx context.Context, userID string) (int64, error)
+	getDueTasksByUserID(ctx context.Context, userID, date string) ([]Task, error)
 }
 
 type repository struct {
@@ -64,6 +68,7 @@ func (r *repository) createTask(ctx context.Context, newTask *NewTask) (string,
 		Details:   newTask.Details,
 		Priority:  newTask.Priority,
 		Category:  newTask.Category,
+		DueDate:   newTask.DueDate,
 		CreatedAt: newTask.CreatedAt,
 		UpdatedAt: newTask.UpdatedAt,
 	}
@@ -75,13 +80,14 @@ func (r *repository) createTask(ctx context.Context, newTask *NewTask) (string,
 	return result.InsertedID.(primitive.ObjectID).Hex(), nil
 }
 
-func (r *repository) getTaskByID(ctx context.Context, id string) (*Task, error) {
-	oID, err := primitive.ObjectIDFromHex(id)
+// getTaskByID finds a task that belongs to a user. The user is part of the filter, so
+// another user's task is not found, even if they know its id.
+func (r *repository) getTaskByID(ctx context.Context, userID, id string) (*Task, error) {
+	filter, err := taskFilter(userID, id)
 	if err != nil {
 		return nil, err
 	}
 
-	filter := bson.M{"_id": oID}
 	var taskDoc TaskDocument
 	if err := r.coll.FindOne(ctx, &filter).Decode(&taskDoc); err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
@@ -97,27 +103,34 @@ func (r *repository) getTaskByID(ctx context.Context, id string) (*Task, error)
 		Details:   taskDoc.Details,
 		Priority:  taskDoc.Priority,
 		Category:  taskDoc.Category,
+		DueDate:   taskDoc.DueDate,
 		CreatedAt: taskDoc.CreatedAt,
 		UpdatedAt: taskDoc.UpdatedAt,
 	}, nil
 }
 
+// updateTask updates a task if it belongs to task.UserID
 func (r *repository) updateTask(ctx context.Context, task *Task) (*Task, error) {
-	oID, err := primitive.ObjectIDFromHex(task.ID)
+	filter, err := taskFilter(task.UserID, task.ID)
 	if err != nil {
 		return nil, err
 	}
 
-	filter := bson.M{"_id": oID}
 	update := bson.M{
 		"$set": &TaskDocument{
 			Name:      task.Name,
 			Details:   task.Details,
 			Priority:  task.Priority,
 			Category:  task.Category,
+			DueDate:   task.DueDate,
 			UpdatedAt: task.UpdatedAt,
 		},
 	}
+	// an empty due date is left out of $set by omitempty, so it is
+	// unset instead, otherwise a task's due date could never be removed
+	if task.DueDate == "" {
+		update["$unset"] = bson.M{"dueDate": ""}
+	}
 	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
 	result := r.coll.FindOneAndUpdate(ctx, &filter, &update, opts)
 	if err := result.Err(); err != nil {
@@ -139,18 +152,20 @@ func (r *repository) updateTask(ctx context.Context, task *Task) (*Task, error)
 		Details:   taskDoc.Details,
 		Priority:  taskDoc.Priority,
 		Category:  taskDoc.Category,
+		DueDate:   taskDoc.DueDate,
 		CreatedAt: taskDoc.CreatedAt,
 		UpdatedAt: taskDoc.UpdatedAt,
 	}, nil
 }
 
-func (r *repository) deleteTaskByID(ctx context.Context, id string) error {
-	oID, err := primitive.ObjectIDFromHex(id)
+// deleteTaskByID deletes a task if it belongs to the user
+func (r *repository) deleteTaskByID(ctx context.Context, userID, id string) error {
+	filter, err := taskFilter(userID, id)
 	if err != nil {
 		return err
 	}
 
-	result, err := r.coll.DeleteOne(ctx, bson.M{"_id": oID})
+	result, err := r.coll.DeleteOne(ctx, filter)
 	if err != nil {
 		return err
 	}
@@ -163,3 +178,120 @@ func (r *repository) deleteTaskByID(ctx context.Context, id string) error {
 
 	return nil
 }
+
+// deleteTasksByUserID deletes every task that belongs to a
+// user and returns how many were deleted (which can be 0)
+func (r *repository) deleteTasksByUserID(ctx context.Context, userID string) (int64, error) {
+	uOID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := r.coll.DeleteMany(ctx, bson.M{"userId": uOID})
+	if err != nil {
+		return 0, err
+	}
+
+	return result.DeletedCount, nil
+}
+
+// getTasksByUserID returns every task that belongs to a user, oldest first
+func (r *repository) getTasksByUserID(ctx context.Context, userID string) ([]Task, error) {
+	uOID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := options.Find().SetSort(bson.M{"createdAt": 1})
+	cursor, err := r.coll.Find(ctx, bson.M{"userId": uOID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var taskDocs []TaskDocument
+	if err := cursor.All(ctx, &taskDocs); err != nil {
+		return nil, err
+	}
+
+	tasks := make([]Task, 0, len(taskDocs))
+	for _, taskDoc := range taskDocs {
+		tasks = append(tasks, Task{
+			ID:        taskDoc.ID.Hex(),
+			UserID:    taskDoc.UserID.Hex(),
+			Name:      taskDoc.Name,
+			Details:   taskDoc.Details,
+			Priority:  taskDoc.Priority,
+			Category:  taskDoc.Category,
+			DueDate:   taskDoc.DueDate,
+			CreatedAt: taskDoc.CreatedAt,
+			UpdatedAt: taskDoc.UpdatedAt,
+		})
+	}
+
+	return tasks, nil
+}
+
+// countTasksByUserID returns how many tasks belong to a user
+func (r *repository) countTasksByUserID(ctx context.Context, userID string) (int64, error) {
+	uOID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return r.coll.CountDocuments(ctx, bson.M{"userId": uOID})
+}
+
+// getDueTasksByUserID returns the tasks of a user that are due on or before a date, earliest first
+func (r *repository) getDueTasksByUserID(ctx context.Context, userID, date string) ([]Task, error) {
+	uOID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// dates sort as strings, since they are all written as yyyy-mm-dd
+	filter := bson.M{"userId": uOID, "dueDate": bson.M{"$exists": true, "$lte": date}}
+	opts := options.Find().SetSort(bson.D{{Key: "dueDate", Value: 1}, {Key: "createdAt", Value: 1}})
+	cursor, err := r.coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var taskDocs []TaskDocument
+	if err := cursor.All(ctx, &taskDocs); err != nil {
+		return nil, err
+	}
+
+	tasks := make([]Task, 0, len(taskDocs))
+	for _, taskDoc := range taskDocs {
+		tasks = append(tasks, Task{
+			ID:        taskDoc.ID.Hex(),
+			UserID:    taskDoc.UserID.Hex(),
+			Name:      taskDoc.Name,
+			Details:   taskDoc.Details,
+			Priority:  taskDoc.Priority,
+			Category:  taskDoc.Category,
+			DueDate:   taskDoc.DueDate,
+			CreatedAt: taskDoc.CreatedAt,
+			UpdatedAt: taskDoc.UpdatedAt,
+		})
+	}
+
+	return tasks, nil
+}
+
+// taskFilter matches a task by its id, but only if it belongs to the user
+func taskFilter(userID, id string) (bson.M, error) {
+	oID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	uOID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return bson.M{"_id": oID, "userId": uOID}, nil
+}
@@ -89,7 +89,7 @@ func TestRepositoryGetTaskByID(t *testing.T) {
 		require.True(primitive.IsValidObjectID(taskID))
 
 		expectedTask := createExpectedTaskFromNew(taskID, newTask)
-		actualTask, err := r.getTaskByID(context.Background(), taskID)
+		actualTask, err := r.getTaskByID(context.Background(), newTask.UserID, taskID)
 		assert.NoError(err)
 
 		if assert.NotNil(actualTask) && assert.NotEmpty(*actualTask) {
@@ -104,11 +104,21 @@ func TestRepositoryGetTaskByID(t *testing.T) {
 		}
 	})
 
+	t.Run("FailAnotherUsersTask", func(t *testing.T) {
+		newTask := createNewTaskForRepo()
+		taskID, err := r.createTask(context.Background(), &newTask)
+		require.NoError(t, err)
+
+		task, err := r.getTaskByID(context.Background(), primitive.NewObjectID().Hex(), taskID)
+		assert.Nil(t, task)
+		assert.ErrorIs(t, err, ErrTaskNotFound)
+	})
+
 	t.Run("Fail", func(t *testing.T) {
 		assert := assert.New(t)
 		taskID := primitive.NewObjectID().Hex()
 
-		task, err := r.getTaskByID(context.Background(), taskID)
+		task, err := r.getTaskByID(context.Background(), primitive.NewObjectID().Hex(), taskID)
 		assert.Nil(task)
 		if assert.Error(err) {
 			assert.EqualError(err, ErrTaskNotFound.Error())
@@ -133,6 +143,7 @@ func TestRepositoryUpdateTask(t *testing.T) {
 
 		updatePayload := Task{
 			ID:       taskID,
+			UserID:   newTask.UserID,
 			Priority: "medium",
 		}
 
@@ -153,11 +164,39 @@ func TestRepositoryUpdateTask(t *testing.T) {
 		}
 	})
 
+	t.Run("SuccessClearDueDate", func(t *testing.T) {
+		require := require.New(t)
+
+		newTask := createNewTaskForRepo()
+		newTask.DueDate = "2023-05-01"
+		taskID, err := r.createTask(context.Background(), &newTask)
+		require.NoError(err)
+
+		updatedTask, err := r.updateTask(context.Background(), &Task{ID: taskID, UserID: newTask.UserID, Name: newTask.Name})
+		require.NoError(err)
+		assert.Empty(t, updatedTask.DueDate)
+
+		task, err := r.getTaskByID(context.Background(), newTask.UserID, taskID)
+		require.NoError(err)
+		assert.Empty(t, task.DueDate)
+	})
+
+	t.Run("FailAnotherUsersTask", func(t *testing.T) {
+		newTask := createNewTaskForRepo()
+		taskID, err := r.createTask(context.Background(), &newTask)
+		require.NoError(t, err)
+
+		updatedTask, err := r.updateTask(context.Background(), &Task{ID: taskID, UserID: primitive.NewObjectID().Hex(), Name: "Groceries"})
+		assert.Nil(t, updatedTask)
+		assert.ErrorIs(t, err, ErrTaskNotFound)
+	})
+
 	t.Run("FailUpdateTask", func(t *testing.T) {
 		assert := assert.New(t)
 		taskID := primitive.NewObjectID().Hex()
 		updatePayload := Task{
 			ID:       taskID,
+			UserID:   primitive.NewObjectID().Hex(),
 			Priority: "medium",
 		}
 
@@ -184,7 +223,11 @@ func TestDeleteTaskByID(t *testing.T) {
 			require.True(primitive.IsValidObjectID(taskID))
 		}
 
-		err = r.deleteTaskByID(context.Background(), taskID)
+		// another user can't delete it
+		err = r.deleteTaskByID(context.Background(), primitive.NewObjectID().Hex(), taskID)
+		assert.ErrorIs(err, ErrTaskNotFound)
+
+		err = r.deleteTaskByID(context.Background(), newTask.UserID, taskID)
 		assert.NoError(err)
 	})
 
@@ -192,7 +235,7 @@ func TestDeleteTaskByID(t *testing.T) {
 		assert := assert.New(t)
 
 		taskID := primitive.NewObjectID().Hex()
-		err := r.deleteTaskByID(context.Background(), taskID)
+		err := r.deleteTaskByID(context.Background(), primitive.NewObjectID().Hex(), taskID)
 		if assert.Error(err) {
 			assert.EqualError(err, ErrTaskNotFound.Error())
 		}
@@ -2,15 +2,20 @@ package task
 
 import (
 	"context"
-	"fmt"
 	"time"
+
+	"github.com/ricxi/flat-list/shared/validation"
 )
 
 type Service interface {
 	createTask(ctx context.Context, task *NewTask) (string, error)
-	getTaskByID(ctx context.Context, id string) (*Task, error)
+	getTaskByID(ctx context.Context, userID, id string) (*Task, error)
 	updateTask(ctx context.Context, task *Task) (*Task, error)
-	deleteTask(ctx context.Context, id string) error
+	deleteTask(ctx context.Context, userID, id string) error
+	deleteTasksByUserID(ctx context.Context, userID string) (int64, error)
+	getTasksByUserID(ctx context.Context, userID string) ([]Task, error)
+	countTasksByUserID(ctx context.Context, userID string) (int64, error)
+	getDueTasksByUserID(ctx context.Context, userID, date string) ([]Task, error)
 }
 
 type service struct {
@@ -26,12 +31,19 @@ func NewService(repository Repository) *service {
 // CreateTask returns the task's id if a new task is successfully
 // created and inserted into the database; otherwise it returns an error
 func (s *service) createTask(ctx context.Context, task *NewTask) (string, error) {
+	var vErr validation.Error
 	if task.Name == "" {
-		return "", fmt.Errorf("%w: name", ErrMissingField)
+		vErr.Required("name")
 	}
 
 	if task.UserID == "" {
-		return "", fmt.Errorf("%w: userId", ErrMissingField)
+		vErr.Required("userId")
+	}
+
+	checkDueDate(&vErr, task.DueDate)
+
+	if err := vErr.Err(); err != nil {
+		return "", err
 	}
 
 	createdAt := time.Now().UTC()
@@ -42,25 +54,37 @@ func (s *service) createTask(ctx context.Context, task *NewTask) (string, error)
 	return s.repository.createTask(ctx, task)
 }
 
-func (s *service) getTaskByID(ctx context.Context, id string) (*Task, error) {
+// getTaskByID returns a task if it belongs to the user; another user's task is not found
+func (s *service) getTaskByID(ctx context.Context, userID, id string) (*Task, error) {
 	if id == "" {
-		return nil, fmt.Errorf("%w: taskId", ErrMissingField)
+		return nil, validation.Required("taskId")
+	}
+
+	if userID == "" {
+		return nil, validation.Required("userId")
 	}
 
-	return s.repository.getTaskByID(ctx, id)
+	return s.repository.getTaskByID(ctx, userID, id)
 }
 
 func (s *service) updateTask(ctx context.Context, task *Task) (*Task, error) {
+	var vErr validation.Error
 	if task.ID == "" {
-		return nil, fmt.Errorf("%w: taskId", ErrMissingField)
+		vErr.Required("taskId")
 	}
 
 	if task.UserID == "" {
-		return nil, fmt.Errorf("%w: userId", ErrMissingField)
+		vErr.Required("userId")
 	}
 
 	if task.Name == "" {
-		return nil, fmt.Errorf("%w: name", ErrMissingField)
+		vErr.Required("name")
+	}
+
+	checkDueDate(&vErr, task.DueDate)
+
+	if err := vErr.Err(); err != nil {
+		return nil, err
 	}
 
 	updatedAt := time.Now().UTC()
@@ -69,10 +93,77 @@ func (s *service) updateTask(ctx context.Context, task *Task) (*Task, error) {
 	return s.repository.updateTask(ctx, task)
 }
 
-func (s *service) deleteTask(ctx context.Context, id string) error {
+// deleteTask deletes a task if it belongs to the user
+func (s *service) deleteTask(ctx context.Context, userID, id string) error {
 	if id == "" {
-		return fmt.Errorf("%w: taskId", ErrMissingField)
+		return validation.Required("taskId")
+	}
+
+	if userID == "" {
+		return validation.Required("userId")
+	}
+
+	return s.repository.deleteTaskByID(ctx, userID, id)
+}
+
+// deleteTasksByUserID is called by the user service when a user deletes their account
+func (s *service) deleteTasksByUserID(ctx context.Context, userID string) (int64, error) {
+	if userID == "" {
+		return 0, validation.Required("userId")
+	}
+
+	return s.repository.deleteTasksByUserID(ctx, userID)
+}
+
+// getTasksByUserID is called by the user service when a user exports their data
+func (s *service) getTasksByUserID(ctx context.Context, userID string) ([]Task, error) {
+	if userID == "" {
+		return nil, validation.Required("userId")
 	}
 
-	return s.repository.deleteTaskByID(ctx, id)
+	return s.repository.getTasksByUserID(ctx, userID)
+}
+
+// countTasksByUserID is called by the user service to find out if a user's
+// data export is small enough to generate while the user waits
+func (s *service) countTasksByUserID(ctx context.Context, userID string) (int64, error) {
+	if userID == "" {
+		return 0, validation.Required("userId")
+	}
+
+	return s.repository.countTasksByUserID(ctx, userID)
+}
+
+// getDueTasksByUserID is called by the user service to send a user a digest of
+// the tasks that are due on a date (which is today where the user is) or overdue
+func (s *service) getDueTasksByUserID(ctx context.Context, userID, date string) ([]Task, error) {
+	var vErr validation.Error
+	if userID == "" {
+		vErr.Required("userId")
+	}
+
+	if date == "" {
+		vErr.Required("date")
+	} else {
+		checkDate(&vErr, "date", date)
+	}
+
+	if err := vErr.Err(); err != nil {
+		return nil, err
+	}
+
+	return s.repository.getDueTasksByUserID(ctx, userID, date)
+}
+
+// checkDueDate adds an error if a task's due date is set and is not a date
+func checkDueDate(vErr *validation.Error, dueDate string) {
+	if dueDate != "" {
+		checkDate(vErr, "dueDate", dueDate)
+	}
+}
+
+func checkDate(vErr *validation.Error, field, date string) {
+	if _, err := time.Parse(dateLayout, date); err != nil {
+		vErr.Add(field, "invalid_date", field+" must be a date, such as 2023-05-01")
+	}
 }
@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/ricxi/flat-list/shared/validation"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -62,6 +63,22 @@ func TestServiceCreateTask(t *testing.T) {
 		}
 	})
 
+	t.Run("FailCreateTaskInvalidDueDate", func(t *testing.T) {
+		s := &service{repository: &mockRepository{}}
+
+		task := NewTask{
+			UserID:  primitive.NewObjectID().Hex(),
+			Name:    "Laundry",
+			DueDate: "05/01/2023",
+		}
+
+		_, err := s.createTask(context.Background(), &task)
+		vErr, ok := validation.As(err)
+		if assert.True(t, ok) {
+			assert.Equal(t, "invalid_date", vErr.Fields[0].Code)
+		}
+	})
+
 	t.Run("FailCreateTaskMissingUserIDField", func(t *testing.T) {
 		assert := assert.New(t)
 		s := &service{
@@ -109,7 +126,7 @@ func TestServiceGetTaskByID(t *testing.T) {
 			},
 		}
 
-		actualTask, err := s.getTaskByID(context.Background(), task.ID)
+		actualTask, err := s.getTaskByID(context.Background(), task.UserID, task.ID)
 		assert.NoError(err)
 		if assert.NotNil(actualTask) && assert.NotEmpty(*actualTask) {
 			assert.Equal(task.ID, actualTask.ID)
@@ -134,7 +151,7 @@ func TestServiceGetTaskByID(t *testing.T) {
 		}
 		taskID := primitive.NewObjectID().Hex()
 
-		actualTask, err := s.getTaskByID(context.Background(), taskID)
+		actualTask, err := s.getTaskByID(context.Background(), primitive.NewObjectID().Hex(), taskID)
 		require.Nil(t, actualTask)
 		if assert.Error(err) {
 			assert.EqualError(err, ErrTaskNotFound.Error())
@@ -261,7 +278,7 @@ func TestServiceDeleteTask(t *testing.T) {
 		}
 
 		taskID := primitive.NewObjectID().Hex()
-		err := s.deleteTask(context.Background(), taskID)
+		err := s.deleteTask(context.Background(), primitive.NewObjectID().Hex(), taskID)
 		assert.NoError(t, err)
 	})
 
@@ -274,7 +291,7 @@ func TestServiceDeleteTask(t *testing.T) {
 		}
 
 		taskID := ""
-		err := s.deleteTask(context.Background(), taskID)
+		err := s.deleteTask(context.Background(), primitive.NewObjectID().Hex(), taskID)
 		foundErr := assert.Error(t, err)
 		if foundErr {
 			assert.EqualError(t, err, ErrMissingField.Error()+": taskId")
@@ -290,10 +307,64 @@ func TestServiceDeleteTask(t *testing.T) {
 		}
 
 		taskID := primitive.NewObjectID().Hex()
-		err := s.deleteTask(context.Background(), taskID)
+		err := s.deleteTask(context.Background(), primitive.NewObjectID().Hex(), taskID)
 		foundErr := assert.Error(err)
 		if foundErr {
 			assert.EqualError(err, ErrTaskNotFound.Error())
 		}
 	})
 }
+
+func TestServiceDeleteTasksByUserID(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		s := service{
+			repository: &mockRepository{
+				deletedCount: 3,
+			},
+		}
+
+		deletedCount, err := s.deleteTasksByUserID(context.Background(), primitive.NewObjectID().Hex())
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), deletedCount)
+	})
+
+	t.Run("FailMissingFieldUserID", func(t *testing.T) {
+		s := service{
+			repository: &mockRepository{},
+		}
+
+		_, err := s.deleteTasksByUserID(context.Background(), "")
+		if assert.Error(t, err) {
+			assert.EqualError(t, err, ErrMissingField.Error()+": userId")
+		}
+	})
+}
+
+func TestServiceGetDueTasksByUserID(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		s := service{
+			repository: &mockRepository{
+				tasks: []Task{{Name: "Laundry", DueDate: "2023-05-01"}},
+			},
+		}
+
+		tasks, err := s.getDueTasksByUserID(context.Background(), primitive.NewObjectID().Hex(), "2023-05-01")
+		assert.NoError(t, err)
+		assert.Len(t, tasks, 1)
+	})
+
+	t.Run("FailMissingFieldDate", func(t *testing.T) {
+		s := service{repository: &mockRepository{}}
+
+		_, err := s.getDueTasksByUserID(context.Background(), primitive.NewObjectID().Hex(), "")
+		assert.ErrorIs(t, err, ErrMissingField)
+	})
+
+	t.Run("FailInvalidDate", func(t *testing.T) {
+		s := service{repository: &mockRepository{}}
+
+		_, err := s.getDueTasksByUserID(context.Background(), primitive.NewObjectID().Hex(), "tomorrow")
+		_, ok := validation.As(err)
+		assert.True(t, ok)
+	})
+}
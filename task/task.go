@@ -2,12 +2,17 @@ package task
 
 import "time"
 
+// dateLayout is the format of due dates, which are calendar dates rather
+// than times, so that a task is due on the same day wherever its user is
+const dateLayout = "2006-01-02"
+
 type NewTask struct {
 	UserID    string     `json:"userId,omitempty"`
 	Name      string     `json:"name"`
 	Details   string     `json:"details,omitempty"`
 	Priority  string     `json:"priority,omitempty"`
 	Category  string     `json:"category,omitempty"`
+	DueDate   string     `json:"dueDate,omitempty"`
 	CreatedAt *time.Time `json:"createdAt,omitempty"`
 	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
 }
@@ -19,6 +24,7 @@ type Task struct {
 	Details   string     `json:"details,omitempty"`
 	Priority  string     `json:"priority,omitempty"`
 	Category  string     `json:"category,omitempty"`
+	DueDate   string     `json:"dueDate,omitempty"`
 	CreatedAt *time.Time `json:"createdAt,omitempty"`
 	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
 }
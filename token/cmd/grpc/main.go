@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net"
+	"time"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/ricxi/flat-list/shared/config"
@@ -25,6 +27,10 @@ func main() {
 
 	repo := token.NewRepository(db)
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go token.CleanupStaleTokens(ctx, repo, time.Hour)
+
 	lis, err := net.Listen("tcp", ":"+envs["GRPC_PORT"])
 	if err != nil {
 		log.Fatalln("fail to listen on tcp", err)
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"time"
@@ -23,6 +24,10 @@ func main() {
 	defer db.Close()
 
 	repo := token.NewRepository(db)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go token.CleanupStaleTokens(ctx, repo, time.Hour)
 	h := token.NewHTTPHandler(repo)
 
 	srv := &http.Server{
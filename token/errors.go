@@ -0,0 +1,11 @@
+package token
+
+import "errors"
+
+var ErrTokenNotFound = errors.New("activation token not found")
+var ErrTokenExpired = errors.New("activation token has expired")
+var ErrTokenUsed = errors.New("activation token has already been used")
+var ErrInvalidTokenPurpose = errors.New("invalid token purpose")
+var ErrPersonalAccessTokenNotFound = errors.New("personal access token not found")
+var ErrInvalidPersonalAccessToken = errors.New("invalid or expired personal access token")
+var ErrDuplicateTokenName = errors.New("a personal access token with this name already exists")
@@ -2,8 +2,21 @@ package token
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"github.com/ricxi/flat-list/token/pb"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// the reasons that are sent with a FailedPrecondition error from ValidateActivationToken,
+// so that the caller can tell if the token was used or has expired
+const (
+	errorDomain        = "token.flat-list"
+	reasonTokenUsed    = "TOKEN_USED"
+	reasonTokenExpired = "TOKEN_EXPIRED"
 )
 
 type Server struct {
@@ -11,14 +24,23 @@ type Server struct {
 	Repository
 }
 
+// CreateActivationToken creates a single use token for a user, which
+// can only be validated for the purpose that it was created for
 func (s Server) CreateActivationToken(ctx context.Context, req *pb.CreateTokenRequest) (*pb.CreateTokenResponse, error) {
+	purpose, err := tokenPurpose(req.GetPurpose())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
 	activationToken, err := generateActivationToken()
 	if err != nil {
 		return nil, err
 	}
 	if err := s.insertActivationToken(ctx, &ActivationTokenInfo{
-		Token:  activationToken,
-		UserID: req.UserId,
+		Token:     activationToken,
+		UserID:    req.UserId,
+		Purpose:   purpose,
+		ExpiresAt: time.Now().Add(activationTokenTTL),
 	}); err != nil {
 		return nil, err
 	}
@@ -28,9 +50,26 @@ func (s Server) CreateActivationToken(ctx context.Context, req *pb.CreateTokenRe
 	}, nil
 }
 
+// ValidateActivationToken returns the id of the user an activation token belongs to,
+// and marks the token as used. The error is NotFound if the token does not exist or is
+// for another purpose, and FailedPrecondition if it has expired or was already used,
+// with an ErrorInfo detail that has the reason (TOKEN_EXPIRED or TOKEN_USED).
 func (s Server) ValidateActivationToken(ctx context.Context, req *pb.ValidateTokenRequest) (*pb.ValidateTokenResponse, error) {
-	userID, err := s.getUserID(ctx, req.ActivationToken)
+	purpose, err := tokenPurpose(req.GetPurpose())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	userID, err := s.consumeActivationToken(ctx, req.ActivationToken, purpose)
 	if err != nil {
+		switch {
+		case errors.Is(err, ErrTokenNotFound):
+			return nil, status.Error(codes.NotFound, err.Error())
+		case errors.Is(err, ErrTokenExpired):
+			return nil, failedPrecondition(err, reasonTokenExpired)
+		case errors.Is(err, ErrTokenUsed):
+			return nil, failedPrecondition(err, reasonTokenUsed)
+		}
 		return nil, err
 	}
 
@@ -38,3 +77,120 @@ func (s Server) ValidateActivationToken(ctx context.Context, req *pb.ValidateTok
 		UserId: userID,
 	}, nil
 }
+
+// failedPrecondition returns a FailedPrecondition error with the reason that it failed
+func failedPrecondition(err error, reason string) error {
+	st := status.New(codes.FailedPrecondition, err.Error())
+	withReason, detailsErr := st.WithDetails(&errdetails.ErrorInfo{Reason: reason, Domain: errorDomain})
+	if detailsErr != nil {
+		return st.Err()
+	}
+
+	return withReason.Err()
+}
+
+// DeleteUserTokens is called when a user deletes their account
+func (s Server) DeleteUserTokens(ctx context.Context, req *pb.DeleteUserTokensRequest) (*pb.DeleteUserTokensResponse, error) {
+	deletedCount, err := s.deleteUserTokens(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.DeleteUserTokensResponse{
+		DeletedCount: deletedCount,
+	}, nil
+}
+
+// CreatePersonalAccessToken creates a token for a user, which is only returned once.
+// The user service checks the name, scopes and expiry before it is called.
+func (s Server) CreatePersonalAccessToken(ctx context.Context, req *pb.CreatePersonalAccessTokenRequest) (*pb.CreatePersonalAccessTokenResponse, error) {
+	if req.UserId == "" || req.Name == "" || len(req.Scopes) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "user id, name and scopes are required")
+	}
+
+	expiresAt := time.Unix(req.ExpiresAt, 0)
+	if !expiresAt.After(time.Now()) {
+		return nil, status.Error(codes.InvalidArgument, "expiry must be in the future")
+	}
+
+	token, id, err := generatePersonalAccessToken()
+	if err != nil {
+		return nil, err
+	}
+
+	pat := PersonalAccessToken{
+		ID:        id,
+		UserID:    req.UserId,
+		Name:      req.Name,
+		Scopes:    req.Scopes,
+		ExpiresAt: expiresAt,
+	}
+	if err := s.insertPersonalAccessToken(ctx, &pat, token); err != nil {
+		if errors.Is(err, ErrDuplicateTokenName) {
+			return nil, status.Error(codes.AlreadyExists, err.Error())
+		}
+		return nil, err
+	}
+
+	return &pb.CreatePersonalAccessTokenResponse{
+		Token:               token,
+		PersonalAccessToken: newPersonalAccessTokenMessage(&pat),
+	}, nil
+}
+
+func (s Server) ListPersonalAccessTokens(ctx context.Context, req *pb.ListPersonalAccessTokensRequest) (*pb.ListPersonalAccessTokensResponse, error) {
+	pats, err := s.listPersonalAccessTokens(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	out := pb.ListPersonalAccessTokensResponse{}
+	for i := range pats {
+		out.PersonalAccessTokens = append(out.PersonalAccessTokens, newPersonalAccessTokenMessage(&pats[i]))
+	}
+
+	return &out, nil
+}
+
+func (s Server) RevokePersonalAccessToken(ctx context.Context, req *pb.RevokePersonalAccessTokenRequest) (*pb.RevokePersonalAccessTokenResponse, error) {
+	if err := s.deletePersonalAccessToken(ctx, req.UserId, req.Id); err != nil {
+		if errors.Is(err, ErrPersonalAccessTokenNotFound) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		return nil, err
+	}
+
+	return &pb.RevokePersonalAccessTokenResponse{}, nil
+}
+
+// ValidatePersonalAccessToken returns the user and scopes of a token,
+// and fails with Unauthenticated if it does not exist or has expired
+func (s Server) ValidatePersonalAccessToken(ctx context.Context, req *pb.ValidatePersonalAccessTokenRequest) (*pb.ValidatePersonalAccessTokenResponse, error) {
+	pat, err := s.usePersonalAccessToken(ctx, req.Token)
+	if err != nil {
+		if errors.Is(err, ErrInvalidPersonalAccessToken) {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		return nil, err
+	}
+
+	return &pb.ValidatePersonalAccessTokenResponse{
+		PersonalAccessToken: newPersonalAccessTokenMessage(pat),
+	}, nil
+}
+
+func newPersonalAccessTokenMessage(pat *PersonalAccessToken) *pb.PersonalAccessToken {
+	msg := &pb.PersonalAccessToken{
+		Id:        pat.ID,
+		UserId:    pat.UserID,
+		Name:      pat.Name,
+		Scopes:    pat.Scopes,
+		CreatedAt: pat.CreatedAt.Unix(),
+		ExpiresAt: pat.ExpiresAt.Unix(),
+	}
+	if pat.LastUsedAt != nil {
+		msg.LastUsedAt = pat.LastUsedAt.Unix()
+	}
+
+	return msg
+}
@@ -0,0 +1,111 @@
+package token
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/ricxi/flat-list/token/pb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	consumeTokenQuery = "UPDATE activation_tokens SET used_at = now()"
+	tokenUsedAtQuery  = "SELECT used_at FROM activation_tokens"
+)
+
+func TestServer_ValidateActivationToken(t *testing.T) {
+	tests := []struct {
+		name      string
+		expect    func(mock sqlmock.Sqlmock)
+		expUserID string
+		expCode   codes.Code
+		expReason string
+	}{
+		{
+			name: "Success",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(consumeTokenQuery).
+					WithArgs(hashToken("activationtoken"), purposeActivation).
+					WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow("5ef7fdd91c19e3222b41b839"))
+			},
+			expUserID: "5ef7fdd91c19e3222b41b839",
+			expCode:   codes.OK,
+		},
+		{
+			name: "FailUsed",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(consumeTokenQuery).WillReturnError(sql.ErrNoRows)
+				mock.ExpectQuery(tokenUsedAtQuery).
+					WithArgs(hashToken("activationtoken"), purposeActivation).
+					WillReturnRows(sqlmock.NewRows([]string{"used_at"}).AddRow(time.Now()))
+			},
+			expCode:   codes.FailedPrecondition,
+			expReason: reasonTokenUsed,
+		},
+		{
+			name: "FailExpired",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(consumeTokenQuery).WillReturnError(sql.ErrNoRows)
+				mock.ExpectQuery(tokenUsedAtQuery).
+					WillReturnRows(sqlmock.NewRows([]string{"used_at"}).AddRow(nil))
+			},
+			expCode:   codes.FailedPrecondition,
+			expReason: reasonTokenExpired,
+		},
+		{
+			name: "FailNotFound",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(consumeTokenQuery).WillReturnError(sql.ErrNoRows)
+				mock.ExpectQuery(tokenUsedAtQuery).WillReturnError(sql.ErrNoRows)
+			},
+			expCode: codes.NotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+			tt.expect(mock)
+
+			s := Server{Repository: NewRepository(db)}
+			out, err := s.ValidateActivationToken(context.Background(), &pb.ValidateTokenRequest{
+				ActivationToken: "activationtoken",
+				Purpose:         pb.TokenPurpose_TOKEN_PURPOSE_ACTIVATION,
+			})
+			assert.NoError(t, mock.ExpectationsWereMet())
+
+			if tt.expCode != codes.OK {
+				assert.Nil(t, out)
+				st := status.Convert(err)
+				assert.Equal(t, tt.expCode, st.Code())
+				if tt.expReason != "" {
+					require.Len(t, st.Details(), 1)
+					assert.Equal(t, tt.expReason, st.Details()[0].(*errdetails.ErrorInfo).Reason)
+				}
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expUserID, out.UserId)
+		})
+	}
+}
+
+func TestServer_ValidateActivationTokenInvalidPurpose(t *testing.T) {
+	s := Server{}
+
+	_, err := s.ValidateActivationToken(context.Background(), &pb.ValidateTokenRequest{
+		ActivationToken: "activationtoken",
+		Purpose:         pb.TokenPurpose(99),
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
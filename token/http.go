@@ -1,10 +1,15 @@
 package token
 
 import (
-	"encoding/json"
+	"database/sql/driver"
+	"errors"
+	"net"
 	"net/http"
+	"time"
 
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/julienschmidt/httprouter"
+	res "github.com/ricxi/flat-list/shared/response"
 )
 
 func NewHTTPHandler(repository Repository) http.Handler {
@@ -15,66 +20,77 @@ func NewHTTPHandler(repository Repository) http.Handler {
 	r := httprouter.New()
 
 	r.POST("/v1/token/activation/:userId", h.handleCreateToken)
-	r.GET("/v1/token/:userId", h.handleValidateToken)
+	r.GET("/v1/token/:token", h.handleValidateToken)
 
-	return r
+	return res.RequestID(r)
 }
 
 type httpHandler struct {
 	repository Repository
 }
 
+// errorClassifier maps the errors that the repository returns to status codes
+var errorClassifier = res.NewErrorClassifier().
+	Map(http.StatusNotFound, ErrTokenNotFound).
+	Map(http.StatusGone, ErrTokenExpired).
+	Map(http.StatusConflict, ErrTokenUsed).
+	MapFunc(http.StatusServiceUnavailable, isUnavailable)
+
+// isUnavailable checks if an error was caused by the
+// database being unreachable or taking too long to respond
+func isUnavailable(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || pgconn.Timeout(err) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
 // create a token for a given user
 func (h *httpHandler) handleCreateToken(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	activationToken, err := generateActivationToken()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	userID := ps.ByName("userId")
+	if userID == "" {
+		res.SendError(w, r, "user id required", http.StatusBadRequest)
 		return
 	}
 
-	userID := ps.ByName("userId")
-	if userID == "" {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	activationToken, err := generateActivationToken()
+	if err != nil {
+		errorClassifier.SendError(w, r, err)
 		return
 	}
 
 	info := ActivationTokenInfo{
-		Token:  activationToken,
-		UserID: userID,
+		Token:     activationToken,
+		UserID:    userID,
+		Purpose:   purposeActivation,
+		ExpiresAt: time.Now().Add(activationTokenTTL),
 	}
 
 	if err := h.repository.insertActivationToken(r.Context(), &info); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		errorClassifier.SendError(w, r, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
-	if err := json.NewEncoder(w).Encode(map[string]string{"token": activationToken}); err != nil {
-		panic(err)
-	}
+	res.SendJSON(w, map[string]string{"token": activationToken}, http.StatusCreated, nil)
 }
 
-// Return all the activation tokens that are listed under
-// the same user id
+// Return the id of the user an activation token belongs to,
+// which can only be done once for each token. The http api only
+// has activation tokens, so other tokens are not found.
 func (h *httpHandler) handleValidateToken(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	activationToken := ps.ByName("token")
 	if activationToken == "" {
-		http.Error(w, "token required", http.StatusBadRequest)
+		res.SendError(w, r, "token required", http.StatusBadRequest)
 		return
 	}
 
-	userID, err := h.repository.getUserID(r.Context(), activationToken)
+	userID, err := h.repository.consumeActivationToken(r.Context(), activationToken, purposeActivation)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		errorClassifier.SendError(w, r, err)
 		return
 	}
 
-	if err := json.NewEncoder(w).Encode(struct {
-		UserID string `json:"userId"`
-	}{
-		UserID: userID,
-	}); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
+	res.SendJSON(w, map[string]string{"userId": userID}, http.StatusOK, nil)
 }
@@ -23,7 +23,22 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type TokenClient interface {
 	CreateActivationToken(ctx context.Context, in *CreateTokenRequest, opts ...grpc.CallOption) (*CreateTokenResponse, error)
+	// ValidateActivationToken can only succeed once for each token.
+	// It fails with NOT_FOUND if the token does not exist or was created for
+	// another purpose, FAILED_PRECONDITION if it has expired, and ALREADY_EXISTS
+	// if it has already been used.
 	ValidateActivationToken(ctx context.Context, in *ValidateTokenRequest, opts ...grpc.CallOption) (*ValidateTokenResponse, error)
+	// DeleteUserTokens deletes a user's activation and personal access tokens
+	DeleteUserTokens(ctx context.Context, in *DeleteUserTokensRequest, opts ...grpc.CallOption) (*DeleteUserTokensResponse, error)
+	// CreatePersonalAccessToken fails with ALREADY_EXISTS if
+	// the user already has a token with the same name
+	CreatePersonalAccessToken(ctx context.Context, in *CreatePersonalAccessTokenRequest, opts ...grpc.CallOption) (*CreatePersonalAccessTokenResponse, error)
+	ListPersonalAccessTokens(ctx context.Context, in *ListPersonalAccessTokensRequest, opts ...grpc.CallOption) (*ListPersonalAccessTokensResponse, error)
+	// RevokePersonalAccessToken fails with NOT_FOUND if the user has no token with the id
+	RevokePersonalAccessToken(ctx context.Context, in *RevokePersonalAccessTokenRequest, opts ...grpc.CallOption) (*RevokePersonalAccessTokenResponse, error)
+	// ValidatePersonalAccessToken records that a token was used. It fails
+	// with UNAUTHENTICATED if the token does not exist or has expired.
+	ValidatePersonalAccessToken(ctx context.Context, in *ValidatePersonalAccessTokenRequest, opts ...grpc.CallOption) (*ValidatePersonalAccessTokenResponse, error)
 }
 
 type tokenClient struct {
@@ -52,12 +67,72 @@ func (c *tokenClient) ValidateActivationToken(ctx context.Context, in *ValidateT
 	return out, nil
 }
 
+func (c *tokenClient) DeleteUserTokens(ctx context.Context, in *DeleteUserTokensRequest, opts ...grpc.CallOption) (*DeleteUserTokensResponse, error) {
+	out := new(DeleteUserTokensResponse)
+	err := c.cc.Invoke(ctx, "/pb.Token/DeleteUserTokens", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tokenClient) CreatePersonalAccessToken(ctx context.Context, in *CreatePersonalAccessTokenRequest, opts ...grpc.CallOption) (*CreatePersonalAccessTokenResponse, error) {
+	out := new(CreatePersonalAccessTokenResponse)
+	err := c.cc.Invoke(ctx, "/pb.Token/CreatePersonalAccessToken", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tokenClient) ListPersonalAccessTokens(ctx context.Context, in *ListPersonalAccessTokensRequest, opts ...grpc.CallOption) (*ListPersonalAccessTokensResponse, error) {
+	out := new(ListPersonalAccessTokensResponse)
+	err := c.cc.Invoke(ctx, "/pb.Token/ListPersonalAccessTokens", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tokenClient) RevokePersonalAccessToken(ctx context.Context, in *RevokePersonalAccessTokenRequest, opts ...grpc.CallOption) (*RevokePersonalAccessTokenResponse, error) {
+	out := new(RevokePersonalAccessTokenResponse)
+	err := c.cc.Invoke(ctx, "/pb.Token/RevokePersonalAccessToken", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tokenClient) ValidatePersonalAccessToken(ctx context.Context, in *ValidatePersonalAccessTokenRequest, opts ...grpc.CallOption) (*ValidatePersonalAccessTokenResponse, error) {
+	out := new(ValidatePersonalAccessTokenResponse)
+	err := c.cc.Invoke(ctx, "/pb.Token/ValidatePersonalAccessToken", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // TokenServer is the server API for Token service.
 // All implementations must embed UnimplementedTokenServer
 // for forward compatibility
 type TokenServer interface {
 	CreateActivationToken(context.Context, *CreateTokenRequest) (*CreateTokenResponse, error)
+	// ValidateActivationToken can only succeed once for each token.
+	// It fails with NOT_FOUND if the token does not exist or was created for
+	// another purpose, FAILED_PRECONDITION if it has expired, and ALREADY_EXISTS
+	// if it has already been used.
 	ValidateActivationToken(context.Context, *ValidateTokenRequest) (*ValidateTokenResponse, error)
+	// DeleteUserTokens deletes a user's activation and personal access tokens
+	DeleteUserTokens(context.Context, *DeleteUserTokensRequest) (*DeleteUserTokensResponse, error)
+	// CreatePersonalAccessToken fails with ALREADY_EXISTS if
+	// the user already has a token with the same name
+	CreatePersonalAccessToken(context.Context, *CreatePersonalAccessTokenRequest) (*CreatePersonalAccessTokenResponse, error)
+	ListPersonalAccessTokens(context.Context, *ListPersonalAccessTokensRequest) (*ListPersonalAccessTokensResponse, error)
+	// RevokePersonalAccessToken fails with NOT_FOUND if the user has no token with the id
+	RevokePersonalAccessToken(context.Context, *RevokePersonalAccessTokenRequest) (*RevokePersonalAccessTokenResponse, error)
+	// ValidatePersonalAccessToken records that a token was used. It fails
+	// with UNAUTHENTICATED if the token does not exist or has expired.
+	ValidatePersonalAccessToken(context.Context, *ValidatePersonalAccessTokenRequest) (*ValidatePersonalAccessTokenResponse, error)
 	mustEmbedUnimplementedTokenServer()
 }
 
@@ -71,6 +146,21 @@ func (UnimplementedTokenServer) CreateActivationToken(context.Context, *CreateTo
 func (UnimplementedTokenServer) ValidateActivationToken(context.Context, *ValidateTokenRequest) (*ValidateTokenResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ValidateActivationToken not implemented")
 }
+func (UnimplementedTokenServer) DeleteUserTokens(context.Context, *DeleteUserTokensRequest) (*DeleteUserTokensResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteUserTokens not implemented")
+}
+func (UnimplementedTokenServer) CreatePersonalAccessToken(context.Context, *CreatePersonalAccessTokenRequest) (*CreatePersonalAccessTokenResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreatePersonalAccessToken not implemented")
+}
+func (UnimplementedTokenServer) ListPersonalAccessTokens(context.Context, *ListPersonalAccessTokensRequest) (*ListPersonalAccessTokensResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListPersonalAccessTokens not implemented")
+}
+func (UnimplementedTokenServer) RevokePersonalAccessToken(context.Context, *RevokePersonalAccessTokenRequest) (*RevokePersonalAccessTokenResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RevokePersonalAccessToken not implemented")
+}
+func (UnimplementedTokenServer) ValidatePersonalAccessToken(context.Context, *ValidatePersonalAccessTokenRequest) (*ValidatePersonalAccessTokenResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ValidatePersonalAccessToken not implemented")
+}
 func (UnimplementedTokenServer) mustEmbedUnimplementedTokenServer() {}
 
 // UnsafeTokenServer may be embedded to opt out of forward compatibility for this service.
@@ -120,6 +210,96 @@ func _Token_ValidateActivationToken_Handler(srv interface{}, ctx context.Context
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Token_DeleteUserTokens_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteUserTokensRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenServer).DeleteUserTokens(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Token/DeleteUserTokens",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenServer).DeleteUserTokens(ctx, req.(*DeleteUserTokensRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Token_CreatePersonalAccessToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreatePersonalAccessTokenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenServer).CreatePersonalAccessToken(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Token/CreatePersonalAccessToken",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenServer).CreatePersonalAccessToken(ctx, req.(*CreatePersonalAccessTokenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Token_ListPersonalAccessTokens_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListPersonalAccessTokensRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenServer).ListPersonalAccessTokens(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Token/ListPersonalAccessTokens",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenServer).ListPersonalAccessTokens(ctx, req.(*ListPersonalAccessTokensRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Token_RevokePersonalAccessToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RevokePersonalAccessTokenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenServer).RevokePersonalAccessToken(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Token/RevokePersonalAccessToken",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenServer).RevokePersonalAccessToken(ctx, req.(*RevokePersonalAccessTokenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Token_ValidatePersonalAccessToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ValidatePersonalAccessTokenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenServer).ValidatePersonalAccessToken(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Token/ValidatePersonalAccessToken",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenServer).ValidatePersonalAccessToken(ctx, req.(*ValidatePersonalAccessTokenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Token_ServiceDesc is the grpc.ServiceDesc for Token service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -135,6 +315,26 @@ var Token_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "ValidateActivationToken",
 			Handler:    _Token_ValidateActivationToken_Handler,
 		},
+		{
+			MethodName: "DeleteUserTokens",
+			Handler:    _Token_DeleteUserTokens_Handler,
+		},
+		{
+			MethodName: "CreatePersonalAccessToken",
+			Handler:    _Token_CreatePersonalAccessToken_Handler,
+		},
+		{
+			MethodName: "ListPersonalAccessTokens",
+			Handler:    _Token_ListPersonalAccessTokens_Handler,
+		},
+		{
+			MethodName: "RevokePersonalAccessToken",
+			Handler:    _Token_RevokePersonalAccessToken_Handler,
+		},
+		{
+			MethodName: "ValidatePersonalAccessToken",
+			Handler:    _Token_ValidatePersonalAccessToken_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "pb/token.proto",
@@ -3,11 +3,36 @@ package token
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
+// uniqueViolation is the code of the error that postgres returns
+// when a row would break a unique constraint
+const uniqueViolation = "23505"
+
 type ActivationTokenInfo struct {
 	UserID string `json:"user_id"`
 	Token  string `json:"token"`
+	// Purpose is what the token can be used for (see tokenPurpose)
+	Purpose   string    `json:"purpose"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// PersonalAccessToken is a token that a user creates to call the api from
+// scripts. The token itself is never stored, only its hash.
+type PersonalAccessToken struct {
+	ID         string
+	UserID     string
+	Name       string
+	Scopes     []string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	LastUsedAt *time.Time
 }
 
 type Repository struct {
@@ -31,24 +56,223 @@ func Connect(connStr string) (*sql.DB, error) {
 	return db, nil
 }
 
-// insertActivationToken inserts a new activation token for a given user based on their id
+// insertActivationToken inserts a new activation token for a given user based on their id.
+// Only a hash of the token is stored.
 func (r *Repository) insertActivationToken(ctx context.Context, info *ActivationTokenInfo) error {
-	query := "INSERT INTO activation_tokens (token, user_id) VALUES ($1, $2)"
+	query := "INSERT INTO activation_tokens (token_hash, user_id, purpose, expires_at) VALUES ($1, $2, $3, $4)"
 
-	_, err := r.db.ExecContext(ctx, query, info.Token, info.UserID)
+	_, err := r.db.ExecContext(ctx, query, hashToken(info.Token), info.UserID, info.Purpose, info.ExpiresAt)
 
 	return err
 }
 
-// getUserID receives an activation token and searches for the user id associated with it
-func (r *Repository) getUserID(ctx context.Context, activationToken string) (string, error) {
-	query := "SELECT user_id FROM activation_tokens WHERE activation_tokens.token = $1"
+// consumeActivationToken receives an activation token and returns the user id associated with it.
+// The token is marked as used in the same statement that finds it, so it can only be used once
+// even if it is validated more than once at the same time. A token that was created for
+// another purpose is not found.
+func (r *Repository) consumeActivationToken(ctx context.Context, activationToken, purpose string) (string, error) {
+	tokenHash := hashToken(activationToken)
+
+	query := `UPDATE activation_tokens SET used_at = now()
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > now()
+		RETURNING user_id`
 
-	row := r.db.QueryRowContext(ctx, query, activationToken)
 	var userID string
-	if err := row.Scan(&userID); err != nil {
+	err := r.db.QueryRowContext(ctx, query, tokenHash, purpose).Scan(&userID)
+	if err == nil {
+		return userID, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+
+	// find out why the token could not be used
+	query = "SELECT used_at FROM activation_tokens WHERE token_hash = $1 AND purpose = $2"
+
+	var usedAt sql.NullTime
+	if err := r.db.QueryRowContext(ctx, query, tokenHash, purpose).Scan(&usedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrTokenNotFound
+		}
 		return "", err
 	}
 
-	return userID, nil
+	if usedAt.Valid {
+		return "", ErrTokenUsed
+	}
+
+	return "", ErrTokenExpired
+}
+
+// deleteStaleTokens deletes tokens that expired or were used before a given time,
+// and returns how many were deleted
+func (r *Repository) deleteStaleTokens(ctx context.Context, before time.Time) (int64, error) {
+	query := "DELETE FROM activation_tokens WHERE expires_at < $1 OR used_at < $1"
+
+	result, err := r.db.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	deletedCount, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	query = "DELETE FROM personal_access_tokens WHERE expires_at < $1"
+
+	result, err = r.db.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	deletedPATs, err := result.RowsAffected()
+	return deletedCount + deletedPATs, err
+}
+
+// CleanupStaleTokens deletes tokens that are no longer useful at every
+// interval until the context is cancelled. It is meant to be run in its own goroutine.
+func CleanupStaleTokens(ctx context.Context, r Repository, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deletedCount, err := r.deleteStaleTokens(ctx, time.Now().Add(-staleTokenRetention))
+			if err != nil {
+				log.Println("problem deleting stale tokens:", err)
+				continue
+			}
+			if deletedCount > 0 {
+				log.Println("deleted", deletedCount, "stale tokens")
+			}
+		}
+	}
+}
+
+// deleteUserTokens deletes every token that belongs to a user, and returns how many were deleted
+func (r *Repository) deleteUserTokens(ctx context.Context, userID string) (int64, error) {
+	var deletedCount int64
+	for _, query := range []string{
+		"DELETE FROM activation_tokens WHERE activation_tokens.user_id = $1",
+		"DELETE FROM personal_access_tokens WHERE personal_access_tokens.user_id = $1",
+	} {
+		result, err := r.db.ExecContext(ctx, query, userID)
+		if err != nil {
+			return 0, err
+		}
+
+		n, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		deletedCount += n
+	}
+
+	return deletedCount, nil
+}
+
+// insertPersonalAccessToken stores a hash of a new personal access token. It returns
+// ErrDuplicateTokenName if the user already has a token with the same name.
+func (r *Repository) insertPersonalAccessToken(ctx context.Context, pat *PersonalAccessToken, token string) error {
+	query := `INSERT INTO personal_access_tokens (id, user_id, name, token_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING created_at`
+
+	err := r.db.QueryRowContext(ctx, query, pat.ID, pat.UserID, pat.Name, hashToken(token), strings.Join(pat.Scopes, " "), pat.ExpiresAt).
+		Scan(&pat.CreatedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+			return ErrDuplicateTokenName
+		}
+		return err
+	}
+
+	return nil
+}
+
+// listPersonalAccessTokens returns a user's personal access tokens, newest first
+func (r *Repository) listPersonalAccessTokens(ctx context.Context, userID string) ([]PersonalAccessToken, error) {
+	query := `SELECT id, user_id, name, scopes, created_at, expires_at, last_used_at
+		FROM personal_access_tokens WHERE user_id = $1 ORDER BY created_at DESC`
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pats []PersonalAccessToken
+	for rows.Next() {
+		pat, err := scanPersonalAccessToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		pats = append(pats, *pat)
+	}
+
+	return pats, rows.Err()
+}
+
+// deletePersonalAccessToken revokes one of a user's personal access tokens
+func (r *Repository) deletePersonalAccessToken(ctx context.Context, userID, id string) error {
+	query := "DELETE FROM personal_access_tokens WHERE id = $1 AND user_id = $2"
+
+	result, err := r.db.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	deletedCount, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if deletedCount == 0 {
+		return ErrPersonalAccessTokenNotFound
+	}
+
+	return nil
+}
+
+// usePersonalAccessToken returns the personal access token with a hash that matches
+// a token, and records that it was used. It returns ErrInvalidPersonalAccessToken if
+// there is no token with a matching hash or it has expired.
+func (r *Repository) usePersonalAccessToken(ctx context.Context, token string) (*PersonalAccessToken, error) {
+	query := `UPDATE personal_access_tokens SET last_used_at = now()
+		WHERE token_hash = $1 AND expires_at > now()
+		RETURNING id, user_id, name, scopes, created_at, expires_at, last_used_at`
+
+	pat, err := scanPersonalAccessToken(r.db.QueryRowContext(ctx, query, hashToken(token)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidPersonalAccessToken
+		}
+		return nil, err
+	}
+
+	return pat, nil
+}
+
+// scanPersonalAccessToken reads a personal access token from a row
+// that has its columns in the same order as listPersonalAccessTokens
+func scanPersonalAccessToken(row interface{ Scan(dest ...any) error }) (*PersonalAccessToken, error) {
+	var pat PersonalAccessToken
+	var scopes string
+	var lastUsedAt sql.NullTime
+
+	if err := row.Scan(&pat.ID, &pat.UserID, &pat.Name, &scopes, &pat.CreatedAt, &pat.ExpiresAt, &lastUsedAt); err != nil {
+		return nil, err
+	}
+
+	pat.Scopes = strings.Fields(scopes)
+	if lastUsedAt.Valid {
+		pat.LastUsedAt = &lastUsedAt.Time
+	}
+
+	return &pat, nil
 }
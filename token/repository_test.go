@@ -0,0 +1,53 @@
+package token
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepository_DeleteStaleTokens(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+
+	before := time.Now().Add(-staleTokenRetention)
+
+	// only tokens that expired or were used before the cutoff are deleted, so
+	// tokens that can still be used, or that were used recently, are kept
+	mock.ExpectExec("DELETE FROM activation_tokens WHERE expires_at < $1 OR used_at < $1").
+		WithArgs(before).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("DELETE FROM personal_access_tokens WHERE expires_at < $1").
+		WithArgs(before).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	r := NewRepository(db)
+	deletedCount, err := r.deleteStaleTokens(context.Background(), before)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), deletedCount)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRepository_ConsumeActivationTokenOtherPurpose(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	// a token for another purpose doesn't match either query, so it is not found
+	mock.ExpectQuery(consumeTokenQuery).
+		WithArgs(hashToken("emailchangetoken"), purposeActivation).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+	mock.ExpectQuery(tokenUsedAtQuery).
+		WithArgs(hashToken("emailchangetoken"), purposeActivation).
+		WillReturnRows(sqlmock.NewRows([]string{"used_at"}))
+
+	r := NewRepository(db)
+	_, err = r.consumeActivationToken(context.Background(), "emailchangetoken", purposeActivation)
+	assert.ErrorIs(t, err, ErrTokenNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -2,9 +2,42 @@ package token
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base32"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/ricxi/flat-list/shared/authz"
+	"github.com/ricxi/flat-list/token/pb"
+)
+
+const (
+	// how long an activation token can be used for after it is created
+	activationTokenTTL = 24 * time.Hour
+	// how long expired and used tokens are kept before they are removed,
+	// so that a user is told their token was used instead of it not existing
+	staleTokenRetention = 7 * 24 * time.Hour
+)
+
+// the purposes that tokens are stored with, which are the values of pb.TokenPurpose
+const (
+	purposeActivation  = "activation"
+	purposeEmailChange = "email_change"
 )
 
+// tokenPurpose returns the purpose that a token is stored with, or ErrInvalidTokenPurpose
+func tokenPurpose(p pb.TokenPurpose) (string, error) {
+	switch p {
+	case pb.TokenPurpose_TOKEN_PURPOSE_ACTIVATION:
+		return purposeActivation, nil
+	case pb.TokenPurpose_TOKEN_PURPOSE_EMAIL_CHANGE:
+		return purposeEmailChange, nil
+	}
+
+	return "", ErrInvalidTokenPurpose
+}
+
 // generate an activation token that is used
 // to validate a newly registered user's account
 func generateActivationToken() (string, error) {
@@ -18,3 +51,28 @@ func generateActivationToken() (string, error) {
 
 	return token, nil
 }
+
+// generatePersonalAccessToken generates a personal access token and an id that
+// it is listed and revoked with. The token has 256 bits so that its hash can't be
+// reversed, and the prefix lets the user service tell it apart from a jwt.
+func generatePersonalAccessToken() (token, id string, err error) {
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", "", err
+	}
+
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", "", err
+	}
+
+	token = authz.PersonalAccessTokenPrefix + base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(tokenBytes)
+
+	return strings.ToLower(token), hex.EncodeToString(idBytes), nil
+}
+
+// hashToken is used so that tokens are not stored in plain text
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,311 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ricxi/flat-list/shared/authz"
+	"github.com/ricxi/flat-list/shared/validation"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// defaultSearchLimit is how many users are returned by a search without a limit
+	defaultSearchLimit = 20
+	// maxSearchLimit is the most users that a search can return at once
+	maxSearchLimit = 100
+)
+
+// the actions that are recorded in the audit log
+const (
+	auditDeactivate       = "user.deactivate"
+	auditReactivate       = "user.reactivate"
+	auditResendActivation = "user.resend_activation"
+	auditImpersonate      = "user.impersonate"
+	auditGrantRole        = "user.grant_role"
+	auditRevokeRole       = "user.revoke_role"
+)
+
+// AdminAction stores request data for an action
+// that an admin takes on another user's account
+type AdminAction struct {
+	Reason string `json:"reason"`
+	// ActorID is the id of the admin, and UserID is the id of the user
+	// whose account is changed; both are set by the handler
+	ActorID   string `json:"-"`
+	UserID    string `json:"-"`
+	IPAddress string `json:"-"`
+}
+
+// AuditLogEntry records an action that an admin took on another user's account
+type AuditLogEntry struct {
+	ActorID   string
+	Action    string
+	TargetID  string
+	Reason    string
+	IPAddress string
+	CreatedAt *time.Time
+}
+
+// UserSearch stores the query parameters for searching users
+type UserSearch struct {
+	// Query is found in a user's first name, last name or email, ignoring case
+	Query  string
+	Role   string
+	Limit  int
+	Offset int
+}
+
+// AdminUserView is how a user is shown to admins,
+// which includes the state of their account
+type AdminUserView struct {
+	ID               string     `json:"id"`
+	FirstName        string     `json:"firstName"`
+	LastName         string     `json:"lastName"`
+	Email            string     `json:"email"`
+	Roles            []string   `json:"roles"`
+	Activated        bool       `json:"activated"`
+	Deactivated      bool       `json:"deactivated"`
+	TwoFactorEnabled bool       `json:"twoFactorEnabled"`
+	CreatedAt        *time.Time `json:"createdAt"`
+}
+
+// UserSearchResult is a page of the users that match a search
+type UserSearchResult struct {
+	Users  []AdminUserView `json:"users"`
+	Total  int64           `json:"total"`
+	Limit  int             `json:"limit"`
+	Offset int             `json:"offset"`
+}
+
+// Impersonation is a token that lets an admin act as a user for support
+type Impersonation struct {
+	UserID    string    `json:"userId"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func newAdminUserView(uInfo UserInfo) AdminUserView {
+	roles := uInfo.Roles
+	if roles == nil {
+		roles = []string{}
+	}
+
+	return AdminUserView{
+		ID:               uInfo.ID,
+		FirstName:        uInfo.FirstName,
+		LastName:         uInfo.LastName,
+		Email:            uInfo.Email,
+		Roles:            roles,
+		Activated:        uInfo.Activated,
+		Deactivated:      uInfo.Deactivated,
+		TwoFactorEnabled: uInfo.TwoFactorEnabled,
+		CreatedAt:        uInfo.CreatedAt,
+	}
+}
+
+// searchUsers returns a page of the users that match a search, newest first
+func (s *service) searchUsers(ctx context.Context, search UserSearch) (*UserSearchResult, error) {
+	if search.Limit == 0 {
+		search.Limit = defaultSearchLimit
+	}
+
+	var vErr validation.Error
+	if search.Limit < 0 || search.Limit > maxSearchLimit {
+		vErr.Add("limit", "out_of_range", fmt.Sprintf("limit must be between 1 and %d", maxSearchLimit))
+	}
+	if search.Offset < 0 {
+		vErr.Add("offset", "out_of_range", "offset cannot be negative")
+	}
+	if err := vErr.Err(); err != nil {
+		return nil, err
+	}
+
+	users, total, err := s.repository.searchUsers(ctx, search)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	result := UserSearchResult{
+		Users:  make([]AdminUserView, 0, len(users)),
+		Total:  total,
+		Limit:  search.Limit,
+		Offset: search.Offset,
+	}
+	for _, u := range users {
+		result.Users = append(result.Users, newAdminUserView(u))
+	}
+
+	return &result, nil
+}
+
+// deactivateUser stops a user from logging in, and stops
+// any jwt that they already have from being accepted
+func (s *service) deactivateUser(ctx context.Context, a AdminAction) error {
+	if a.UserID == a.ActorID {
+		return ErrCannotDeactivateSelf
+	}
+
+	return s.setDeactivated(ctx, a, true)
+}
+
+// reactivateUser lets a deactivated user log in again
+func (s *service) reactivateUser(ctx context.Context, a AdminAction) error {
+	return s.setDeactivated(ctx, a, false)
+}
+
+func (s *service) setDeactivated(ctx context.Context, a AdminAction, deactivated bool) error {
+	if _, err := s.repository.getUserByID(ctx, a.UserID); err != nil {
+		return err
+	}
+
+	action := auditReactivate
+	if deactivated {
+		action = auditDeactivate
+	}
+	if err := s.audit(ctx, a, action); err != nil {
+		return err
+	}
+
+	updateTime := time.Now().In(time.UTC)
+	userUpdate := UserUpdate{
+		Deactivated: &deactivated,
+		UpdatedAt:   &updateTime,
+	}
+
+	if err := s.repository.updateUserByID(ctx, a.UserID, userUpdate); err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+// resendActivation sends a new activation email to a user who has not
+// activated their account, for users who can't use restartActivation
+// because they don't know their password or never had one
+func (s *service) resendActivation(ctx context.Context, a AdminAction) error {
+	uInfo, err := s.repository.getUserByID(ctx, a.UserID)
+	if err != nil {
+		return err
+	}
+
+	if uInfo.Activated {
+		return ErrUserAlreadyActivated
+	}
+
+	if err := s.audit(ctx, a, auditResendActivation); err != nil {
+		return err
+	}
+
+	activationToken, err := s.token.CreateActivationToken(ctx, uInfo.ID)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if err := s.mailer.sendActivationEmail(ctx, uInfo.Email, uInfo.FirstName, uInfo.Locale, activationToken); err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+// impersonateUser creates a short-lived jwt that lets an admin act as a user.
+// A reason is required, and the token is only created once it is in the audit log.
+func (s *service) impersonateUser(ctx context.Context, a AdminAction) (*Impersonation, error) {
+	if err := s.validate.NonEmptyString("reason", a.Reason); err != nil {
+		return nil, err
+	}
+
+	uInfo, err := s.repository.getUserByID(ctx, a.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	// the token would have the admin's roles, so it could be used to act as them
+	p := authz.Principal{Roles: uInfo.Roles}
+	if p.HasRole(authz.RoleAdmin) {
+		return nil, ErrCannotImpersonateAdmin
+	}
+
+	if uInfo.Deactivated {
+		return nil, ErrUserDeactivated
+	}
+
+	if err := s.audit(ctx, a, auditImpersonate); err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(impersonationExpiry).Truncate(time.Second)
+	token, err := generateImpersonationJWT(uInfo.ID, uInfo.Roles, a.ActorID, expiresAt)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return &Impersonation{UserID: uInfo.ID, Token: token, ExpiresAt: expiresAt}, nil
+}
+
+// audit records an admin's action before it is taken,
+// so that nothing is done that is not in the audit log
+func (s *service) audit(ctx context.Context, a AdminAction, action string) error {
+	createdAt := time.Now().In(time.UTC)
+	entry := AuditLogEntry{
+		ActorID:   a.ActorID,
+		Action:    action,
+		TargetID:  a.UserID,
+		Reason:    a.Reason,
+		IPAddress: a.IPAddress,
+		CreatedAt: &createdAt,
+	}
+
+	if err := s.repository.createAuditLogEntry(ctx, entry); err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+// GrantRole gives a role to the user with an email, or takes it away if
+// revoke is true, and records it in the audit log. It is used to create the
+// first admin, since only admins can manage other users (see cmd/grantrole).
+func GrantRole(ctx context.Context, client *mongo.Client, database, email, role string, revoke bool) error {
+	db := client.Database(database)
+
+	var userDocument UserDocument
+	opts := options.FindOne().SetCollation(emailCollation)
+	if err := db.Collection("users").FindOne(ctx, bson.M{"email": email}, opts).Decode(&userDocument); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return fmt.Errorf("%w by email", ErrUserNotFound)
+		}
+		return err
+	}
+
+	action, update := auditGrantRole, bson.M{"$addToSet": bson.M{"roles": role}}
+	if revoke {
+		action, update = auditRevokeRole, bson.M{"$pull": bson.M{"roles": role}}
+	}
+
+	createdAt := time.Now().In(time.UTC)
+	entry := AuditLogEntry{
+		Action:    action,
+		TargetID:  userDocument.OID.Hex(),
+		Reason:    role,
+		CreatedAt: &createdAt,
+	}
+	if _, err := db.Collection("auditLog").InsertOne(ctx, newAuditLogDocument(entry)); err != nil {
+		return err
+	}
+
+	_, err := db.Collection("users").UpdateByID(ctx, userDocument.OID, update)
+	return err
+}
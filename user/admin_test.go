@@ -0,0 +1,196 @@
+package user
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ricxi/flat-list/shared/authz"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testAdminID = "6448958b96118a48b722fd20"
+	testUserID  = "6448958b96118a48b722fd21"
+)
+
+// usersByIDRepository returns a different user for each id from getUserByID
+type usersByIDRepository struct {
+	*mockRepository
+	users map[string]*UserInfo
+}
+
+func (m *usersByIDRepository) getUserByID(ctx context.Context, id string) (*UserInfo, error) {
+	uInfo, ok := m.users[id]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	copied := *uInfo
+	return &copied, nil
+}
+
+func newAdminTestRepository() *usersByIDRepository {
+	return &usersByIDRepository{
+		mockRepository: &mockRepository{},
+		users: map[string]*UserInfo{
+			testAdminID: {ID: testAdminID, Activated: true, Roles: []string{authz.RoleAdmin}},
+			testUserID:  {ID: testUserID, Email: "dwightschrute@dundermifflin.com", FirstName: "Dwight", Activated: true},
+		},
+	}
+}
+
+func Test_Service_ImpersonateUser(t *testing.T) {
+	t.Setenv("JWT_SECRET_KEY", jwtSecretTestKey)
+
+	repository := newAdminTestRepository()
+	s := &service{repository: repository, validate: &validator{}}
+
+	t.Run("FailMissingReason", func(t *testing.T) {
+		_, err := s.impersonateUser(context.Background(), AdminAction{ActorID: testAdminID, UserID: testUserID})
+		assert.ErrorIs(t, err, ErrMissingField)
+		assert.Empty(t, repository.auditLog)
+	})
+
+	t.Run("FailAdmin", func(t *testing.T) {
+		_, err := s.impersonateUser(context.Background(), AdminAction{ActorID: testAdminID, UserID: testAdminID, Reason: "support ticket 42"})
+		assert.ErrorIs(t, err, ErrCannotImpersonateAdmin)
+		assert.Empty(t, repository.auditLog)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		a := AdminAction{ActorID: testAdminID, UserID: testUserID, Reason: "support ticket 42", IPAddress: "10.0.0.1"}
+		impersonation, err := s.impersonateUser(context.Background(), a)
+		require.NoError(t, err)
+
+		require.Len(t, repository.auditLog, 1)
+		entry := repository.auditLog[0]
+		assert.Equal(t, auditImpersonate, entry.Action)
+		assert.Equal(t, testAdminID, entry.ActorID)
+		assert.Equal(t, testUserID, entry.TargetID)
+		assert.Equal(t, "support ticket 42", entry.Reason)
+		assert.Equal(t, "10.0.0.1", entry.IPAddress)
+
+		principal, err := s.authenticate(context.Background(), impersonation.Token)
+		require.NoError(t, err)
+		assert.Equal(t, testUserID, principal.UserID)
+		assert.Equal(t, testAdminID, principal.ImpersonatorID)
+		assert.False(t, principal.HasRole(authz.RoleAdmin))
+
+		// the token stops working once the admin is no longer an admin
+		repository.users[testAdminID].Roles = nil
+		_, err = s.authenticate(context.Background(), impersonation.Token)
+		assert.ErrorIs(t, err, ErrInvalidJWT)
+	})
+}
+
+func Test_Service_DeactivateUser(t *testing.T) {
+	t.Setenv("JWT_SECRET_KEY", jwtSecretTestKey)
+
+	repository := newAdminTestRepository()
+	s := &service{repository: repository, validate: &validator{}}
+
+	err := s.deactivateUser(context.Background(), AdminAction{ActorID: testAdminID, UserID: testAdminID})
+	assert.ErrorIs(t, err, ErrCannotDeactivateSelf)
+
+	err = s.deactivateUser(context.Background(), AdminAction{ActorID: testAdminID, UserID: "6448958b96118a48b722fd99"})
+	assert.ErrorIs(t, err, ErrUserNotFound)
+
+	err = s.deactivateUser(context.Background(), AdminAction{ActorID: testAdminID, UserID: testUserID, Reason: "spam"})
+	require.NoError(t, err)
+
+	require.Len(t, repository.auditLog, 1)
+	assert.Equal(t, auditDeactivate, repository.auditLog[0].Action)
+	require.Len(t, repository.userUpdates, 1)
+	require.NotNil(t, repository.userUpdates[0].Deactivated)
+	assert.True(t, *repository.userUpdates[0].Deactivated)
+
+	// a jwt that the user already has is no longer accepted
+	signedJWT, err := generateUserJWT(testUserID, nil)
+	require.NoError(t, err)
+	repository.users[testUserID].Deactivated = true
+	_, err = s.authenticate(context.Background(), signedJWT)
+	assert.ErrorIs(t, err, ErrUserDeactivated)
+}
+
+func Test_Service_ResendActivation(t *testing.T) {
+	repository := newAdminTestRepository()
+	s := &service{
+		repository: repository,
+		token:      &mockTokenClient{mockActivationToken: "activationtoken"},
+		mailer:     &mockMailerClient{},
+	}
+
+	err := s.resendActivation(context.Background(), AdminAction{ActorID: testAdminID, UserID: testUserID})
+	assert.ErrorIs(t, err, ErrUserAlreadyActivated)
+	assert.Empty(t, repository.auditLog)
+
+	repository.users[testUserID].Activated = false
+	err = s.resendActivation(context.Background(), AdminAction{ActorID: testAdminID, UserID: testUserID})
+	require.NoError(t, err)
+	require.Len(t, repository.auditLog, 1)
+	assert.Equal(t, auditResendActivation, repository.auditLog[0].Action)
+}
+
+func Test_Service_SearchUsers(t *testing.T) {
+	s := &service{repository: &mockRepository{
+		users: []UserInfo{{ID: testUserID, Email: "dwightschrute@dundermifflin.com", Activated: true}},
+	}}
+
+	result, err := s.searchUsers(context.Background(), UserSearch{Query: "dwight"})
+	require.NoError(t, err)
+	assert.Equal(t, defaultSearchLimit, result.Limit)
+	assert.EqualValues(t, 1, result.Total)
+	require.Len(t, result.Users, 1)
+	assert.Equal(t, []string{}, result.Users[0].Roles)
+
+	_, err = s.searchUsers(context.Background(), UserSearch{Limit: maxSearchLimit + 1, Offset: -1})
+	assert.ErrorIs(t, err, ErrInvalidField)
+	assert.EqualError(t, err, "limit must be between 1 and 100; offset cannot be negative")
+}
+
+func TestHandleAdminRoutes(t *testing.T) {
+	tests := []struct {
+		name          string
+		service       mockService
+		request       *http.Request
+		expStatusCode int
+	}{
+		{
+			name:          "SuccessSearch",
+			service:       mockService{userID: testAdminID, roles: []string{authz.RoleAdmin}, searchResult: &UserSearchResult{Users: []AdminUserView{}}},
+			request:       httptest.NewRequest(http.MethodGet, "/v1/user/admin/users?q=dwight&limit=10", nil),
+			expStatusCode: http.StatusOK,
+		},
+		{
+			name:          "FailSearchLimitNotANumber",
+			service:       mockService{userID: testAdminID, roles: []string{authz.RoleAdmin}},
+			request:       httptest.NewRequest(http.MethodGet, "/v1/user/admin/users?limit=ten", nil),
+			expStatusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:          "FailNotAdmin",
+			service:       mockService{userID: testUserID},
+			request:       httptest.NewRequest(http.MethodPost, "/v1/user/admin/users/"+testAdminID+"/deactivate", nil),
+			expStatusCode: http.StatusForbidden,
+		},
+		{
+			name:          "SuccessDeactivate",
+			service:       mockService{userID: testAdminID, roles: []string{authz.RoleAdmin}},
+			request:       httptest.NewRequest(http.MethodPost, "/v1/user/admin/users/"+testUserID+"/deactivate", nil),
+			expStatusCode: http.StatusNoContent,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.request.Header.Set("Authorization", "Bearer token_goes_here")
+			rr := httptest.NewRecorder()
+
+			NewHTTPHandler(tt.service).ServeHTTP(rr, tt.request)
+
+			assert.Equal(t, tt.expStatusCode, rr.Code, rr.Body.String())
+		})
+	}
+}
@@ -0,0 +1,437 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// LoginAttempts tracks failed attempts to log in to an account or from an ip address
+type LoginAttempts struct {
+	Failures      int
+	LastFailureAt *time.Time
+	LockedUntil   *time.Time
+	// LockoutNotified is set once the user has been emailed about the lockout,
+	// and is cleared with the failures when the count restarts
+	LockoutNotified bool
+}
+
+// AttemptStore stores failed login attempts. The mongo store should be used when
+// more than one instance of the user service is running, so that every instance
+// sees the same attempts. The in-memory store is meant for development and tests.
+type AttemptStore interface {
+	getAttempts(ctx context.Context, key string) (*LoginAttempts, error)
+	// recordFailure adds a failed attempt and returns the number of failures
+	// since the last success, which restarts from one if the last failure
+	// was longer ago than the window
+	recordFailure(ctx context.Context, key string, now time.Time, window time.Duration) (int, error)
+	lock(ctx context.Context, key string, until time.Time) error
+	// markLockoutNotified sets LockoutNotified, and returns false if it was already
+	// set, so that only one caller emails the user even if they fail at the same time
+	markLockoutNotified(ctx context.Context, key string) (bool, error)
+	reset(ctx context.Context, key string) error
+}
+
+// attemptPolicy decides how long to block attempts after failures
+type attemptPolicy struct {
+	// failures that are allowed before attempts are delayed
+	backoffAfter int
+	// failures before attempts are locked out for the lockout duration
+	lockoutAfter int
+	lockout      time.Duration
+	// failures are forgotten if there are none for this long
+	window time.Duration
+}
+
+var (
+	// accountAttemptPolicy limits attempts to log in to a single account
+	accountAttemptPolicy = attemptPolicy{
+		backoffAfter: 3,
+		lockoutAfter: 10,
+		lockout:      15 * time.Minute,
+		window:       time.Hour,
+	}
+	// ipAttemptPolicy limits attempts from a single ip address to any account,
+	// so it allows more failures because users may share an ip address
+	ipAttemptPolicy = attemptPolicy{
+		backoffAfter: 10,
+		lockoutAfter: 50,
+		lockout:      time.Hour,
+		window:       time.Hour,
+	}
+)
+
+// blockDuration returns how long to block attempts after a number of failures.
+// The delay doubles after every failure, until the account is locked out.
+func (p attemptPolicy) blockDuration(failures int) time.Duration {
+	if failures >= p.lockoutAfter {
+		return p.lockout
+	}
+
+	if failures < p.backoffAfter {
+		return 0
+	}
+
+	delay := time.Second
+	for i := p.backoffAfter; i < failures; i++ {
+		delay *= 2
+		if delay >= p.lockout {
+			return p.lockout
+		}
+	}
+
+	return delay
+}
+
+// lockoutError is returned when there have been too many failed
+// attempts, and tells the caller when they can try again
+type lockoutError struct {
+	retryAfter time.Duration
+}
+
+func (e *lockoutError) Error() string {
+	return fmt.Sprintf("%s: retry after %d seconds", ErrTooManyAttempts, e.retrySeconds())
+}
+
+func (e *lockoutError) Unwrap() error {
+	return ErrTooManyAttempts
+}
+
+func (e *lockoutError) retrySeconds() int {
+	return retrySeconds(e.retryAfter)
+}
+
+// retrySeconds rounds up, so that a caller doesn't retry too early
+func retrySeconds(retryAfter time.Duration) int {
+	seconds := int((retryAfter + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
+
+func accountAttemptKey(email string) string {
+	return "account:" + email
+}
+
+func ipAttemptKey(ip string) string {
+	return "ip:" + ip
+}
+
+// checkAttempts returns a lockoutError if attempts for any of the keys are blocked
+func checkAttempts(ctx context.Context, store AttemptStore, now time.Time, keys ...string) error {
+	for _, key := range keys {
+		attempts, err := store.getAttempts(ctx, key)
+		if err != nil {
+			return err
+		}
+
+		if attempts != nil && attempts.LockedUntil != nil && now.Before(*attempts.LockedUntil) {
+			return &lockoutError{retryAfter: attempts.LockedUntil.Sub(now)}
+		}
+	}
+
+	return nil
+}
+
+// recordFailedAttempt adds a failed attempt for a key and blocks further
+// attempts if the policy requires it. It returns the number of failures.
+func recordFailedAttempt(ctx context.Context, store AttemptStore, p attemptPolicy, key string, now time.Time) (int, error) {
+	failures, err := store.recordFailure(ctx, key, now, p.window)
+	if err != nil {
+		return 0, err
+	}
+
+	if block := p.blockDuration(failures); block > 0 {
+		if err := store.lock(ctx, key, now.Add(block)); err != nil {
+			return failures, err
+		}
+	}
+
+	return failures, nil
+}
+
+// memoryPruneInterval is how often the in-memory store removes attempts that have expired
+const memoryPruneInterval = time.Minute
+
+// loginAttemptsExpiry is how long after its last failure that mongo deletes a key's
+// attempts (see ttlIndexes), which is longer than any policy's window or lockout
+const loginAttemptsExpiry = 24 * time.Hour
+
+type memoryAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]memoryAttempts
+	// lastPrune is when attempts that have expired were last removed
+	lastPrune time.Time
+}
+
+// memoryAttempts are the attempts for a key and when they can be forgotten,
+// which is when the window of the last failure and any lockout are both over
+type memoryAttempts struct {
+	LoginAttempts
+	expiresAt time.Time
+}
+
+func NewMemoryAttemptStore() AttemptStore {
+	return &memoryAttemptStore{
+		attempts: make(map[string]memoryAttempts),
+	}
+}
+
+func (m *memoryAttemptStore) getAttempts(ctx context.Context, key string) (*LoginAttempts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attempts, ok := m.attempts[key]
+	if !ok {
+		return nil, nil
+	}
+
+	return &attempts.LoginAttempts, nil
+}
+
+func (m *memoryAttemptStore) recordFailure(ctx context.Context, key string, now time.Time, window time.Duration) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.prune(now)
+
+	attempts := m.attempts[key]
+	if attempts.LastFailureAt != nil && now.Sub(*attempts.LastFailureAt) > window {
+		attempts = memoryAttempts{}
+	}
+
+	attempts.Failures++
+	attempts.LastFailureAt = &now
+	if expiresAt := now.Add(window); expiresAt.After(attempts.expiresAt) {
+		attempts.expiresAt = expiresAt
+	}
+	m.attempts[key] = attempts
+
+	return attempts.Failures, nil
+}
+
+func (m *memoryAttemptStore) lock(ctx context.Context, key string, until time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attempts := m.attempts[key]
+	attempts.LockedUntil = &until
+	if until.After(attempts.expiresAt) {
+		attempts.expiresAt = until
+	}
+	m.attempts[key] = attempts
+
+	return nil
+}
+
+func (m *memoryAttemptStore) markLockoutNotified(ctx context.Context, key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attempts, ok := m.attempts[key]
+	if !ok || attempts.LockoutNotified {
+		return false, nil
+	}
+
+	attempts.LockoutNotified = true
+	m.attempts[key] = attempts
+
+	return true, nil
+}
+
+// prune removes the attempts that have expired, at most once every
+// memoryPruneInterval, so that the store doesn't keep growing.
+// The caller must hold the lock.
+func (m *memoryAttemptStore) prune(now time.Time) {
+	if now.Sub(m.lastPrune) < memoryPruneInterval {
+		return
+	}
+	m.lastPrune = now
+
+	for key, attempts := range m.attempts {
+		if now.After(attempts.expiresAt) {
+			delete(m.attempts, key)
+		}
+	}
+}
+
+func (m *memoryAttemptStore) reset(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.attempts, key)
+	return nil
+}
+
+// LoginAttemptsDocument is used to store failed login attempts in mongo
+type LoginAttemptsDocument struct {
+	Key             string     `bson:"_id"`
+	Failures        int        `bson:"failures"`
+	LastFailureAt   *time.Time `bson:"lastFailureAt,omitempty"`
+	LockedUntil     *time.Time `bson:"lockedUntil,omitempty"`
+	LockoutNotified bool       `bson:"lockoutNotified,omitempty"`
+}
+
+type mongoAttemptStore struct {
+	coll *mongo.Collection
+}
+
+func NewMongoAttemptStore(client *mongo.Client, database string) AttemptStore {
+	return &mongoAttemptStore{
+		coll: client.Database(database).Collection("loginAttempts"),
+	}
+}
+
+func (m *mongoAttemptStore) getAttempts(ctx context.Context, key string) (*LoginAttempts, error) {
+	var attemptsDocument LoginAttemptsDocument
+	if err := m.coll.FindOne(ctx, bson.M{"_id": key}).Decode(&attemptsDocument); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &LoginAttempts{
+		Failures:        attemptsDocument.Failures,
+		LastFailureAt:   attemptsDocument.LastFailureAt,
+		LockedUntil:     attemptsDocument.LockedUntil,
+		LockoutNotified: attemptsDocument.LockoutNotified,
+	}, nil
+}
+
+// recordFailure uses an update pipeline so that the count is
+// restarted and incremented in one step across every instance
+func (m *mongoAttemptStore) recordFailure(ctx context.Context, key string, now time.Time, window time.Duration) (int, error) {
+	restart := bson.M{"$lt": bson.A{"$lastFailureAt", now.Add(-window)}}
+	update := bson.A{
+		bson.M{"$set": bson.M{
+			"failures": bson.M{"$cond": bson.A{
+				restart,
+				1,
+				bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$failures", 0}}, 1}},
+			}},
+			"lockoutNotified": bson.M{"$cond": bson.A{
+				restart,
+				false,
+				bson.M{"$ifNull": bson.A{"$lockoutNotified", false}},
+			}},
+			"lastFailureAt": now,
+		}},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var attemptsDocument LoginAttemptsDocument
+	if err := m.coll.FindOneAndUpdate(ctx, bson.M{"_id": key}, update, opts).Decode(&attemptsDocument); err != nil {
+		return 0, err
+	}
+
+	return attemptsDocument.Failures, nil
+}
+
+func (m *mongoAttemptStore) lock(ctx context.Context, key string, until time.Time) error {
+	_, err := m.coll.UpdateOne(ctx, bson.M{"_id": key}, bson.M{"$set": bson.M{"lockedUntil": until}})
+	return err
+}
+
+func (m *mongoAttemptStore) markLockoutNotified(ctx context.Context, key string) (bool, error) {
+	filter := bson.M{"_id": key, "lockoutNotified": bson.M{"$ne": true}}
+	result, err := m.coll.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"lockoutNotified": true}})
+	if err != nil {
+		return false, err
+	}
+
+	return result.ModifiedCount == 1, nil
+}
+
+func (m *mongoAttemptStore) reset(ctx context.Context, key string) error {
+	_, err := m.coll.DeleteOne(ctx, bson.M{"_id": key})
+	return err
+}
+
+// checkLoginAttempts returns a lockoutError if there have been too many
+// failed attempts to log in to an account, or from the user's ip address.
+// Failed attempts are not tracked if the service has no attempt store.
+func (s *service) checkLoginAttempts(ctx context.Context, u UserLoginInfo) error {
+	if s.attempts == nil {
+		return nil
+	}
+
+	keys := []string{accountAttemptKey(u.Email)}
+	if u.IPAddress != "" {
+		keys = append(keys, ipAttemptKey(u.IPAddress))
+	}
+
+	if err := checkAttempts(ctx, s.attempts, time.Now(), keys...); err != nil {
+		if !errors.Is(err, ErrTooManyAttempts) {
+			log.Println(err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// recordLoginFailure adds a failed attempt for the user's ip address, and for
+// their account if it exists. The user is emailed when their account is locked.
+func (s *service) recordLoginFailure(ctx context.Context, u UserLoginInfo, uInfo *UserInfo) {
+	if s.attempts == nil {
+		return
+	}
+
+	now := time.Now()
+
+	if u.IPAddress != "" {
+		if _, err := recordFailedAttempt(ctx, s.attempts, ipAttemptPolicy, ipAttemptKey(u.IPAddress), now); err != nil {
+			log.Println(err)
+		}
+	}
+
+	if uInfo == nil {
+		return
+	}
+
+	failures, err := recordFailedAttempt(ctx, s.attempts, accountAttemptPolicy, accountAttemptKey(u.Email), now)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	if failures < accountAttemptPolicy.lockoutAfter {
+		return
+	}
+
+	// the user is emailed the first time their account is locked out, and not
+	// again for the failures after it until the count restarts
+	notify, err := s.attempts.markLockoutNotified(ctx, accountAttemptKey(u.Email))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	if notify {
+		go func() {
+			if err := s.mailer.sendLockoutEmail(context.Background(), uInfo.Email, uInfo.FirstName, uInfo.Locale); err != nil {
+				log.Println(err)
+			}
+		}()
+	}
+}
+
+// resetLoginAttempts forgets an account's failed attempts after a successful login
+func (s *service) resetLoginAttempts(ctx context.Context, u UserLoginInfo) {
+	if s.attempts == nil {
+		return
+	}
+
+	if err := s.attempts.reset(ctx, accountAttemptKey(u.Email)); err != nil {
+		log.Println(err)
+	}
+}
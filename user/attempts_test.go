@@ -0,0 +1,182 @@
+package user
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttemptPolicyBlockDuration(t *testing.T) {
+	p := attemptPolicy{
+		backoffAfter: 3,
+		lockoutAfter: 10,
+		lockout:      15 * time.Minute,
+		window:       time.Hour,
+	}
+
+	tests := []struct {
+		failures int
+		expected time.Duration
+	}{
+		{failures: 1, expected: 0},
+		{failures: 2, expected: 0},
+		{failures: 3, expected: time.Second},
+		{failures: 4, expected: 2 * time.Second},
+		{failures: 6, expected: 8 * time.Second},
+		{failures: 10, expected: 15 * time.Minute},
+		{failures: 12, expected: 15 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, p.blockDuration(tt.failures), "failures: %d", tt.failures)
+	}
+}
+
+func TestMemoryAttemptStoreWindow(t *testing.T) {
+	store := NewMemoryAttemptStore()
+	now := time.Now()
+
+	failures, err := store.recordFailure(context.Background(), "account:michael", now, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 1, failures)
+
+	failures, err = store.recordFailure(context.Background(), "account:michael", now.Add(time.Minute), time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 2, failures)
+
+	// the count restarts when the last failure is outside of the window
+	failures, err = store.recordFailure(context.Background(), "account:michael", now.Add(2*time.Hour), time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 1, failures)
+}
+
+func TestMemoryAttemptStorePrune(t *testing.T) {
+	store := NewMemoryAttemptStore()
+	now := time.Now()
+
+	_, err := store.recordFailure(context.Background(), "ip:192.0.2.1", now, time.Hour)
+	require.NoError(t, err)
+	_, err = store.recordFailure(context.Background(), "ip:192.0.2.2", now, time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, store.lock(context.Background(), "ip:192.0.2.2", now.Add(3*time.Hour)))
+
+	// the first ip's window is over, but the second is still locked out
+	_, err = store.recordFailure(context.Background(), "ip:192.0.2.3", now.Add(2*time.Hour), time.Hour)
+	require.NoError(t, err)
+
+	attempts, err := store.getAttempts(context.Background(), "ip:192.0.2.1")
+	require.NoError(t, err)
+	assert.Nil(t, attempts)
+
+	attempts, err = store.getAttempts(context.Background(), "ip:192.0.2.2")
+	require.NoError(t, err)
+	assert.NotNil(t, attempts)
+}
+
+func TestMemoryAttemptStoreLockoutNotified(t *testing.T) {
+	store := NewMemoryAttemptStore()
+	now := time.Now()
+
+	_, err := store.recordFailure(context.Background(), "account:michael", now, time.Hour)
+	require.NoError(t, err)
+
+	notify, err := store.markLockoutNotified(context.Background(), "account:michael")
+	require.NoError(t, err)
+	assert.True(t, notify)
+
+	notify, err = store.markLockoutNotified(context.Background(), "account:michael")
+	require.NoError(t, err)
+	assert.False(t, notify)
+
+	// the flag is cleared when the count restarts
+	_, err = store.recordFailure(context.Background(), "account:michael", now.Add(2*time.Hour), time.Hour)
+	require.NoError(t, err)
+
+	notify, err = store.markLockoutNotified(context.Background(), "account:michael")
+	require.NoError(t, err)
+	assert.True(t, notify)
+}
+
+func Test_Service_RecordLoginFailureLockoutEmail(t *testing.T) {
+	store := NewMemoryAttemptStore()
+	s := &service{
+		mailer:   &mockMailerClient{},
+		attempts: store,
+	}
+	u := UserLoginInfo{Email: "michaelscott@dundermifflin.com"}
+	uInfo := &UserInfo{Email: u.Email}
+
+	// the failures after the one that locks the account don't notify the user again
+	for i := 0; i < accountAttemptPolicy.lockoutAfter+2; i++ {
+		s.recordLoginFailure(context.Background(), u, uInfo)
+	}
+
+	attempts, err := store.getAttempts(context.Background(), accountAttemptKey(u.Email))
+	require.NoError(t, err)
+	require.NotNil(t, attempts)
+	assert.True(t, attempts.LockoutNotified)
+	assert.Equal(t, accountAttemptPolicy.lockoutAfter+2, attempts.Failures)
+}
+
+func Test_Service_LoginUserLockout(t *testing.T) {
+	t.Setenv("JWT_SECRET_KEY", jwtSecretTestKey)
+	assert := assert.New(t)
+	store := NewMemoryAttemptStore()
+	password := &mockPasswordManager{err: ErrInvalidPassword}
+	s := &service{
+		repository: &mockRepository{
+			user: &UserInfo{
+				ID:        "5ef7fdd91c19e3222b41b839",
+				Email:     "michaelscott@dundermifflin.com",
+				Activated: true,
+			},
+		},
+		password: password,
+		validate: &validator{},
+		mailer:   &mockMailerClient{},
+		attempts: store,
+	}
+	u := UserLoginInfo{
+		Email:     "michaelscott@dundermifflin.com",
+		Password:  "4321",
+		IPAddress: "192.0.2.1",
+	}
+
+	for i := 1; i < accountAttemptPolicy.backoffAfter; i++ {
+		_, err := s.loginUser(context.Background(), u)
+		assert.ErrorIs(err, ErrInvalidPassword)
+	}
+
+	// this failure starts the backoff
+	_, err := s.loginUser(context.Background(), u)
+	assert.ErrorIs(err, ErrInvalidPassword)
+
+	// the correct password is rejected until the backoff is over
+	password.err = nil
+	_, err = s.loginUser(context.Background(), u)
+	assert.ErrorIs(err, ErrTooManyAttempts)
+
+	var lockErr *lockoutError
+	if assert.ErrorAs(err, &lockErr) {
+		assert.LessOrEqual(lockErr.retrySeconds(), 1)
+	}
+
+	// a successful login resets the account's attempts
+	require.NoError(t, store.lock(context.Background(), accountAttemptKey(u.Email), time.Now()))
+	_, err = s.loginUser(context.Background(), u)
+	require.NoError(t, err)
+
+	attempts, err := store.getAttempts(context.Background(), accountAttemptKey(u.Email))
+	require.NoError(t, err)
+	assert.Nil(attempts)
+
+	// failures from the same ip address are still counted
+	attempts, err = store.getAttempts(context.Background(), ipAttemptKey(u.IPAddress))
+	require.NoError(t, err)
+	if assert.NotNil(attempts) {
+		assert.Equal(accountAttemptPolicy.backoffAfter, attempts.Failures)
+	}
+}
@@ -9,6 +9,10 @@ type ServiceBuilder interface {
 	Repository(repository Repository) ServiceBuilder
 	MailerClient(client MailerClient) ServiceBuilder
 	TokenClient(token TokenClient) ServiceBuilder
+	TaskClient(task TaskClient) ServiceBuilder
+	AttemptStore(attempts AttemptStore) ServiceBuilder
+	OIDCProviders(providers ...*OIDCProvider) ServiceBuilder
+	OAuthStore(oauth OAuthStore) ServiceBuilder
 	PasswordManager(passwordManager PasswordManager) ServiceBuilder
 	Validator(validator Validator) ServiceBuilder
 	Build() Service
@@ -22,6 +26,10 @@ type serviceBuilder struct {
 	repository      Repository
 	mailer          MailerClient
 	token           TokenClient
+	task            TaskClient
+	attempts        AttemptStore
+	oidcProviders   []*OIDCProvider
+	oauth           OAuthStore
 	passwordManager PasswordManager
 	validator       Validator
 }
@@ -41,6 +49,26 @@ func (sb *serviceBuilder) TokenClient(token TokenClient) ServiceBuilder {
 	return sb
 }
 
+func (sb *serviceBuilder) TaskClient(task TaskClient) ServiceBuilder {
+	sb.task = task
+	return sb
+}
+
+func (sb *serviceBuilder) AttemptStore(attempts AttemptStore) ServiceBuilder {
+	sb.attempts = attempts
+	return sb
+}
+
+func (sb *serviceBuilder) OIDCProviders(providers ...*OIDCProvider) ServiceBuilder {
+	sb.oidcProviders = providers
+	return sb
+}
+
+func (sb *serviceBuilder) OAuthStore(oauth OAuthStore) ServiceBuilder {
+	sb.oauth = oauth
+	return sb
+}
+
 func (sb *serviceBuilder) PasswordManager(passwordManager PasswordManager) ServiceBuilder {
 	sb.passwordManager = passwordManager
 	return sb
@@ -52,11 +80,17 @@ func (sb *serviceBuilder) Validator(validator Validator) ServiceBuilder {
 }
 
 func (sb *serviceBuilder) Build() Service {
-	return &service{
+	s := &service{
 		repository: sb.repository,
 		mailer:     sb.mailer,
 		password:   sb.passwordManager,
 		validate:   sb.validator,
 		token:      sb.token,
+		task:       sb.task,
+		attempts:   sb.attempts,
+		oauth:      sb.oauth,
 	}
+	WithOIDCProviders(sb.oidcProviders...)(s)
+
+	return s
 }
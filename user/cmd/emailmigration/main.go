@@ -0,0 +1,77 @@
+// The emailmigration command finds users whose emails are the same once they
+// are normalised, which stop the unique index on users.email from being created.
+// It normalises the emails of every other user if it is run with -apply.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/ricxi/flat-list/shared/config"
+	"github.com/ricxi/flat-list/user"
+)
+
+func main() {
+	apply := flag.Bool("apply", false, "replace every email that is not a duplicate with its normalised form")
+	flag.Parse()
+
+	envs, err := config.LoadEnvs(
+		"MONGODB_URI",
+		"MONGODB_NAME",
+		"MONGODB_TIMEOUT",
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mongoTimeout, err := strconv.Atoi(envs["MONGODB_TIMEOUT"])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	policy, err := user.LoadEmailPolicy()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	client, err := user.NewMongoClient(envs["MONGODB_URI"], mongoTimeout)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer client.Disconnect(context.Background())
+
+	report, err := user.MigrateEmails(context.Background(), client, envs["MONGODB_NAME"], policy, *apply)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, d := range report.Duplicates {
+		fmt.Printf("duplicate %s:\n", d.Email)
+		for _, u := range d.Users {
+			fmt.Printf("\tuser %s has %s\n", u.UserID, u.Email)
+		}
+	}
+
+	for _, u := range report.Invalid {
+		fmt.Printf("invalid: user %s has %q\n", u.UserID, u.Email)
+	}
+
+	verb := "would change"
+	if *apply {
+		verb = "changed"
+	}
+	for _, c := range report.Changes {
+		fmt.Printf("%s: user %s from %s to %s\n", verb, c.UserID, c.From, c.To)
+	}
+
+	fmt.Printf("%d duplicate emails, %d invalid emails, %d emails %s\n", len(report.Duplicates), len(report.Invalid), len(report.Changes), verb)
+
+	// duplicates have to be merged or removed before the unique index can be created
+	if len(report.Duplicates) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,57 @@
+// The grantrole command gives a role to a user, or takes it away with -revoke.
+// It is used to create the first admin, since only admins can manage users.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/ricxi/flat-list/shared/authz"
+	"github.com/ricxi/flat-list/shared/config"
+	"github.com/ricxi/flat-list/user"
+)
+
+func main() {
+	email := flag.String("email", "", "the email of the user")
+	role := flag.String("role", authz.RoleAdmin, "the role to give to the user")
+	revoke := flag.Bool("revoke", false, "take the role away from the user instead")
+	flag.Parse()
+
+	if *email == "" || *role == "" {
+		flag.Usage()
+		log.Fatal("an email and a role are required")
+	}
+
+	envs, err := config.LoadEnvs(
+		"MONGODB_URI",
+		"MONGODB_NAME",
+		"MONGODB_TIMEOUT",
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mongoTimeout, err := strconv.Atoi(envs["MONGODB_TIMEOUT"])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	client, err := user.NewMongoClient(envs["MONGODB_URI"], mongoTimeout)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer client.Disconnect(context.Background())
+
+	if err := user.GrantRole(context.Background(), client, envs["MONGODB_NAME"], *email, *role, *revoke); err != nil {
+		log.Fatal(err)
+	}
+
+	verb := "gave"
+	if *revoke {
+		verb = "took away"
+	}
+	fmt.Printf("%s role %s for %s\n", verb, *role, *email)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"log"
 	"strconv"
+	"time"
 
 	"github.com/ricxi/flat-list/shared/config"
 	"github.com/ricxi/flat-list/user"
@@ -18,6 +19,8 @@ func main() {
 		"MONGODB_TIMEOUT",
 		"MAILER_GRPC_PORT",
 		"TOKEN_GRPC_PORT",
+		"TASK_INTERNAL_URL",
+		"TASK_INTERNAL_SECRET",
 	)
 	if err != nil {
 		log.Fatal(err)
@@ -34,8 +37,24 @@ func main() {
 	}
 	defer client.Disconnect(context.Background())
 
+	indexCtx, cancelIndex := context.WithTimeout(context.Background(), time.Duration(mongoTimeout)*time.Second)
+	defer cancelIndex()
+	if err := user.EnsureIndexes(indexCtx, client, envs["MONGODB_NAME"]); err != nil {
+		log.Fatalln(err)
+	}
+
 	mr := user.NewRepository(client, envs["MONGODB_NAME"])
-	v := user.NewValidator()
+	passwordPolicy, err := user.LoadPasswordPolicy()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	emailPolicy, err := user.LoadEmailPolicy()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	v := user.NewValidator(user.WithPasswordPolicy(passwordPolicy), user.WithEmailPolicy(emailPolicy))
 	pm := user.NewPasswordManager(bcrypt.MinCost)
 
 	mc, err := user.NewGRPCMailerClient(envs["MAILER_GRPC_PORT"])
@@ -48,14 +67,43 @@ func main() {
 		log.Fatalln(err)
 	}
 
+	taskc, err := user.NewHTTPTaskClient(envs["TASK_INTERNAL_URL"], envs["TASK_INTERNAL_SECRET"])
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	oidcConfigs, err := user.LoadOIDCProviderConfigs()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	var oidcProviders []*user.OIDCProvider
+	for _, cfg := range oidcConfigs {
+		p, err := user.NewOIDCProvider(context.Background(), cfg)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		oidcProviders = append(oidcProviders, p)
+	}
+
 	service := user.NewService(
 		mr,
 		user.WithValidator(v),
 		user.WithPasswordManager(pm),
 		user.WithMailerClient(mc),
 		user.WithTokenClient(tc),
+		user.WithTaskClient(taskc),
+		user.WithAttemptStore(user.NewMongoAttemptStore(client, envs["MONGODB_NAME"])),
+		user.WithOIDCProviders(oidcProviders...),
+		user.WithOAuthStore(user.NewMongoOAuthStore(client, envs["MONGODB_NAME"])),
 	)
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go user.RetryDeletionJobs(ctx, service, time.Minute)
+	go user.SendDigests(ctx, service, time.Minute)
+	go user.RunDataExports(ctx, service, 30*time.Second)
+
 	handler := user.NewHTTPHandler(service)
 	server := user.NewServer(handler, envs["PORT"])
 
@@ -3,23 +3,39 @@ package main_test
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"io"
 	"log"
+	"net"
+	"net/http"
 	"net/http/httptest"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/ricxi/flat-list/mailer"
+	"github.com/ricxi/flat-list/mailer/devsmtp"
+	"github.com/ricxi/flat-list/mailer/pb"
 	"github.com/ricxi/flat-list/shared/config"
+	tservice "github.com/ricxi/flat-list/token/pb"
 	"github.com/ricxi/flat-list/user"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
-var service user.Service
+var (
+	service user.Service
+	// smtpServer captures the emails that the mailer sends during the tests
+	smtpServer *devsmtp.Server
+)
 
 func TestMain(m *testing.M) {
 	envs, err := config.LoadEnvs("MONGODB_URI")
@@ -51,17 +67,29 @@ func TestMain(m *testing.M) {
 	os.Exit(exitCode)
 }
 
+// setupService runs the mailer and a token service in this process, so that
+// the tests don't need them to be running, and emails are captured by smtpServer
 func setupService(repository user.Repository) (user.Service, error) {
+	mailerPort, err := startMailer()
+	if err != nil {
+		return nil, err
+	}
+
+	tokenPort, err := startTokenServer()
+	if err != nil {
+		return nil, err
+	}
+
 	passwordManager := user.NewPasswordManager(bcrypt.MinCost)
 	validator := user.NewValidator()
-	mailerClient, err := user.NewGRPCMailerClient("5001")
+	mailerClient, err := user.NewGRPCMailerClient(mailerPort)
 	if err != nil {
 		return nil, err
 	}
 
-	tokenClient, err := user.NewTokenClient("5003")
+	tokenClient, err := user.NewTokenClient(tokenPort)
 	if err != nil {
-		log.Fatalln(err)
+		return nil, err
 	}
 
 	service := user.
@@ -76,6 +104,81 @@ func setupService(repository user.Repository) (user.Service, error) {
 	return service, nil
 }
 
+// startMailer starts the mailer's grpc server, which sends emails to a devsmtp server, and returns its port
+func startMailer() (string, error) {
+	smtpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	smtpServer = devsmtp.NewServer()
+	go smtpServer.Serve(smtpListener)
+
+	templates, err := mailer.LoadTemplates("../../../mailer/templates")
+	if err != nil {
+		return "", err
+	}
+
+	smtpPort := smtpListener.Addr().(*net.TCPAddr).Port
+	m := mailer.NewMailer("", "", "127.0.0.1", smtpPort)
+	srv := mailer.NewGrpcServer(mailer.NewService(m, templates))
+
+	grpcServer := grpc.NewServer()
+	pb.RegisterMailerServer(grpcServer, srv)
+
+	return serveGRPC(grpcServer)
+}
+
+// startTokenServer starts a token service that keeps activation tokens in memory, and returns its port
+func startTokenServer() (string, error) {
+	grpcServer := grpc.NewServer()
+	tservice.RegisterTokenServer(grpcServer, &tokenServer{tokens: make(map[string]string)})
+
+	return serveGRPC(grpcServer)
+}
+
+func serveGRPC(grpcServer *grpc.Server) (string, error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	go grpcServer.Serve(lis)
+
+	return strconv.Itoa(lis.Addr().(*net.TCPAddr).Port), nil
+}
+
+// tokenServer only implements the activation tokens that registration needs
+type tokenServer struct {
+	tservice.UnimplementedTokenServer
+
+	mu sync.Mutex
+	// tokens maps activation tokens to the ids of their users,
+	// and tokens are deleted when they are used
+	tokens map[string]string
+}
+
+func (s *tokenServer) CreateActivationToken(ctx context.Context, in *tservice.CreateTokenRequest) (*tservice.CreateTokenResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token := uuid.New().String()
+	s.tokens[token] = in.UserId
+
+	return &tservice.CreateTokenResponse{ActivationToken: token}, nil
+}
+
+func (s *tokenServer) ValidateActivationToken(ctx context.Context, in *tservice.ValidateTokenRequest) (*tservice.ValidateTokenResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	userID, ok := s.tokens[in.ActivationToken]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "activation token not found")
+	}
+	delete(s.tokens, in.ActivationToken)
+
+	return &tservice.ValidateTokenResponse{UserId: userID}, nil
+}
+
 const registerUserPayload string = `
 {
     "firstName": "Michael",
@@ -85,30 +188,54 @@ const registerUserPayload string = `
 }
 `
 
+var activationLinkPattern = regexp.MustCompile(regexp.QuoteMeta(user.ActivationPageLink) + `([A-Za-z0-9-]+)`)
+
 func TestRegisterUser(t *testing.T) {
-	t.Skip()
-	t.Run("Success", func(t *testing.T) {
-		assert := assert.New(t)
-		require := require.New(t)
-		h := user.NewHTTPHandler(service)
-		ts := httptest.NewTLSServer(h)
-		defer ts.Close()
-
-		endpoint := ts.URL + "/v1/user/register"
-		body := strings.NewReader(registerUserPayload)
-		resp, err := ts.Client().Post(endpoint, "application/json", body)
+	assert := assert.New(t)
+	require := require.New(t)
+	h := user.NewHTTPHandler(service)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	resp, err := ts.Client().Post(ts.URL+"/v1/user/register", "application/json", strings.NewReader(registerUserPayload))
+	require.NoError(err)
+	defer resp.Body.Close()
+	require.Equal(http.StatusCreated, resp.StatusCode)
+
+	var registered struct {
+		ID string `json:"id"`
+	}
+	fromJSON(t, resp.Body, &registered)
+	assert.NotEmpty(registered.ID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	email, err := smtpServer.WaitFor(ctx, devsmtp.SentTo("michaelscott@dundermifflin.com"))
+	require.NoError(err, "the activation email was not sent")
+
+	match := activationLinkPattern.FindStringSubmatch(email.Text)
+	require.Len(match, 2, "the activation email has no activation link:\n%s", email.Text)
+	activationToken := match[1]
+
+	t.Run("Activate", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPut, ts.URL+"/v1/user/activate/"+activationToken, nil)
 		require.NoError(err)
 
+		resp, err := ts.Client().Do(req)
+		require.NoError(err)
 		defer resp.Body.Close()
-		var u user.UserInfo
-		fromJSON(t, resp.Body, &u)
+		assert.Equal(http.StatusNoContent, resp.StatusCode)
+	})
 
-		if assert.NotEmpty(u) {
-			fmt.Println(u)
-		}
+	t.Run("ErrorTokenAlreadyUsed", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPut, ts.URL+"/v1/user/activate/"+activationToken, nil)
+		require.NoError(err)
 
+		resp, err := ts.Client().Do(req)
+		require.NoError(err)
+		defer resp.Body.Close()
+		assert.Equal(http.StatusNotFound, resp.StatusCode)
 	})
-
 }
 
 func fromJSON(t testing.TB, r io.Reader, out any) {
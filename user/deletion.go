@@ -0,0 +1,159 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	// delay before the first retry of a background job (ie. a deletion job) that did not complete
+	retryBaseDelay = 30 * time.Second
+	// the delay between retries doubles until it reaches this
+	retryMaxDelay = time.Hour
+)
+
+// deleteAccount removes a user's account after they confirm their password
+// (or a reauthentication token, if they log in with an identity provider).
+// It records a deletion job before anything is deleted, so that if one of the
+// services that stores the user's data cannot be reached, its part of the job
+// is retried later by RetryDeletionJobs.
+func (s *service) deleteAccount(ctx context.Context, userID string, d AccountDeletionInfo) (*DeletionJob, error) {
+	uInfo, err := s.repository.getUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.confirmPassword(uInfo, d.Password, d.ReauthToken); err != nil {
+		return nil, err
+	}
+
+	createdAt := time.Now().In(time.UTC)
+	job := DeletionJob{
+		UserID:        uInfo.ID,
+		NextAttemptAt: &createdAt,
+		CreatedAt:     &createdAt,
+		UpdatedAt:     &createdAt,
+	}
+
+	jobID, err := s.repository.createDeletionJob(ctx, job)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	job.ID = jobID
+
+	// the user only has to wait for the first attempt
+	if err := s.runDeletionJob(ctx, &job); err != nil {
+		log.Println(err)
+	}
+
+	return &job, nil
+}
+
+// retryDeletionJobs runs every deletion job that is due to be retried.
+func (s *service) retryDeletionJobs(ctx context.Context) error {
+	jobs, err := s.repository.getDueDeletionJobs(ctx, time.Now().In(time.UTC))
+	if err != nil {
+		return err
+	}
+
+	for i := range jobs {
+		if err := s.runDeletionJob(ctx, &jobs[i]); err != nil {
+			log.Println(err)
+		}
+	}
+
+	return nil
+}
+
+// runDeletionJob asks each service that has not yet confirmed the
+// deletion of a user's data to delete it, then stores the job's progress.
+// Every step can be safely repeated, so it does not matter if a job
+// is run more than once (ie. by more than one instance of this service).
+func (s *service) runDeletionJob(ctx context.Context, job *DeletionJob) error {
+	var errs []string
+
+	// the user is deleted first so that they can no longer log in
+	if !job.UserDeleted {
+		err := s.repository.deleteUserByID(ctx, job.UserID)
+		if err == nil || errors.Is(err, ErrUserNotFound) {
+			// exports are a copy of the user's data, so they are deleted with the user
+			err = s.repository.deleteDataExportsByUserID(ctx, job.UserID)
+		}
+		if err == nil && s.oauth != nil {
+			err = s.oauth.deleteUserData(ctx, job.UserID)
+		}
+		if err == nil {
+			job.UserDeleted = true
+		} else {
+			errs = append(errs, "user: "+err.Error())
+		}
+	}
+
+	if !job.TasksDeleted {
+		if err := s.task.deleteUserTasks(ctx, job.UserID); err != nil {
+			errs = append(errs, "tasks: "+err.Error())
+		} else {
+			job.TasksDeleted = true
+		}
+	}
+
+	if !job.TokensDeleted {
+		if err := s.token.DeleteUserTokens(ctx, job.UserID); err != nil {
+			errs = append(errs, "tokens: "+err.Error())
+		} else {
+			job.TokensDeleted = true
+		}
+	}
+
+	now := time.Now().In(time.UTC)
+	job.Attempts++
+	job.UpdatedAt = &now
+
+	if len(errs) > 0 {
+		job.LastError = strings.Join(errs, "; ")
+		nextAttemptAt := now.Add(retryDelay(job.Attempts))
+		job.NextAttemptAt = &nextAttemptAt
+	} else {
+		job.LastError = ""
+		job.NextAttemptAt = nil
+	}
+
+	return s.repository.updateDeletionJob(ctx, *job)
+}
+
+// retryDelay returns how long to wait before retrying a
+// background job, which doubles after every failed attempt.
+func retryDelay(attempts int) time.Duration {
+	delay := retryBaseDelay
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= retryMaxDelay {
+			return retryMaxDelay
+		}
+	}
+
+	return delay
+}
+
+// RetryDeletionJobs checks for deletion jobs that need to be
+// retried at every interval until the context is cancelled.
+// It is meant to be run in its own goroutine.
+func RetryDeletionJobs(ctx context.Context, s Service, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.retryDeletionJobs(ctx); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
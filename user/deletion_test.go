@@ -0,0 +1,188 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Service_DeleteAccount(t *testing.T) {
+	tests := []struct {
+		name         string
+		password     PasswordManager
+		task         TaskClient
+		token        TokenClient
+		expCompleted bool
+		expLastError string
+		expErr       string
+	}{
+		{
+			name:         "Success",
+			password:     &mockPasswordManager{},
+			task:         &mockTaskClient{},
+			token:        &mockTokenClient{},
+			expCompleted: true,
+		},
+		{
+			name:         "TaskServiceUnavailable",
+			password:     &mockPasswordManager{},
+			task:         &mockTaskClient{err: errors.New("connection refused")},
+			token:        &mockTokenClient{},
+			expCompleted: false,
+			expLastError: "tasks: connection refused",
+		},
+		{
+			name:     "FailWrongPassword",
+			password: &mockPasswordManager{err: ErrInvalidPassword},
+			task:     &mockTaskClient{},
+			token:    &mockTokenClient{},
+			expErr:   "invalid password provided",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			repository := &mockRepository{
+				user: &UserInfo{
+					ID:             "5ef7fdd91c19e3222b41b839",
+					HashedPassword: "hashedpassword",
+				},
+			}
+			s := &service{
+				repository: repository,
+				password:   tt.password,
+				validate:   &validator{},
+				task:       tt.task,
+				token:      tt.token,
+			}
+
+			job, err := s.deleteAccount(context.Background(), "5ef7fdd91c19e3222b41b839", AccountDeletionInfo{Password: "1234"})
+			if tt.expErr != "" {
+				assert.EqualError(err, tt.expErr)
+				assert.Nil(job)
+				assert.Empty(repository.updatedDeletionJobs)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal("6448958b96118a48b722fd15", job.ID)
+			assert.True(job.UserDeleted)
+			assert.Equal(tt.expCompleted, job.completed())
+			assert.Equal(tt.expLastError, job.LastError)
+			assert.Equal(1, job.Attempts)
+			if tt.expCompleted {
+				assert.Nil(job.NextAttemptAt)
+			} else {
+				assert.NotNil(job.NextAttemptAt)
+			}
+			// the job's progress must be saved so that it can be retried
+			assert.Len(repository.updatedDeletionJobs, 1)
+		})
+	}
+}
+
+func Test_Service_DeleteAccountWithoutPassword(t *testing.T) {
+	t.Setenv("JWT_SECRET_KEY", jwtSecretTestKey)
+	userID := "5ef7fdd91c19e3222b41b839"
+
+	reauthToken, err := generateReauthJWT(userID)
+	require.NoError(t, err)
+	otherUserToken, err := generateReauthJWT("5ef7fdd91c19e3222b41b840")
+	require.NoError(t, err)
+	challengeToken, err := generateChallengeJWT(userID)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		d      AccountDeletionInfo
+		expErr error
+	}{
+		// users who log in with an identity provider log in with it again instead
+		{name: "SuccessReauthToken", d: AccountDeletionInfo{ReauthToken: reauthToken}},
+		{name: "FailPassword", d: AccountDeletionInfo{Password: "1234"}, expErr: ErrPasswordNotSet},
+		{name: "FailAnotherUsersReauthToken", d: AccountDeletionInfo{ReauthToken: otherUserToken}, expErr: ErrInvalidReauthToken},
+		{name: "FailChallengeToken", d: AccountDeletionInfo{ReauthToken: challengeToken}, expErr: ErrInvalidReauthToken},
+		{name: "FailMalformedToken", d: AccountDeletionInfo{ReauthToken: "not-a-jwt"}, expErr: ErrInvalidReauthToken},
+		{name: "FailMissing", expErr: ErrMissingField},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository := &mockRepository{
+				user: &UserInfo{
+					ID:         userID,
+					Identities: []OIDCIdentity{{Provider: "stub", Subject: "stub-subject-1"}},
+				},
+			}
+			s := &service{
+				repository: repository,
+				password:   NewPasswordManager(0),
+				validate:   &validator{},
+				task:       &mockTaskClient{},
+				token:      &mockTokenClient{},
+			}
+
+			job, err := s.deleteAccount(context.Background(), userID, tt.d)
+			if tt.expErr != nil {
+				assert.ErrorIs(t, err, tt.expErr)
+				assert.Nil(t, job)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.True(t, job.UserDeleted)
+			assert.True(t, job.completed())
+		})
+	}
+}
+
+func Test_Service_RetryDeletionJobs(t *testing.T) {
+	assert := assert.New(t)
+
+	createdAt := time.Now().Add(-time.Hour)
+	repository := &mockRepository{
+		// the user's document and tokens were deleted during an earlier attempt
+		deletionJobs: []DeletionJob{
+			{
+				ID:            "6448958b96118a48b722fd15",
+				UserID:        "5ef7fdd91c19e3222b41b839",
+				UserDeleted:   true,
+				TokensDeleted: true,
+				Attempts:      1,
+				LastError:     "tasks: connection refused",
+				NextAttemptAt: &createdAt,
+				CreatedAt:     &createdAt,
+				UpdatedAt:     &createdAt,
+			},
+		},
+	}
+	s := &service{
+		repository: repository,
+		task:       &mockTaskClient{},
+		token:      &mockTokenClient{err: errors.New("tokens should not be deleted again")},
+	}
+
+	err := s.retryDeletionJobs(context.Background())
+	require.NoError(t, err)
+
+	if assert.Len(repository.updatedDeletionJobs, 1) {
+		job := repository.updatedDeletionJobs[0]
+		assert.True(job.completed())
+		assert.Empty(job.LastError)
+		assert.Equal(2, job.Attempts)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(30*time.Second, retryDelay(1))
+	assert.Equal(time.Minute, retryDelay(2))
+	assert.Equal(4*time.Minute, retryDelay(4))
+	assert.Equal(time.Hour, retryDelay(20))
+}
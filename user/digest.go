@@ -0,0 +1,172 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// defaultDigestHour is the hour that digests are sent at if the user hasn't chosen one
+const defaultDigestHour = 8
+
+// digestTemplate is the mailer's template for daily digests
+const digestTemplate = "digest"
+
+// dateLayout is the format of the task service's due dates
+const dateLayout = "2006-01-02"
+
+// Digest is the tasks that are sent to a user in their daily digest
+type Digest struct {
+	// Date is the day that the digest is for, where the user is
+	Date     string
+	DueToday []TaskData
+	Overdue  []TaskData
+}
+
+// newDigest sorts tasks that are due on or before a date into the ones that are due that day and overdue
+func newDigest(date string, tasks []TaskData) Digest {
+	digest := Digest{Date: date}
+	for _, task := range tasks {
+		if task.DueDate < date {
+			digest.Overdue = append(digest.Overdue, task)
+		} else {
+			digest.DueToday = append(digest.DueToday, task)
+		}
+	}
+
+	return digest
+}
+
+// templateData is the data of the mailer's digest template (see digest.schema.json in the mailer's templates).
+// It only has types that can be converted to a protobuf struct.
+func (d Digest) templateData(name string) map[string]any {
+	dueToday := make([]any, 0, len(d.DueToday))
+	for _, task := range d.DueToday {
+		dueToday = append(dueToday, map[string]any{"title": task.Name})
+	}
+
+	overdue := make([]any, 0, len(d.Overdue))
+	for _, task := range d.Overdue {
+		overdue = append(overdue, map[string]any{"title": task.Name, "dueDate": task.DueDate})
+	}
+
+	return map[string]any{
+		"name":      name,
+		"date":      d.Date,
+		"dueToday":  dueToday,
+		"overdue":   overdue,
+		"hyperlink": TasksPageLink,
+	}
+}
+
+// sendDigests sends a digest to every user who wants one, and whose
+// digest hour has passed today where they are, if it hasn't been sent yet.
+// A digest that fails is tried again the next time this runs, unless the
+// mailer won't send to the user's address. It stops at the first digest that
+// the mailer rate limits, and returns its error, since the rest would be too.
+func (s *service) sendDigests(ctx context.Context, now time.Time) error {
+	users, err := s.repository.getDigestUsers(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	for i := range users {
+		if err := s.sendDigest(ctx, &users[i], now); err != nil {
+			if errors.Is(err, ErrEmailRateLimited) {
+				return err
+			}
+			log.Printf("problem sending the daily digest of user %s: %v", users[i].ID, err)
+		}
+	}
+
+	return nil
+}
+
+// sendDigest sends a user their digest for the day if it is due
+func (s *service) sendDigest(ctx context.Context, u *UserInfo, now time.Time) error {
+	loc, err := time.LoadLocation(u.Timezone)
+	if err != nil {
+		return fmt.Errorf("invalid timezone %q: %w", u.Timezone, err)
+	}
+
+	hour := defaultDigestHour
+	if u.DigestHour != nil {
+		hour = *u.DigestHour
+	}
+
+	local := now.In(loc)
+	today := local.Format(dateLayout)
+	if local.Hour() < hour || u.DigestLastSentOn == today {
+		return nil
+	}
+
+	// the digest is claimed before it is sent, since sending it twice is worse than not sending it
+	if err := s.repository.claimDigest(ctx, u.ID, today); err != nil {
+		if errors.Is(err, ErrDigestAlreadySent) {
+			return nil
+		}
+		return err
+	}
+
+	if err := s.deliverDigest(ctx, u, today); err != nil {
+		// the claim is kept for suppressed addresses, so that they aren't tried again until tomorrow
+		if errors.Is(err, ErrRecipientSuppressed) {
+			return err
+		}
+		if releaseErr := s.repository.releaseDigest(ctx, u.ID, today, u.DigestLastSentOn); releaseErr != nil {
+			log.Println(releaseErr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// deliverDigest gets a user's due and overdue tasks from the task
+// service, and sends them to the user if there are any
+func (s *service) deliverDigest(ctx context.Context, u *UserInfo, today string) error {
+	tasks, err := s.task.getDueTasks(ctx, u.ID, today)
+	if err != nil {
+		return err
+	}
+
+	// users aren't sent an empty digest, but they aren't checked again until tomorrow
+	if len(tasks) == 0 {
+		return nil
+	}
+
+	return s.mailer.sendDigestEmail(ctx, u.Email, u.FirstName, u.Locale, newDigest(today, tasks))
+}
+
+// SendDigests checks for users whose daily digest is due at every
+// interval until the context is cancelled. The interval should be
+// well under an hour, so that digests are sent close to their hour.
+// When the mailer rate limits a digest, it waits for as long as the
+// mailer asks before it checks again. It is meant to be run in its own goroutine.
+func SendDigests(ctx context.Context, s Service, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var resumeAt time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if now.Before(resumeAt) {
+				continue
+			}
+
+			if err := s.sendDigests(ctx, now); err != nil {
+				log.Println(err)
+
+				var rlErr *emailRateLimitError
+				if errors.As(err, &rlErr) {
+					resumeAt = now.Add(rlErr.retryAfter)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Service_SendDigests(t *testing.T) {
+	// 07:30 in UTC, which is 09:30 in Paris and 03:30 in Toronto
+	now := time.Date(2023, 5, 2, 7, 30, 0, 0, time.UTC)
+	seven := 7
+	dueTasks := []TaskData{
+		{ID: "6448958b96118a48b722fd17", Name: "Laundry", DueDate: "2023-04-30"},
+		{ID: "6448958b96118a48b722fd18", Name: "Groceries", DueDate: "2023-05-02"},
+	}
+
+	tests := []struct {
+		name        string
+		user        UserInfo
+		task        *mockTaskClient
+		mailer      *mockMailerClient
+		claimErr    error
+		expClaimed  bool
+		expReleased bool
+		expDigests  []Digest
+	}{
+		{
+			name:       "SuccessUserTimezone",
+			user:       UserInfo{DigestSettings: DigestSettings{DigestEnabled: true, Timezone: "Europe/Paris"}},
+			task:       &mockTaskClient{tasks: dueTasks},
+			mailer:     &mockMailerClient{},
+			expClaimed: true,
+			expDigests: []Digest{{Date: "2023-05-02", DueToday: dueTasks[1:], Overdue: dueTasks[:1]}},
+		},
+		{
+			name:       "SuccessDigestHour",
+			user:       UserInfo{DigestSettings: DigestSettings{DigestEnabled: true, DigestHour: &seven}},
+			task:       &mockTaskClient{tasks: dueTasks[1:]},
+			mailer:     &mockMailerClient{},
+			expClaimed: true,
+			expDigests: []Digest{{Date: "2023-05-02", DueToday: dueTasks[1:]}},
+		},
+		{
+			name:   "SkipBeforeDigestHour",
+			user:   UserInfo{DigestSettings: DigestSettings{DigestEnabled: true, Timezone: "America/Toronto"}},
+			task:   &mockTaskClient{tasks: dueTasks},
+			mailer: &mockMailerClient{},
+		},
+		{
+			name:   "SkipAlreadySent",
+			user:   UserInfo{DigestSettings: DigestSettings{DigestEnabled: true, Timezone: "Europe/Paris"}, DigestLastSentOn: "2023-05-02"},
+			task:   &mockTaskClient{tasks: dueTasks},
+			mailer: &mockMailerClient{},
+		},
+		{
+			name:     "SkipClaimedByAnotherInstance",
+			user:     UserInfo{DigestSettings: DigestSettings{DigestEnabled: true, Timezone: "Europe/Paris"}},
+			task:     &mockTaskClient{tasks: dueTasks},
+			mailer:   &mockMailerClient{},
+			claimErr: ErrDigestAlreadySent,
+		},
+		{
+			name:       "NoTasks",
+			user:       UserInfo{DigestSettings: DigestSettings{DigestEnabled: true, Timezone: "Europe/Paris"}},
+			task:       &mockTaskClient{},
+			mailer:     &mockMailerClient{},
+			expClaimed: true,
+		},
+		{
+			name:        "ReleasedWhenMailerFails",
+			user:        UserInfo{DigestSettings: DigestSettings{DigestEnabled: true, Timezone: "Europe/Paris"}},
+			task:        &mockTaskClient{tasks: dueTasks},
+			mailer:      &mockMailerClient{err: errors.New("connection refused")},
+			expClaimed:  true,
+			expReleased: true,
+			expDigests:  []Digest{{Date: "2023-05-02", DueToday: dueTasks[1:], Overdue: dueTasks[:1]}},
+		},
+		{
+			name:       "KeptWhenRecipientSuppressed",
+			user:       UserInfo{DigestSettings: DigestSettings{DigestEnabled: true, Timezone: "Europe/Paris"}},
+			task:       &mockTaskClient{tasks: dueTasks},
+			mailer:     &mockMailerClient{err: ErrRecipientSuppressed},
+			expClaimed: true,
+			expDigests: []Digest{{Date: "2023-05-02", DueToday: dueTasks[1:], Overdue: dueTasks[:1]}},
+		},
+		{
+			name:        "ReleasedWhenTaskServiceFails",
+			user:        UserInfo{DigestSettings: DigestSettings{DigestEnabled: true, Timezone: "Europe/Paris"}},
+			task:        &mockTaskClient{err: errors.New("connection refused")},
+			mailer:      &mockMailerClient{},
+			expClaimed:  true,
+			expReleased: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			tt.user.ID = "5ef7fdd91c19e3222b41b839"
+			tt.user.Email = "michaelscott@dundermifflin.com"
+			tt.user.FirstName = "Michael"
+			repository := &mockRepository{users: []UserInfo{tt.user}, claimErr: tt.claimErr}
+			s := &service{
+				repository: repository,
+				task:       tt.task,
+				mailer:     tt.mailer,
+			}
+
+			require.NoError(t, s.sendDigests(context.Background(), now))
+
+			assert.Equal(tt.expClaimed, len(repository.digestClaims) == 1)
+			assert.Equal(tt.expReleased, len(repository.digestReleases) == 1)
+			assert.Equal(tt.expDigests, tt.mailer.digests)
+		})
+	}
+}
+
+func Test_Service_SendDigestsRateLimited(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Date(2023, 5, 2, 7, 30, 0, 0, time.UTC)
+
+	repository := &mockRepository{users: []UserInfo{
+		{ID: "5ef7fdd91c19e3222b41b839", Email: "michaelscott@dundermifflin.com", DigestSettings: DigestSettings{DigestEnabled: true, Timezone: "Europe/Paris"}},
+		{ID: "5ef7fdd91c19e3222b41b840", Email: "dwightschrute@dundermifflin.com", DigestSettings: DigestSettings{DigestEnabled: true, Timezone: "Europe/Paris"}},
+	}}
+	mailer := &mockMailerClient{err: &emailRateLimitError{retryAfter: time.Minute}}
+	s := &service{
+		repository: repository,
+		task:       &mockTaskClient{tasks: []TaskData{{ID: "6448958b96118a48b722fd18", Name: "Groceries", DueDate: "2023-05-02"}}},
+		mailer:     mailer,
+	}
+
+	err := s.sendDigests(context.Background(), now)
+
+	// the rest of the users aren't sent their digest until the mailer's limit has passed
+	assert.ErrorIs(err, ErrEmailRateLimited)
+	assert.Len(mailer.digests, 1)
+	assert.Equal([]string{"5ef7fdd91c19e3222b41b839"}, repository.digestReleases)
+}
+
+func TestDigestTemplateData(t *testing.T) {
+	digest := newDigest("2023-05-02", []TaskData{
+		{Name: "Laundry", DueDate: "2023-04-30"},
+		{Name: "Groceries", DueDate: "2023-05-02"},
+	})
+
+	assert.Equal(t, map[string]any{
+		"name":      "Michael",
+		"date":      "2023-05-02",
+		"dueToday":  []any{map[string]any{"title": "Groceries"}},
+		"overdue":   []any{map[string]any{"title": "Laundry", "dueDate": "2023-04-30"}},
+		"hyperlink": TasksPageLink,
+	}, digest.templateData("Michael"))
+}
@@ -14,18 +14,125 @@ type UserDocument struct {
 	FirstName      string             `bson:"firstName"`
 	LastName       string             `bson:"lastName"`
 	Email          string             `bson:"email"`
+	PendingEmail   string             `bson:"pendingEmail,omitempty"`
+	Locale         string             `bson:"locale,omitempty"`
 	HashedPassword string             `bson:"hashedPassword"`
 	Activated      bool               `bson:"activated"`
+	Deactivated    bool               `bson:"deactivated,omitempty"`
+	Roles          []string           `bson:"roles,omitempty"`
 	CreatedAt      *time.Time         `bson:"createdAt"`
 	UpdatedAt      *time.Time         `bson:"updatedAt"`
+
+	TwoFactorEnabled       bool     `bson:"twoFactorEnabled,omitempty"`
+	TwoFactorSecret        string   `bson:"twoFactorSecret,omitempty"`
+	TwoFactorPendingSecret string   `bson:"twoFactorPendingSecret,omitempty"`
+	TwoFactorLastStep      int64    `bson:"twoFactorLastStep,omitempty"`
+	RecoveryCodeHashes     []string `bson:"recoveryCodeHashes,omitempty"`
+
+	Identities []IdentityDocument `bson:"identities,omitempty"`
+
+	DigestEnabled    bool   `bson:"digestEnabled,omitempty"`
+	Timezone         string `bson:"timezone,omitempty"`
+	DigestHour       *int   `bson:"digestHour,omitempty"`
+	DigestLastSentOn string `bson:"digestLastSentOn,omitempty"`
 }
 
 type UserRegistrationDocument struct {
 	FirstName      string     `bson:"firstName"`
 	LastName       string     `bson:"lastName"`
 	Email          string     `bson:"email"`
+	Locale         string     `bson:"locale,omitempty"`
 	HashedPassword string     `bson:"hashedPassword"`
 	Activated      bool       `bson:"activated"`
 	CreatedAt      *time.Time `bson:"createdAt"`
 	UpdatedAt      *time.Time `bson:"updatedAt"`
+
+	Identities []IdentityDocument `bson:"identities,omitempty"`
+}
+
+// IdentityDocument links a user to their account with an identity provider
+type IdentityDocument struct {
+	Provider string `bson:"provider"`
+	Subject  string `bson:"subject"`
+}
+
+// OIDCLoginDocument is used to store a login with an identity provider
+// until the user is redirected back, and is deleted when it is used
+type OIDCLoginDocument struct {
+	State        string     `bson:"_id"`
+	Provider     string     `bson:"provider"`
+	Nonce        string     `bson:"nonce"`
+	CodeVerifier string     `bson:"codeVerifier"`
+	ExpiresAt    *time.Time `bson:"expiresAt"`
+	Reauth       bool       `bson:"reauth,omitempty"`
+}
+
+// UserUpdateDocument is passed to '$set' to partially
+// update a user document. The fields are pointers so that
+// only the nil ones are left out, which still allows a
+// field to be set to its zero value (ie. activated: false).
+type UserUpdateDocument struct {
+	FirstName      *string    `bson:"firstName,omitempty"`
+	LastName       *string    `bson:"lastName,omitempty"`
+	Email          *string    `bson:"email,omitempty"`
+	PendingEmail   *string    `bson:"pendingEmail,omitempty"`
+	Locale         *string    `bson:"locale,omitempty"`
+	HashedPassword *string    `bson:"hashedPassword,omitempty"`
+	Activated      *bool      `bson:"activated,omitempty"`
+	Deactivated    *bool      `bson:"deactivated,omitempty"`
+	UpdatedAt      *time.Time `bson:"updatedAt,omitempty"`
+
+	TwoFactorEnabled       *bool     `bson:"twoFactorEnabled,omitempty"`
+	TwoFactorSecret        *string   `bson:"twoFactorSecret,omitempty"`
+	TwoFactorPendingSecret *string   `bson:"twoFactorPendingSecret,omitempty"`
+	TwoFactorLastStep      *int64    `bson:"twoFactorLastStep,omitempty"`
+	RecoveryCodeHashes     *[]string `bson:"recoveryCodeHashes,omitempty"`
+
+	DigestEnabled *bool   `bson:"digestEnabled,omitempty"`
+	Timezone      *string `bson:"timezone,omitempty"`
+	DigestHour    *int    `bson:"digestHour,omitempty"`
+}
+
+// DeletionJobDocument is used to store the
+// progress of deleting a user's account
+type DeletionJobDocument struct {
+	OID           primitive.ObjectID `bson:"_id,omitempty"`
+	UserID        string             `bson:"userId"`
+	UserDeleted   bool               `bson:"userDeleted"`
+	TasksDeleted  bool               `bson:"tasksDeleted"`
+	TokensDeleted bool               `bson:"tokensDeleted"`
+	Completed     bool               `bson:"completed"`
+	Attempts      int                `bson:"attempts"`
+	LastError     string             `bson:"lastError,omitempty"`
+	NextAttemptAt *time.Time         `bson:"nextAttemptAt,omitempty"`
+	CreatedAt     *time.Time         `bson:"createdAt"`
+	UpdatedAt     *time.Time         `bson:"updatedAt"`
+}
+
+// DataExportDocument is used to store a user's data export. Its archive
+// is stored in gridfs with the same id, since it can be larger than
+// mongo's 16MB limit for a single document.
+type DataExportDocument struct {
+	OID               primitive.ObjectID `bson:"_id,omitempty"`
+	UserID            string             `bson:"userId"`
+	Status            string             `bson:"status"`
+	Error             string             `bson:"error,omitempty"`
+	DownloadTokenHash string             `bson:"downloadTokenHash,omitempty"`
+	Attempts          int                `bson:"attempts"`
+	NextAttemptAt     *time.Time         `bson:"nextAttemptAt,omitempty"`
+	CreatedAt         *time.Time         `bson:"createdAt"`
+	CompletedAt       *time.Time         `bson:"completedAt,omitempty"`
+	ExpiresAt         *time.Time         `bson:"expiresAt,omitempty"`
+}
+
+// AuditLogDocument is used to store an action that
+// an admin took on another user's account
+type AuditLogDocument struct {
+	OID       primitive.ObjectID `bson:"_id,omitempty"`
+	ActorID   string             `bson:"actorId"`
+	Action    string             `bson:"action"`
+	TargetID  string             `bson:"targetId"`
+	Reason    string             `bson:"reason,omitempty"`
+	IPAddress string             `bson:"ipAddress,omitempty"`
+	CreatedAt *time.Time         `bson:"createdAt"`
 }
@@ -0,0 +1,182 @@
+package user
+
+import (
+	"fmt"
+	"net/mail"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ricxi/flat-list/shared/validation"
+)
+
+const (
+	// maxEmailLength is the longest address that can be used in a mail path (RFC 5321)
+	maxEmailLength = 254
+	// maxLocalPartLength is the longest part of an address before the @ (RFC 5321)
+	maxLocalPartLength = 64
+)
+
+// EmailPolicy decides which addresses are the same mailbox, so that a user
+// can't register more than once with different spellings of their email.
+// The domain is always lowercased, since domains are not case-sensitive.
+type EmailPolicy struct {
+	// LowercaseLocalPart lowercases the part before the @. It is case-sensitive
+	// according to RFC 5321, but almost every mail provider ignores its case.
+	LowercaseLocalPart bool
+	// StripSubaddress removes everything after a + in
+	// the part before the @ (ie. bob+lists@x.com is bob@x.com)
+	StripSubaddress bool
+	// IgnoreDotsDomains are domains that ignore dots in the
+	// part before the @ (ie. b.o.b@gmail.com is bob@gmail.com)
+	IgnoreDotsDomains []string
+}
+
+// DefaultEmailPolicy returns the policy that is used
+// when the validator is not given a different one
+func DefaultEmailPolicy() EmailPolicy {
+	return EmailPolicy{
+		LowercaseLocalPart: true,
+	}
+}
+
+// LoadEmailPolicy changes the default policy with any of the environment
+// variables EMAIL_LOWERCASE_LOCAL_PART, EMAIL_STRIP_SUBADDRESS and
+// EMAIL_IGNORE_DOTS_DOMAINS (ie. EMAIL_IGNORE_DOTS_DOMAINS=gmail.com,googlemail.com)
+func LoadEmailPolicy() (EmailPolicy, error) {
+	p := DefaultEmailPolicy()
+
+	bools := map[string]*bool{
+		"EMAIL_LOWERCASE_LOCAL_PART": &p.LowercaseLocalPart,
+		"EMAIL_STRIP_SUBADDRESS":     &p.StripSubaddress,
+	}
+	for name, field := range bools {
+		if env := os.Getenv(name); env != "" {
+			b, err := strconv.ParseBool(env)
+			if err != nil {
+				return p, fmt.Errorf("%s: %w", name, err)
+			}
+			*field = b
+		}
+	}
+
+	if env := os.Getenv("EMAIL_IGNORE_DOTS_DOMAINS"); env != "" {
+		for _, domain := range strings.Split(env, ",") {
+			if domain = strings.ToLower(strings.TrimSpace(domain)); domain != "" {
+				p.IgnoreDotsDomains = append(p.IgnoreDotsDomains, domain)
+			}
+		}
+	}
+
+	return p, nil
+}
+
+// Normalize parses an address and returns the form that it is stored
+// and looked up in. The address must be a plain addr-spec (RFC 5322)
+// without a display name or comments (ie. "Bob <bob@x.com>"), a quoted
+// local part or a domain literal, and the domain must have more than one label.
+func (p EmailPolicy) Normalize(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	if strings.ContainsAny(email, "<>()\"") {
+		return "", ErrInvalidEmailAddress
+	}
+
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Name != "" {
+		return "", ErrInvalidEmailAddress
+	}
+
+	at := strings.LastIndex(addr.Address, "@")
+	local, domain := addr.Address[:at], strings.ToLower(addr.Address[at+1:])
+
+	if !isDotAtom(local) || !isDomainName(domain) {
+		return "", ErrInvalidEmailAddress
+	}
+
+	if len(local) > maxLocalPartLength || len(addr.Address) > maxEmailLength {
+		return "", ErrInvalidEmailAddress
+	}
+
+	if p.LowercaseLocalPart {
+		local = strings.ToLower(local)
+	}
+
+	if p.StripSubaddress {
+		if i := strings.Index(local, "+"); i > 0 {
+			local = local[:i]
+		}
+	}
+
+	for _, d := range p.IgnoreDotsDomains {
+		if strings.EqualFold(d, domain) {
+			local = strings.ReplaceAll(local, ".", "")
+			break
+		}
+	}
+
+	return local + "@" + domain, nil
+}
+
+// isDotAtom checks that a local part is made of atext separated by single dots
+func isDotAtom(s string) bool {
+	if s == "" || s[0] == '.' || s[len(s)-1] == '.' || strings.Contains(s, "..") {
+		return false
+	}
+
+	for _, r := range s {
+		if r == '.' || isAtext(r) {
+			continue
+		}
+		return false
+	}
+
+	return true
+}
+
+// isAtext reports if a character can be used in an atom without quotes (RFC 5322)
+func isAtext(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return true
+	case r > 127:
+		// internationalised addresses (RFC 6531)
+		return true
+	}
+
+	return strings.ContainsRune("!#$%&'*+-/=?^_`{|}~", r)
+}
+
+// isDomainName checks that a domain is made of at least two labels of letters,
+// digits and hyphens, so addresses at domain literals or local hosts are rejected
+func isDomainName(domain string) bool {
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return false
+	}
+
+	for _, label := range labels {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+
+		for _, r := range label {
+			if !(r == '-' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r > 127) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// check adds a field error if an email is missing or not valid
+func (p EmailPolicy) check(vErr *validation.Error, field, email string) {
+	if email == "" {
+		vErr.Required(field)
+		return
+	}
+
+	if _, err := p.Normalize(email); err != nil {
+		vErr.Add(field, "invalid_email", fmt.Sprintf("%s is not a valid email address", field))
+	}
+}
@@ -0,0 +1,129 @@
+package user
+
+import (
+	"testing"
+
+	"github.com/ricxi/flat-list/shared/validation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmailPolicyNormalize(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   EmailPolicy
+		email    string
+		expEmail string
+		expErr   error
+	}{
+		{
+			name:     "SuccessLowercaseDomain",
+			policy:   EmailPolicy{},
+			email:    "MichaelScott@DunderMifflin.com",
+			expEmail: "MichaelScott@dundermifflin.com",
+		},
+		{
+			name:     "SuccessLowercaseLocalPart",
+			policy:   DefaultEmailPolicy(),
+			email:    "  MichaelScott@DunderMifflin.com ",
+			expEmail: "michaelscott@dundermifflin.com",
+		},
+		{
+			name:     "SuccessStripSubaddress",
+			policy:   EmailPolicy{LowercaseLocalPart: true, StripSubaddress: true},
+			email:    "michael.scott+worldsbestboss@dundermifflin.com",
+			expEmail: "michael.scott@dundermifflin.com",
+		},
+		{
+			name:     "SuccessIgnoreDots",
+			policy:   EmailPolicy{IgnoreDotsDomains: []string{"gmail.com"}},
+			email:    "michael.g.scott@GMail.com",
+			expEmail: "michaelgscott@gmail.com",
+		},
+		{
+			name:     "SuccessDotsKeptForOtherDomains",
+			policy:   EmailPolicy{IgnoreDotsDomains: []string{"gmail.com"}},
+			email:    "michael.g.scott@dundermifflin.com",
+			expEmail: "michael.g.scott@dundermifflin.com",
+		},
+		{
+			name:   "FailDisplayName",
+			email:  "Michael Scott <michaelscott@dundermifflin.com>",
+			expErr: ErrInvalidEmailAddress,
+		},
+		{
+			name:   "FailQuotedLocalPart",
+			email:  `"michael scott"@dundermifflin.com`,
+			expErr: ErrInvalidEmailAddress,
+		},
+		{
+			name:   "FailNoDomain",
+			email:  "michaelscott",
+			expErr: ErrInvalidEmailAddress,
+		},
+		{
+			name:   "FailSingleLabelDomain",
+			email:  "michaelscott@localhost",
+			expErr: ErrInvalidEmailAddress,
+		},
+		{
+			name:   "FailDomainLiteral",
+			email:  "michaelscott@[127.0.0.1]",
+			expErr: ErrInvalidEmailAddress,
+		},
+		{
+			name:   "FailConsecutiveDots",
+			email:  "michael..scott@dundermifflin.com",
+			expErr: ErrInvalidEmailAddress,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email, err := tt.policy.Normalize(tt.email)
+			if tt.expErr != nil {
+				assert.ErrorIs(t, err, tt.expErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expEmail, email)
+		})
+	}
+}
+
+func TestValidatorRegistrationInvalidEmail(t *testing.T) {
+	v := NewValidator()
+
+	err := v.Registration(UserRegistrationInfo{
+		Email:    "michaelscott@",
+		Password: "That's what she said 2005",
+	})
+
+	vErr, ok := validation.As(err)
+	require.True(t, ok)
+	assert.Equal(t, []validation.FieldError{
+		{Field: "email", Code: "invalid_email", Message: "email is not a valid email address"},
+	}, vErr.Fields)
+}
+
+func TestPlanEmailMigration(t *testing.T) {
+	users := []StoredEmail{
+		{UserID: "1", Email: "michaelscott@dundermifflin.com"},
+		{UserID: "2", Email: "MichaelScott@DunderMifflin.com"},
+		{UserID: "3", Email: "DwightSchrute@dundermifflin.com"},
+		{UserID: "4", Email: "jimhalpert@dundermifflin.com"},
+		{UserID: "5", Email: "not an email"},
+	}
+
+	report := planEmailMigration(users, DefaultEmailPolicy())
+
+	assert.Equal(t, []DuplicateEmail{{
+		Email: "michaelscott@dundermifflin.com",
+		Users: []StoredEmail{users[0], users[1]},
+	}}, report.Duplicates)
+	assert.Equal(t, []StoredEmail{users[4]}, report.Invalid)
+	assert.Equal(t, []EmailChange{
+		{UserID: "3", From: "DwightSchrute@dundermifflin.com", To: "dwightschrute@dundermifflin.com"},
+	}, report.Changes)
+}
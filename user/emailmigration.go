@@ -0,0 +1,122 @@
+package user
+
+import (
+	"context"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// StoredEmail is a user's email as it is stored in the database
+type StoredEmail struct {
+	UserID string
+	Email  string
+}
+
+// DuplicateEmail is a normalised email that more than one user has
+type DuplicateEmail struct {
+	Email string
+	Users []StoredEmail
+}
+
+// EmailChange replaces a stored email with its normalised form
+type EmailChange struct {
+	UserID string
+	From   string
+	To     string
+}
+
+// EmailMigrationReport describes what has to change so that every stored
+// email is normalised. Users with duplicate or invalid emails are not changed,
+// because someone has to decide which account to keep or how to contact them.
+type EmailMigrationReport struct {
+	Duplicates []DuplicateEmail
+	Invalid    []StoredEmail
+	Changes    []EmailChange
+}
+
+// planEmailMigration groups users by their normalised emails
+func planEmailMigration(users []StoredEmail, p EmailPolicy) EmailMigrationReport {
+	var report EmailMigrationReport
+	byEmail := make(map[string][]StoredEmail)
+
+	for _, u := range users {
+		email, err := p.Normalize(u.Email)
+		if err != nil {
+			report.Invalid = append(report.Invalid, u)
+			continue
+		}
+		byEmail[email] = append(byEmail[email], u)
+	}
+
+	emails := make([]string, 0, len(byEmail))
+	for email := range byEmail {
+		emails = append(emails, email)
+	}
+	sort.Strings(emails)
+
+	for _, email := range emails {
+		users := byEmail[email]
+		if len(users) > 1 {
+			report.Duplicates = append(report.Duplicates, DuplicateEmail{Email: email, Users: users})
+			continue
+		}
+
+		if users[0].Email != email {
+			report.Changes = append(report.Changes, EmailChange{UserID: users[0].UserID, From: users[0].Email, To: email})
+		}
+	}
+
+	return report
+}
+
+// MigrateEmails finds users whose emails are the same once they are normalised,
+// and users whose emails are not valid. If apply is true, the emails of every
+// other user are replaced with their normalised form.
+func MigrateEmails(ctx context.Context, client *mongo.Client, database string, p EmailPolicy, apply bool) (*EmailMigrationReport, error) {
+	coll := client.Database(database).Collection("users")
+
+	cursor, err := coll.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"email": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var users []StoredEmail
+	for cursor.Next(ctx) {
+		var userDocument struct {
+			ID    primitive.ObjectID `bson:"_id"`
+			Email string             `bson:"email"`
+		}
+		if err := cursor.Decode(&userDocument); err != nil {
+			return nil, err
+		}
+		users = append(users, StoredEmail{UserID: userDocument.ID.Hex(), Email: userDocument.Email})
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	report := planEmailMigration(users, p)
+	if !apply {
+		return &report, nil
+	}
+
+	for _, change := range report.Changes {
+		userOID, err := primitive.ObjectIDFromHex(change.UserID)
+		if err != nil {
+			return nil, err
+		}
+
+		// the email is only replaced if it has not changed since it was read
+		filter := bson.M{"_id": userOID, "email": change.From}
+		if _, err := coll.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"email": change.To}}); err != nil {
+			return nil, err
+		}
+	}
+
+	return &report, nil
+}
@@ -2,14 +2,55 @@ package user
 
 import (
 	"errors"
+
+	"github.com/ricxi/flat-list/shared/validation"
 )
 
-var ErrMissingField = errors.New("missing field is required")
+var ErrMissingField = validation.ErrMissingField
+var ErrInvalidField = validation.ErrInvalidField
 var ErrUserNotFound = errors.New("user not found")
 var ErrDuplicateUser = errors.New("user already exists")
+var ErrDuplicateEmails = errors.New("users have emails that only differ by case")
 var ErrUserNotActivated = errors.New("user has not activated their account")
+var ErrUserAlreadyActivated = errors.New("user has already activated their account")
+var ErrUserDeactivated = errors.New("user account has been deactivated")
+var ErrCannotDeactivateSelf = errors.New("admins cannot deactivate their own account")
+var ErrCannotImpersonateAdmin = errors.New("admins cannot be impersonated")
 var ErrInvalidEmail = errors.New("user with this email was not found")
+var ErrInvalidEmailAddress = errors.New("invalid email address")
 var ErrInvalidPassword = errors.New("invalid password provided")
+var ErrNoFieldsToUpdate = errors.New("no fields to update were provided")
+var ErrNoPendingEmail = errors.New("user has no pending email change")
+var ErrTooManyAttempts = errors.New("too many failed attempts, try again later")
+var ErrTwoFactorEnabled = errors.New("two-factor authentication is already enabled")
+var ErrTwoFactorNotEnabled = errors.New("two-factor authentication is not enabled")
+var ErrNoPendingTwoFactor = errors.New("two-factor authentication enrolment has not been started")
+var ErrInvalidTwoFactorCode = errors.New("invalid two-factor authentication code")
+var ErrPasswordNotSet = errors.New("user does not have a password, log in with an identity provider instead")
+var ErrInvalidReauthToken = errors.New("invalid or expired reauthentication token")
+var ErrUnknownOIDCProvider = errors.New("unknown identity provider")
+var ErrInvalidOIDCState = errors.New("invalid or expired login state")
+var ErrOIDCExchange = errors.New("unable to exchange authorization code")
+var ErrInvalidIDToken = errors.New("invalid id token")
+var ErrOIDCEmailNotVerified = errors.New("the identity provider has not verified this email")
+var ErrActivationTokenNotFound = errors.New("activation token not found")
+var ErrActivationTokenExpired = errors.New("activation token has expired")
+var ErrActivationTokenUsed = errors.New("activation token has already been used")
+var ErrExportNotFound = errors.New("data export not found")
+var ErrExportNotReady = errors.New("data export is not ready to download")
+var ErrExportExpired = errors.New("data export has expired")
+var ErrPersonalAccessTokenNotFound = errors.New("personal access token not found")
+var ErrInvalidPersonalAccessToken = errors.New("invalid or expired personal access token")
+var ErrDuplicateTokenName = errors.New("a personal access token with this name already exists")
+var ErrOAuthNotEnabled = errors.New("the oauth authorization server is not enabled")
+var ErrOAuthClientNotFound = errors.New("oauth client not found")
+var ErrOAuthConsentNotFound = errors.New("the user has not given consent to this oauth client")
+var ErrRedirectURIMismatch = errors.New("redirect uri is not registered for this oauth client")
+var ErrInvalidAuthorizationCode = errors.New("invalid or expired authorization code")
+var ErrInvalidOAuthAccessToken = errors.New("invalid or expired oauth access token")
+var ErrDigestAlreadySent = errors.New("the user's daily digest has already been sent today")
+var ErrEmailRateLimited = errors.New("too many emails have been sent, try again later")
+var ErrRecipientSuppressed = errors.New("emails cannot be sent to this address")
 
 // used by helper functions in service
 var ErrMissingEnvs = errors.New("service: missing environment variables")
@@ -0,0 +1,380 @@
+package user
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	// accounts with more tasks than this are exported by a background job
+	exportSyncTaskLimit = 500
+	// how long an export that was generated by a background job can be downloaded for
+	exportExpiry = 7 * 24 * time.Hour
+	// how long an export is claimed for while it is being generated, after
+	// which it is retried (ie. if the instance generating it was stopped)
+	exportLease = 10 * time.Minute
+	// an export is marked as failed after this many unsuccessful attempts
+	exportMaxAttempts = 5
+)
+
+// exportData copies a user's profile and tasks into a zip archive.
+// The archive is returned straight away for most users, but if the user
+// has too many tasks a pending export is returned instead. It is generated
+// by RunDataExports, and the user is emailed a link to download it.
+func (s *service) exportData(ctx context.Context, userID string) (*DataExport, error) {
+	uInfo, err := s.repository.getUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	taskCount, err := s.task.countUserTasks(ctx, userID)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	createdAt := time.Now().In(time.UTC)
+
+	if taskCount > exportSyncTaskLimit {
+		export := DataExport{
+			UserID:        uInfo.ID,
+			Status:        ExportPending,
+			NextAttemptAt: &createdAt,
+			CreatedAt:     &createdAt,
+		}
+
+		exportID, err := s.repository.createDataExport(ctx, export)
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		export.ID = exportID
+
+		return &export, nil
+	}
+
+	tasks, err := s.task.getUserTasks(ctx, userID)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	archive, err := buildExportArchive(uInfo, tasks, createdAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DataExport{
+		UserID:      uInfo.ID,
+		Status:      ExportReady,
+		Archive:     archive,
+		CreatedAt:   &createdAt,
+		CompletedAt: &createdAt,
+	}, nil
+}
+
+// runDataExports generates every pending export that is due, one at a time.
+// Each export is claimed before it is generated, so that more than one
+// instance of this service can run this at the same time.
+func (s *service) runDataExports(ctx context.Context) error {
+	for {
+		now := time.Now().In(time.UTC)
+		export, err := s.repository.claimDataExport(ctx, now, now.Add(exportLease))
+		if err != nil {
+			if errors.Is(err, ErrExportNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		if err := s.runDataExport(ctx, export); err != nil {
+			log.Printf("problem generating data export %s: %v", export.ID, err)
+		}
+	}
+}
+
+// runDataExport generates the archive of a pending export, stores it, and emails
+// the user a link to download it. If any step fails, the export is retried later,
+// until it has failed exportMaxAttempts times.
+func (s *service) runDataExport(ctx context.Context, export *DataExport) error {
+	downloadToken, err := s.completeDataExport(ctx, export)
+	now := time.Now().In(time.UTC)
+	export.Attempts++
+
+	if err != nil {
+		export.Error = err.Error()
+		if export.Attempts >= exportMaxAttempts || errors.Is(err, ErrUserNotFound) {
+			export.Status = ExportFailed
+			export.NextAttemptAt = nil
+			export.CompletedAt = &now
+		} else {
+			nextAttemptAt := now.Add(retryDelay(export.Attempts))
+			export.NextAttemptAt = &nextAttemptAt
+		}
+
+		if updateErr := s.repository.updateDataExport(ctx, *export); updateErr != nil {
+			log.Println(updateErr)
+		}
+		return err
+	}
+
+	expiresAt := now.Add(exportExpiry)
+	export.Status = ExportReady
+	export.Error = ""
+	export.DownloadTokenHash = hashSecret(downloadToken)
+	export.NextAttemptAt = nil
+	export.CompletedAt = &now
+	export.ExpiresAt = &expiresAt
+
+	// if this fails, the export is claimed again once its lease runs out,
+	// and the user is sent a new link since this one won't work
+	return s.repository.updateDataExport(ctx, *export)
+}
+
+// completeDataExport does the work of generating an export, and returns
+// the download token from the link that was emailed to the user.
+// Every step can be repeated if a later one fails.
+func (s *service) completeDataExport(ctx context.Context, export *DataExport) (string, error) {
+	uInfo, err := s.repository.getUserByID(ctx, export.UserID)
+	if err != nil {
+		return "", err
+	}
+
+	tasks, err := s.task.getUserTasks(ctx, export.UserID)
+	if err != nil {
+		return "", err
+	}
+
+	archive, err := buildExportArchive(uInfo, tasks, *export.CreatedAt)
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.repository.saveExportArchive(ctx, export.ID, archive); err != nil {
+		return "", err
+	}
+
+	downloadToken, err := generateDownloadToken()
+	if err != nil {
+		return "", err
+	}
+
+	downloadLink := DataExportDownloadLink + export.ID + "/download?token=" + downloadToken
+	if err := s.mailer.sendDataExportEmail(ctx, uInfo.Email, uInfo.FirstName, uInfo.Locale, downloadLink); err != nil {
+		return "", err
+	}
+
+	return downloadToken, nil
+}
+
+// getDataExport returns the status of one of a user's data exports
+func (s *service) getDataExport(ctx context.Context, userID, exportID string) (*DataExport, error) {
+	export, err := s.repository.getDataExportByID(ctx, exportID)
+	if err != nil {
+		return nil, err
+	}
+
+	// don't reveal that another user's export exists
+	if export.UserID != userID {
+		return nil, ErrExportNotFound
+	}
+
+	return export, nil
+}
+
+// downloadDataExport opens the archive of an export if the download
+// token from the link that was emailed to the user is correct.
+// The caller must close the archive.
+func (s *service) downloadDataExport(ctx context.Context, exportID, downloadToken string) (*ExportArchive, error) {
+	if err := s.validate.NonEmptyString("token", downloadToken); err != nil {
+		return nil, err
+	}
+
+	export, err := s.repository.getDataExportByID(ctx, exportID)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenHash := hashSecret(downloadToken)
+	if subtle.ConstantTimeCompare([]byte(tokenHash), []byte(export.DownloadTokenHash)) != 1 {
+		return nil, ErrExportNotFound
+	}
+
+	if export.Status != ExportReady {
+		return nil, ErrExportNotReady
+	}
+
+	if export.ExpiresAt != nil && time.Now().After(*export.ExpiresAt) {
+		return nil, ErrExportExpired
+	}
+
+	return s.repository.openExportArchive(ctx, export.ID)
+}
+
+// RunDataExports generates the pending data exports at every
+// interval until the context is cancelled.
+// It is meant to be run in its own goroutine.
+func RunDataExports(ctx context.Context, s Service, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.runDataExports(ctx); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
+
+// exportProfile is the part of the user's
+// profile that is included in an export
+type exportProfile struct {
+	ID               string           `json:"id"`
+	FirstName        string           `json:"firstName"`
+	LastName         string           `json:"lastName"`
+	Email            string           `json:"email"`
+	Locale           string           `json:"locale,omitempty"`
+	Activated        bool             `json:"activated"`
+	TwoFactorEnabled bool             `json:"twoFactorEnabled"`
+	Digest           DigestSettings   `json:"digest"`
+	Identities       []exportIdentity `json:"identities"`
+	CreatedAt        *time.Time       `json:"createdAt,omitempty"`
+	UpdatedAt        *time.Time       `json:"updatedAt,omitempty"`
+}
+
+// exportIdentity is an identity provider that the user is linked to. The subject is
+// the user's id at the provider, which is kept out of their profile but is theirs.
+type exportIdentity struct {
+	Provider string `json:"provider"`
+	Subject  string `json:"subject"`
+}
+
+func newExportProfile(uInfo *UserInfo) exportProfile {
+	identities := make([]exportIdentity, 0, len(uInfo.Identities))
+	for _, identity := range uInfo.Identities {
+		identities = append(identities, exportIdentity{Provider: identity.Provider, Subject: identity.Subject})
+	}
+
+	return exportProfile{
+		ID:               uInfo.ID,
+		FirstName:        uInfo.FirstName,
+		LastName:         uInfo.LastName,
+		Email:            uInfo.Email,
+		Locale:           uInfo.Locale,
+		Activated:        uInfo.Activated,
+		TwoFactorEnabled: uInfo.TwoFactorEnabled,
+		Digest:           uInfo.DigestSettings,
+		Identities:       identities,
+		CreatedAt:        uInfo.CreatedAt,
+		UpdatedAt:        uInfo.UpdatedAt,
+	}
+}
+
+// buildExportArchive creates a zip archive with a data.json file that has
+// all of a user's data, and a tasks.csv file to open in a spreadsheet.
+func buildExportArchive(uInfo *UserInfo, tasks []TaskData, exportedAt time.Time) ([]byte, error) {
+	if tasks == nil {
+		tasks = []TaskData{}
+	}
+
+	data := struct {
+		ExportedAt time.Time     `json:"exportedAt"`
+		Profile    exportProfile `json:"profile"`
+		Tasks      []TaskData    `json:"tasks"`
+	}{
+		ExportedAt: exportedAt,
+		Profile:    newExportProfile(uInfo),
+		Tasks:      tasks,
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	jsonFile, err := zw.Create("data.json")
+	if err != nil {
+		return nil, err
+	}
+
+	enc := json.NewEncoder(jsonFile)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(&data); err != nil {
+		return nil, err
+	}
+
+	csvFile, err := zw.Create("tasks.csv")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeTasksCSV(csvFile, tasks); err != nil {
+		return nil, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeTasksCSV(w io.Writer, tasks []TaskData) error {
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write([]string{"taskId", "name", "details", "priority", "category", "dueDate", "createdAt", "updatedAt"}); err != nil {
+		return err
+	}
+
+	for _, t := range tasks {
+		record := []string{t.ID, escapeCSVFormula(t.Name), escapeCSVFormula(t.Details), escapeCSVFormula(t.Priority),
+			escapeCSVFormula(t.Category), t.DueDate, formatExportTime(t.CreatedAt), formatExportTime(t.UpdatedAt)}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// escapeCSVFormula prefixes a cell that a spreadsheet would run as a formula with a quote,
+// so that a task can't run one when the user opens their export (CSV injection)
+func escapeCSVFormula(cell string) string {
+	if cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+		return "'" + cell
+	}
+
+	return cell
+}
+
+func formatExportTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+
+	return t.UTC().Format(time.RFC3339)
+}
+
+// generateDownloadToken creates a random token for the link to download an export
+func generateDownloadToken() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b), nil
+}
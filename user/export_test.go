@@ -0,0 +1,293 @@
+package user
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildExportArchive(t *testing.T) {
+	createdAt := time.Date(2023, time.April, 26, 12, 0, 0, 0, time.UTC)
+	uInfo := UserInfo{
+		ID:        "5ef7fdd91c19e3222b41b839",
+		FirstName: "Michael",
+		LastName:  "Scott",
+		Email:     "michael.scott@dundermifflin.com",
+		Locale:    "fr-CA",
+		Activated: true,
+		CreatedAt: &createdAt,
+
+		TwoFactorEnabled: true,
+		DigestSettings:   DigestSettings{DigestEnabled: true, Timezone: "America/Toronto"},
+		Identities:       []OIDCIdentity{{Provider: "google", Subject: "google-subject-1"}},
+	}
+	tasks := []TaskData{
+		{ID: "6448958b96118a48b722fd17", Name: "Laundry", Details: "tumble low, then dry", Priority: "low", DueDate: "2023-05-01", CreatedAt: &createdAt},
+	}
+
+	archive, err := buildExportArchive(&uInfo, tasks, createdAt)
+	require.NoError(t, err)
+
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	require.NoError(t, err)
+	require.Len(t, zr.File, 2)
+
+	data := struct {
+		Profile exportProfile `json:"profile"`
+		Tasks   []TaskData    `json:"tasks"`
+	}{}
+	require.NoError(t, json.Unmarshal(readZipFile(t, zr.File[0]), &data))
+	assert.Equal(t, "data.json", zr.File[0].Name)
+	assert.Equal(t, uInfo.Email, data.Profile.Email)
+	assert.True(t, data.Profile.Activated)
+	assert.Equal(t, "fr-CA", data.Profile.Locale)
+	assert.True(t, data.Profile.TwoFactorEnabled)
+	assert.Equal(t, uInfo.DigestSettings, data.Profile.Digest)
+	assert.Equal(t, []exportIdentity{{Provider: "google", Subject: "google-subject-1"}}, data.Profile.Identities)
+	assert.Equal(t, tasks[0].Name, data.Tasks[0].Name)
+
+	records, err := csv.NewReader(bytes.NewReader(readZipFile(t, zr.File[1]))).ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, "tasks.csv", zr.File[1].Name)
+	assert.Equal(t, []string{"taskId", "name", "details", "priority", "category", "dueDate", "createdAt", "updatedAt"}, records[0])
+	assert.Equal(t, []string{"6448958b96118a48b722fd17", "Laundry", "tumble low, then dry", "low", "", "2023-05-01", "2023-04-26T12:00:00Z", ""}, records[1])
+}
+
+func TestWriteTasksCSVEscapesFormulas(t *testing.T) {
+	tasks := []TaskData{
+		{ID: "6448958b96118a48b722fd17", Name: "=HYPERLINK(\"http://example.com\")", Details: "+1 for this", Priority: "-high", Category: "@work"},
+		{ID: "6448958b96118a48b722fd18", Name: "Laundry", Details: "costs $5 = cheap"},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, writeTasksCSV(&buf, tasks))
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"'=HYPERLINK(\"http://example.com\")", "'+1 for this", "'-high", "'@work"}, records[1][1:5])
+	assert.Equal(t, []string{"Laundry", "costs $5 = cheap", "", ""}, records[2][1:5])
+}
+
+func readZipFile(t *testing.T, f *zip.File) []byte {
+	t.Helper()
+
+	rc, err := f.Open()
+	require.NoError(t, err)
+	defer rc.Close()
+
+	b, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	return b
+}
+
+func Test_Service_ExportData(t *testing.T) {
+	assert := assert.New(t)
+	repository := &mockRepository{
+		user: &UserInfo{ID: "5ef7fdd91c19e3222b41b839", Email: "michael.scott@dundermifflin.com"},
+	}
+	s := &service{
+		repository: repository,
+		task:       &mockTaskClient{tasks: []TaskData{{ID: "6448958b96118a48b722fd17", Name: "Laundry"}}},
+	}
+
+	export, err := s.exportData(context.Background(), "5ef7fdd91c19e3222b41b839")
+	require.NoError(t, err)
+	assert.Equal(ExportReady, export.Status)
+	assert.NotEmpty(export.Archive)
+	// small exports are not stored
+	assert.Empty(export.ID)
+}
+
+func Test_Service_ExportDataLarge(t *testing.T) {
+	assert := assert.New(t)
+	mailer := &mockMailerClient{}
+	s := &service{
+		repository: &mockRepository{user: &UserInfo{ID: "5ef7fdd91c19e3222b41b839"}},
+		task:       &mockTaskClient{tasks: make([]TaskData, exportSyncTaskLimit+1)},
+		mailer:     mailer,
+	}
+
+	export, err := s.exportData(context.Background(), "5ef7fdd91c19e3222b41b839")
+	require.NoError(t, err)
+	assert.Equal(ExportPending, export.Status)
+	assert.Equal("6448958b96118a48b722fd16", export.ID)
+	assert.Empty(export.Archive)
+	assert.NotNil(export.NextAttemptAt)
+	// it is generated by the background job
+	assert.Empty(mailer.downloadLink)
+}
+
+func Test_Service_RunDataExports(t *testing.T) {
+	assert := assert.New(t)
+	createdAt := time.Now().In(time.UTC)
+	repository := &mockRepository{
+		user: &UserInfo{ID: "5ef7fdd91c19e3222b41b839", Email: "michael.scott@dundermifflin.com"},
+		pendingExports: []DataExport{{
+			ID:        "6448958b96118a48b722fd16",
+			UserID:    "5ef7fdd91c19e3222b41b839",
+			Status:    ExportPending,
+			CreatedAt: &createdAt,
+		}},
+	}
+	mailer := &mockMailerClient{}
+	s := &service{
+		repository: repository,
+		task:       &mockTaskClient{tasks: []TaskData{{ID: "6448958b96118a48b722fd17", Name: "Laundry"}}},
+		mailer:     mailer,
+	}
+
+	require.NoError(t, s.runDataExports(context.Background()))
+	require.Len(t, repository.updatedDataExports, 1)
+
+	updated := repository.updatedDataExports[0]
+	assert.Equal(ExportReady, updated.Status)
+	assert.Equal(1, updated.Attempts)
+	assert.Nil(updated.NextAttemptAt)
+	assert.NotEmpty(repository.exportArchives["6448958b96118a48b722fd16"])
+	if assert.NotNil(updated.ExpiresAt) {
+		assert.WithinDuration(createdAt.Add(exportExpiry), *updated.ExpiresAt, time.Minute)
+	}
+
+	// the token in the link that was emailed to the user is the one that is stored
+	_, downloadToken, ok := strings.Cut(mailer.downloadLink, "6448958b96118a48b722fd16/download?token=")
+	require.True(t, ok)
+	assert.Equal(hashSecret(downloadToken), updated.DownloadTokenHash)
+}
+
+func Test_Service_RunDataExportRetry(t *testing.T) {
+	createdAt := time.Now().In(time.UTC)
+
+	tests := []struct {
+		name      string
+		attempts  int
+		expStatus ExportStatus
+	}{
+		{
+			name:      "RetryLater",
+			attempts:  0,
+			expStatus: ExportPending,
+		},
+		{
+			name:      "FailAfterMaxAttempts",
+			attempts:  exportMaxAttempts - 1,
+			expStatus: ExportFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			repository := &mockRepository{user: &UserInfo{ID: "5ef7fdd91c19e3222b41b839"}}
+			mailer := &mockMailerClient{err: errors.New("mailer is down")}
+			s := &service{
+				repository: repository,
+				task:       &mockTaskClient{},
+				mailer:     mailer,
+			}
+
+			export := DataExport{
+				ID:        "6448958b96118a48b722fd16",
+				UserID:    "5ef7fdd91c19e3222b41b839",
+				Status:    ExportPending,
+				Attempts:  tt.attempts,
+				CreatedAt: &createdAt,
+			}
+
+			err := s.runDataExport(context.Background(), &export)
+			assert.Error(err)
+			require.Len(t, repository.updatedDataExports, 1)
+
+			updated := repository.updatedDataExports[0]
+			assert.Equal(tt.expStatus, updated.Status)
+			assert.Equal(tt.attempts+1, updated.Attempts)
+			assert.Equal("mailer is down", updated.Error)
+			assert.Empty(updated.DownloadTokenHash)
+			if tt.expStatus == ExportPending {
+				assert.NotNil(updated.NextAttemptAt)
+			} else {
+				assert.Nil(updated.NextAttemptAt)
+			}
+		})
+	}
+}
+
+func Test_Service_DownloadDataExport(t *testing.T) {
+	future := time.Now().Add(time.Hour)
+	past := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name          string
+		export        DataExport
+		downloadToken string
+		expErr        error
+	}{
+		{
+			name:          "Success",
+			export:        DataExport{Status: ExportReady, DownloadTokenHash: hashSecret("downloadtoken"), ExpiresAt: &future},
+			downloadToken: "downloadtoken",
+		},
+		{
+			name:          "FailWrongToken",
+			export:        DataExport{Status: ExportReady, DownloadTokenHash: hashSecret("downloadtoken"), ExpiresAt: &future},
+			downloadToken: "wrongtoken",
+			expErr:        ErrExportNotFound,
+		},
+		{
+			name:          "FailNotReady",
+			export:        DataExport{Status: ExportPending, DownloadTokenHash: hashSecret("downloadtoken")},
+			downloadToken: "downloadtoken",
+			expErr:        ErrExportNotReady,
+		},
+		{
+			name:          "FailExpired",
+			export:        DataExport{Status: ExportReady, DownloadTokenHash: hashSecret("downloadtoken"), ExpiresAt: &past},
+			downloadToken: "downloadtoken",
+			expErr:        ErrExportExpired,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			export := tt.export
+			export.ID = "6448958b96118a48b722fd16"
+			s := &service{
+				repository: &mockRepository{
+					dataExport:     &export,
+					exportArchives: map[string][]byte{"6448958b96118a48b722fd16": []byte("archive")},
+				},
+				validate: &validator{},
+			}
+
+			got, err := s.downloadDataExport(context.Background(), "6448958b96118a48b722fd16", tt.downloadToken)
+			if tt.expErr != nil {
+				assert.ErrorIs(t, err, tt.expErr)
+				assert.Nil(t, got)
+				return
+			}
+
+			require.NoError(t, err)
+			defer got.Close()
+			assert.Equal(t, int64(len("archive")), got.Size)
+		})
+	}
+}
+
+func Test_Service_GetDataExportOtherUser(t *testing.T) {
+	s := &service{
+		repository: &mockRepository{dataExport: &DataExport{UserID: "5ef7fdd91c19e3222b41b839"}},
+	}
+
+	export, err := s.getDataExport(context.Background(), "6448958b96118a48b722fd18", "6448958b96118a48b722fd16")
+	assert.ErrorIs(t, err, ErrExportNotFound)
+	assert.Nil(t, export)
+}
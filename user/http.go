@@ -1,29 +1,89 @@
 package user
 
 import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	"github.com/ricxi/flat-list/shared/authz"
 	req "github.com/ricxi/flat-list/shared/request"
 	res "github.com/ricxi/flat-list/shared/response"
+	"github.com/ricxi/flat-list/shared/validation"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type httpHandler struct {
 	service Service
 }
 
+// errorClassifier maps the errors that the service returns to status codes
+var errorClassifier = res.NewErrorClassifier().
+	Map(http.StatusNotFound, ErrUserNotFound, ErrExportNotFound, ErrActivationTokenNotFound, ErrUnknownOIDCProvider,
+		ErrPersonalAccessTokenNotFound, ErrOAuthNotEnabled, ErrOAuthClientNotFound, ErrOAuthConsentNotFound).
+	Map(http.StatusBadRequest, ErrRedirectURIMismatch, ErrInvalidAuthorizationCode).
+	MapFunc(http.StatusBadRequest, isOAuthError).
+	Map(http.StatusUnauthorized, ErrInvalidEmail, ErrInvalidPassword, ErrPasswordNotSet, ErrInvalidJWT, ErrInvalidJWTSignature,
+		ErrInvalidTwoFactorCode, ErrInvalidOIDCState, ErrInvalidIDToken, ErrInvalidPersonalAccessToken, ErrInvalidOAuthAccessToken,
+		ErrInvalidReauthToken).
+	Map(http.StatusForbidden, ErrUserNotActivated, ErrOIDCEmailNotVerified, ErrUserDeactivated, ErrCannotDeactivateSelf,
+		ErrCannotImpersonateAdmin).
+	Map(http.StatusConflict, ErrDuplicateUser, ErrUserAlreadyActivated, ErrNoPendingEmail, ErrTwoFactorEnabled, ErrTwoFactorNotEnabled,
+		ErrNoPendingTwoFactor, ErrActivationTokenUsed, ErrExportNotReady, ErrDuplicateTokenName).
+	Map(http.StatusGone, ErrActivationTokenExpired, ErrExportExpired).
+	// the mailer won't send to suppressed addresses (hard bounces and complaints), so they can't receive the email
+	Map(http.StatusUnprocessableEntity, ErrNoFieldsToUpdate, ErrInvalidEmailAddress, ErrRecipientSuppressed).
+	Map(http.StatusTooManyRequests, ErrTooManyAttempts, ErrEmailRateLimited).
+	Map(http.StatusBadGateway, ErrOIDCExchange).
+	MapFunc(http.StatusServiceUnavailable, isUnavailable)
+
+func isOAuthError(err error) bool {
+	var oErr *OAuthError
+	return errors.As(err, &oErr)
+}
+
+// isUnavailable checks if an error was caused by the database, or
+// another service, being unreachable or taking too long to respond
+func isUnavailable(err error) bool {
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) {
+		return true
+	}
+
+	var selectionErr topology.ServerSelectionError
+	if errors.As(err, &selectionErr) {
+		return true
+	}
+
+	var grpcErr interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &grpcErr) {
+		code := grpcErr.GRPCStatus().Code()
+		return code == codes.Unavailable || code == codes.DeadlineExceeded
+	}
+
+	return false
+}
+
 func NewHTTPHandler(service Service) http.Handler {
 	h := httpHandler{service: service}
 
 	r := chi.NewRouter()
 
+	r.Use(res.RequestID)
 	r.Use(middleware.Recoverer)
 
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"https://*", "http://*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
 		ExposedHeaders:   []string{"Link"},
 		AllowCredentials: true,
@@ -31,16 +91,80 @@ func NewHTTPHandler(service Service) http.Handler {
 	}))
 
 	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
-		res.SendErrorJSON(w, "resource not found", http.StatusNotFound)
+		res.SendError(w, r, "resource not found", http.StatusNotFound)
 	})
 
 	r.Route("/v1/user", func(r chi.Router) {
 		r.Get("/healthcheck", h.handleHealthCheck)
 		r.Post("/register", h.handleRegister)
 		r.Post("/login", h.handleLogin)
+		r.Post("/login/2fa", h.handleLoginTwoFactor)
 		r.Put("/activate/{token}", h.handleActivate)
 		r.Post("/restart/activation", h.handleRestartActivation)
 		r.Post("/authenticate", h.handleAuthenticate)
+		r.Put("/email/confirm/{token}", h.handleConfirmEmailChange)
+		r.Get("/export/{exportId}/download", h.handleDownloadDataExport)
+		r.Get("/oidc/{provider}/login", h.handleStartOIDCLogin)
+		r.Get("/oidc/{provider}/callback", h.handleOIDCCallback)
+
+		// routes for a user to manage their own account
+		r.Route("/me", func(r chi.Router) {
+			r.Use(h.authenticate)
+			r.Get("/", h.handleGetProfile)
+			r.Patch("/", h.handleUpdateProfile)
+			r.Get("/export/{exportId}", h.handleGetDataExport)
+			r.Get("/tokens", h.handleListPersonalAccessTokens)
+
+			// an admin who is impersonating the user can't take over or remove their account
+			r.Group(func(r chi.Router) {
+				r.Use(authz.DenyImpersonation)
+				r.Delete("/", h.handleDeleteAccount)
+				r.Post("/password", h.handleChangePassword)
+				r.Post("/email", h.handleRequestEmailChange)
+				r.Post("/export", h.handleExportData)
+				r.Post("/2fa/enroll", h.handleEnrollTwoFactor)
+				r.Post("/2fa/confirm", h.handleConfirmTwoFactor)
+				r.Post("/2fa/disable", h.handleDisableTwoFactor)
+				r.Post("/2fa/recovery-codes", h.handleRegenerateRecoveryCodes)
+				r.Post("/tokens", h.handleCreatePersonalAccessToken)
+				r.Delete("/tokens/{tokenId}", h.handleRevokePersonalAccessToken)
+				r.Delete("/oauth/consents/{clientId}", h.handleRevokeOAuthConsent)
+			})
+			r.Get("/oauth/consents", h.handleListOAuthConsents)
+		})
+
+		// routes for the oauth authorization server, which lets third-party apps access users' tasks.
+		// The token, introspection and revocation endpoints are called by apps, which authenticate
+		// themselves with their credentials; the others are called by users who are logged in.
+		r.Route("/oauth", func(r chi.Router) {
+			r.Post("/token", h.handleOAuthToken)
+			r.Post("/introspect", h.handleOAuthIntrospect)
+			r.Post("/revoke", h.handleOAuthRevoke)
+
+			r.Group(func(r chi.Router) {
+				r.Use(h.authenticate)
+				r.Get("/authorize", h.handleGetAuthorizationPrompt)
+				r.Get("/clients", h.handleListOAuthClients)
+
+				r.Group(func(r chi.Router) {
+					r.Use(authz.DenyImpersonation)
+					r.Post("/authorize", h.handleAuthorize)
+					r.Post("/clients", h.handleRegisterOAuthClient)
+					r.Delete("/clients/{clientId}", h.handleDeleteOAuthClient)
+				})
+			})
+		})
+
+		// routes for admins to manage other users' accounts
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(h.authenticate)
+			r.Use(authz.RequireRole(authz.RoleAdmin))
+			r.Get("/users", h.handleSearchUsers)
+			r.Post("/users/{userId}/deactivate", h.handleDeactivateUser)
+			r.Post("/users/{userId}/reactivate", h.handleReactivateUser)
+			r.Post("/users/{userId}/activation", h.handleResendActivation)
+			r.Post("/users/{userId}/impersonate", h.handleImpersonateUser)
+		})
 	})
 
 	return r
@@ -53,14 +177,17 @@ func (h httpHandler) handleHealthCheck(w http.ResponseWriter, r *http.Request) {
 func (h httpHandler) handleRegister(w http.ResponseWriter, r *http.Request) {
 	var u UserRegistrationInfo
 	if err := req.ParseJSON(r, &u); err != nil {
-		res.SendErrorJSON(w, err.Error(), http.StatusBadRequest)
+		res.SendError(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if u.Locale == "" {
+		u.Locale = acceptedLocale(r)
+	}
 
 	id, err := h.service.registerUser(r.Context(), u)
 	if err != nil {
 		// Should I return a 409 status code for a duplicate user?
-		res.SendErrorJSON(w, err.Error(), http.StatusBadRequest)
+		sendError(w, r, err)
 		return
 	}
 
@@ -70,13 +197,38 @@ func (h httpHandler) handleRegister(w http.ResponseWriter, r *http.Request) {
 func (h httpHandler) handleLogin(w http.ResponseWriter, r *http.Request) {
 	var u UserLoginInfo
 	if err := req.ParseJSON(r, &u); err != nil {
-		res.SendErrorJSON(w, err.Error(), http.StatusBadRequest)
+		res.SendError(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
+	u.IPAddress = clientIP(r)
 
 	uInfo, err := h.service.loginUser(r.Context(), u)
 	if err != nil {
-		res.SendErrorJSON(w, err.Error(), http.StatusBadRequest)
+		sendError(w, r, err)
+		return
+	}
+
+	// users with two-factor authentication must send a code with this token to get a jwt
+	if uInfo.ChallengeToken != "" {
+		res.SendSuccessJSON(w, res.Payload{"twoFactorRequired": true, "challengeToken": uInfo.ChallengeToken}, http.StatusOK, nil)
+		return
+	}
+
+	res.SendSuccessJSON(w, res.Payload{"user": uInfo}, http.StatusOK, nil)
+}
+
+// handleLoginTwoFactor is the second step of logging in for users with two-factor authentication
+func (h httpHandler) handleLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var l TwoFactorLoginInfo
+	if err := req.ParseJSON(r, &l); err != nil {
+		res.SendError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	l.IPAddress = clientIP(r)
+
+	uInfo, err := h.service.loginTwoFactor(r.Context(), l)
+	if err != nil {
+		sendError(w, r, err)
 		return
 	}
 
@@ -87,12 +239,12 @@ func (h httpHandler) handleLogin(w http.ResponseWriter, r *http.Request) {
 func (h httpHandler) handleActivate(w http.ResponseWriter, r *http.Request) {
 	activationToken := chi.URLParam(r, "token")
 	if activationToken == "" {
-		res.SendErrorJSON(w, "missing activation token parameter", http.StatusBadRequest)
+		res.SendError(w, r, "missing activation token parameter", http.StatusBadRequest)
 		return
 	}
 
 	if err := h.service.activateUser(r.Context(), activationToken); err != nil {
-		res.SendErrorJSON(w, err.Error(), http.StatusBadRequest)
+		sendError(w, r, err)
 		return
 	}
 
@@ -103,12 +255,13 @@ func (h httpHandler) handleActivate(w http.ResponseWriter, r *http.Request) {
 func (h httpHandler) handleRestartActivation(w http.ResponseWriter, r *http.Request) {
 	var u UserLoginInfo
 	if err := req.ParseJSON(r, &u); err != nil {
-		res.SendErrorJSON(w, err.Error(), http.StatusBadRequest)
+		res.SendError(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
+	u.IPAddress = clientIP(r)
 
 	if err := h.service.restartActivation(r.Context(), u); err != nil {
-		res.SendErrorJSON(w, err.Error(), http.StatusBadRequest)
+		sendError(w, r, err)
 		return
 	}
 
@@ -118,20 +271,848 @@ func (h httpHandler) handleRestartActivation(w http.ResponseWriter, r *http.Requ
 func (h httpHandler) handleAuthenticate(w http.ResponseWriter, r *http.Request) {
 	token := make(map[string]string)
 	if err := req.ParseJSON(r, &token); err != nil {
-		res.SendErrorJSON(w, err.Error(), http.StatusBadRequest)
+		res.SendError(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	if token["token"] == "" {
-		res.SendErrorJSON(w, "no token provided", http.StatusBadRequest)
+		res.SendError(w, r, "no token provided", http.StatusBadRequest)
+		return
+	}
+
+	// the other services send personal access tokens and
+	// the access tokens of third-party apps here as well as jwts
+	authenticate := h.service.authenticate
+	switch {
+	case strings.HasPrefix(token["token"], authz.PersonalAccessTokenPrefix):
+		authenticate = h.service.authenticatePersonalAccessToken
+	case strings.HasPrefix(token["token"], authz.OAuthAccessTokenPrefix):
+		authenticate = h.service.authenticateOAuthAccessToken
+	}
+
+	principal, err := authenticate(r.Context(), token["token"])
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	roles := principal.Roles
+	if roles == nil {
+		roles = []string{}
+	}
+
+	payload := res.Payload{"userId": principal.UserID, "roles": roles}
+	if principal.Impersonated() {
+		payload["impersonatorId"] = principal.ImpersonatorID
+	}
+	if principal.TokenID != "" {
+		payload["tokenId"] = principal.TokenID
+		payload["scopes"] = principal.Scopes
+	}
+	if principal.ClientID != "" {
+		payload["clientId"] = principal.ClientID
+	}
+
+	res.SendSuccessJSON(w, payload, http.StatusOK, nil)
+}
+
+func (h httpHandler) handleGetProfile(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		res.SendError(w, r, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	uInfo, err := h.service.getProfile(r.Context(), userID)
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	res.SendSuccessJSON(w, res.Payload{"user": uInfo}, http.StatusOK, nil)
+}
+
+func (h httpHandler) handleUpdateProfile(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		res.SendError(w, r, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var p ProfileUpdate
+	if err := req.ParseJSON(r, &p); err != nil {
+		res.SendError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	uInfo, err := h.service.updateProfile(r.Context(), userID, p)
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	res.SendSuccessJSON(w, res.Payload{"user": uInfo}, http.StatusOK, nil)
+}
+
+func (h httpHandler) handleChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		res.SendError(w, r, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var p PasswordChangeInfo
+	if err := req.ParseJSON(r, &p); err != nil {
+		res.SendError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.changePassword(r.Context(), userID, p); err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRequestEmailChange sends an email to the address
+// a user wants to change to, so that they can confirm it
+func (h httpHandler) handleRequestEmailChange(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		res.SendError(w, r, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var e EmailChangeInfo
+	if err := req.ParseJSON(r, &e); err != nil {
+		res.SendError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.requestEmailChange(r.Context(), userID, e); err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleConfirmEmailChange is called when a user follows
+// the link that was sent to the new email they requested
+func (h httpHandler) handleConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+	if token == "" {
+		res.SendError(w, r, "missing token parameter", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.confirmEmailChange(r.Context(), token); err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleDeleteAccount deletes a user's account after they confirm their password.
+// If any of the user's data could not be deleted right away, it responds with a
+// 202 status code, since the rest of the data will be deleted when it is retried.
+func (h httpHandler) handleDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		res.SendError(w, r, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var d AccountDeletionInfo
+	if err := req.ParseJSON(r, &d); err != nil {
+		res.SendError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	job, err := h.service.deleteAccount(r.Context(), userID, d)
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	if !job.completed() {
+		res.SendSuccessJSON(w, res.Payload{"deletionJobId": job.ID}, http.StatusAccepted, nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleExportData responds with a zip archive of the user's data, unless the
+// export is too large to generate right away. In that case it responds with
+// a 202 status code and the user is emailed a link when the export is ready.
+func (h httpHandler) handleExportData(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		res.SendError(w, r, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	export, err := h.service.exportData(r.Context(), userID)
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	if export.Status != ExportReady {
+		res.SendSuccessJSON(w, res.Payload{"export": export}, http.StatusAccepted, nil)
+		return
+	}
+
+	sendExportArchive(w, bytes.NewReader(export.Archive), int64(len(export.Archive)))
+}
+
+func (h httpHandler) handleGetDataExport(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		res.SendError(w, r, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	export, err := h.service.getDataExport(r.Context(), userID, chi.URLParam(r, "exportId"))
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	res.SendSuccessJSON(w, res.Payload{"export": export}, http.StatusOK, nil)
+}
+
+// handleDownloadDataExport is called when a user follows the link that
+// was emailed to them, so the download token is used instead of a jwt
+func (h httpHandler) handleDownloadDataExport(w http.ResponseWriter, r *http.Request) {
+	exportID := chi.URLParam(r, "exportId")
+	downloadToken := r.URL.Query().Get("token")
+
+	archive, err := h.service.downloadDataExport(r.Context(), exportID, downloadToken)
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+	defer archive.Close()
+
+	sendExportArchive(w, archive, archive.Size)
+}
+
+func sendExportArchive(w http.ResponseWriter, archive io.Reader, size int64) {
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="flat-list-export.zip"`)
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, archive)
+}
+
+// retryAfterError is an error that tells the client when it can try again,
+// such as a lockoutError or an emailRateLimitError
+type retryAfterError interface {
+	error
+	retrySeconds() int
+}
+
+// sendError sends an error with the status code that errorClassifier maps it to,
+// and a Retry-After header if the error says when the request can be retried
+func sendError(w http.ResponseWriter, r *http.Request, err error) {
+	var retryErr retryAfterError
+	if errors.As(err, &retryErr) {
+		w.Header().Set("Retry-After", strconv.Itoa(retryErr.retrySeconds()))
+	}
+
+	errorClassifier.SendError(w, r, err)
+}
+
+// acceptedLocale returns the language that a request's Accept-Language header prefers
+// (eg. fr-CA for "fr-CA,fr;q=0.9,en;q=0.8"), or an empty string if it has none
+func acceptedLocale(r *http.Request) string {
+	first, _, _ := strings.Cut(r.Header.Get("Accept-Language"), ",")
+	tag, _, _ := strings.Cut(first, ";")
+	tag = strings.TrimSpace(tag)
+	if !localePattern.MatchString(tag) {
+		return ""
+	}
+
+	return tag
+}
+
+// clientIP returns the ip address of the client that made the request
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// handleEnrollTwoFactor responds with a new secret and a URI to
+// show as a QR code, which the user scans with an authenticator app
+func (h httpHandler) handleEnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		res.SendError(w, r, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	enrollment, err := h.service.enrollTwoFactor(r.Context(), userID)
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	res.SendSuccessJSON(w, res.Payload{"twoFactor": enrollment}, http.StatusOK, nil)
+}
+
+func (h httpHandler) handleConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		res.SendError(w, r, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var c TwoFactorCode
+	if err := req.ParseJSON(r, &c); err != nil {
+		res.SendError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	recoveryCodes, err := h.service.confirmTwoFactor(r.Context(), userID, c)
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	res.SendSuccessJSON(w, res.Payload{"recoveryCodes": recoveryCodes}, http.StatusOK, nil)
+}
+
+func (h httpHandler) handleDisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		res.SendError(w, r, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var p PasswordConfirmation
+	if err := req.ParseJSON(r, &p); err != nil {
+		res.SendError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.disableTwoFactor(r.Context(), userID, p); err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h httpHandler) handleRegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		res.SendError(w, r, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var p PasswordConfirmation
+	if err := req.ParseJSON(r, &p); err != nil {
+		res.SendError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	recoveryCodes, err := h.service.regenerateRecoveryCodes(r.Context(), userID, p)
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	res.SendSuccessJSON(w, res.Payload{"recoveryCodes": recoveryCodes}, http.StatusOK, nil)
+}
+
+func (h httpHandler) handleCreatePersonalAccessToken(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		res.SendError(w, r, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var info PersonalAccessTokenInfo
+	if err := req.ParseJSON(r, &info); err != nil {
+		res.SendError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	pat, err := h.service.createPersonalAccessToken(r.Context(), userID, info)
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	res.SendSuccessJSON(w, res.Payload{"token": pat}, http.StatusCreated, nil)
+}
+
+func (h httpHandler) handleListPersonalAccessTokens(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		res.SendError(w, r, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	pats, err := h.service.listPersonalAccessTokens(r.Context(), userID)
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	res.SendSuccessJSON(w, res.Payload{"tokens": pats}, http.StatusOK, nil)
+}
+
+func (h httpHandler) handleRevokePersonalAccessToken(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		res.SendError(w, r, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.service.revokePersonalAccessToken(r.Context(), userID, chi.URLParam(r, "tokenId")); err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h httpHandler) handleListOAuthConsents(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		res.SendError(w, r, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	consents, err := h.service.listOAuthConsents(r.Context(), userID)
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	res.SendSuccessJSON(w, res.Payload{"consents": consents}, http.StatusOK, nil)
+}
+
+func (h httpHandler) handleRevokeOAuthConsent(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		res.SendError(w, r, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.service.revokeOAuthConsent(r.Context(), userID, chi.URLParam(r, "clientId")); err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h httpHandler) handleRegisterOAuthClient(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		res.SendError(w, r, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var info OAuthClientInfo
+	if err := req.ParseJSON(r, &info); err != nil {
+		res.SendError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	client, err := h.service.registerOAuthClient(r.Context(), userID, info)
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	res.SendSuccessJSON(w, res.Payload{"client": client}, http.StatusCreated, nil)
+}
+
+func (h httpHandler) handleListOAuthClients(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		res.SendError(w, r, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	clients, err := h.service.listOAuthClients(r.Context(), userID)
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	res.SendSuccessJSON(w, res.Payload{"clients": clients}, http.StatusOK, nil)
+}
+
+func (h httpHandler) handleDeleteOAuthClient(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		res.SendError(w, r, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.service.deleteOAuthClient(r.Context(), userID, chi.URLParam(r, "clientId")); err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleGetAuthorizationPrompt is called by the frontend with the query that an app sent
+// the user to it with, so that it can ask the user whether to let the app access their account
+func (h httpHandler) handleGetAuthorizationPrompt(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		res.SendError(w, r, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	prompt, err := h.service.getAuthorizationPrompt(r.Context(), userID, getAuthorizationRequest(r))
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	res.SendSuccessJSON(w, res.Payload{"authorization": prompt}, http.StatusOK, nil)
+}
+
+// handleAuthorize is called by the frontend with the same query when the user approves or
+// denies the app's request, and returns the url that the frontend should send the user to
+func (h httpHandler) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		res.SendError(w, r, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var decision struct {
+		Approved bool `json:"approved"`
+	}
+	if err := req.ParseJSON(r, &decision); err != nil {
+		res.SendError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	redirectURI, err := h.service.authorize(r.Context(), userID, getAuthorizationRequest(r), decision.Approved)
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	res.SendSuccessJSON(w, res.Payload{"redirectUri": redirectURI}, http.StatusOK, nil)
+}
+
+func getAuthorizationRequest(r *http.Request) AuthorizationRequest {
+	query := r.URL.Query()
+
+	return AuthorizationRequest{
+		ResponseType:        query.Get("response_type"),
+		ClientID:            query.Get("client_id"),
+		RedirectURI:         query.Get("redirect_uri"),
+		Scope:               query.Get("scope"),
+		State:               query.Get("state"),
+		CodeChallenge:       query.Get("code_challenge"),
+		CodeChallengeMethod: query.Get("code_challenge_method"),
+	}
+}
+
+func (h httpHandler) handleOAuthToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		sendOAuthError(w, r, newOAuthError("invalid_request", "the body must be form encoded"))
+		return
+	}
+
+	t := OAuthTokenRequest{
+		OAuthClientCredentials: getOAuthClientCredentials(r),
+		GrantType:              r.PostForm.Get("grant_type"),
+		Code:                   r.PostForm.Get("code"),
+		RedirectURI:            r.PostForm.Get("redirect_uri"),
+		CodeVerifier:           r.PostForm.Get("code_verifier"),
+		Scope:                  r.PostForm.Get("scope"),
+	}
+
+	token, err := h.service.exchangeOAuthToken(r.Context(), t)
+	if err != nil {
+		sendOAuthError(w, r, err)
+		return
+	}
+
+	res.SendJSON(w, token, http.StatusOK, oauthNoStoreHeaders)
+}
+
+func (h httpHandler) handleOAuthIntrospect(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("token") == "" {
+		sendOAuthError(w, r, newOAuthError("invalid_request", "token is required"))
 		return
 	}
 
-	userID, err := h.service.authenticate(r.Context(), token["token"])
+	introspection, err := h.service.introspectOAuthToken(r.Context(), getOAuthClientCredentials(r), r.PostForm.Get("token"))
 	if err != nil {
-		res.SendErrorJSON(w, err.Error(), http.StatusBadRequest)
+		sendOAuthError(w, r, err)
 		return
 	}
 
-	res.SendSuccessJSON(w, res.Payload{"userId": userID}, http.StatusOK, nil)
+	res.SendJSON(w, introspection, http.StatusOK, oauthNoStoreHeaders)
+}
+
+// handleOAuthRevoke responds with 200 even if the token was not found,
+// since the app only needs to know that the token can't be used (RFC 7009 section 2.2)
+func (h httpHandler) handleOAuthRevoke(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("token") == "" {
+		sendOAuthError(w, r, newOAuthError("invalid_request", "token is required"))
+		return
+	}
+
+	if err := h.service.revokeOAuthToken(r.Context(), getOAuthClientCredentials(r), r.PostForm.Get("token")); err != nil {
+		sendOAuthError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// oauthNoStoreHeaders stop responses with tokens from being cached (RFC 6749 section 5.1)
+var oauthNoStoreHeaders = map[string]string{"Cache-Control": "no-store", "Pragma": "no-cache"}
+
+// getOAuthClientCredentials reads an app's credentials from the basic auth header, or from the form
+// if there is no header. The header's credentials are form encoded (RFC 6749 section 2.3.1).
+func getOAuthClientCredentials(r *http.Request) OAuthClientCredentials {
+	if clientID, clientSecret, ok := r.BasicAuth(); ok {
+		c := OAuthClientCredentials{ClientID: clientID, ClientSecret: clientSecret}
+		if id, err := url.QueryUnescape(clientID); err == nil {
+			c.ClientID = id
+		}
+		if secret, err := url.QueryUnescape(clientSecret); err == nil {
+			c.ClientSecret = secret
+		}
+		return c
+	}
+
+	return OAuthClientCredentials{
+		ClientID:     r.PostForm.Get("client_id"),
+		ClientSecret: r.PostForm.Get("client_secret"),
+	}
+}
+
+// sendOAuthError sends an error to an app in the format of RFC 6749 section 5.2,
+// which apps expect instead of a problem. Other errors are sent as usual.
+func sendOAuthError(w http.ResponseWriter, r *http.Request, err error) {
+	var oErr *OAuthError
+	if !errors.As(err, &oErr) {
+		sendError(w, r, err)
+		return
+	}
+
+	statusCode := http.StatusBadRequest
+	headers := map[string]string{"Cache-Control": "no-store"}
+	if oErr.Code == "invalid_client" {
+		statusCode = http.StatusUnauthorized
+		headers["WWW-Authenticate"] = `Basic realm="flat-list"`
+	}
+
+	payload := map[string]string{"error": oErr.Code, "error_description": oErr.Description}
+	res.SendJSON(w, payload, statusCode, headers)
+}
+
+// oidcStateCookie stores the state of a login with an identity provider
+// in the user's browser, so that the callback can check that it is the
+// same browser that started the login
+const oidcStateCookie = "oidc_state"
+
+// handleStartOIDCLogin redirects the user to log in with an identity provider. With ?reauth=true,
+// the callback returns a reauthentication token instead, which users without a password can
+// confirm actions with (eg. deleting their account).
+func (h httpHandler) handleStartOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	authURL, state, err := h.service.startOIDCLogin(r.Context(), chi.URLParam(r, "provider"), r.URL.Query().Get("reauth") == "true")
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/v1/user/oidc",
+		MaxAge:   int(oidcLoginExpiry.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// handleOIDCCallback is where an identity provider redirects the user back to
+// after they log in. It responds in the same way as a login with a password.
+func (h httpHandler) handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	// the state can only be used once, so the cookie is removed either way
+	http.SetCookie(w, &http.Cookie{
+		Name:   oidcStateCookie,
+		Value:  "",
+		Path:   "/v1/user/oidc",
+		MaxAge: -1,
+	})
+
+	q := r.URL.Query()
+	if providerErr := q.Get("error"); providerErr != "" {
+		res.SendError(w, r, "identity provider returned an error: "+providerErr, http.StatusBadRequest)
+		return
+	}
+
+	c := OIDCCallbackInfo{
+		Provider: chi.URLParam(r, "provider"),
+		Code:     q.Get("code"),
+		State:    q.Get("state"),
+	}
+	if cookie, err := r.Cookie(oidcStateCookie); err == nil {
+		c.StateCookie = cookie.Value
+	}
+
+	uInfo, err := h.service.finishOIDCLogin(r.Context(), c)
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	if uInfo.ReauthToken != "" {
+		res.SendSuccessJSON(w, res.Payload{"reauthToken": uInfo.ReauthToken}, http.StatusOK, nil)
+		return
+	}
+
+	if uInfo.ChallengeToken != "" {
+		res.SendSuccessJSON(w, res.Payload{"twoFactorRequired": true, "challengeToken": uInfo.ChallengeToken}, http.StatusOK, nil)
+		return
+	}
+
+	res.SendSuccessJSON(w, res.Payload{"user": uInfo}, http.StatusOK, nil)
+}
+
+// handleSearchUsers lists the users that match the 'q' and 'role'
+// query parameters a page at a time, using 'limit' and 'offset'
+func (h httpHandler) handleSearchUsers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	search := UserSearch{
+		Query: q.Get("q"),
+		Role:  q.Get("role"),
+	}
+
+	var vErr validation.Error
+	for field, value := range map[string]*int{"limit": &search.Limit, "offset": &search.Offset} {
+		if s := q.Get(field); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				vErr.Add(field, "not_a_number", field+" must be a whole number")
+			}
+			*value = n
+		}
+	}
+	if err := vErr.Err(); err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	result, err := h.service.searchUsers(r.Context(), search)
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	res.SendSuccessJSON(w, res.Payload{"result": result}, http.StatusOK, nil)
+}
+
+func (h httpHandler) handleDeactivateUser(w http.ResponseWriter, r *http.Request) {
+	a, ok := parseAdminAction(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.deactivateUser(r.Context(), a); err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h httpHandler) handleReactivateUser(w http.ResponseWriter, r *http.Request) {
+	a, ok := parseAdminAction(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.reactivateUser(r.Context(), a); err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleResendActivation sends a new activation email to a user
+func (h httpHandler) handleResendActivation(w http.ResponseWriter, r *http.Request) {
+	a, ok := parseAdminAction(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.resendActivation(r.Context(), a); err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleImpersonateUser returns a token that lets an admin act as a user,
+// which requires a reason that is stored in the audit log
+func (h httpHandler) handleImpersonateUser(w http.ResponseWriter, r *http.Request) {
+	a, ok := parseAdminAction(w, r)
+	if !ok {
+		return
+	}
+
+	impersonation, err := h.service.impersonateUser(r.Context(), a)
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	res.SendSuccessJSON(w, res.Payload{"impersonation": impersonation}, http.StatusCreated, nil)
+}
+
+// parseAdminAction reads the reason for an admin's action from the request body,
+// which is optional for every action but impersonation. It sends an error
+// response and returns false if the request can't be parsed.
+func parseAdminAction(w http.ResponseWriter, r *http.Request) (AdminAction, bool) {
+	var a AdminAction
+	if r.ContentLength != 0 {
+		if err := req.ParseJSON(r, &a); err != nil {
+			res.SendError(w, r, err.Error(), http.StatusBadRequest)
+			return a, false
+		}
+	}
+
+	actorID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		res.SendError(w, r, err.Error(), http.StatusUnauthorized)
+		return a, false
+	}
+
+	a.ActorID = actorID
+	a.UserID = chi.URLParam(r, "userId")
+	a.IPAddress = clientIP(r)
+
+	return a, true
 }
@@ -1,13 +1,18 @@
 package user
 
 import (
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/ricxi/flat-list/shared/validation"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // newRequestWithHeaders creates an *http.Request from the httptest package, and adds some headers to it.
@@ -95,10 +100,36 @@ func TestHandleRegister(t *testing.T) {
 				},
 			},
 			expected: expected{
-				statusCode: 400,
+				statusCode: 409,
 				body:       `{"error":"user already exists", "success":false}`,
 			},
 		},
+		{
+			name: "FailPasswordPolicy",
+			service: mockService{
+				err: &validation.Error{Fields: []validation.FieldError{
+					{Field: "password", Code: "too_short", Message: "password must be at least 12 characters long"},
+				}},
+			},
+			r: req{
+				method: http.MethodPost,
+				target: "/v1/user/register",
+				body: `
+				{
+					"firstName": "Michael",
+					"lastName": "Scott",
+					"email": "michaelscott@dundermifflin.com",
+					"password": "1234"
+				}`,
+				headers: map[string]string{
+					"Content-Type": "application/json",
+				},
+			},
+			expected: expected{
+				statusCode: 422,
+				body:       `{"error":"password must be at least 12 characters long","fields":[{"field":"password","code":"too_short","message":"password must be at least 12 characters long"}],"success":false}`,
+			},
+		},
 		{
 			name: "WrongContentTypeNoHeaders",
 			service: mockService{
@@ -143,6 +174,7 @@ func TestHandleLogin(t *testing.T) {
 	type expected struct {
 		statusCode int
 		body       string
+		retryAfter string
 	}
 	tests := []struct {
 		name     string
@@ -206,7 +238,7 @@ func TestHandleLogin(t *testing.T) {
 				`),
 			),
 			expected: expected{
-				statusCode: 400,
+				statusCode: 401,
 				body: `
 				{
 					"error": "user with this email was not found",
@@ -231,7 +263,7 @@ func TestHandleLogin(t *testing.T) {
 				`),
 			),
 			expected: expected{
-				statusCode: 400,
+				statusCode: 403,
 				body: `
 				{
 					"error": "user has not activated their account",
@@ -239,6 +271,32 @@ func TestHandleLogin(t *testing.T) {
 				}`,
 			},
 		},
+		{
+			name: "FailTooManyAttempts",
+			service: mockService{
+				userInfo: nil,
+				err:      &lockoutError{retryAfter: 90 * time.Second},
+			},
+			request: newRequestWithJSONHeader(
+				http.MethodPost,
+				"/v1/user/login",
+				strings.NewReader(`
+				{
+					"email": "michaelscott@dundermifflin.com",
+					"password": "4321"
+				}
+				`),
+			),
+			expected: expected{
+				statusCode: 429,
+				body: `
+				{
+					"error": "too many failed attempts, try again later: retry after 90 seconds",
+					"success": false
+				}`,
+				retryAfter: "90",
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -248,6 +306,7 @@ func TestHandleLogin(t *testing.T) {
 
 			assert.Equal(t, tt.expected.statusCode, rr.Code)
 			assert.JSONEq(t, tt.expected.body, rr.Body.String())
+			assert.Equal(t, tt.expected.retryAfter, rr.Header().Get("Retry-After"))
 		})
 	}
 }
@@ -292,7 +351,7 @@ func TestHandleActivate(t *testing.T) {
 				nil,
 			),
 			expected: expected{
-				statusCode: 400,
+				statusCode: 404,
 				hasBody:    true,
 				body:       `{"error":"user not found", "success":false}`,
 			},
@@ -332,6 +391,7 @@ func TestHandleRestartActivation(t *testing.T) {
 		statusCode int
 		hasBody    bool
 		body       string
+		retryAfter string
 	}
 
 	testCases := []struct {
@@ -377,11 +437,42 @@ func TestHandleRestartActivation(t *testing.T) {
 				`),
 			),
 			expected: expected{
-				statusCode: 400,
+				statusCode: 401,
 				hasBody:    true,
 				body:       `{"error":"invalid password provided", "success":false}`,
 			},
 		},
+		{
+			name: "EmailRateLimited",
+			service: mockService{
+				err: &emailRateLimitError{retryAfter: 30 * time.Second},
+			},
+			request: newRequestWithJSONHeader(
+				http.MethodPost,
+				"/v1/user/restart/activation",
+				strings.NewReader(`{"email": "michaelscott@dundermifflin.com", "password": "1234"}`),
+			),
+			expected: expected{
+				statusCode: 429,
+				retryAfter: "30",
+			},
+		},
+		{
+			name: "RecipientSuppressed",
+			service: mockService{
+				err: ErrRecipientSuppressed,
+			},
+			request: newRequestWithJSONHeader(
+				http.MethodPost,
+				"/v1/user/restart/activation",
+				strings.NewReader(`{"email": "michaelscott@dundermifflin.com", "password": "1234"}`),
+			),
+			expected: expected{
+				statusCode: 422,
+				hasBody:    true,
+				body:       `{"error":"emails cannot be sent to this address", "success":false}`,
+			},
+		},
 	}
 
 	for _, tt := range testCases {
@@ -391,6 +482,7 @@ func TestHandleRestartActivation(t *testing.T) {
 		h.ServeHTTP(rr, tt.request)
 
 		assert.Equal(t, tt.expected.statusCode, rr.Code)
+		assert.Equal(t, tt.expected.retryAfter, rr.Header().Get("Retry-After"))
 
 		if tt.expected.hasBody {
 			assert.JSONEq(t, tt.expected.body, rr.Body.String())
@@ -414,6 +506,7 @@ func TestHandleAuthenticate(t *testing.T) {
 			name: "success",
 			service: mockService{
 				userID: "507f191e810c19729de860ea",
+				roles:  []string{"admin"},
 				err:    nil,
 			},
 			request: newRequestWithJSONHeader(
@@ -427,7 +520,28 @@ func TestHandleAuthenticate(t *testing.T) {
 			),
 			expected: expected{
 				statusCode: 200,
-				body:       `{"success":true,"userId":"507f191e810c19729de860ea"}`,
+				body:       `{"success":true,"userId":"507f191e810c19729de860ea","roles":["admin"]}`,
+			},
+		},
+		{
+			name: "successPersonalAccessToken",
+			service: mockService{
+				userID: "507f191e810c19729de860ea",
+				roles:  []string{"admin"},
+				scopes: []string{"tasks:read"},
+			},
+			request: newRequestWithJSONHeader(
+				http.MethodPost,
+				"/v1/user/authenticate",
+				strings.NewReader(`
+				{
+					"token": "flpat_token_goes_here"
+				}
+				`),
+			),
+			expected: expected{
+				statusCode: 200,
+				body:       `{"success":true,"userId":"507f191e810c19729de860ea","roles":[],"tokenId":"a1b2c3d4e5f60718","scopes":["tasks:read"]}`,
 			},
 		},
 	}
@@ -442,3 +556,235 @@ func TestHandleAuthenticate(t *testing.T) {
 		assert.JSONEq(t, tt.expected.body, rr.Body.String())
 	}
 }
+
+func TestHandleProfile(t *testing.T) {
+	type expected struct {
+		statusCode int
+		hasBody    bool
+		body       string
+	}
+
+	tests := []struct {
+		name     string
+		service  Service
+		request  *http.Request
+		expected expected
+	}{
+		{
+			name: "GetProfileSuccess",
+			service: mockService{
+				userID: "60af7bf76c21d03b7c174f88",
+				userInfo: &UserInfo{
+					ID:        "60af7bf76c21d03b7c174f88",
+					FirstName: "Michael",
+					LastName:  "Scott",
+					Email:     "michaelscott@dundermifflin.com",
+				},
+			},
+			request: newRequestWithHeaders(
+				http.MethodGet,
+				"/v1/user/me",
+				nil,
+				map[string]string{"Authorization": "Bearer token_goes_here"},
+			),
+			expected: expected{
+				statusCode: 200,
+				hasBody:    true,
+				body: `
+				{
+					"user": {
+						"id": "60af7bf76c21d03b7c174f88",
+						"firstName": "Michael",
+						"lastName": "Scott",
+						"email": "michaelscott@dundermifflin.com"
+					},
+					"success": true
+				}`,
+			},
+		},
+		{
+			name:    "GetProfileMissingAuthHeader",
+			service: mockService{},
+			request: httptest.NewRequest(http.MethodGet, "/v1/user/me", nil),
+			expected: expected{
+				statusCode: 401,
+				hasBody:    true,
+				body:       `{"error":"auth header is empty or missing", "success":false}`,
+			},
+		},
+		{
+			// the mock service's authenticate method also returns this error
+			name: "UpdateProfileInvalidJWT",
+			service: mockService{
+				err: ErrInvalidJWT,
+			},
+			request: newRequestWithHeaders(
+				http.MethodPatch,
+				"/v1/user/me",
+				strings.NewReader(`{}`),
+				map[string]string{
+					"Authorization": "Bearer token_goes_here",
+					"Content-Type":  "application/json",
+				},
+			),
+			expected: expected{
+				statusCode: 401,
+				hasBody:    true,
+				body:       `{"error":"unable to authorize user", "success":false}`,
+			},
+		},
+		{
+			name: "ChangePasswordSuccess",
+			service: mockService{
+				userID: "60af7bf76c21d03b7c174f88",
+			},
+			request: newRequestWithHeaders(
+				http.MethodPost,
+				"/v1/user/me/password",
+				strings.NewReader(`{"currentPassword":"1234","newPassword":"5678"}`),
+				map[string]string{
+					"Authorization": "Bearer token_goes_here",
+					"Content-Type":  "application/json",
+				},
+			),
+			expected: expected{
+				statusCode: 204,
+			},
+		},
+		{
+			name: "RequestEmailChangeSuccess",
+			service: mockService{
+				userID: "60af7bf76c21d03b7c174f88",
+			},
+			request: newRequestWithHeaders(
+				http.MethodPost,
+				"/v1/user/me/email",
+				strings.NewReader(`{"email":"michael.scott@dundermifflin.com","password":"1234"}`),
+				map[string]string{
+					"Authorization": "Bearer token_goes_here",
+					"Content-Type":  "application/json",
+				},
+			),
+			expected: expected{
+				statusCode: 202,
+			},
+		},
+		{
+			name: "DeleteAccountCompleted",
+			service: mockService{
+				userID:      "60af7bf76c21d03b7c174f88",
+				deletionJob: &DeletionJob{UserDeleted: true, TasksDeleted: true, TokensDeleted: true},
+			},
+			request: newRequestWithHeaders(
+				http.MethodDelete,
+				"/v1/user/me",
+				strings.NewReader(`{"password":"1234"}`),
+				map[string]string{
+					"Authorization": "Bearer token_goes_here",
+					"Content-Type":  "application/json",
+				},
+			),
+			expected: expected{
+				statusCode: 204,
+			},
+		},
+		{
+			name: "DeleteAccountPending",
+			service: mockService{
+				userID:      "60af7bf76c21d03b7c174f88",
+				deletionJob: &DeletionJob{ID: "6448958b96118a48b722fd15", UserDeleted: true},
+			},
+			request: newRequestWithHeaders(
+				http.MethodDelete,
+				"/v1/user/me",
+				strings.NewReader(`{"password":"1234"}`),
+				map[string]string{
+					"Authorization": "Bearer token_goes_here",
+					"Content-Type":  "application/json",
+				},
+			),
+			expected: expected{
+				statusCode: 202,
+				hasBody:    true,
+				body:       `{"deletionJobId":"6448958b96118a48b722fd15", "success":true}`,
+			},
+		},
+		{
+			name:    "ConfirmEmailChangeSuccess",
+			service: mockService{},
+			request: httptest.NewRequest(http.MethodPut, "/v1/user/email/confirm/tokengoeshere", nil),
+			expected: expected{
+				statusCode: 204,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHTTPHandler(tt.service)
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, tt.request)
+
+			assert.Equal(t, tt.expected.statusCode, rr.Code)
+			if tt.expected.hasBody {
+				assert.JSONEq(t, tt.expected.body, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestAcceptedLocale(t *testing.T) {
+	tests := []struct {
+		acceptLanguage string
+		locale         string
+	}{
+		{acceptLanguage: "fr-CA,fr;q=0.9,en;q=0.8", locale: "fr-CA"},
+		{acceptLanguage: "de;q=0.9", locale: "de"},
+		{acceptLanguage: "*", locale: ""},
+		{acceptLanguage: "", locale: ""},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/v1/user/register", nil)
+		r.Header.Set("Accept-Language", tt.acceptLanguage)
+
+		assert.Equal(t, tt.locale, acceptedLocale(r), tt.acceptLanguage)
+	}
+}
+
+func TestHandleServerErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		statusCode int
+		body       string
+	}{
+		{
+			name:       "InternalErrorIsHidden",
+			err:        errors.New("mongo: write to 10.0.0.12:27017 failed"),
+			statusCode: http.StatusInternalServerError,
+			body:       `{"success":false,"error":"an internal server error has occurred","requestId":"test-request-id"}`,
+		},
+		{
+			name:       "TokenServiceUnavailable",
+			err:        status.Error(codes.Unavailable, "connection refused"),
+			statusCode: http.StatusServiceUnavailable,
+			body:       `{"success":false,"error":"the service is temporarily unavailable, try again later","requestId":"test-request-id"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHTTPHandler(mockService{err: tt.err})
+
+			r := httptest.NewRequest(http.MethodPut, "/v1/user/activate/tokengoeshere", nil)
+			r.Header.Set("X-Request-ID", "test-request-id")
+			rr := httptest.NewRecorder()
+
+			h.ServeHTTP(rr, r)
+
+			assert.Equal(t, tt.statusCode, rr.Code)
+			assert.Equal(t, "test-request-id", rr.Header().Get("X-Request-ID"))
+			assert.JSONEq(t, tt.body, rr.Body.String())
+		})
+	}
+}
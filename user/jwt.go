@@ -17,12 +17,38 @@ var (
 type UserClaims struct {
 	jwt.MapClaims
 	UserID string
+	// Roles are sent so that clients can tell what a user can do, but
+	// they are not trusted; authenticate reads them from the database
+	Roles []string `json:",omitempty"`
+	// ImpersonatorID is set for tokens that an admin uses to act as the user
+	ImpersonatorID string `json:",omitempty"`
+	// Purpose is only set for tokens that cannot be used to access
+	// a user's account, such as a two-factor challenge token
+	Purpose string `json:",omitempty"`
 }
 
-// generateUserJWT creates a signed jwt with the user's ID.
-func generateUserJWT(userID string) (string, error) {
+// challengePurpose is the purpose of a token returned by login
+// to users who still need to provide a two-factor code
+const challengePurpose = "2fa-challenge"
+
+// challengeExpiry is how long a user has to provide a two-factor code after logging in
+const challengeExpiry = 5 * time.Minute
+
+// reauthPurpose is the purpose of a token returned to a user who has logged in with their
+// identity provider again, which they can send instead of a password to confirm an action
+const reauthPurpose = "reauth"
+
+// reauthExpiry is how long a user has to confirm an action after logging in with their identity provider again
+const reauthExpiry = 5 * time.Minute
+
+// impersonationExpiry is how long an admin can act as a user with one token
+const impersonationExpiry = time.Hour
+
+// generateUserJWT creates a signed jwt with the user's ID and roles.
+func generateUserJWT(userID string, roles []string) (string, error) {
 	userClaims := UserClaims{
 		UserID: userID,
+		Roles:  roles,
 		MapClaims: jwt.MapClaims{
 			"exp": time.Now().Add(time.Hour * 24).Unix(),
 		},
@@ -30,6 +56,47 @@ func generateUserJWT(userID string) (string, error) {
 	return generateJWT(userClaims)
 }
 
+// generateImpersonationJWT creates a short-lived signed jwt
+// which lets an admin act as a user for support
+func generateImpersonationJWT(userID string, roles []string, adminID string, expiresAt time.Time) (string, error) {
+	userClaims := UserClaims{
+		UserID:         userID,
+		Roles:          roles,
+		ImpersonatorID: adminID,
+		MapClaims: jwt.MapClaims{
+			"exp": expiresAt.Unix(),
+		},
+	}
+	return generateJWT(userClaims)
+}
+
+// generateChallengeJWT creates a short-lived signed jwt which shows that
+// a user has provided their password, but not their two-factor code.
+func generateChallengeJWT(userID string) (string, error) {
+	userClaims := UserClaims{
+		UserID:  userID,
+		Purpose: challengePurpose,
+		MapClaims: jwt.MapClaims{
+			"exp": time.Now().Add(challengeExpiry).Unix(),
+		},
+	}
+	return generateJWT(userClaims)
+}
+
+// generateReauthJWT creates a short-lived signed jwt which shows that a user
+// has just logged in with their identity provider again, so that users without
+// a password can confirm the actions that other users confirm with theirs.
+func generateReauthJWT(userID string) (string, error) {
+	userClaims := UserClaims{
+		UserID:  userID,
+		Purpose: reauthPurpose,
+		MapClaims: jwt.MapClaims{
+			"exp": time.Now().Add(reauthExpiry).Unix(),
+		},
+	}
+	return generateJWT(userClaims)
+}
+
 // generateJWT creates a signed jwt and receives any type
 // that embeds a struct that implements the jwt.Claims interface
 func generateJWT(claims jwt.Claims) (string, error) {
@@ -67,7 +134,8 @@ func verifyUserJWT(signedJWT string, userClaims *UserClaims) error {
 		}
 	}
 
-	if !token.Valid {
+	// a malformed jwt isn't parsed into a token at all
+	if token == nil || !token.Valid {
 		return ErrInvalidJWT
 	}
 
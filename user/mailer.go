@@ -5,22 +5,90 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/ricxi/flat-list/mailer"
 	"github.com/ricxi/flat-list/mailer/pb"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
 const ActivationPageLink string = "http://localhost:5173/activate?token="
+const EmailChangePageLink string = "http://localhost:5173/email/confirm?token="
+
+// LoginPageLink is included in the email that is sent when a user's account is locked
+const LoginPageLink string = "http://localhost:5173/login"
+
+// TasksPageLink is included in daily digests
+const TasksPageLink string = "http://localhost:5173/tasks"
+
+// DataExportDownloadLink is joined with an export's id and download token
+const DataExportDownloadLink string = "http://localhost:5004/v1/user/export/"
 
 // MailerClient is used by Service to make
-// http or grpc calls to other services
+// http or grpc calls to other services.
+// The locale is the user's preferred locale, which the mailer translates
+// emails to if it can, and their subjects come from the mailer in that locale.
 type MailerClient interface {
-	sendActivationEmail(ctx context.Context, email, name, activationToken string) error
+	sendActivationEmail(ctx context.Context, email, name, locale, activationToken string) error
+	sendEmailChangeEmail(ctx context.Context, email, name, locale, token string) error
+	sendDataExportEmail(ctx context.Context, email, name, locale, downloadLink string) error
+	sendLockoutEmail(ctx context.Context, email, name, locale string) error
+	sendDigestEmail(ctx context.Context, email, name, locale string, digest Digest) error
+}
+
+// emailRateLimitWait is how long to wait before sending again when
+// the mailer rate limits an email without saying for how long
+const emailRateLimitWait = time.Minute
+
+// emailRateLimitError is returned when the mailer won't send an email because too
+// many have been sent, and tells the caller when they can try again
+type emailRateLimitError struct {
+	retryAfter time.Duration
+}
+
+func (e *emailRateLimitError) Error() string {
+	return fmt.Sprintf("%s: retry after %d seconds", ErrEmailRateLimited, e.retrySeconds())
+}
+
+func (e *emailRateLimitError) Unwrap() error {
+	return ErrEmailRateLimited
+}
+
+func (e *emailRateLimitError) retrySeconds() int {
+	return retrySeconds(e.retryAfter)
+}
+
+// mailerError converts the statuses that the mailer's grpc server
+// sends for rate limited and suppressed emails to their errors
+func mailerError(err error) error {
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+
+	switch st.Code() {
+	case codes.ResourceExhausted:
+		rlErr := &emailRateLimitError{retryAfter: emailRateLimitWait}
+		for _, detail := range st.Details() {
+			if retryInfo, ok := detail.(*errdetails.RetryInfo); ok {
+				rlErr.retryAfter = retryInfo.RetryDelay.AsDuration()
+			}
+		}
+		return rlErr
+	case codes.FailedPrecondition:
+		return fmt.Errorf("%w: %s", ErrRecipientSuppressed, st.Message())
+	}
+
+	return err
 }
 
 type grpcMailerClient struct {
@@ -42,21 +110,100 @@ func NewGRPCMailerClient(port string) (*grpcMailerClient, error) {
 
 // SendActivationEmail makes a remote procedure call to the mailer service,
 // which sends an account activation email to a newly registered user
-func (g *grpcMailerClient) sendActivationEmail(ctx context.Context, email, name, activationToken string) error {
+func (g *grpcMailerClient) sendActivationEmail(ctx context.Context, email, name, locale, activationToken string) error {
 	activationHyperlink := ActivationPageLink + activationToken
 	in := pb.EmailRequest{
-		From:    "the.team@flat-list.com",
-		To:      email,
-		Subject: "Please activate your account",
+		From:   "the.team@flat-list.com",
+		To:     email,
+		Locale: locale,
 		ActivationData: &pb.ActivationData{
 			Name:      name,
 			Hyperlink: activationHyperlink,
 		},
 	}
 	if _, err := g.c.SendActivationEmail(ctx, &in); err != nil {
+		return mailerError(err)
+	}
+
+	return nil
+}
+
+// sendEmailChangeEmail makes a remote procedure call to the mailer service,
+// which sends an email to the new address a user wants to change to
+func (g *grpcMailerClient) sendEmailChangeEmail(ctx context.Context, email, name, locale, token string) error {
+	in := pb.EmailChangeRequest{
+		From:   "the.team@flat-list.com",
+		To:     email,
+		Locale: locale,
+		EmailChangeData: &pb.EmailChangeData{
+			Name:      name,
+			Hyperlink: EmailChangePageLink + token,
+		},
+	}
+	if _, err := g.c.SendEmailChangeEmail(ctx, &in); err != nil {
+		return mailerError(err)
+	}
+
+	return nil
+}
+
+// sendDataExportEmail makes a remote procedure call to the mailer service,
+// which sends a user a link to download the data they exported
+func (g *grpcMailerClient) sendDataExportEmail(ctx context.Context, email, name, locale, downloadLink string) error {
+	in := pb.EmailRequest{
+		From:   "the.team@flat-list.com",
+		To:     email,
+		Locale: locale,
+		ActivationData: &pb.ActivationData{
+			Name:      name,
+			Hyperlink: downloadLink,
+		},
+	}
+	if _, err := g.c.SendDataExportEmail(ctx, &in); err != nil {
+		return mailerError(err)
+	}
+
+	return nil
+}
+
+// sendLockoutEmail makes a remote procedure call to the mailer service, which
+// tells a user that their account was locked after too many failed logins
+func (g *grpcMailerClient) sendLockoutEmail(ctx context.Context, email, name, locale string) error {
+	in := pb.EmailRequest{
+		From:   "the.team@flat-list.com",
+		To:     email,
+		Locale: locale,
+		ActivationData: &pb.ActivationData{
+			Name:      name,
+			Hyperlink: LoginPageLink,
+		},
+	}
+	if _, err := g.c.SendLockoutEmail(ctx, &in); err != nil {
+		return mailerError(err)
+	}
+
+	return nil
+}
+
+// sendDigestEmail makes a remote procedure call to the mailer service,
+// which sends a user their daily digest with the mailer's digest template
+func (g *grpcMailerClient) sendDigestEmail(ctx context.Context, email, name, locale string, digest Digest) error {
+	data, err := structpb.NewStruct(digest.templateData(name))
+	if err != nil {
 		return err
 	}
 
+	in := pb.TemplatedEmailRequest{
+		From:     "the.team@flat-list.com",
+		To:       email,
+		Template: digestTemplate,
+		Locale:   locale,
+		Data:     data,
+	}
+	if _, err := g.c.SendTemplatedEmail(ctx, &in); err != nil {
+		return mailerError(err)
+	}
+
 	return nil
 }
 
@@ -64,6 +211,9 @@ type httpMailerClient struct {
 	mailerEndpointURL url.URL
 }
 
+// NewHTTPMailerClient receives the base url of the mailer
+// service (ie. http://localhost:5002), which is joined
+// with the path of the endpoint for each kind of email.
 func NewHTTPMailerClient(mailerEndpoint string) (*httpMailerClient, error) {
 	mailerEndpointURL, err := url.Parse(mailerEndpoint)
 	if err != nil {
@@ -75,29 +225,92 @@ func NewHTTPMailerClient(mailerEndpoint string) (*httpMailerClient, error) {
 	}, nil
 }
 
-func (h *httpMailerClient) sendActivationEmail(ctx context.Context, email, name, activationToken string) error {
+func (h *httpMailerClient) sendActivationEmail(ctx context.Context, email, name, locale, activationToken string) error {
 	activationHyperlink := ActivationPageLink + activationToken
 
 	data := mailer.ActivationEmailData{
-		From:    "the.team@flat-list.com",
-		To:      email,
-		Subject: "Follow the instructions to activate your account",
+		From:   "the.team@flat-list.com",
+		To:     email,
+		Locale: locale,
 		ActivationData: mailer.ActivationData{
 			Name:      name,
 			Hyperlink: activationHyperlink,
 		},
 	}
 
+	return h.post(ctx, "/v1/mailer/activate", &data)
+}
+
+func (h *httpMailerClient) sendEmailChangeEmail(ctx context.Context, email, name, locale, token string) error {
+	data := mailer.EmailChangeEmailData{
+		From:   "the.team@flat-list.com",
+		To:     email,
+		Locale: locale,
+		EmailChangeData: mailer.EmailChangeData{
+			Name:      name,
+			Hyperlink: EmailChangePageLink + token,
+		},
+	}
+
+	return h.post(ctx, "/v1/mailer/emailchange", &data)
+}
+
+func (h *httpMailerClient) sendDataExportEmail(ctx context.Context, email, name, locale, downloadLink string) error {
+	data := mailer.ActivationEmailData{
+		From:   "the.team@flat-list.com",
+		To:     email,
+		Locale: locale,
+		ActivationData: mailer.ActivationData{
+			Name:      name,
+			Hyperlink: downloadLink,
+		},
+	}
+
+	return h.post(ctx, "/v1/mailer/dataexport", &data)
+}
+
+func (h *httpMailerClient) sendLockoutEmail(ctx context.Context, email, name, locale string) error {
+	data := mailer.ActivationEmailData{
+		From:   "the.team@flat-list.com",
+		To:     email,
+		Locale: locale,
+		ActivationData: mailer.ActivationData{
+			Name:      name,
+			Hyperlink: LoginPageLink,
+		},
+	}
+
+	return h.post(ctx, "/v1/mailer/lockout", &data)
+}
+
+func (h *httpMailerClient) sendDigestEmail(ctx context.Context, email, name, locale string, digest Digest) error {
+	data := mailer.TemplatedEmailData{
+		From:     "the.team@flat-list.com",
+		To:       email,
+		Template: digestTemplate,
+		Locale:   locale,
+		Data:     digest.templateData(name),
+	}
+
+	return h.post(ctx, "/v1/mailer/send", &data)
+}
+
+// post sends data as JSON to an endpoint of the mailer service,
+// and returns the error from the response if it is not successful.
+// Any 2xx status is a success, since the mailer answers 202 Accepted
+// when it queues an email instead of sending it right away.
+func (h *httpMailerClient) post(ctx context.Context, path string, data any) error {
 	reqBody := new(bytes.Buffer)
-	if err := json.NewEncoder(reqBody).Encode(&data); err != nil {
+	if err := json.NewEncoder(reqBody).Encode(data); err != nil {
 		return err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.mailerEndpointURL.String(), reqBody)
-	req.Header.Set("Content-Type", "application/json")
+	endpoint := h.mailerEndpointURL.JoinPath(path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.String(), reqBody)
 	if err != nil {
 		return err
 	}
+	req.Header.Set("Content-Type", "application/json")
 
 	c := http.Client{Timeout: 5 * time.Second}
 
@@ -105,14 +318,25 @@ func (h *httpMailerClient) sendActivationEmail(ctx context.Context, email, name,
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		rlErr := &emailRateLimitError{retryAfter: emailRateLimitWait}
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			rlErr.retryAfter = time.Duration(seconds) * time.Second
+		}
+		return rlErr
+	case http.StatusConflict:
+		return ErrRecipientSuppressed
+	}
 
-	if resp.StatusCode != http.StatusOK {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
 		// Custom utility to extract errors?
 		errs := struct {
 			ErrStr string `json:"error"`
 		}{}
 
-		defer resp.Body.Close()
 		if err := json.NewDecoder(resp.Body).Decode(&errs); err != nil {
 			return err
 		}
@@ -2,18 +2,28 @@ package user
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
 
+	"github.com/ricxi/flat-list/mailer"
 	res "github.com/ricxi/flat-list/shared/response"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
 func Test_httpClient_sendActivationEmail(t *testing.T) {
 	t.Run("Success", func(t *testing.T) {
+		var data mailer.ActivationEmailData
 		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewDecoder(r.Body).Decode(&data)
 			res.SendJSON(w, map[string]any{"success": true}, http.StatusOK, nil)
 		}))
 		defer ts.Close()
@@ -31,6 +41,36 @@ func Test_httpClient_sendActivationEmail(t *testing.T) {
 			context.Background(),
 			"michaelscott@dundermifflin.com",
 			"michael",
+			"fr-CA",
+			"activation_token_placeholder",
+		)
+
+		assert.NoError(t, err)
+		// the subject is left to the mailer, so that it is in the user's locale
+		assert.Equal(t, "fr-CA", data.Locale)
+		assert.Empty(t, data.Subject)
+	})
+
+	t.Run("SuccessQueued", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			res.SendJSON(w, map[string]any{"success": true}, http.StatusAccepted, nil)
+		}))
+		defer ts.Close()
+
+		mailerEndpointURL, err := url.Parse(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c := httpMailerClient{
+			mailerEndpointURL: *mailerEndpointURL,
+		}
+
+		err = c.sendActivationEmail(
+			context.Background(),
+			"michaelscott@dundermifflin.com",
+			"michael",
+			"fr-CA",
 			"activation_token_placeholder",
 		)
 
@@ -56,6 +96,7 @@ func Test_httpClient_sendActivationEmail(t *testing.T) {
 			context.Background(),
 			"michaelscott@dundermifflin.com",
 			"michael",
+			"fr-CA",
 			"activation_token_placeholder",
 		)
 
@@ -83,6 +124,7 @@ func Test_httpClient_sendActivationEmail(t *testing.T) {
 			context.Background(),
 			"michaelscott@dundermifflin.com",
 			"michael",
+			"fr-CA",
 			"activation_token_placeholder",
 		)
 
@@ -115,3 +157,62 @@ func Test_httpClient_sendActivationEmail(t *testing.T) {
 	// 	assert.EqualError(t, err, "missing field is required: to")
 	// })
 }
+
+func Test_httpClient_sendActivationEmailRateLimited(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "45")
+		res.SendErrorJSON(w, "rate limited", http.StatusTooManyRequests)
+	}))
+	defer ts.Close()
+
+	mailerEndpointURL, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := httpMailerClient{
+		mailerEndpointURL: *mailerEndpointURL,
+	}
+
+	err = c.sendActivationEmail(context.Background(), "michaelscott@dundermifflin.com", "michael", "", "activation_token_placeholder")
+
+	var rlErr *emailRateLimitError
+	if assert.ErrorAs(t, err, &rlErr) {
+		assert.Equal(t, 45, rlErr.retrySeconds())
+	}
+	assert.ErrorIs(t, err, ErrEmailRateLimited)
+}
+
+func Test_mailerError(t *testing.T) {
+	rateLimited, err := status.New(codes.ResourceExhausted, "rate limited").
+		WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(90 * time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name            string
+		err             error
+		expErr          error
+		expRetrySeconds int
+	}{
+		{name: "RateLimited", err: rateLimited.Err(), expErr: ErrEmailRateLimited, expRetrySeconds: 90},
+		{name: "RateLimitedWithoutRetryInfo", err: status.Error(codes.ResourceExhausted, "rate limited"), expErr: ErrEmailRateLimited, expRetrySeconds: 60},
+		{name: "RecipientSuppressed", err: status.Error(codes.FailedPrecondition, "recipient is suppressed"), expErr: ErrRecipientSuppressed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := mailerError(tt.err)
+			assert.ErrorIs(t, err, tt.expErr)
+
+			var rlErr *emailRateLimitError
+			if errors.As(err, &rlErr) {
+				assert.Equal(t, tt.expRetrySeconds, rlErr.retrySeconds())
+			}
+		})
+	}
+
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+	assert.Equal(t, unavailable, mailerError(unavailable))
+}
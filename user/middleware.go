@@ -0,0 +1,49 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/ricxi/flat-list/shared/authz"
+	res "github.com/ricxi/flat-list/shared/response"
+)
+
+// authenticate verifies the jwt in the 'Authorization' header and stores
+// the id and roles of the user it belongs to in the request's context.
+func (h httpHandler) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := getAuthToken(r)
+		if err != nil {
+			res.SendError(w, r, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		principal, err := h.service.authenticate(r.Context(), token)
+		if err != nil {
+			res.SendError(w, r, "unable to authorize user", http.StatusUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), UserIDCtxKey, principal.UserID)
+		ctx = authz.WithPrincipal(ctx, principal)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// getAuthToken obtains the 'Bearer' token
+// from the request's 'Authorization' header.
+func getAuthToken(r *http.Request) (string, error) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return "", errors.New("auth header is empty or missing")
+	}
+
+	slicedAuthHeader := strings.Split(authHeader, " ")
+	if len(slicedAuthHeader) != 2 || slicedAuthHeader[0] != "Bearer" {
+		return "", errors.New("invalid Bearer token")
+	}
+
+	return slicedAuthHeader[1], nil
+}
@@ -1,7 +1,12 @@
 package user
 
 import (
+	"bytes"
 	"context"
+	"io"
+	"time"
+
+	"github.com/ricxi/flat-list/shared/authz"
 )
 
 var _ Repository = &mockRepository{}
@@ -11,6 +16,41 @@ type mockRepository struct {
 	userID string
 	user   *UserInfo
 	err    error
+	// emailErr is returned by getUserByEmail instead of err when it is set,
+	// for methods that expect an email to not belong to any user
+	emailErr error
+	// deletionJobs is returned by getDueDeletionJobs, and every
+	// job passed to updateDeletionJob is added to updatedDeletionJobs
+	deletionJobs        []DeletionJob
+	updatedDeletionJobs []DeletionJob
+	// dataExport is returned by getDataExportByID, and every
+	// export passed to updateDataExport is added to updatedDataExports
+	dataExport         *DataExport
+	updatedDataExports []DataExport
+	// pendingExports are returned by claimDataExport one at a time, and
+	// every archive passed to saveExportArchive is added to exportArchives
+	pendingExports []DataExport
+	exportArchives map[string][]byte
+	// identityErr is returned by getUserByIdentity instead of err when it is set,
+	// and every identity passed to linkIdentity is added to linkedIdentities
+	identityErr      error
+	linkedIdentities []OIDCIdentity
+	// oidcLogin is stored by createOIDCLogin and removed by consumeOIDCLogin
+	oidcLogin *OIDCLogin
+	// users is returned by searchUsers, every update passed to updateUserByID
+	// is added to userUpdates, and every entry passed to createAuditLogEntry
+	// is added to auditLog
+	users       []UserInfo
+	userUpdates []UserUpdate
+	auditLog    []AuditLogEntry
+	// users is also returned by getDigestUsers, the id of every user whose digest
+	// is claimed is added to digestClaims, and released ones to digestReleases
+	digestClaims   []string
+	digestReleases []string
+	// claimErr is returned by claimDigest instead of err when it is set
+	claimErr error
+	// twoFactorStep is the last step passed to useTwoFactorStep
+	twoFactorStep int64
 }
 
 func (m *mockRepository) createUser(ctx context.Context, u UserRegistrationInfo) (string, error) {
@@ -18,6 +58,9 @@ func (m *mockRepository) createUser(ctx context.Context, u UserRegistrationInfo)
 }
 
 func (m *mockRepository) getUserByEmail(ctx context.Context, email string) (*UserInfo, error) {
+	if m.emailErr != nil {
+		return nil, m.emailErr
+	}
 	return m.user, m.err
 }
 
@@ -25,15 +68,155 @@ func (m *mockRepository) getUserByID(ctx context.Context, id string) (*UserInfo,
 	return m.user, m.err
 }
 
-func (m *mockRepository) updateUserByID(ctx context.Context, u UserInfo) error {
+func (m *mockRepository) updateUserByID(ctx context.Context, id string, u UserUpdate) error {
+	m.userUpdates = append(m.userUpdates, u)
+	return m.err
+}
+
+func (m *mockRepository) deleteUserByID(ctx context.Context, id string) error {
+	return m.err
+}
+
+func (m *mockRepository) createDeletionJob(ctx context.Context, job DeletionJob) (string, error) {
+	return "6448958b96118a48b722fd15", m.err
+}
+
+func (m *mockRepository) updateDeletionJob(ctx context.Context, job DeletionJob) error {
+	m.updatedDeletionJobs = append(m.updatedDeletionJobs, job)
+	return m.err
+}
+
+func (m *mockRepository) getDueDeletionJobs(ctx context.Context, now time.Time) ([]DeletionJob, error) {
+	return m.deletionJobs, m.err
+}
+
+func (m *mockRepository) createDataExport(ctx context.Context, export DataExport) (string, error) {
+	return "6448958b96118a48b722fd16", m.err
+}
+
+func (m *mockRepository) updateDataExport(ctx context.Context, export DataExport) error {
+	m.updatedDataExports = append(m.updatedDataExports, export)
+	return m.err
+}
+
+func (m *mockRepository) getDataExportByID(ctx context.Context, id string) (*DataExport, error) {
+	return m.dataExport, m.err
+}
+
+func (m *mockRepository) deleteDataExportsByUserID(ctx context.Context, userID string) error {
+	return m.err
+}
+
+func (m *mockRepository) claimDataExport(ctx context.Context, now, leaseUntil time.Time) (*DataExport, error) {
+	if len(m.pendingExports) == 0 {
+		return nil, ErrExportNotFound
+	}
+	export := m.pendingExports[0]
+	m.pendingExports = m.pendingExports[1:]
+	return &export, nil
+}
+
+func (m *mockRepository) saveExportArchive(ctx context.Context, exportID string, archive []byte) error {
+	if m.exportArchives == nil {
+		m.exportArchives = make(map[string][]byte)
+	}
+	m.exportArchives[exportID] = archive
+	return nil
+}
+
+func (m *mockRepository) openExportArchive(ctx context.Context, exportID string) (*ExportArchive, error) {
+	archive, ok := m.exportArchives[exportID]
+	if !ok {
+		return nil, ErrExportNotFound
+	}
+	return &ExportArchive{ReadCloser: io.NopCloser(bytes.NewReader(archive)), Size: int64(len(archive))}, nil
+}
+
+func (m *mockRepository) useRecoveryCode(ctx context.Context, userID, codeHash string) error {
+	return m.err
+}
+
+func (m *mockRepository) useTwoFactorStep(ctx context.Context, userID string, step int64) error {
+	if step <= m.twoFactorStep {
+		return ErrInvalidTwoFactorCode
+	}
+	m.twoFactorStep = step
+	return m.err
+}
+
+func (m *mockRepository) getUserByIdentity(ctx context.Context, identity OIDCIdentity) (*UserInfo, error) {
+	if m.identityErr != nil {
+		return nil, m.identityErr
+	}
+	return m.user, m.err
+}
+
+func (m *mockRepository) linkIdentity(ctx context.Context, userID string, identity OIDCIdentity) error {
+	m.linkedIdentities = append(m.linkedIdentities, identity)
+	return m.err
+}
+
+func (m *mockRepository) createOIDCLogin(ctx context.Context, login OIDCLogin) error {
+	m.oidcLogin = &login
+	return m.err
+}
+
+func (m *mockRepository) consumeOIDCLogin(ctx context.Context, state string) (*OIDCLogin, error) {
+	if m.oidcLogin == nil || m.oidcLogin.State != state {
+		return nil, ErrInvalidOIDCState
+	}
+	login := m.oidcLogin
+	m.oidcLogin = nil
+	return login, nil
+}
+
+func (m *mockRepository) searchUsers(ctx context.Context, s UserSearch) ([]UserInfo, int64, error) {
+	return m.users, int64(len(m.users)), m.err
+}
+
+func (m *mockRepository) createAuditLogEntry(ctx context.Context, entry AuditLogEntry) error {
+	m.auditLog = append(m.auditLog, entry)
+	return m.err
+}
+
+func (m *mockRepository) getDigestUsers(ctx context.Context, now time.Time) ([]UserInfo, error) {
+	return m.users, m.err
+}
+
+func (m *mockRepository) claimDigest(ctx context.Context, userID, date string) error {
+	if m.claimErr != nil {
+		return m.claimErr
+	}
+	m.digestClaims = append(m.digestClaims, userID)
+	return m.err
+}
+
+func (m *mockRepository) releaseDigest(ctx context.Context, userID, date, previousDate string) error {
+	m.digestReleases = append(m.digestReleases, userID)
 	return m.err
 }
 
 // Service mock
 type mockService struct {
-	userID   string
-	userInfo *UserInfo
-	err      error
+	userID      string
+	userInfo    *UserInfo
+	deletionJob *DeletionJob
+	dataExport  *DataExport
+	// recoveryCodes is returned by the two-factor methods that create them
+	recoveryCodes []string
+	// roles are the roles of the user that authenticate returns
+	roles         []string
+	searchResult  *UserSearchResult
+	impersonation *Impersonation
+	// scopes are the scopes of the personal access
+	// token that authenticatePersonalAccessToken returns
+	scopes []string
+	pat    *NewPersonalAccessToken
+	// clientID is the id of the app that authenticateOAuthAccessToken returns,
+	// and oauthToken is returned by exchangeOAuthToken
+	clientID   string
+	oauthToken *OAuthTokenResponse
+	err        error
 }
 
 func (m mockService) registerUser(ctx context.Context, user UserRegistrationInfo) (string, error) {
@@ -52,8 +235,188 @@ func (m mockService) restartActivation(ctx context.Context, u UserLoginInfo) err
 	return m.err
 }
 
-func (m mockService) authenticate(ctx context.Context, signedJWT string) (string, error) {
-	return m.userID, m.err
+func (m mockService) authenticate(ctx context.Context, signedJWT string) (*authz.Principal, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &authz.Principal{UserID: m.userID, Roles: m.roles}, nil
+}
+
+func (m mockService) getProfile(ctx context.Context, userID string) (*UserInfo, error) {
+	return m.userInfo, m.err
+}
+
+func (m mockService) updateProfile(ctx context.Context, userID string, p ProfileUpdate) (*UserInfo, error) {
+	return m.userInfo, m.err
+}
+
+func (m mockService) changePassword(ctx context.Context, userID string, p PasswordChangeInfo) error {
+	return m.err
+}
+
+func (m mockService) requestEmailChange(ctx context.Context, userID string, e EmailChangeInfo) error {
+	return m.err
+}
+
+func (m mockService) confirmEmailChange(ctx context.Context, token string) error {
+	return m.err
+}
+
+func (m mockService) deleteAccount(ctx context.Context, userID string, d AccountDeletionInfo) (*DeletionJob, error) {
+	return m.deletionJob, m.err
+}
+
+func (m mockService) retryDeletionJobs(ctx context.Context) error {
+	return m.err
+}
+
+func (m mockService) sendDigests(ctx context.Context, now time.Time) error {
+	return m.err
+}
+
+func (m mockService) exportData(ctx context.Context, userID string) (*DataExport, error) {
+	return m.dataExport, m.err
+}
+
+func (m mockService) getDataExport(ctx context.Context, userID, exportID string) (*DataExport, error) {
+	return m.dataExport, m.err
+}
+
+func (m mockService) runDataExports(ctx context.Context) error {
+	return m.err
+}
+
+func (m mockService) downloadDataExport(ctx context.Context, exportID, downloadToken string) (*ExportArchive, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &ExportArchive{ReadCloser: io.NopCloser(bytes.NewReader(m.dataExport.Archive)), Size: int64(len(m.dataExport.Archive))}, nil
+}
+
+func (m mockService) enrollTwoFactor(ctx context.Context, userID string) (*TwoFactorEnrollment, error) {
+	return &TwoFactorEnrollment{}, m.err
+}
+
+func (m mockService) confirmTwoFactor(ctx context.Context, userID string, c TwoFactorCode) ([]string, error) {
+	return m.recoveryCodes, m.err
+}
+
+func (m mockService) disableTwoFactor(ctx context.Context, userID string, p PasswordConfirmation) error {
+	return m.err
+}
+
+func (m mockService) regenerateRecoveryCodes(ctx context.Context, userID string, p PasswordConfirmation) ([]string, error) {
+	return m.recoveryCodes, m.err
+}
+
+func (m mockService) loginTwoFactor(ctx context.Context, l TwoFactorLoginInfo) (*UserInfo, error) {
+	return m.userInfo, m.err
+}
+
+func (m mockService) startOIDCLogin(ctx context.Context, provider string, reauth bool) (string, string, error) {
+	return "http://localhost:8080/authorize", "state", m.err
+}
+
+func (m mockService) finishOIDCLogin(ctx context.Context, c OIDCCallbackInfo) (*UserInfo, error) {
+	return m.userInfo, m.err
+}
+
+func (m mockService) searchUsers(ctx context.Context, s UserSearch) (*UserSearchResult, error) {
+	return m.searchResult, m.err
+}
+
+func (m mockService) deactivateUser(ctx context.Context, a AdminAction) error {
+	return m.err
+}
+
+func (m mockService) reactivateUser(ctx context.Context, a AdminAction) error {
+	return m.err
+}
+
+func (m mockService) resendActivation(ctx context.Context, a AdminAction) error {
+	return m.err
+}
+
+func (m mockService) impersonateUser(ctx context.Context, a AdminAction) (*Impersonation, error) {
+	return m.impersonation, m.err
+}
+
+func (m mockService) createPersonalAccessToken(ctx context.Context, userID string, info PersonalAccessTokenInfo) (*NewPersonalAccessToken, error) {
+	return m.pat, m.err
+}
+
+func (m mockService) listPersonalAccessTokens(ctx context.Context, userID string) ([]PersonalAccessToken, error) {
+	if m.pat == nil {
+		return []PersonalAccessToken{}, m.err
+	}
+	return []PersonalAccessToken{m.pat.PersonalAccessToken}, m.err
+}
+
+func (m mockService) revokePersonalAccessToken(ctx context.Context, userID, tokenID string) error {
+	return m.err
+}
+
+func (m mockService) authenticatePersonalAccessToken(ctx context.Context, token string) (*authz.Principal, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &authz.Principal{UserID: m.userID, TokenID: "a1b2c3d4e5f60718", Scopes: m.scopes}, nil
+}
+
+func (m mockService) registerOAuthClient(ctx context.Context, ownerID string, info OAuthClientInfo) (*NewOAuthClient, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &NewOAuthClient{OAuthClient: OAuthClient{ID: m.clientID, OwnerID: ownerID, Name: info.Name}}, nil
+}
+
+func (m mockService) listOAuthClients(ctx context.Context, ownerID string) ([]OAuthClient, error) {
+	return []OAuthClient{}, m.err
+}
+
+func (m mockService) deleteOAuthClient(ctx context.Context, ownerID, clientID string) error {
+	return m.err
+}
+
+func (m mockService) getAuthorizationPrompt(ctx context.Context, userID string, a AuthorizationRequest) (*AuthorizationPrompt, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &AuthorizationPrompt{ClientID: a.ClientID, Scopes: m.scopes}, nil
+}
+
+func (m mockService) authorize(ctx context.Context, userID string, a AuthorizationRequest, approved bool) (string, error) {
+	return a.RedirectURI, m.err
+}
+
+func (m mockService) exchangeOAuthToken(ctx context.Context, t OAuthTokenRequest) (*OAuthTokenResponse, error) {
+	return m.oauthToken, m.err
+}
+
+func (m mockService) introspectOAuthToken(ctx context.Context, c OAuthClientCredentials, token string) (*OAuthTokenIntrospection, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &OAuthTokenIntrospection{Active: false}, nil
+}
+
+func (m mockService) revokeOAuthToken(ctx context.Context, c OAuthClientCredentials, token string) error {
+	return m.err
+}
+
+func (m mockService) listOAuthConsents(ctx context.Context, userID string) ([]OAuthConsent, error) {
+	return []OAuthConsent{}, m.err
+}
+
+func (m mockService) revokeOAuthConsent(ctx context.Context, userID, clientID string) error {
+	return m.err
+}
+
+func (m mockService) authenticateOAuthAccessToken(ctx context.Context, token string) (*authz.Principal, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &authz.Principal{UserID: m.userID, TokenID: "a1b2c3d4e5f60718", Scopes: m.scopes, ClientID: m.clientID}, nil
 }
 
 // PasswordManager mock
@@ -73,9 +436,38 @@ func (m *mockPasswordManager) CompareHashWith(hashedPassword, password string) e
 // Client mock form mailer client
 type mockMailerClient struct {
 	err error
+	// downloadLink is set to the link passed to sendDataExportEmail
+	downloadLink string
+	// locale is set to the locale that the last email was sent in
+	locale string
+	// digests has every digest passed to sendDigestEmail
+	digests []Digest
+}
+
+func (m *mockMailerClient) sendActivationEmail(ctx context.Context, email, name, locale, activationToken string) error {
+	m.locale = locale
+	return m.err
+}
+
+func (m *mockMailerClient) sendEmailChangeEmail(ctx context.Context, email, name, locale, token string) error {
+	m.locale = locale
+	return m.err
+}
+
+func (m *mockMailerClient) sendDataExportEmail(ctx context.Context, email, name, locale, downloadLink string) error {
+	m.downloadLink = downloadLink
+	m.locale = locale
+	return m.err
+}
+
+func (m *mockMailerClient) sendLockoutEmail(ctx context.Context, email, name, locale string) error {
+	m.locale = locale
+	return m.err
 }
 
-func (m *mockMailerClient) sendActivationEmail(ctx context.Context, email, name, activationToken string) error {
+func (m *mockMailerClient) sendDigestEmail(ctx context.Context, email, name, locale string, digest Digest) error {
+	m.digests = append(m.digests, digest)
+	m.locale = locale
 	return m.err
 }
 
@@ -98,10 +490,34 @@ func (m *mockValidator) NonEmptyString(name, field string) error {
 	return m.err
 }
 
+func (m *mockValidator) ProfileUpdate(p ProfileUpdate) error {
+	return m.err
+}
+
+func (m *mockValidator) NewPassword(field, password string, personal ...string) error {
+	return m.err
+}
+
+func (m *mockValidator) Email(field, email string) (string, error) {
+	return email, m.err
+}
+
+func (m *mockValidator) PersonalAccessToken(info PersonalAccessTokenInfo) error {
+	return m.err
+}
+
+func (m *mockValidator) OAuthClient(info OAuthClientInfo) error {
+	return m.err
+}
+
 type mockTokenClient struct {
 	mockActivationToken string
 	mockUserID          string
-	err                 error
+	// pat is returned by the personal access token methods,
+	// and expiresAt is the expiry that the last one was created with
+	pat       *PersonalAccessToken
+	expiresAt time.Time
+	err       error
 }
 
 func (m *mockTokenClient) CreateActivationToken(ctx context.Context, userID string) (string, error) {
@@ -111,3 +527,222 @@ func (m *mockTokenClient) CreateActivationToken(ctx context.Context, userID stri
 func (m *mockTokenClient) ValidateActivationToken(ctx context.Context, activationToken string) (string, error) {
 	return m.mockUserID, m.err
 }
+
+func (m *mockTokenClient) CreateEmailChangeToken(ctx context.Context, userID string) (string, error) {
+	return m.mockActivationToken, m.err
+}
+
+func (m *mockTokenClient) ValidateEmailChangeToken(ctx context.Context, token string) (string, error) {
+	return m.mockUserID, m.err
+}
+
+func (m *mockTokenClient) DeleteUserTokens(ctx context.Context, userID string) error {
+	return m.err
+}
+
+func (m *mockTokenClient) CreatePersonalAccessToken(ctx context.Context, userID, name string, scopes []string, expiresAt time.Time) (*NewPersonalAccessToken, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	m.expiresAt = expiresAt
+	pat := PersonalAccessToken{ID: "a1b2c3d4e5f60718", UserID: userID, Name: name, Scopes: scopes, ExpiresAt: expiresAt}
+	return &NewPersonalAccessToken{Token: "flpat_token", PersonalAccessToken: pat}, nil
+}
+
+func (m *mockTokenClient) ListPersonalAccessTokens(ctx context.Context, userID string) ([]PersonalAccessToken, error) {
+	if m.pat == nil {
+		return nil, m.err
+	}
+	return []PersonalAccessToken{*m.pat}, m.err
+}
+
+func (m *mockTokenClient) RevokePersonalAccessToken(ctx context.Context, userID, tokenID string) error {
+	return m.err
+}
+
+func (m *mockTokenClient) ValidatePersonalAccessToken(ctx context.Context, token string) (*PersonalAccessToken, error) {
+	return m.pat, m.err
+}
+
+// TaskClient mock
+type mockTaskClient struct {
+	tasks []TaskData
+	err   error
+}
+
+func (m *mockTaskClient) getUserTasks(ctx context.Context, userID string) ([]TaskData, error) {
+	return m.tasks, m.err
+}
+
+func (m *mockTaskClient) countUserTasks(ctx context.Context, userID string) (int64, error) {
+	return int64(len(m.tasks)), m.err
+}
+
+func (m *mockTaskClient) deleteUserTasks(ctx context.Context, userID string) error {
+	return m.err
+}
+
+func (m *mockTaskClient) getDueTasks(ctx context.Context, userID, date string) ([]TaskData, error) {
+	return m.tasks, m.err
+}
+
+// OAuthStore mock, which keeps everything in maps
+// so that the authorization server can be tested end to end
+type mockOAuthStore struct {
+	clients  map[string]OAuthClient
+	consents map[string]OAuthConsent
+	codes    map[string]AuthorizationCode
+	tokens   map[string]OAuthAccessToken
+}
+
+func newMockOAuthStore() *mockOAuthStore {
+	return &mockOAuthStore{
+		clients:  make(map[string]OAuthClient),
+		consents: make(map[string]OAuthConsent),
+		codes:    make(map[string]AuthorizationCode),
+		tokens:   make(map[string]OAuthAccessToken),
+	}
+}
+
+func (m *mockOAuthStore) createClient(ctx context.Context, client OAuthClient) error {
+	m.clients[client.ID] = client
+	return nil
+}
+
+func (m *mockOAuthStore) getClient(ctx context.Context, clientID string) (*OAuthClient, error) {
+	client, ok := m.clients[clientID]
+	if !ok {
+		return nil, ErrOAuthClientNotFound
+	}
+	return &client, nil
+}
+
+func (m *mockOAuthStore) getClientsByOwner(ctx context.Context, ownerID string) ([]OAuthClient, error) {
+	var clients []OAuthClient
+	for _, client := range m.clients {
+		if client.OwnerID == ownerID {
+			clients = append(clients, client)
+		}
+	}
+	return clients, nil
+}
+
+func (m *mockOAuthStore) deleteClient(ctx context.Context, clientID string) error {
+	if _, ok := m.clients[clientID]; !ok {
+		return ErrOAuthClientNotFound
+	}
+	for hash, code := range m.codes {
+		if code.ClientID == clientID {
+			delete(m.codes, hash)
+		}
+	}
+	for hash, token := range m.tokens {
+		if token.ClientID == clientID {
+			delete(m.tokens, hash)
+		}
+	}
+	for key, consent := range m.consents {
+		if consent.ClientID == clientID {
+			delete(m.consents, key)
+		}
+	}
+	delete(m.clients, clientID)
+	return nil
+}
+
+func (m *mockOAuthStore) getConsent(ctx context.Context, userID, clientID string) (*OAuthConsent, error) {
+	consent, ok := m.consents[userID+":"+clientID]
+	if !ok {
+		return nil, nil
+	}
+	return &consent, nil
+}
+
+func (m *mockOAuthStore) getConsentsByUser(ctx context.Context, userID string) ([]OAuthConsent, error) {
+	var consents []OAuthConsent
+	for _, consent := range m.consents {
+		if consent.UserID == userID {
+			consents = append(consents, consent)
+		}
+	}
+	return consents, nil
+}
+
+func (m *mockOAuthStore) saveConsent(ctx context.Context, consent OAuthConsent) error {
+	m.consents[consent.UserID+":"+consent.ClientID] = consent
+	return nil
+}
+
+func (m *mockOAuthStore) deleteConsent(ctx context.Context, userID, clientID string) error {
+	for hash, code := range m.codes {
+		if code.UserID == userID && code.ClientID == clientID {
+			delete(m.codes, hash)
+		}
+	}
+	for hash, token := range m.tokens {
+		if token.UserID == userID && token.ClientID == clientID {
+			delete(m.tokens, hash)
+		}
+	}
+	if _, ok := m.consents[userID+":"+clientID]; !ok {
+		return ErrOAuthConsentNotFound
+	}
+	delete(m.consents, userID+":"+clientID)
+	return nil
+}
+
+func (m *mockOAuthStore) createAuthorizationCode(ctx context.Context, code AuthorizationCode) error {
+	m.codes[code.CodeHash] = code
+	return nil
+}
+
+func (m *mockOAuthStore) consumeAuthorizationCode(ctx context.Context, codeHash string) (*AuthorizationCode, error) {
+	code, ok := m.codes[codeHash]
+	if !ok {
+		return nil, ErrInvalidAuthorizationCode
+	}
+	delete(m.codes, codeHash)
+	return &code, nil
+}
+
+func (m *mockOAuthStore) createAccessToken(ctx context.Context, token OAuthAccessToken) error {
+	m.tokens[token.TokenHash] = token
+	return nil
+}
+
+func (m *mockOAuthStore) getAccessToken(ctx context.Context, tokenHash string) (*OAuthAccessToken, error) {
+	token, ok := m.tokens[tokenHash]
+	if !ok {
+		return nil, ErrInvalidOAuthAccessToken
+	}
+	return &token, nil
+}
+
+func (m *mockOAuthStore) deleteAccessToken(ctx context.Context, tokenHash string) error {
+	delete(m.tokens, tokenHash)
+	return nil
+}
+
+func (m *mockOAuthStore) deleteUserData(ctx context.Context, userID string) error {
+	for id, client := range m.clients {
+		if client.OwnerID == userID {
+			m.deleteClient(ctx, id)
+		}
+	}
+	for hash, code := range m.codes {
+		if code.UserID == userID {
+			delete(m.codes, hash)
+		}
+	}
+	for hash, token := range m.tokens {
+		if token.UserID == userID {
+			delete(m.tokens, hash)
+		}
+	}
+	for key, consent := range m.consents {
+		if consent.UserID == userID {
+			delete(m.consents, key)
+		}
+	}
+	return nil
+}
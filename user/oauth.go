@@ -0,0 +1,796 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ricxi/flat-list/shared/authz"
+	"github.com/ricxi/flat-list/shared/validation"
+)
+
+const (
+	// authorizationCodeExpiry is how long an app has to exchange an authorization code
+	authorizationCodeExpiry = 10 * time.Minute
+	// oauthAccessTokenExpiry is how long an access token that is issued to an app lasts
+	oauthAccessTokenExpiry = time.Hour
+	// maxOAuthClientNameLength is the longest name that an app can have
+	maxOAuthClientNameLength = 100
+)
+
+// the grant types that the token endpoint supports
+const (
+	grantTypeAuthorizationCode = "authorization_code"
+	grantTypeClientCredentials = "client_credentials"
+)
+
+// OAuthClient is a third-party app that users can let access their tasks.
+// Confidential clients have a secret and can use the client credentials grant;
+// public clients, such as mobile apps, can't keep a secret and must use PKCE.
+type OAuthClient struct {
+	ID           string    `json:"clientId"`
+	OwnerID      string    `json:"-"`
+	SecretHash   string    `json:"-"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirectUris"`
+	Scopes       []string  `json:"scopes"`
+	Confidential bool      `json:"confidential"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// NewOAuthClient is returned when an app is registered,
+// which is the only time that its secret is returned
+type NewOAuthClient struct {
+	ClientSecret string `json:"clientSecret,omitempty"`
+	OAuthClient
+}
+
+// OAuthClientInfo stores request data for registering an app
+type OAuthClientInfo struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirectUris"`
+	// Scopes are the most that the app can ask a user for
+	Scopes       []string `json:"scopes"`
+	Confidential bool     `json:"confidential"`
+}
+
+// OAuthConsent records the scopes that a user has let an app use
+type OAuthConsent struct {
+	UserID     string    `json:"-"`
+	ClientID   string    `json:"clientId"`
+	ClientName string    `json:"clientName"`
+	Scopes     []string  `json:"scopes"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// AuthorizationRequest stores the query parameters that an app
+// sends a user to the authorization endpoint with (RFC 6749 section 4.1.1)
+type AuthorizationRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+// AuthorizationPrompt is shown to a user so that they can decide whether to let an app access their account
+type AuthorizationPrompt struct {
+	ClientID   string   `json:"clientId"`
+	ClientName string   `json:"clientName"`
+	Scopes     []string `json:"scopes"`
+	// Consented is true if the user has already let the app use every scope
+	Consented bool `json:"consented"`
+}
+
+// AuthorizationCode is given to an app when a user lets it access their
+// account, and is exchanged for an access token at the token endpoint
+type AuthorizationCode struct {
+	CodeHash      string
+	ClientID      string
+	UserID        string
+	RedirectURI   string
+	Scopes        []string
+	CodeChallenge string
+	ExpiresAt     time.Time
+}
+
+// OAuthAccessToken is an access token that has been issued to an app.
+// Only a hash of the token is stored, like personal access tokens.
+type OAuthAccessToken struct {
+	ID        string
+	TokenHash string
+	ClientID  string
+	UserID    string
+	Scopes    []string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// OAuthClientCredentials are how an app authenticates itself to the token,
+// introspection and revocation endpoints; public clients only send their id
+type OAuthClientCredentials struct {
+	ClientID     string
+	ClientSecret string
+}
+
+// OAuthTokenRequest stores the form parameters that are sent to the token endpoint
+type OAuthTokenRequest struct {
+	OAuthClientCredentials
+	GrantType    string
+	Code         string
+	RedirectURI  string
+	CodeVerifier string
+	Scope        string
+}
+
+// OAuthTokenResponse is the successful response of the token endpoint (RFC 6749 section 5.1)
+type OAuthTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	Scope       string `json:"scope"`
+}
+
+// OAuthTokenIntrospection is the response of the introspection endpoint (RFC 7662 section 2.2).
+// Only Active is set for tokens that are not active.
+type OAuthTokenIntrospection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+}
+
+// OAuthError is an error that is sent to apps in the format of RFC 6749 section 5.2,
+// either in the body of a response or in the query of a redirect to the app
+type OAuthError struct {
+	Code        string
+	Description string
+}
+
+func (e *OAuthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+func newOAuthError(code, description string) *OAuthError {
+	return &OAuthError{Code: code, Description: description}
+}
+
+// OAuthClient returns a validation.Error with every
+// field of a new app that is missing or not valid
+func (v *validator) OAuthClient(info OAuthClientInfo) error {
+	var vErr validation.Error
+
+	if info.Name == "" {
+		vErr.Required("name")
+	} else if len(info.Name) > maxOAuthClientNameLength {
+		vErr.Add("name", "too_long", fmt.Sprintf("name must be at most %d characters long", maxOAuthClientNameLength))
+	}
+
+	if len(info.RedirectURIs) == 0 {
+		vErr.Required("redirectUris")
+	}
+	for _, redirectURI := range info.RedirectURIs {
+		if !isRedirectURI(redirectURI) {
+			vErr.Add("redirectUris", "invalid_uri", fmt.Sprintf("%q must be an https url, or an http url on localhost, without a fragment", redirectURI))
+		}
+	}
+
+	if len(info.Scopes) == 0 {
+		vErr.Required("scopes")
+	}
+	for _, scope := range info.Scopes {
+		if !isScope(scope) {
+			vErr.Add("scopes", "unknown_scope", fmt.Sprintf("%q is not a scope", scope))
+		}
+	}
+
+	return vErr.Err()
+}
+
+// isRedirectURI checks that a redirect uri is absolute and can't be read by
+// anyone between the user and the app (RFC 6749 section 3.1.2), which allows
+// http for apps that run on the user's computer (RFC 8252 section 7.3)
+func isRedirectURI(redirectURI string) bool {
+	u, err := url.Parse(redirectURI)
+	if err != nil || u.Host == "" || u.Fragment != "" {
+		return false
+	}
+
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	default:
+		return false
+	}
+}
+
+// registerOAuthClient registers an app that is owned by the user. The secret
+// of a confidential client is returned once, and only its hash is stored.
+func (s *service) registerOAuthClient(ctx context.Context, ownerID string, info OAuthClientInfo) (*NewOAuthClient, error) {
+	if s.oauth == nil {
+		return nil, ErrOAuthNotEnabled
+	}
+
+	if err := s.validate.OAuthClient(info); err != nil {
+		return nil, err
+	}
+
+	clientID, err := randomHexString(16)
+	if err != nil {
+		return nil, err
+	}
+
+	client := NewOAuthClient{
+		OAuthClient: OAuthClient{
+			ID:           clientID,
+			OwnerID:      ownerID,
+			Name:         info.Name,
+			RedirectURIs: info.RedirectURIs,
+			Scopes:       info.Scopes,
+			Confidential: info.Confidential,
+			CreatedAt:    time.Now().In(time.UTC),
+		},
+	}
+
+	if info.Confidential {
+		client.ClientSecret, err = randomURLString()
+		if err != nil {
+			return nil, err
+		}
+		client.SecretHash = hashSecret(client.ClientSecret)
+	}
+
+	if err := s.oauth.createClient(ctx, client.OAuthClient); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return &client, nil
+}
+
+// listOAuthClients returns the apps that a user has registered
+func (s *service) listOAuthClients(ctx context.Context, ownerID string) ([]OAuthClient, error) {
+	if s.oauth == nil {
+		return nil, ErrOAuthNotEnabled
+	}
+
+	clients, err := s.oauth.getClientsByOwner(ctx, ownerID)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	if clients == nil {
+		clients = []OAuthClient{}
+	}
+
+	return clients, nil
+}
+
+// deleteOAuthClient deletes an app that a user has registered, along
+// with every consent that was given to it and every token it was issued
+func (s *service) deleteOAuthClient(ctx context.Context, ownerID, clientID string) error {
+	if s.oauth == nil {
+		return ErrOAuthNotEnabled
+	}
+
+	client, err := s.oauth.getClient(ctx, clientID)
+	if err != nil {
+		return err
+	}
+
+	// other users' apps are hidden, so that their ids can't be checked
+	if client.OwnerID != ownerID {
+		return ErrOAuthClientNotFound
+	}
+
+	if err := s.oauth.deleteClient(ctx, clientID); err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+// getAuthorizationPrompt checks the request that an app sent a user to the
+// authorization endpoint with, and returns what to ask the user for
+func (s *service) getAuthorizationPrompt(ctx context.Context, userID string, a AuthorizationRequest) (*AuthorizationPrompt, error) {
+	client, scopes, err := s.checkAuthorizationRequest(ctx, a)
+	if err != nil {
+		return nil, err
+	}
+
+	consent, err := s.oauth.getConsent(ctx, userID, client.ID)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return &AuthorizationPrompt{
+		ClientID:   client.ID,
+		ClientName: client.Name,
+		Scopes:     scopes,
+		Consented:  consent != nil && containsAll(consent.Scopes, scopes),
+	}, nil
+}
+
+// authorize is called when a user approves or denies an app's authorization request. It returns
+// the url to send the user back to the app with, which has an authorization code if they approved,
+// and the error if they didn't or the request is not valid (RFC 6749 section 4.1.2). An error is
+// returned instead if the app or its redirect uri is not valid, since the user can't be sent back.
+func (s *service) authorize(ctx context.Context, userID string, a AuthorizationRequest, approved bool) (string, error) {
+	client, scopes, err := s.checkAuthorizationRequest(ctx, a)
+	if err != nil {
+		var oErr *OAuthError
+		if errors.As(err, &oErr) {
+			return authorizationRedirect(a, url.Values{"error": {oErr.Code}, "error_description": {oErr.Description}}), nil
+		}
+		return "", err
+	}
+
+	if !approved {
+		return authorizationRedirect(a, url.Values{"error": {"access_denied"}, "error_description": {"the user denied the request"}}), nil
+	}
+
+	if err := s.giveConsent(ctx, userID, client, scopes); err != nil {
+		return "", err
+	}
+
+	code, err := randomURLString()
+	if err != nil {
+		return "", err
+	}
+
+	authorizationCode := AuthorizationCode{
+		CodeHash:      hashSecret(code),
+		ClientID:      client.ID,
+		UserID:        userID,
+		RedirectURI:   a.RedirectURI,
+		Scopes:        scopes,
+		CodeChallenge: a.CodeChallenge,
+		ExpiresAt:     time.Now().Add(authorizationCodeExpiry),
+	}
+	if err := s.oauth.createAuthorizationCode(ctx, authorizationCode); err != nil {
+		log.Println(err)
+		return "", err
+	}
+
+	return authorizationRedirect(a, url.Values{"code": {code}}), nil
+}
+
+// checkAuthorizationRequest returns the app that sent a user to the authorization endpoint and the
+// scopes it asked for. An OAuthError is returned if the request is not valid but the app can be told.
+func (s *service) checkAuthorizationRequest(ctx context.Context, a AuthorizationRequest) (*OAuthClient, []string, error) {
+	if s.oauth == nil {
+		return nil, nil, ErrOAuthNotEnabled
+	}
+
+	client, err := s.oauth.getClient(ctx, a.ClientID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// redirect uris must match exactly, so that codes can't be sent anywhere else
+	if !containsAll(client.RedirectURIs, []string{a.RedirectURI}) {
+		return nil, nil, ErrRedirectURIMismatch
+	}
+
+	if a.ResponseType != "code" {
+		return nil, nil, newOAuthError("unsupported_response_type", "response_type must be code")
+	}
+
+	// every app must use PKCE, since the code could be stolen on its way back to a public client
+	if a.CodeChallenge == "" || a.CodeChallengeMethod != "S256" {
+		return nil, nil, newOAuthError("invalid_request", "a code_challenge with the S256 code_challenge_method is required")
+	}
+
+	scopes, err := requestedScopes(a.Scope, client.Scopes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return client, scopes, nil
+}
+
+// giveConsent records that a user has let an app use scopes,
+// adding them to any scopes that the user has already given it
+func (s *service) giveConsent(ctx context.Context, userID string, client *OAuthClient, scopes []string) error {
+	consent, err := s.oauth.getConsent(ctx, userID, client.ID)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	now := time.Now().In(time.UTC)
+	if consent == nil {
+		consent = &OAuthConsent{UserID: userID, ClientID: client.ID, CreatedAt: now}
+	}
+	for _, scope := range scopes {
+		if !containsAll(consent.Scopes, []string{scope}) {
+			consent.Scopes = append(consent.Scopes, scope)
+		}
+	}
+	consent.ClientName = client.Name
+	consent.UpdatedAt = now
+
+	if err := s.oauth.saveConsent(ctx, *consent); err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+// authorizationRedirect adds parameters and the state to an app's redirect uri
+func authorizationRedirect(a AuthorizationRequest, params url.Values) string {
+	// the redirect uri has already been checked, so it can be parsed
+	u, _ := url.Parse(a.RedirectURI)
+
+	query := u.Query()
+	for k, v := range params {
+		query[k] = v
+	}
+	if a.State != "" {
+		query.Set("state", a.State)
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+// exchangeOAuthToken issues an access token to an app for an authorization code,
+// or for the app's owner if it uses its own credentials (RFC 6749 sections 4.1.3 and 4.4)
+func (s *service) exchangeOAuthToken(ctx context.Context, t OAuthTokenRequest) (*OAuthTokenResponse, error) {
+	client, err := s.authenticateOAuthClient(ctx, t.OAuthClientCredentials)
+	if err != nil {
+		return nil, err
+	}
+
+	var userID string
+	var scopes []string
+
+	switch t.GrantType {
+	case grantTypeAuthorizationCode:
+		code, err := s.consumeAuthorizationCode(ctx, client, t)
+		if err != nil {
+			return nil, err
+		}
+		userID, scopes = code.UserID, code.Scopes
+
+	case grantTypeClientCredentials:
+		if !client.Confidential {
+			return nil, newOAuthError("unauthorized_client", "public clients can't use the client_credentials grant")
+		}
+
+		scopes, err = requestedScopes(t.Scope, client.Scopes)
+		if err != nil {
+			return nil, err
+		}
+		userID = client.OwnerID
+
+	case "":
+		return nil, newOAuthError("invalid_request", "grant_type is required")
+
+	default:
+		return nil, newOAuthError("unsupported_grant_type", "grant_type must be authorization_code or client_credentials")
+	}
+
+	// tokens are not issued for users who can't log in anymore
+	uInfo, err := s.repository.getUserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, newOAuthError("invalid_grant", "the user no longer exists")
+		}
+		return nil, err
+	}
+	if !uInfo.Activated || uInfo.Deactivated {
+		return nil, newOAuthError("invalid_grant", "the user's account is not active")
+	}
+
+	return s.issueOAuthAccessToken(ctx, client.ID, userID, scopes)
+}
+
+// consumeAuthorizationCode checks an authorization code against
+// the request that it was issued for, and stops it from being used again
+func (s *service) consumeAuthorizationCode(ctx context.Context, client *OAuthClient, t OAuthTokenRequest) (*AuthorizationCode, error) {
+	if t.Code == "" || t.CodeVerifier == "" {
+		return nil, newOAuthError("invalid_request", "code and code_verifier are required")
+	}
+
+	code, err := s.oauth.consumeAuthorizationCode(ctx, hashSecret(t.Code))
+	if err != nil {
+		if errors.Is(err, ErrInvalidAuthorizationCode) {
+			return nil, newOAuthError("invalid_grant", err.Error())
+		}
+		log.Println(err)
+		return nil, err
+	}
+
+	challenge := pkceChallenge(t.CodeVerifier)
+	if code.ClientID != client.ID ||
+		code.RedirectURI != t.RedirectURI ||
+		time.Now().After(code.ExpiresAt) ||
+		subtle.ConstantTimeCompare([]byte(challenge), []byte(code.CodeChallenge)) != 1 {
+		return nil, newOAuthError("invalid_grant", ErrInvalidAuthorizationCode.Error())
+	}
+
+	return code, nil
+}
+
+func (s *service) issueOAuthAccessToken(ctx context.Context, clientID, userID string, scopes []string) (*OAuthTokenResponse, error) {
+	secret, err := randomURLString()
+	if err != nil {
+		return nil, err
+	}
+	token := authz.OAuthAccessTokenPrefix + secret
+
+	tokenID, err := randomHexString(8)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().In(time.UTC)
+	accessToken := OAuthAccessToken{
+		ID:        tokenID,
+		TokenHash: hashSecret(token),
+		ClientID:  clientID,
+		UserID:    userID,
+		Scopes:    scopes,
+		CreatedAt: now,
+		ExpiresAt: now.Add(oauthAccessTokenExpiry),
+	}
+	if err := s.oauth.createAccessToken(ctx, accessToken); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return &OAuthTokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(oauthAccessTokenExpiry.Seconds()),
+		Scope:       strings.Join(scopes, " "),
+	}, nil
+}
+
+// authenticateOAuthClient checks the credentials of an app. Confidential clients must
+// send their secret, and public clients are identified by their id (RFC 6749 section 2.3).
+func (s *service) authenticateOAuthClient(ctx context.Context, c OAuthClientCredentials) (*OAuthClient, error) {
+	if s.oauth == nil {
+		return nil, ErrOAuthNotEnabled
+	}
+
+	invalidClient := newOAuthError("invalid_client", "client authentication failed")
+	if c.ClientID == "" {
+		return nil, invalidClient
+	}
+
+	client, err := s.oauth.getClient(ctx, c.ClientID)
+	if err != nil {
+		if errors.Is(err, ErrOAuthClientNotFound) {
+			return nil, invalidClient
+		}
+		log.Println(err)
+		return nil, err
+	}
+
+	if client.Confidential {
+		secretHash := hashSecret(c.ClientSecret)
+		if c.ClientSecret == "" || subtle.ConstantTimeCompare([]byte(secretHash), []byte(client.SecretHash)) != 1 {
+			return nil, invalidClient
+		}
+	}
+
+	return client, nil
+}
+
+// introspectOAuthToken tells an app whether an access token is active (RFC 7662).
+// Apps can only see their own tokens, so any other token is not active.
+func (s *service) introspectOAuthToken(ctx context.Context, c OAuthClientCredentials, token string) (*OAuthTokenIntrospection, error) {
+	client, err := s.authenticateOAuthClient(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := s.getOAuthAccessToken(ctx, token)
+	if err != nil {
+		if errors.Is(err, ErrInvalidOAuthAccessToken) {
+			return &OAuthTokenIntrospection{Active: false}, nil
+		}
+		return nil, err
+	}
+
+	if accessToken.ClientID != client.ID {
+		return &OAuthTokenIntrospection{Active: false}, nil
+	}
+
+	return &OAuthTokenIntrospection{
+		Active:    true,
+		Scope:     strings.Join(accessToken.Scopes, " "),
+		ClientID:  accessToken.ClientID,
+		Subject:   accessToken.UserID,
+		TokenType: "Bearer",
+		ExpiresAt: accessToken.ExpiresAt.Unix(),
+		IssuedAt:  accessToken.CreatedAt.Unix(),
+	}, nil
+}
+
+// revokeOAuthToken deletes one of an app's access tokens (RFC 7009). Tokens that don't
+// exist, or that belong to another app, are ignored so that apps can't find them.
+func (s *service) revokeOAuthToken(ctx context.Context, c OAuthClientCredentials, token string) error {
+	client, err := s.authenticateOAuthClient(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	accessToken, err := s.getOAuthAccessToken(ctx, token)
+	if err != nil {
+		if errors.Is(err, ErrInvalidOAuthAccessToken) {
+			return nil
+		}
+		return err
+	}
+
+	if accessToken.ClientID != client.ID {
+		return nil
+	}
+
+	if err := s.oauth.deleteAccessToken(ctx, accessToken.TokenHash); err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+// listOAuthConsents returns the apps that a user has let access their account
+func (s *service) listOAuthConsents(ctx context.Context, userID string) ([]OAuthConsent, error) {
+	if s.oauth == nil {
+		return nil, ErrOAuthNotEnabled
+	}
+
+	consents, err := s.oauth.getConsentsByUser(ctx, userID)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	if consents == nil {
+		consents = []OAuthConsent{}
+	}
+
+	return consents, nil
+}
+
+// revokeOAuthConsent stops an app from accessing a user's account, by
+// deleting their consent and every authorization code and access token
+// that the app was issued for them
+func (s *service) revokeOAuthConsent(ctx context.Context, userID, clientID string) error {
+	if s.oauth == nil {
+		return ErrOAuthNotEnabled
+	}
+
+	if err := s.oauth.deleteConsent(ctx, userID, clientID); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// authenticateOAuthAccessToken checks an access token that was issued to an app in the
+// same way that authenticatePersonalAccessToken checks a personal access token
+func (s *service) authenticateOAuthAccessToken(ctx context.Context, token string) (*authz.Principal, error) {
+	accessToken, err := s.getOAuthAccessToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	uInfo, err := s.repository.getUserByID(ctx, accessToken.UserID)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, ErrInvalidOAuthAccessToken
+		}
+		return nil, err
+	}
+
+	if !uInfo.Activated {
+		return nil, ErrUserNotActivated
+	}
+
+	if uInfo.Deactivated {
+		return nil, ErrUserDeactivated
+	}
+
+	return &authz.Principal{
+		UserID:   uInfo.ID,
+		TokenID:  accessToken.ID,
+		Scopes:   accessToken.Scopes,
+		ClientID: accessToken.ClientID,
+	}, nil
+}
+
+// getOAuthAccessToken returns the access token that has not expired with the same hash
+func (s *service) getOAuthAccessToken(ctx context.Context, token string) (*OAuthAccessToken, error) {
+	if s.oauth == nil {
+		return nil, ErrOAuthNotEnabled
+	}
+
+	if !strings.HasPrefix(token, authz.OAuthAccessTokenPrefix) {
+		return nil, ErrInvalidOAuthAccessToken
+	}
+
+	accessToken, err := s.oauth.getAccessToken(ctx, hashSecret(token))
+	if err != nil {
+		if !errors.Is(err, ErrInvalidOAuthAccessToken) {
+			log.Println(err)
+		}
+		return nil, err
+	}
+
+	// expired tokens are only deleted by mongo every minute
+	if time.Now().After(accessToken.ExpiresAt) {
+		return nil, ErrInvalidOAuthAccessToken
+	}
+
+	return accessToken, nil
+}
+
+// requestedScopes splits the scope parameter of a request, which can't ask for more
+// than the app is allowed; the app is given all of its scopes if it doesn't ask for any
+func requestedScopes(scope string, allowed []string) ([]string, error) {
+	scopes := strings.Fields(scope)
+	if len(scopes) == 0 {
+		return allowed, nil
+	}
+
+	for _, s := range scopes {
+		if !isScope(s) || !containsAll(allowed, []string{s}) {
+			return nil, newOAuthError("invalid_scope", fmt.Sprintf("%q is not a scope that this client can use", s))
+		}
+	}
+
+	return scopes, nil
+}
+
+// containsAll checks if every value is in a list
+func containsAll(list, values []string) bool {
+	for _, v := range values {
+		found := false
+		for _, l := range list {
+			if l == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// randomHexString creates a random string from n random bytes, which is used for ids
+func randomHexString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
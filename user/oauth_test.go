@@ -0,0 +1,358 @@
+package user
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ricxi/flat-list/shared/authz"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testRedirectURI = "https://app.example.com/callback"
+
+func newOAuthTestService(t *testing.T) (*service, *mockOAuthStore) {
+	t.Helper()
+
+	oauth := newMockOAuthStore()
+	s := &service{repository: newAdminTestRepository(), validate: &validator{}, oauth: oauth}
+
+	return s, oauth
+}
+
+// authorizeTestClient approves an authorization request as the test user and returns the code
+func authorizeTestClient(t *testing.T, s *service, clientID, codeVerifier string) string {
+	t.Helper()
+
+	a := AuthorizationRequest{
+		ResponseType:        "code",
+		ClientID:            clientID,
+		RedirectURI:         testRedirectURI,
+		Scope:               authz.ScopeTasksRead,
+		State:               "xyz",
+		CodeChallenge:       pkceChallenge(codeVerifier),
+		CodeChallengeMethod: "S256",
+	}
+	redirectURI, err := s.authorize(context.Background(), testUserID, a, true)
+	require.NoError(t, err)
+
+	u, err := url.Parse(redirectURI)
+	require.NoError(t, err)
+	assert.Equal(t, "xyz", u.Query().Get("state"))
+	require.NotEmpty(t, u.Query().Get("code"))
+
+	return u.Query().Get("code")
+}
+
+func TestValidator_OAuthClient(t *testing.T) {
+	tests := []struct {
+		name   string
+		info   OAuthClientInfo
+		expErr string
+	}{
+		{
+			name: "Success",
+			info: OAuthClientInfo{Name: "app", RedirectURIs: []string{testRedirectURI, "http://localhost:8080/callback"}, Scopes: []string{authz.ScopeTasksRead}},
+		},
+		{
+			name:   "FailHTTP",
+			info:   OAuthClientInfo{Name: "app", RedirectURIs: []string{"http://app.example.com/callback"}, Scopes: []string{authz.ScopeTasksRead}},
+			expErr: `"http://app.example.com/callback" must be an https url, or an http url on localhost, without a fragment`,
+		},
+		{
+			name:   "FailFragment",
+			info:   OAuthClientInfo{Name: "app", RedirectURIs: []string{testRedirectURI + "#token"}, Scopes: []string{authz.ScopeTasksRead}},
+			expErr: `"https://app.example.com/callback#token" must be an https url, or an http url on localhost, without a fragment`,
+		},
+		{
+			name:   "FailMissingFields",
+			info:   OAuthClientInfo{},
+			expErr: "missing field is required: name; missing field is required: redirectUris; missing field is required: scopes",
+		},
+	}
+
+	v := &validator{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := v.OAuthClient(tt.info)
+			if tt.expErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tt.expErr)
+		})
+	}
+}
+
+func Test_Service_AuthorizationCodeGrant(t *testing.T) {
+	s, oauth := newOAuthTestService(t)
+	ctx := context.Background()
+
+	client, err := s.registerOAuthClient(ctx, testAdminID, OAuthClientInfo{
+		Name:         "app",
+		RedirectURIs: []string{testRedirectURI},
+		Scopes:       []string{authz.ScopeTasksRead, authz.ScopeTasksWrite},
+	})
+	require.NoError(t, err)
+	assert.Empty(t, client.ClientSecret)
+
+	codeVerifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	code := authorizeTestClient(t, s, client.ID, codeVerifier)
+
+	prompt, err := s.getAuthorizationPrompt(ctx, testUserID, AuthorizationRequest{
+		ResponseType:        "code",
+		ClientID:            client.ID,
+		RedirectURI:         testRedirectURI,
+		CodeChallenge:       "challenge",
+		CodeChallengeMethod: "S256",
+	})
+	require.NoError(t, err)
+	assert.False(t, prompt.Consented, "the user only consented to tasks:read")
+
+	t.Run("FailWrongCodeVerifier", func(t *testing.T) {
+		code := authorizeTestClient(t, s, client.ID, codeVerifier)
+		_, err := s.exchangeOAuthToken(ctx, OAuthTokenRequest{
+			OAuthClientCredentials: OAuthClientCredentials{ClientID: client.ID},
+			GrantType:              grantTypeAuthorizationCode,
+			Code:                   code,
+			RedirectURI:            testRedirectURI,
+			CodeVerifier:           "wrong",
+		})
+		assert.EqualError(t, err, "invalid_grant: "+ErrInvalidAuthorizationCode.Error())
+	})
+
+	tokenRequest := OAuthTokenRequest{
+		OAuthClientCredentials: OAuthClientCredentials{ClientID: client.ID},
+		GrantType:              grantTypeAuthorizationCode,
+		Code:                   code,
+		RedirectURI:            testRedirectURI,
+		CodeVerifier:           codeVerifier,
+	}
+	token, err := s.exchangeOAuthToken(ctx, tokenRequest)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(token.AccessToken, authz.OAuthAccessTokenPrefix))
+	assert.Equal(t, authz.ScopeTasksRead, token.Scope)
+
+	// codes can only be used once
+	_, err = s.exchangeOAuthToken(ctx, tokenRequest)
+	assert.EqualError(t, err, "invalid_grant: "+ErrInvalidAuthorizationCode.Error())
+
+	principal, err := s.authenticateOAuthAccessToken(ctx, token.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, testUserID, principal.UserID)
+	assert.Equal(t, client.ID, principal.ClientID)
+	assert.True(t, principal.HasScope(authz.ScopeTasksRead))
+	assert.False(t, principal.HasScope(authz.ScopeTasksWrite))
+
+	introspection, err := s.introspectOAuthToken(ctx, OAuthClientCredentials{ClientID: client.ID}, token.AccessToken)
+	require.NoError(t, err)
+	assert.True(t, introspection.Active)
+	assert.Equal(t, testUserID, introspection.Subject)
+
+	// revoking the user's consent also revokes the app's tokens, and the codes it hasn't exchanged yet
+	pendingCode := authorizeTestClient(t, s, client.ID, codeVerifier)
+	require.NoError(t, s.revokeOAuthConsent(ctx, testUserID, client.ID))
+	_, err = s.authenticateOAuthAccessToken(ctx, token.AccessToken)
+	assert.ErrorIs(t, err, ErrInvalidOAuthAccessToken)
+	assert.Empty(t, oauth.tokens)
+
+	tokenRequest.Code = pendingCode
+	_, err = s.exchangeOAuthToken(ctx, tokenRequest)
+	assert.EqualError(t, err, "invalid_grant: "+ErrInvalidAuthorizationCode.Error())
+	assert.Empty(t, oauth.codes)
+}
+
+func Test_Service_Authorize(t *testing.T) {
+	s, _ := newOAuthTestService(t)
+	ctx := context.Background()
+
+	client, err := s.registerOAuthClient(ctx, testAdminID, OAuthClientInfo{
+		Name:         "app",
+		RedirectURIs: []string{testRedirectURI},
+		Scopes:       []string{authz.ScopeTasksRead},
+	})
+	require.NoError(t, err)
+
+	valid := AuthorizationRequest{
+		ResponseType:        "code",
+		ClientID:            client.ID,
+		RedirectURI:         testRedirectURI,
+		State:               "xyz",
+		CodeChallenge:       "challenge",
+		CodeChallengeMethod: "S256",
+	}
+
+	t.Run("FailRedirectURIMismatch", func(t *testing.T) {
+		a := valid
+		a.RedirectURI = "https://attacker.example.com/callback"
+		_, err := s.authorize(ctx, testUserID, a, true)
+		assert.ErrorIs(t, err, ErrRedirectURIMismatch)
+	})
+
+	tests := []struct {
+		name     string
+		request  func(a *AuthorizationRequest)
+		approved bool
+		expError string
+	}{
+		{
+			name:     "FailDenied",
+			request:  func(a *AuthorizationRequest) {},
+			expError: "access_denied",
+		},
+		{
+			name:     "FailNoPKCE",
+			request:  func(a *AuthorizationRequest) { a.CodeChallenge = "" },
+			approved: true,
+			expError: "invalid_request",
+		},
+		{
+			name:     "FailScopeNotAllowed",
+			request:  func(a *AuthorizationRequest) { a.Scope = authz.ScopeTasksWrite },
+			approved: true,
+			expError: "invalid_scope",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := valid
+			tt.request(&a)
+
+			redirectURI, err := s.authorize(ctx, testUserID, a, tt.approved)
+			require.NoError(t, err)
+
+			u, err := url.Parse(redirectURI)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expError, u.Query().Get("error"))
+			assert.Equal(t, "xyz", u.Query().Get("state"))
+			assert.Empty(t, u.Query().Get("code"))
+		})
+	}
+}
+
+func Test_Service_ClientCredentialsGrant(t *testing.T) {
+	s, _ := newOAuthTestService(t)
+	ctx := context.Background()
+
+	confidential, err := s.registerOAuthClient(ctx, testUserID, OAuthClientInfo{
+		Name:         "sync",
+		RedirectURIs: []string{testRedirectURI},
+		Scopes:       []string{authz.ScopeTasksRead, authz.ScopeTasksWrite},
+		Confidential: true,
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, confidential.ClientSecret)
+
+	_, err = s.exchangeOAuthToken(ctx, OAuthTokenRequest{
+		OAuthClientCredentials: OAuthClientCredentials{ClientID: confidential.ID, ClientSecret: "wrong"},
+		GrantType:              grantTypeClientCredentials,
+	})
+	assert.EqualError(t, err, "invalid_client: client authentication failed")
+
+	token, err := s.exchangeOAuthToken(ctx, OAuthTokenRequest{
+		OAuthClientCredentials: OAuthClientCredentials{ClientID: confidential.ID, ClientSecret: confidential.ClientSecret},
+		GrantType:              grantTypeClientCredentials,
+		Scope:                  authz.ScopeTasksWrite,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, authz.ScopeTasksWrite, token.Scope)
+
+	// the app acts as the user who registered it
+	principal, err := s.authenticateOAuthAccessToken(ctx, token.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, testUserID, principal.UserID)
+
+	public, err := s.registerOAuthClient(ctx, testUserID, OAuthClientInfo{
+		Name:         "mobile",
+		RedirectURIs: []string{testRedirectURI},
+		Scopes:       []string{authz.ScopeTasksRead},
+	})
+	require.NoError(t, err)
+
+	_, err = s.exchangeOAuthToken(ctx, OAuthTokenRequest{
+		OAuthClientCredentials: OAuthClientCredentials{ClientID: public.ID},
+		GrantType:              grantTypeClientCredentials,
+	})
+	assert.EqualError(t, err, "unauthorized_client: public clients can't use the client_credentials grant")
+
+	// other apps can't see or revoke the token
+	other := OAuthClientCredentials{ClientID: public.ID}
+	introspection, err := s.introspectOAuthToken(ctx, other, token.AccessToken)
+	require.NoError(t, err)
+	assert.False(t, introspection.Active)
+	require.NoError(t, s.revokeOAuthToken(ctx, other, token.AccessToken))
+	_, err = s.authenticateOAuthAccessToken(ctx, token.AccessToken)
+	require.NoError(t, err)
+
+	owner := OAuthClientCredentials{ClientID: confidential.ID, ClientSecret: confidential.ClientSecret}
+	require.NoError(t, s.revokeOAuthToken(ctx, owner, token.AccessToken))
+	_, err = s.authenticateOAuthAccessToken(ctx, token.AccessToken)
+	assert.ErrorIs(t, err, ErrInvalidOAuthAccessToken)
+}
+
+func TestHandleOAuthToken(t *testing.T) {
+	tests := []struct {
+		name          string
+		service       mockService
+		basicAuth     bool
+		expStatusCode int
+		expBody       string
+	}{
+		{
+			name:          "Success",
+			service:       mockService{oauthToken: &OAuthTokenResponse{AccessToken: "float_token", TokenType: "Bearer", ExpiresIn: 3600, Scope: "tasks:read"}},
+			expStatusCode: http.StatusOK,
+			expBody:       `{"access_token":"float_token","token_type":"Bearer","expires_in":3600,"scope":"tasks:read"}`,
+		},
+		{
+			name:          "FailInvalidGrant",
+			service:       mockService{err: newOAuthError("invalid_grant", ErrInvalidAuthorizationCode.Error())},
+			expStatusCode: http.StatusBadRequest,
+			expBody:       `{"error":"invalid_grant","error_description":"invalid or expired authorization code"}`,
+		},
+		{
+			name:          "FailInvalidClient",
+			service:       mockService{err: newOAuthError("invalid_client", "client authentication failed")},
+			basicAuth:     true,
+			expStatusCode: http.StatusUnauthorized,
+			expBody:       `{"error":"invalid_client","error_description":"client authentication failed"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"grant_type": {grantTypeClientCredentials}}
+			r := httptest.NewRequest(http.MethodPost, "/v1/user/oauth/token", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.basicAuth {
+				r.SetBasicAuth("client", "secret")
+			}
+			rr := httptest.NewRecorder()
+
+			NewHTTPHandler(tt.service).ServeHTTP(rr, r)
+
+			assert.Equal(t, tt.expStatusCode, rr.Code)
+			assert.JSONEq(t, tt.expBody, rr.Body.String())
+			assert.Equal(t, "no-store", rr.Header().Get("Cache-Control"))
+			if tt.basicAuth {
+				assert.NotEmpty(t, rr.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestHandleAuthenticateOAuthAccessToken(t *testing.T) {
+	service := mockService{userID: testUserID, scopes: []string{authz.ScopeTasksRead}, clientID: "c1"}
+	r := newRequestWithJSONHeader(http.MethodPost, "/v1/user/authenticate", strings.NewReader(`{"token": "float_token_goes_here"}`))
+	rr := httptest.NewRecorder()
+
+	NewHTTPHandler(service).ServeHTTP(rr, r)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"success":true,"userId":"`+testUserID+`","roles":[],"tokenId":"a1b2c3d4e5f60718","scopes":["tasks:read"],"clientId":"c1"}`, rr.Body.String())
+}
@@ -0,0 +1,378 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// OAuthStore stores the apps that are registered with the authorization server,
+// the consents that users have given them, and the codes and tokens they are issued
+type OAuthStore interface {
+	createClient(ctx context.Context, client OAuthClient) error
+	getClient(ctx context.Context, clientID string) (*OAuthClient, error)
+	getClientsByOwner(ctx context.Context, ownerID string) ([]OAuthClient, error)
+	// deleteClient also deletes the consents that were given to the client and
+	// the codes and tokens that it was issued, so none of them can be used
+	deleteClient(ctx context.Context, clientID string) error
+	// getConsent returns nil if the user has not given the client consent
+	getConsent(ctx context.Context, userID, clientID string) (*OAuthConsent, error)
+	getConsentsByUser(ctx context.Context, userID string) ([]OAuthConsent, error)
+	saveConsent(ctx context.Context, consent OAuthConsent) error
+	// deleteConsent also deletes the codes and tokens that were issued to the client for the user
+	deleteConsent(ctx context.Context, userID, clientID string) error
+	createAuthorizationCode(ctx context.Context, code AuthorizationCode) error
+	// consumeAuthorizationCode finds and deletes a code in one step, so that it can only be used once
+	consumeAuthorizationCode(ctx context.Context, codeHash string) (*AuthorizationCode, error)
+	createAccessToken(ctx context.Context, token OAuthAccessToken) error
+	getAccessToken(ctx context.Context, tokenHash string) (*OAuthAccessToken, error)
+	deleteAccessToken(ctx context.Context, tokenHash string) error
+	// deleteUserData deletes the clients that a user owns, the consents
+	// they have given, and the codes and tokens that were issued for them
+	deleteUserData(ctx context.Context, userID string) error
+}
+
+// OAuthClientDocument is used to store an app that is registered with the authorization server
+type OAuthClientDocument struct {
+	ClientID     string    `bson:"_id"`
+	OwnerID      string    `bson:"ownerId"`
+	SecretHash   string    `bson:"secretHash,omitempty"`
+	Name         string    `bson:"name"`
+	RedirectURIs []string  `bson:"redirectUris"`
+	Scopes       []string  `bson:"scopes"`
+	Confidential bool      `bson:"confidential"`
+	CreatedAt    time.Time `bson:"createdAt"`
+}
+
+// OAuthConsentDocument is used to store the scopes that a user has let an app use
+type OAuthConsentDocument struct {
+	UserID     string    `bson:"userId"`
+	ClientID   string    `bson:"clientId"`
+	ClientName string    `bson:"clientName"`
+	Scopes     []string  `bson:"scopes"`
+	CreatedAt  time.Time `bson:"createdAt"`
+	UpdatedAt  time.Time `bson:"updatedAt"`
+}
+
+// AuthorizationCodeDocument is used to store an authorization code until
+// it is exchanged, and is deleted by mongo when it expires
+type AuthorizationCodeDocument struct {
+	CodeHash      string    `bson:"_id"`
+	ClientID      string    `bson:"clientId"`
+	UserID        string    `bson:"userId"`
+	RedirectURI   string    `bson:"redirectUri"`
+	Scopes        []string  `bson:"scopes"`
+	CodeChallenge string    `bson:"codeChallenge"`
+	ExpiresAt     time.Time `bson:"expiresAt"`
+}
+
+// OAuthAccessTokenDocument is used to store an access token that was
+// issued to an app, and is deleted by mongo when it expires
+type OAuthAccessTokenDocument struct {
+	TokenHash string    `bson:"_id"`
+	TokenID   string    `bson:"tokenId"`
+	ClientID  string    `bson:"clientId"`
+	UserID    string    `bson:"userId"`
+	Scopes    []string  `bson:"scopes"`
+	CreatedAt time.Time `bson:"createdAt"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+type mongoOAuthStore struct {
+	clients  *mongo.Collection
+	consents *mongo.Collection
+	codes    *mongo.Collection
+	tokens   *mongo.Collection
+}
+
+func NewMongoOAuthStore(client *mongo.Client, database string) OAuthStore {
+	db := client.Database(database)
+
+	return &mongoOAuthStore{
+		clients:  db.Collection("oauthClients"),
+		consents: db.Collection("oauthConsents"),
+		codes:    db.Collection("oauthCodes"),
+		tokens:   db.Collection("oauthTokens"),
+	}
+}
+
+func (m *mongoOAuthStore) createClient(ctx context.Context, client OAuthClient) error {
+	clientDocument := OAuthClientDocument{
+		ClientID:     client.ID,
+		OwnerID:      client.OwnerID,
+		SecretHash:   client.SecretHash,
+		Name:         client.Name,
+		RedirectURIs: client.RedirectURIs,
+		Scopes:       client.Scopes,
+		Confidential: client.Confidential,
+		CreatedAt:    client.CreatedAt,
+	}
+
+	_, err := m.clients.InsertOne(ctx, &clientDocument)
+	return err
+}
+
+func (m *mongoOAuthStore) getClient(ctx context.Context, clientID string) (*OAuthClient, error) {
+	var clientDocument OAuthClientDocument
+	if err := m.clients.FindOne(ctx, bson.M{"_id": clientID}).Decode(&clientDocument); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrOAuthClientNotFound
+		}
+		return nil, err
+	}
+
+	client := newOAuthClient(clientDocument)
+	return &client, nil
+}
+
+func (m *mongoOAuthStore) getClientsByOwner(ctx context.Context, ownerID string) ([]OAuthClient, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	cursor, err := m.clients.Find(ctx, bson.M{"ownerId": ownerID}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var clientDocuments []OAuthClientDocument
+	if err := cursor.All(ctx, &clientDocuments); err != nil {
+		return nil, err
+	}
+
+	clients := make([]OAuthClient, 0, len(clientDocuments))
+	for _, clientDocument := range clientDocuments {
+		clients = append(clients, newOAuthClient(clientDocument))
+	}
+
+	return clients, nil
+}
+
+// deleteClient deletes the client last, so that it can be
+// deleted again if deleting its consents or tokens fails
+func (m *mongoOAuthStore) deleteClient(ctx context.Context, clientID string) error {
+	if _, err := m.codes.DeleteMany(ctx, bson.M{"clientId": clientID}); err != nil {
+		return err
+	}
+
+	if _, err := m.tokens.DeleteMany(ctx, bson.M{"clientId": clientID}); err != nil {
+		return err
+	}
+
+	if _, err := m.consents.DeleteMany(ctx, bson.M{"clientId": clientID}); err != nil {
+		return err
+	}
+
+	result, err := m.clients.DeleteOne(ctx, bson.M{"_id": clientID})
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return ErrOAuthClientNotFound
+	}
+
+	return nil
+}
+
+func (m *mongoOAuthStore) getConsent(ctx context.Context, userID, clientID string) (*OAuthConsent, error) {
+	var consentDocument OAuthConsentDocument
+	if err := m.consents.FindOne(ctx, bson.M{"userId": userID, "clientId": clientID}).Decode(&consentDocument); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	consent := newOAuthConsent(consentDocument)
+	return &consent, nil
+}
+
+func (m *mongoOAuthStore) getConsentsByUser(ctx context.Context, userID string) ([]OAuthConsent, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "updatedAt", Value: -1}})
+	cursor, err := m.consents.Find(ctx, bson.M{"userId": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var consentDocuments []OAuthConsentDocument
+	if err := cursor.All(ctx, &consentDocuments); err != nil {
+		return nil, err
+	}
+
+	consents := make([]OAuthConsent, 0, len(consentDocuments))
+	for _, consentDocument := range consentDocuments {
+		consents = append(consents, newOAuthConsent(consentDocument))
+	}
+
+	return consents, nil
+}
+
+// saveConsent replaces a user's consent for a client, or creates it if there is none
+func (m *mongoOAuthStore) saveConsent(ctx context.Context, consent OAuthConsent) error {
+	consentDocument := OAuthConsentDocument{
+		UserID:     consent.UserID,
+		ClientID:   consent.ClientID,
+		ClientName: consent.ClientName,
+		Scopes:     consent.Scopes,
+		CreatedAt:  consent.CreatedAt,
+		UpdatedAt:  consent.UpdatedAt,
+	}
+
+	filter := bson.M{"userId": consent.UserID, "clientId": consent.ClientID}
+	_, err := m.consents.ReplaceOne(ctx, filter, &consentDocument, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (m *mongoOAuthStore) deleteConsent(ctx context.Context, userID, clientID string) error {
+	filter := bson.M{"userId": userID, "clientId": clientID}
+
+	// a code that has not been exchanged yet would otherwise still get a token
+	if _, err := m.codes.DeleteMany(ctx, filter); err != nil {
+		return err
+	}
+
+	if _, err := m.tokens.DeleteMany(ctx, filter); err != nil {
+		return err
+	}
+
+	result, err := m.consents.DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return ErrOAuthConsentNotFound
+	}
+
+	return nil
+}
+
+func (m *mongoOAuthStore) createAuthorizationCode(ctx context.Context, code AuthorizationCode) error {
+	codeDocument := AuthorizationCodeDocument{
+		CodeHash:      code.CodeHash,
+		ClientID:      code.ClientID,
+		UserID:        code.UserID,
+		RedirectURI:   code.RedirectURI,
+		Scopes:        code.Scopes,
+		CodeChallenge: code.CodeChallenge,
+		ExpiresAt:     code.ExpiresAt,
+	}
+
+	_, err := m.codes.InsertOne(ctx, &codeDocument)
+	return err
+}
+
+func (m *mongoOAuthStore) consumeAuthorizationCode(ctx context.Context, codeHash string) (*AuthorizationCode, error) {
+	var codeDocument AuthorizationCodeDocument
+	if err := m.codes.FindOneAndDelete(ctx, bson.M{"_id": codeHash}).Decode(&codeDocument); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrInvalidAuthorizationCode
+		}
+		return nil, err
+	}
+
+	return &AuthorizationCode{
+		CodeHash:      codeDocument.CodeHash,
+		ClientID:      codeDocument.ClientID,
+		UserID:        codeDocument.UserID,
+		RedirectURI:   codeDocument.RedirectURI,
+		Scopes:        codeDocument.Scopes,
+		CodeChallenge: codeDocument.CodeChallenge,
+		ExpiresAt:     codeDocument.ExpiresAt,
+	}, nil
+}
+
+func (m *mongoOAuthStore) createAccessToken(ctx context.Context, token OAuthAccessToken) error {
+	tokenDocument := OAuthAccessTokenDocument{
+		TokenHash: token.TokenHash,
+		TokenID:   token.ID,
+		ClientID:  token.ClientID,
+		UserID:    token.UserID,
+		Scopes:    token.Scopes,
+		CreatedAt: token.CreatedAt,
+		ExpiresAt: token.ExpiresAt,
+	}
+
+	_, err := m.tokens.InsertOne(ctx, &tokenDocument)
+	return err
+}
+
+func (m *mongoOAuthStore) getAccessToken(ctx context.Context, tokenHash string) (*OAuthAccessToken, error) {
+	var tokenDocument OAuthAccessTokenDocument
+	if err := m.tokens.FindOne(ctx, bson.M{"_id": tokenHash}).Decode(&tokenDocument); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrInvalidOAuthAccessToken
+		}
+		return nil, err
+	}
+
+	return &OAuthAccessToken{
+		ID:        tokenDocument.TokenID,
+		TokenHash: tokenDocument.TokenHash,
+		ClientID:  tokenDocument.ClientID,
+		UserID:    tokenDocument.UserID,
+		Scopes:    tokenDocument.Scopes,
+		CreatedAt: tokenDocument.CreatedAt,
+		ExpiresAt: tokenDocument.ExpiresAt,
+	}, nil
+}
+
+func (m *mongoOAuthStore) deleteAccessToken(ctx context.Context, tokenHash string) error {
+	_, err := m.tokens.DeleteOne(ctx, bson.M{"_id": tokenHash})
+	return err
+}
+
+func (m *mongoOAuthStore) deleteUserData(ctx context.Context, userID string) error {
+	cursor, err := m.clients.Find(ctx, bson.M{"ownerId": userID})
+	if err != nil {
+		return err
+	}
+
+	var clientDocuments []OAuthClientDocument
+	if err := cursor.All(ctx, &clientDocuments); err != nil {
+		return err
+	}
+
+	for _, clientDocument := range clientDocuments {
+		if err := m.deleteClient(ctx, clientDocument.ClientID); err != nil && !errors.Is(err, ErrOAuthClientNotFound) {
+			return err
+		}
+	}
+
+	if _, err := m.codes.DeleteMany(ctx, bson.M{"userId": userID}); err != nil {
+		return err
+	}
+
+	if _, err := m.tokens.DeleteMany(ctx, bson.M{"userId": userID}); err != nil {
+		return err
+	}
+
+	_, err = m.consents.DeleteMany(ctx, bson.M{"userId": userID})
+	return err
+}
+
+func newOAuthClient(clientDocument OAuthClientDocument) OAuthClient {
+	return OAuthClient{
+		ID:           clientDocument.ClientID,
+		OwnerID:      clientDocument.OwnerID,
+		SecretHash:   clientDocument.SecretHash,
+		Name:         clientDocument.Name,
+		RedirectURIs: clientDocument.RedirectURIs,
+		Scopes:       clientDocument.Scopes,
+		Confidential: clientDocument.Confidential,
+		CreatedAt:    clientDocument.CreatedAt,
+	}
+}
+
+func newOAuthConsent(consentDocument OAuthConsentDocument) OAuthConsent {
+	return OAuthConsent{
+		UserID:     consentDocument.UserID,
+		ClientID:   consentDocument.ClientID,
+		ClientName: consentDocument.ClientName,
+		Scopes:     consentDocument.Scopes,
+		CreatedAt:  consentDocument.CreatedAt,
+		UpdatedAt:  consentDocument.UpdatedAt,
+	}
+}
@@ -0,0 +1,355 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubOIDCProvider is a local identity provider that implements just
+// enough of the authorization code flow with PKCE to log a user in
+type stubOIDCProvider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	// claims that are put in the id tokens that are issued
+	subject       string
+	email         string
+	emailVerified bool
+	// wrongNonce makes the provider issue id tokens with a nonce that was not requested
+	wrongNonce bool
+
+	mu    sync.Mutex
+	codes map[string]stubAuthRequest
+}
+
+type stubAuthRequest struct {
+	nonce         string
+	codeChallenge string
+}
+
+func newStubOIDCProvider(t *testing.T) *stubOIDCProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	stub := &stubOIDCProvider{
+		key:           key,
+		subject:       "stub-subject-1",
+		email:         "michaelscott@dundermifflin.com",
+		emailVerified: true,
+		codes:         make(map[string]stubAuthRequest),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 stub.URL,
+			"authorization_endpoint": stub.URL + "/authorize",
+			"token_endpoint":         stub.URL + "/token",
+			"jwks_uri":               stub.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "stub-key",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	// the user logs in straight away and is redirected back with a code
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("code_challenge_method") != "S256" {
+			http.Error(w, "S256 code challenge is required", http.StatusBadRequest)
+			return
+		}
+
+		code, _ := randomURLString()
+		stub.mu.Lock()
+		stub.codes[code] = stubAuthRequest{nonce: q.Get("nonce"), codeChallenge: q.Get("code_challenge")}
+		stub.mu.Unlock()
+
+		redirect, _ := url.Parse(q.Get("redirect_uri"))
+		rq := redirect.Query()
+		rq.Set("code", code)
+		rq.Set("state", q.Get("state"))
+		redirect.RawQuery = rq.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if clientID, secret, ok := r.BasicAuth(); !ok || clientID != "flat-list" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+
+		stub.mu.Lock()
+		authReq, ok := stub.codes[r.PostFormValue("code")]
+		delete(stub.codes, r.PostFormValue("code"))
+		stub.mu.Unlock()
+
+		if !ok || pkceChallenge(r.PostFormValue("code_verifier")) != authReq.codeChallenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		nonce := authReq.nonce
+		if stub.wrongNonce {
+			nonce = "not-the-nonce"
+		}
+
+		claims := IDTokenClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    stub.URL,
+				Subject:   stub.subject,
+				Audience:  jwt.ClaimStrings{"flat-list"},
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+				IssuedAt:  jwt.NewNumericDate(time.Now()),
+			},
+			Nonce:         nonce,
+			Email:         stub.email,
+			EmailVerified: stub.emailVerified,
+			GivenName:     "Michael",
+			FamilyName:    "Scott",
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "stub-key"
+		idToken, err := token.SignedString(key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idToken, "token_type": "Bearer"})
+	})
+
+	stub.Server = httptest.NewServer(mux)
+	t.Cleanup(stub.Close)
+
+	return stub
+}
+
+// authorize follows the login url to the stub provider and
+// returns the query of the url it redirects the user back to
+func (stub *stubOIDCProvider) authorize(t *testing.T, authURL string) url.Values {
+	t.Helper()
+
+	client := http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Get(authURL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	redirect, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+
+	return redirect.Query()
+}
+
+func Test_Service_OIDCLogin(t *testing.T) {
+	t.Setenv("JWT_SECRET_KEY", jwtSecretTestKey)
+
+	existingUser := &UserInfo{
+		ID:             "5ef7fdd91c19e3222b41b839",
+		Email:          "michaelscott@dundermifflin.com",
+		HashedPassword: "hashedpassword",
+		Activated:      true,
+	}
+
+	tests := []struct {
+		name          string
+		repository    *mockRepository
+		emailVerified bool
+		wrongNonce    bool
+		wrongCookie   bool
+		reauth        bool
+		expLinked     bool
+		expClaimed    bool
+		expErr        error
+	}{
+		{
+			name:          "SuccessLinkedUser",
+			repository:    &mockRepository{user: existingUser},
+			emailVerified: true,
+		},
+		{
+			name:          "SuccessLinkExistingUserByEmail",
+			repository:    &mockRepository{user: existingUser, identityErr: ErrUserNotFound},
+			emailVerified: true,
+			expLinked:     true,
+		},
+		{
+			// someone else may have registered the account with the user's email
+			name: "SuccessLinkUnactivatedUserByEmail",
+			repository: &mockRepository{
+				user: &UserInfo{
+					ID:               "5ef7fdd91c19e3222b41b839",
+					Email:            "michaelscott@dundermifflin.com",
+					HashedPassword:   "hashedpassword",
+					TwoFactorEnabled: true,
+					TwoFactorSecret:  rfcTestSecret,
+				},
+				identityErr: ErrUserNotFound,
+			},
+			emailVerified: true,
+			expLinked:     true,
+			expClaimed:    true,
+		},
+		{
+			name:          "SuccessCreateUser",
+			repository:    &mockRepository{userID: "5ef7fdd91c19e3222b41b840", identityErr: ErrUserNotFound, emailErr: ErrUserNotFound},
+			emailVerified: true,
+		},
+		{
+			// a reauth login only returns a token, and doesn't link or create a user
+			name:          "SuccessReauth",
+			repository:    &mockRepository{user: existingUser},
+			emailVerified: true,
+			reauth:        true,
+		},
+		{
+			name:          "FailReauthNotLinked",
+			repository:    &mockRepository{user: existingUser, identityErr: ErrUserNotFound},
+			emailVerified: true,
+			reauth:        true,
+			expErr:        ErrUserNotFound,
+		},
+		{
+			name:          "FailEmailNotVerified",
+			repository:    &mockRepository{user: existingUser, identityErr: ErrUserNotFound},
+			emailVerified: false,
+			expErr:        ErrOIDCEmailNotVerified,
+		},
+		{
+			name:          "FailWrongNonce",
+			repository:    &mockRepository{user: existingUser},
+			emailVerified: true,
+			wrongNonce:    true,
+			expErr:        ErrInvalidIDToken,
+		},
+		{
+			name:          "FailStateFromAnotherBrowser",
+			repository:    &mockRepository{user: existingUser},
+			emailVerified: true,
+			wrongCookie:   true,
+			expErr:        ErrInvalidOIDCState,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newStubOIDCProvider(t)
+			stub.emailVerified = tt.emailVerified
+			stub.wrongNonce = tt.wrongNonce
+
+			provider, err := NewOIDCProvider(context.Background(), OIDCProviderConfig{
+				Name:         "stub",
+				Issuer:       stub.URL,
+				ClientID:     "flat-list",
+				ClientSecret: "secret",
+				RedirectURL:  "http://localhost:5004/v1/user/oidc/stub/callback",
+			})
+			require.NoError(t, err)
+
+			s := &service{
+				repository: tt.repository,
+				validate:   &validator{},
+				token:      &mockTokenClient{},
+			}
+			WithOIDCProviders(provider)(s)
+
+			authURL, state, err := s.startOIDCLogin(context.Background(), "stub", tt.reauth)
+			require.NoError(t, err)
+
+			callback := stub.authorize(t, authURL)
+			require.Equal(t, state, callback.Get("state"))
+
+			stateCookie := state
+			if tt.wrongCookie {
+				stateCookie = "another-state"
+			}
+
+			uInfo, err := s.finishOIDCLogin(context.Background(), OIDCCallbackInfo{
+				Provider:    "stub",
+				Code:        callback.Get("code"),
+				State:       callback.Get("state"),
+				StateCookie: stateCookie,
+			})
+			if tt.expErr != nil {
+				assert.ErrorIs(t, err, tt.expErr)
+				assert.Nil(t, uInfo)
+				return
+			}
+
+			require.NoError(t, err)
+			if tt.reauth {
+				assert.Empty(t, uInfo.Token)
+				assert.NoError(t, s.confirmPassword(existingUser, "", uInfo.ReauthToken))
+				assert.Empty(t, tt.repository.linkedIdentities)
+				return
+			}
+			assert.NotEmpty(t, uInfo.Token)
+			assert.Empty(t, uInfo.ReauthToken)
+			assert.Empty(t, uInfo.HashedPassword)
+			assert.Equal(t, "michaelscott@dundermifflin.com", uInfo.Email)
+			if tt.expLinked {
+				assert.Equal(t, []OIDCIdentity{{Provider: "stub", Subject: "stub-subject-1"}}, tt.repository.linkedIdentities)
+			} else {
+				assert.Empty(t, tt.repository.linkedIdentities)
+			}
+			if tt.expClaimed {
+				require.Len(t, tt.repository.userUpdates, 1)
+				update := tt.repository.userUpdates[0]
+				assert.True(t, *update.Activated)
+				assert.Empty(t, *update.HashedPassword)
+				assert.False(t, *update.TwoFactorEnabled)
+				assert.Empty(t, *update.TwoFactorSecret)
+				assert.Empty(t, *update.RecoveryCodeHashes)
+				assert.False(t, uInfo.TwoFactorEnabled)
+			}
+
+			// the state can only be used once
+			_, err = s.finishOIDCLogin(context.Background(), OIDCCallbackInfo{
+				Provider:    "stub",
+				Code:        callback.Get("code"),
+				State:       callback.Get("state"),
+				StateCookie: stateCookie,
+			})
+			assert.ErrorIs(t, err, ErrInvalidOIDCState)
+		})
+	}
+}
+
+func TestPasswordManagerCompareHashWithNoPassword(t *testing.T) {
+	pm := NewPasswordManager(0)
+
+	// users who only log in with an identity provider have no
+	// password hash, so they can't log in with any password
+	err := pm.CompareHashWith("", "")
+	assert.ErrorIs(t, err, ErrPasswordNotSet)
+}
@@ -0,0 +1,286 @@
+package user
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/ricxi/flat-list/shared/validation"
+)
+
+// oidcLoginExpiry is how long a user has to log in with an identity provider
+const oidcLoginExpiry = 10 * time.Minute
+
+// startOIDCLogin creates the state, nonce and PKCE code verifier for a login with an
+// identity provider, and returns the url to send the user to along with the state,
+// which the handler also stores in the user's browser. A reauth login is for a user
+// to confirm an action rather than to log in (see finishOIDCLogin).
+func (s *service) startOIDCLogin(ctx context.Context, providerName string, reauth bool) (string, string, error) {
+	provider, ok := s.oidc[providerName]
+	if !ok {
+		return "", "", ErrUnknownOIDCProvider
+	}
+
+	var values [3]string
+	for i := range values {
+		v, err := randomURLString()
+		if err != nil {
+			return "", "", err
+		}
+		values[i] = v
+	}
+	state, nonce, codeVerifier := values[0], values[1], values[2]
+
+	expiresAt := time.Now().Add(oidcLoginExpiry)
+	login := OIDCLogin{
+		State:        state,
+		Provider:     providerName,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		ExpiresAt:    &expiresAt,
+		Reauth:       reauth,
+	}
+	if err := s.repository.createOIDCLogin(ctx, login); err != nil {
+		log.Println(err)
+		return "", "", err
+	}
+
+	return provider.authCodeURL(state, nonce, codeVerifier), state, nil
+}
+
+// finishOIDCLogin is called when an identity provider redirects the user back.
+// It exchanges the authorization code for an id token, then logs in the user who
+// is linked to the provider's account. If there is no linked user, the account is
+// linked to the user with the same email (if the provider has verified it), or
+// a new user without a password is created. A reauth login only returns a
+// reauthentication token for the user who is already linked to the account.
+func (s *service) finishOIDCLogin(ctx context.Context, c OIDCCallbackInfo) (*UserInfo, error) {
+	provider, ok := s.oidc[c.Provider]
+	if !ok {
+		return nil, ErrUnknownOIDCProvider
+	}
+
+	if err := s.validate.NonEmptyString("code", c.Code); err != nil {
+		return nil, err
+	}
+
+	if c.State == "" || subtle.ConstantTimeCompare([]byte(c.State), []byte(c.StateCookie)) != 1 {
+		return nil, ErrInvalidOIDCState
+	}
+
+	login, err := s.repository.consumeOIDCLogin(ctx, c.State)
+	if err != nil {
+		return nil, err
+	}
+
+	if login.Provider != c.Provider || login.ExpiresAt == nil || time.Now().After(*login.ExpiresAt) {
+		return nil, ErrInvalidOIDCState
+	}
+
+	rawIDToken, err := provider.exchange(ctx, c.Code, login.CodeVerifier)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	claims, err := provider.verifyIDToken(ctx, rawIDToken, login.Nonce)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	identity := OIDCIdentity{Provider: c.Provider, Subject: claims.Subject}
+	if login.Reauth {
+		return s.reauthenticateOIDCUser(ctx, identity)
+	}
+
+	uInfo, err := s.findOrCreateOIDCUser(ctx, identity, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	if uInfo.Deactivated {
+		return nil, ErrUserDeactivated
+	}
+
+	if uInfo.TwoFactorEnabled {
+		challengeToken, err := generateChallengeJWT(uInfo.ID)
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+
+		return &UserInfo{ID: uInfo.ID, ChallengeToken: challengeToken, TwoFactorEnabled: true}, nil
+	}
+
+	token, err := generateUserJWT(uInfo.ID, uInfo.Roles)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	uInfo.HashedPassword = ""
+	uInfo.Token = token
+
+	return uInfo, nil
+}
+
+// reauthenticateOIDCUser returns a reauthentication token for the user who is linked to an identity
+func (s *service) reauthenticateOIDCUser(ctx context.Context, identity OIDCIdentity) (*UserInfo, error) {
+	uInfo, err := s.repository.getUserByIdentity(ctx, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	if uInfo.Deactivated {
+		return nil, ErrUserDeactivated
+	}
+
+	reauthToken, err := generateReauthJWT(uInfo.ID)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return &UserInfo{ID: uInfo.ID, ReauthToken: reauthToken}, nil
+}
+
+// confirmPassword checks that a user has confirmed an action, with their password or a
+// reauthentication token. Users without a password can only confirm with a token.
+func (s *service) confirmPassword(uInfo *UserInfo, password, reauthToken string) error {
+	if reauthToken != "" {
+		var userClaims UserClaims
+		if err := verifyUserJWT(reauthToken, &userClaims); err != nil {
+			return ErrInvalidReauthToken
+		}
+
+		if userClaims.Purpose != reauthPurpose || userClaims.UserID != uInfo.ID {
+			return ErrInvalidReauthToken
+		}
+
+		return nil
+	}
+
+	if password == "" {
+		return validation.Required("password")
+	}
+
+	return s.password.CompareHashWith(uInfo.HashedPassword, password)
+}
+
+// claimUnactivatedUser activates an account for the owner of its email, who has logged in with
+// an identity provider. Anyone could have registered the account with that email, so its password,
+// two-factor authentication and pending email change are removed, and so are its tokens, so that
+// whoever registered it can't log in to it once it is activated.
+func (s *service) claimUnactivatedUser(ctx context.Context, uInfo *UserInfo) error {
+	if err := s.token.DeleteUserTokens(ctx, uInfo.ID); err != nil {
+		log.Println(err)
+		return err
+	}
+
+	activated := true
+	noPassword := ""
+	noPendingEmail := ""
+	disabled := false
+	noSecret := ""
+	var noStep int64
+	noHashes := []string{}
+	updatedAt := time.Now().In(time.UTC)
+	userUpdate := UserUpdate{
+		Activated:              &activated,
+		HashedPassword:         &noPassword,
+		PendingEmail:           &noPendingEmail,
+		TwoFactorEnabled:       &disabled,
+		TwoFactorSecret:        &noSecret,
+		TwoFactorPendingSecret: &noSecret,
+		TwoFactorLastStep:      &noStep,
+		RecoveryCodeHashes:     &noHashes,
+		UpdatedAt:              &updatedAt,
+	}
+	if err := s.repository.updateUserByID(ctx, uInfo.ID, userUpdate); err != nil {
+		log.Println(err)
+		return err
+	}
+
+	uInfo.Activated = true
+	uInfo.HashedPassword = ""
+	uInfo.PendingEmail = ""
+	uInfo.TwoFactorEnabled = false
+	uInfo.TwoFactorSecret = ""
+	uInfo.RecoveryCodeHashes = nil
+
+	return nil
+}
+
+func (s *service) findOrCreateOIDCUser(ctx context.Context, identity OIDCIdentity, claims *IDTokenClaims) (*UserInfo, error) {
+	uInfo, err := s.repository.getUserByIdentity(ctx, identity)
+	if err == nil {
+		return uInfo, nil
+	}
+	if !errors.Is(err, ErrUserNotFound) {
+		log.Println(err)
+		return nil, err
+	}
+
+	// an unverified email could belong to someone else, so it can't be linked or registered
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, ErrOIDCEmailNotVerified
+	}
+
+	email, err := s.validate.Email("email", claims.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	uInfo, err = s.repository.getUserByEmail(ctx, email)
+	if err == nil {
+		// the provider has verified the email, so the account doesn't need to be activated
+		if !uInfo.Activated {
+			if err := s.claimUnactivatedUser(ctx, uInfo); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := s.repository.linkIdentity(ctx, uInfo.ID, identity); err != nil {
+			log.Println(err)
+			return nil, err
+		}
+
+		uInfo.Identities = append(uInfo.Identities, identity)
+		return uInfo, nil
+	}
+	if !errors.Is(err, ErrUserNotFound) {
+		log.Println(err)
+		return nil, err
+	}
+
+	createdAt := time.Now().In(time.UTC)
+	u := UserRegistrationInfo{
+		FirstName:  claims.GivenName,
+		LastName:   claims.FamilyName,
+		Email:      email,
+		Activated:  true,
+		CreatedAt:  &createdAt,
+		UpdatedAt:  &createdAt,
+		Identities: []OIDCIdentity{identity},
+	}
+
+	userID, err := s.repository.createUser(ctx, u)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return &UserInfo{
+		ID:         userID,
+		FirstName:  u.FirstName,
+		LastName:   u.LastName,
+		Email:      u.Email,
+		Activated:  true,
+		CreatedAt:  &createdAt,
+		UpdatedAt:  &createdAt,
+		Identities: u.Identities,
+	}, nil
+}
@@ -1,6 +1,8 @@
 package user
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 
 	"golang.org/x/crypto/bcrypt"
@@ -34,11 +36,26 @@ func (pm *passwordManager) GenerateHash(password string) (string, error) {
 	return string(hashedPassword), nil
 }
 
+// CompareHashWith checks a password against a hash. Users who log in
+// with an identity provider have no hash, so no password matches it.
 func (pm *passwordManager) CompareHashWith(hashedPassword, password string) error {
+	if hashedPassword == "" {
+		return ErrPasswordNotSet
+	}
+
 	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
 	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
 		return ErrInvalidPassword
 	}
 
-	return nil
+	return err
+}
+
+// hashSecret is used so that random secrets (ie. download tokens, recovery codes,
+// and the client secrets, authorization codes and access tokens of apps) are not
+// stored in plain text. They are random, so they don't need to be hashed as
+// slowly as passwords.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
 }
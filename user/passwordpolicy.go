@@ -0,0 +1,310 @@
+package user
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ricxi/flat-list/shared/validation"
+)
+
+// bcryptMaxBytes is the number of bytes of a password that bcrypt uses,
+// any bytes after this are ignored when a password is hashed
+const bcryptMaxBytes = 72
+
+// personalInfoMinLength is the length a part of a user's email or
+// name must have before a password is rejected for containing it
+const personalInfoMinLength = 3
+
+// PasswordPolicy is the set of rules a new password must follow
+type PasswordPolicy struct {
+	// MinLength is the minimum number of characters
+	MinLength int
+	// MaxBytes is the maximum number of bytes, which can't be more than bcrypt's limit
+	MaxBytes      int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// Breached rejects passwords that have appeared in data breaches, if it is set
+	Breached BreachedPasswordChecker
+}
+
+// DefaultPasswordPolicy returns the policy that is used
+// when the validator is not given a different one
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength:    12,
+		MaxBytes:     bcryptMaxBytes,
+		RequireUpper: true,
+		RequireLower: true,
+		RequireDigit: true,
+	}
+}
+
+// LoadPasswordPolicy changes the default policy with any of the environment
+// variables PASSWORD_MIN_LENGTH, PASSWORD_MAX_BYTES, PASSWORD_REQUIRE_UPPER,
+// PASSWORD_REQUIRE_LOWER, PASSWORD_REQUIRE_DIGIT and PASSWORD_REQUIRE_SYMBOL
+// that are set. Breached passwords are rejected if BREACHED_PASSWORDS_DIR (a downloaded
+// copy of the range api) or BREACHED_PASSWORDS_API_URL (the range api itself) is set.
+func LoadPasswordPolicy() (PasswordPolicy, error) {
+	p := DefaultPasswordPolicy()
+
+	ints := map[string]*int{
+		"PASSWORD_MIN_LENGTH": &p.MinLength,
+		"PASSWORD_MAX_BYTES":  &p.MaxBytes,
+	}
+	for name, field := range ints {
+		if env := os.Getenv(name); env != "" {
+			n, err := strconv.Atoi(env)
+			if err != nil {
+				return p, fmt.Errorf("%s: %w", name, err)
+			}
+			*field = n
+		}
+	}
+
+	bools := map[string]*bool{
+		"PASSWORD_REQUIRE_UPPER":  &p.RequireUpper,
+		"PASSWORD_REQUIRE_LOWER":  &p.RequireLower,
+		"PASSWORD_REQUIRE_DIGIT":  &p.RequireDigit,
+		"PASSWORD_REQUIRE_SYMBOL": &p.RequireSymbol,
+	}
+	for name, field := range bools {
+		if env := os.Getenv(name); env != "" {
+			b, err := strconv.ParseBool(env)
+			if err != nil {
+				return p, fmt.Errorf("%s: %w", name, err)
+			}
+			*field = b
+		}
+	}
+
+	if p.MaxBytes <= 0 || p.MaxBytes > bcryptMaxBytes {
+		p.MaxBytes = bcryptMaxBytes
+	}
+
+	if dir := os.Getenv("BREACHED_PASSWORDS_DIR"); dir != "" {
+		breached, err := NewBreachedPasswordDir(dir)
+		if err != nil {
+			return p, err
+		}
+		p.Breached = breached
+	} else if rangeURL := os.Getenv("BREACHED_PASSWORDS_API_URL"); rangeURL != "" {
+		breached, err := NewBreachedPasswordAPI(rangeURL)
+		if err != nil {
+			return p, err
+		}
+		p.Breached = breached
+	}
+
+	return p, nil
+}
+
+// check adds a field error for every rule that a password breaks. The
+// personal values are the user's email and name, which it can't contain.
+func (p PasswordPolicy) check(vErr *validation.Error, field, password string, personal ...string) {
+	before := len(vErr.Fields)
+	addErr := func(code, message string) {
+		vErr.Add(field, code, message)
+	}
+
+	if n := utf8.RuneCountInString(password); n < p.MinLength {
+		addErr("too_short", fmt.Sprintf("%s must be at least %d characters long", field, p.MinLength))
+	}
+
+	maxBytes := p.MaxBytes
+	if maxBytes <= 0 || maxBytes > bcryptMaxBytes {
+		maxBytes = bcryptMaxBytes
+	}
+	if len(password) > maxBytes {
+		addErr("too_long", fmt.Sprintf("%s must be at most %d bytes long", field, maxBytes))
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+
+	if p.RequireUpper && !hasUpper {
+		addErr("missing_upper", fmt.Sprintf("%s must contain an uppercase letter", field))
+	}
+	if p.RequireLower && !hasLower {
+		addErr("missing_lower", fmt.Sprintf("%s must contain a lowercase letter", field))
+	}
+	if p.RequireDigit && !hasDigit {
+		addErr("missing_digit", fmt.Sprintf("%s must contain a digit", field))
+	}
+	if p.RequireSymbol && !hasSymbol {
+		addErr("missing_symbol", fmt.Sprintf("%s must contain a symbol", field))
+	}
+
+	if containsPersonalInfo(password, personal...) {
+		addErr("personal_info", fmt.Sprintf("%s must not contain your email or name", field))
+	}
+
+	// the breached list is only searched for passwords that pass every other rule
+	if len(vErr.Fields) == before && p.Breached != nil {
+		breached, err := p.Breached.IsBreached(password)
+		if err != nil {
+			// a password is not rejected if the list can't be searched
+			log.Println(err)
+		} else if breached {
+			addErr("breached", fmt.Sprintf("%s has appeared in a data breach, choose a different one", field))
+		}
+	}
+}
+
+// containsPersonalInfo checks if a password contains a user's email, the
+// part of their email before the @ or their name, ignoring case.
+func containsPersonalInfo(password string, personal ...string) bool {
+	password = strings.ToLower(password)
+
+	var parts []string
+	for _, value := range personal {
+		value = strings.ToLower(strings.TrimSpace(value))
+		parts = append(parts, value)
+		if local, _, ok := strings.Cut(value, "@"); ok {
+			parts = append(parts, local)
+		}
+	}
+
+	for _, part := range parts {
+		if utf8.RuneCountInString(part) >= personalInfoMinLength && strings.Contains(password, part) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// BreachedPasswordChecker checks if a password has appeared in a data breach
+type BreachedPasswordChecker interface {
+	IsBreached(password string) (bool, error)
+}
+
+// breachedPasswordPrefixLength is the number of hex characters of a password's
+// SHA-1 hash that are used to look up the hashes that start with them
+const breachedPasswordPrefixLength = 5
+
+// splitPasswordHash returns the prefix and the suffix of a password's SHA-1 hash in uppercase hex
+func splitPasswordHash(password string) (prefix, suffix string) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	return hash[:breachedPasswordPrefixLength], hash[breachedPasswordPrefixLength:]
+}
+
+// containsHashSuffix reads a range of breached password hashes that all start with the
+// same prefix, in the format of the Have I Been Pwned range api. Each line has the rest
+// of a hash after the prefix (35 hex characters) and a count (ie. SUFFIX:COUNT). Lines
+// with a count of 0 are padding, which is added so that the size of the response doesn't
+// give away the prefix, and are not breached passwords.
+func containsHashSuffix(r io.Reader, suffix string) (bool, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lineSuffix, count, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if strings.EqualFold(lineSuffix, suffix) && count != "0" {
+			return true, nil
+		}
+	}
+
+	return false, scanner.Err()
+}
+
+// breachedPasswordDir searches a copy of the Have I Been Pwned range api that has
+// been downloaded into a directory, with a file for each prefix (ie. 21BD1.txt),
+// so that only the hashes that share a password's prefix are read.
+type breachedPasswordDir struct {
+	dir string
+}
+
+// NewBreachedPasswordDir checks that the directory of breached password ranges can be read
+func NewBreachedPasswordDir(dir string) (BreachedPasswordChecker, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		return nil, fmt.Errorf("breached password ranges %s is not a directory", dir)
+	}
+
+	return &breachedPasswordDir{dir: dir}, nil
+}
+
+func (b *breachedPasswordDir) IsBreached(password string) (bool, error) {
+	prefix, suffix := splitPasswordHash(password)
+
+	f, err := os.Open(filepath.Join(b.dir, prefix+".txt"))
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	return containsHashSuffix(f, suffix)
+}
+
+// breachedPasswordAPI searches the Have I Been Pwned range api. Only the first
+// five characters of a password's hash are sent, and the response has every hash
+// that starts with them, so the api never learns which password was checked.
+type breachedPasswordAPI struct {
+	rangeURL string
+	client   *http.Client
+}
+
+// NewBreachedPasswordAPI receives the url that a prefix is added to
+// to get its range (ie. https://api.pwnedpasswords.com/range/)
+func NewBreachedPasswordAPI(rangeURL string) (BreachedPasswordChecker, error) {
+	if _, err := url.Parse(rangeURL); err != nil {
+		return nil, err
+	}
+
+	return &breachedPasswordAPI{
+		rangeURL: rangeURL,
+		client:   &http.Client{Timeout: 5 * time.Second},
+	}, nil
+}
+
+func (b *breachedPasswordAPI) IsBreached(password string) (bool, error) {
+	prefix, suffix := splitPasswordHash(password)
+
+	req, err := http.NewRequest(http.MethodGet, b.rangeURL+prefix, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Add-Padding", "true")
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("breached password range api responded with %s", resp.Status)
+	}
+
+	return containsHashSuffix(resp.Body, suffix)
+}
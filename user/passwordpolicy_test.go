@@ -0,0 +1,185 @@
+package user
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ricxi/flat-list/shared/validation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// breachedPasswordRanges groups the hashes of breached passwords by prefix,
+// in the same format as the Have I Been Pwned range api
+func breachedPasswordRanges(passwords ...string) map[string]string {
+	ranges := make(map[string]string)
+	for _, password := range passwords {
+		prefix, suffix := splitPasswordHash(password)
+		ranges[prefix] += suffix + ":42\r\n"
+	}
+
+	return ranges
+}
+
+// writeBreachedPasswordDir writes a file for each range of breached passwords
+func writeBreachedPasswordDir(t *testing.T, passwords ...string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for prefix, lines := range breachedPasswordRanges(passwords...) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, prefix+".txt"), []byte(lines), 0o600))
+	}
+
+	return dir
+}
+
+func TestBreachedPasswordDir(t *testing.T) {
+	breachedPasswords := []string{"password", "123456", "Tr0ub4dor&3", "qwertyuiop", "letmein", "dragon"}
+	dir := writeBreachedPasswordDir(t, breachedPasswords...)
+
+	// the range of a password that isn't breached can have other hashes
+	prefix, _ := splitPasswordHash("Password")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, prefix+".txt"), []byte(strings.Repeat("0", 35)+":3\r\n"), 0o600))
+
+	breached, err := NewBreachedPasswordDir(dir)
+	require.NoError(t, err)
+
+	for _, password := range breachedPasswords {
+		isBreached, err := breached.IsBreached(password)
+		require.NoError(t, err)
+		assert.True(t, isBreached, password)
+	}
+
+	isBreached, err := breached.IsBreached("Password")
+	require.NoError(t, err)
+	assert.False(t, isBreached)
+
+	_, err = NewBreachedPasswordDir(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
+
+func TestBreachedPasswordAPI(t *testing.T) {
+	ranges := breachedPasswordRanges("password")
+	var requestedPaths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestedPaths = append(requestedPaths, r.URL.Path)
+		assert.Equal(t, "true", r.Header.Get("Add-Padding"))
+
+		prefix := strings.TrimPrefix(r.URL.Path, "/range/")
+		// padding has a count of 0, and must not match any password
+		_, paddingSuffix := splitPasswordHash("correct horse battery staple")
+		w.Write([]byte(ranges[prefix] + paddingSuffix + ":0\r\n"))
+	}))
+	defer server.Close()
+
+	breached, err := NewBreachedPasswordAPI(server.URL + "/range/")
+	require.NoError(t, err)
+
+	isBreached, err := breached.IsBreached("password")
+	require.NoError(t, err)
+	assert.True(t, isBreached)
+
+	isBreached, err = breached.IsBreached("correct horse battery staple")
+	require.NoError(t, err)
+	assert.False(t, isBreached)
+
+	// only the prefix of the hash is sent
+	prefix, _ := splitPasswordHash("password")
+	if assert.Len(t, requestedPaths, 2) {
+		assert.Equal(t, "/range/"+prefix, requestedPaths[0])
+		assert.Len(t, strings.TrimPrefix(requestedPaths[1], "/range/"), breachedPasswordPrefixLength)
+	}
+}
+
+func TestValidatorNewPassword(t *testing.T) {
+	breached, err := NewBreachedPasswordDir(writeBreachedPasswordDir(t, "Summer2023!Summer"))
+	require.NoError(t, err)
+
+	policy := DefaultPasswordPolicy()
+	policy.RequireSymbol = true
+	policy.Breached = breached
+	v := NewValidator(WithPasswordPolicy(policy))
+
+	personal := []string{"michaelscott@dundermifflin.com", "Michael", "Scott"}
+
+	tests := []struct {
+		name     string
+		password string
+		expCodes []string
+	}{
+		{
+			name:     "Success",
+			password: "correct-Horse-battery-9",
+		},
+		{
+			name:     "FailMissingClasses",
+			password: "correcthorsebattery",
+			expCodes: []string{"missing_upper", "missing_digit", "missing_symbol"},
+		},
+		{
+			name:     "FailTooShort",
+			password: "Sh0rt!",
+			expCodes: []string{"too_short"},
+		},
+		{
+			name:     "FailTooLongForBcrypt",
+			password: "Aa1!" + strings.Repeat("x", bcryptMaxBytes),
+			expCodes: []string{"too_long"},
+		},
+		{
+			name:     "FailContainsName",
+			password: "I-am-MICHAEL-2005",
+			expCodes: []string{"personal_info"},
+		},
+		{
+			name:     "FailContainsEmailLocalPart",
+			password: "Michaelscott-1!x",
+			expCodes: []string{"personal_info"},
+		},
+		{
+			name:     "FailBreached",
+			password: "Summer2023!Summer",
+			expCodes: []string{"breached"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := v.NewPassword("password", tt.password, personal...)
+			if tt.expCodes == nil {
+				assert.NoError(t, err)
+				return
+			}
+
+			vErr, ok := validation.As(err)
+			require.True(t, ok)
+			assert.ErrorIs(t, err, ErrInvalidField)
+
+			var codes []string
+			for _, f := range vErr.Fields {
+				assert.Equal(t, "password", f.Field)
+				assert.NotEmpty(t, f.Message)
+				codes = append(codes, f.Code)
+			}
+			assert.Equal(t, tt.expCodes, codes)
+		})
+	}
+}
+
+func TestValidatorRegistrationPasswordPolicy(t *testing.T) {
+	v := NewValidator()
+
+	err := v.Registration(UserRegistrationInfo{
+		FirstName: "Michael",
+		LastName:  "Scott",
+		Email:     "michaelscott@dundermifflin.com",
+		Password:  "1234",
+	})
+
+	assert.ErrorIs(t, err, ErrInvalidField)
+	assert.EqualError(t, err, "password must be at least 12 characters long; password must contain an uppercase letter; password must contain a lowercase letter")
+}
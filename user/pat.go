@@ -0,0 +1,159 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ricxi/flat-list/shared/authz"
+	"github.com/ricxi/flat-list/shared/validation"
+)
+
+const (
+	// defaultPATExpiryDays is how long a personal access token lasts if the user doesn't say
+	defaultPATExpiryDays = 30
+	// maxPATExpiryDays is the longest that a personal access token can last
+	maxPATExpiryDays = 365
+	// maxPATNameLength is the longest name that a personal access token can have
+	maxPATNameLength = 100
+)
+
+// PersonalAccessToken is a token that a user creates to call the api from scripts,
+// without it being able to do anything outside of its scopes. Only the token
+// service has a hash of the token, so it can't be shown again after it is created.
+type PersonalAccessToken struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"-"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+}
+
+// NewPersonalAccessToken is returned when a token is created,
+// which is the only time that the token is returned
+type NewPersonalAccessToken struct {
+	Token string `json:"token"`
+	PersonalAccessToken
+}
+
+// PersonalAccessTokenInfo stores request data for creating a personal access token
+type PersonalAccessTokenInfo struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// ExpiresInDays is how many days the token lasts for, up
+	// to a year; it lasts for 30 days if it is not given
+	ExpiresInDays int `json:"expiresInDays"`
+}
+
+// PersonalAccessToken returns a validation.Error with every
+// field of a new personal access token that is missing or not valid
+func (v *validator) PersonalAccessToken(info PersonalAccessTokenInfo) error {
+	var vErr validation.Error
+
+	if info.Name == "" {
+		vErr.Required("name")
+	} else if len(info.Name) > maxPATNameLength {
+		vErr.Add("name", "too_long", fmt.Sprintf("name must be at most %d characters long", maxPATNameLength))
+	}
+
+	if len(info.Scopes) == 0 {
+		vErr.Required("scopes")
+	}
+	for _, scope := range info.Scopes {
+		if !isScope(scope) {
+			vErr.Add("scopes", "unknown_scope", fmt.Sprintf("%q is not a scope", scope))
+		}
+	}
+
+	if info.ExpiresInDays < 0 || info.ExpiresInDays > maxPATExpiryDays {
+		vErr.Add("expiresInDays", "out_of_range", fmt.Sprintf("expiresInDays must be between 1 and %d", maxPATExpiryDays))
+	}
+
+	return vErr.Err()
+}
+
+func isScope(scope string) bool {
+	for _, s := range authz.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// createPersonalAccessToken creates a token that a user can
+// call the task service with, within the scopes they choose
+func (s *service) createPersonalAccessToken(ctx context.Context, userID string, info PersonalAccessTokenInfo) (*NewPersonalAccessToken, error) {
+	if err := s.validate.PersonalAccessToken(info); err != nil {
+		return nil, err
+	}
+
+	if info.ExpiresInDays == 0 {
+		info.ExpiresInDays = defaultPATExpiryDays
+	}
+	expiresAt := time.Now().AddDate(0, 0, info.ExpiresInDays)
+
+	pat, err := s.token.CreatePersonalAccessToken(ctx, userID, info.Name, info.Scopes, expiresAt)
+	if err != nil {
+		if !errors.Is(err, ErrDuplicateTokenName) {
+			log.Println(err)
+		}
+		return nil, err
+	}
+
+	return pat, nil
+}
+
+// listPersonalAccessTokens returns a user's personal access tokens, newest first
+func (s *service) listPersonalAccessTokens(ctx context.Context, userID string) ([]PersonalAccessToken, error) {
+	pats, err := s.token.ListPersonalAccessTokens(ctx, userID)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	if pats == nil {
+		pats = []PersonalAccessToken{}
+	}
+
+	return pats, nil
+}
+
+// revokePersonalAccessToken deletes one of a user's personal
+// access tokens, so that it can't be used anymore
+func (s *service) revokePersonalAccessToken(ctx context.Context, userID, tokenID string) error {
+	return s.token.RevokePersonalAccessToken(ctx, userID, tokenID)
+}
+
+// authenticatePersonalAccessToken checks a personal access token in the same way
+// that authenticate checks a jwt. The principal that it returns can only be used
+// within the token's scopes, and has none of the user's roles.
+func (s *service) authenticatePersonalAccessToken(ctx context.Context, token string) (*authz.Principal, error) {
+	pat, err := s.token.ValidatePersonalAccessToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	uInfo, err := s.repository.getUserByID(ctx, pat.UserID)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, ErrInvalidPersonalAccessToken
+		}
+		return nil, err
+	}
+
+	if !uInfo.Activated {
+		return nil, ErrUserNotActivated
+	}
+
+	if uInfo.Deactivated {
+		return nil, ErrUserDeactivated
+	}
+
+	return &authz.Principal{UserID: uInfo.ID, TokenID: pat.ID, Scopes: pat.Scopes}, nil
+}
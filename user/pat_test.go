@@ -0,0 +1,84 @@
+package user
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ricxi/flat-list/shared/authz"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidator_PersonalAccessToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		info   PersonalAccessTokenInfo
+		expErr string
+	}{
+		{
+			name: "Success",
+			info: PersonalAccessTokenInfo{Name: "ci", Scopes: []string{authz.ScopeTasksRead}, ExpiresInDays: 7},
+		},
+		{
+			name:   "FailMissingFields",
+			info:   PersonalAccessTokenInfo{},
+			expErr: "missing field is required: name; missing field is required: scopes",
+		},
+		{
+			name:   "FailUnknownScope",
+			info:   PersonalAccessTokenInfo{Name: "ci", Scopes: []string{"users:write"}},
+			expErr: `"users:write" is not a scope`,
+		},
+		{
+			name:   "FailExpiryTooLong",
+			info:   PersonalAccessTokenInfo{Name: "ci", Scopes: []string{authz.ScopeTasksRead}, ExpiresInDays: maxPATExpiryDays + 1},
+			expErr: "expiresInDays must be between 1 and 365",
+		},
+	}
+
+	v := &validator{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := v.PersonalAccessToken(tt.info)
+			if tt.expErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tt.expErr)
+		})
+	}
+}
+
+func Test_Service_CreatePersonalAccessToken(t *testing.T) {
+	token := &mockTokenClient{}
+	s := &service{token: token, validate: &validator{}}
+
+	pat, err := s.createPersonalAccessToken(context.Background(), testUserID, PersonalAccessTokenInfo{Name: "ci", Scopes: []string{authz.ScopeTasksRead}})
+	require.NoError(t, err)
+	assert.Equal(t, "flpat_token", pat.Token)
+	assert.WithinDuration(t, time.Now().AddDate(0, 0, defaultPATExpiryDays), token.expiresAt, time.Minute)
+}
+
+func Test_Service_AuthenticatePersonalAccessToken(t *testing.T) {
+	repository := newAdminTestRepository()
+	pat := &PersonalAccessToken{ID: "a1b2c3d4e5f60718", UserID: testUserID, Scopes: []string{authz.ScopeTasksRead}}
+	s := &service{repository: repository, token: &mockTokenClient{pat: pat}}
+
+	principal, err := s.authenticatePersonalAccessToken(context.Background(), "flpat_token")
+	require.NoError(t, err)
+	assert.Equal(t, testUserID, principal.UserID)
+	assert.True(t, principal.HasScope(authz.ScopeTasksRead))
+	assert.False(t, principal.HasScope(authz.ScopeTasksWrite))
+
+	// the admin's roles are never given to their tokens
+	pat.UserID = testAdminID
+	principal, err = s.authenticatePersonalAccessToken(context.Background(), "flpat_token")
+	require.NoError(t, err)
+	assert.False(t, principal.HasRole(authz.RoleAdmin))
+
+	pat.UserID = testUserID
+	repository.users[testUserID].Deactivated = true
+	_, err = s.authenticatePersonalAccessToken(context.Background(), "flpat_token")
+	assert.ErrorIs(t, err, ErrUserDeactivated)
+}
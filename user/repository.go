@@ -1,14 +1,17 @@
 package user
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"regexp"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
@@ -18,15 +21,112 @@ import (
 type Repository interface {
 	createUser(ctx context.Context, user UserRegistrationInfo) (string, error)
 	getUserByEmail(ctx context.Context, email string) (*UserInfo, error)
-	updateUserByID(ctx context.Context, u UserInfo) error
+	updateUserByID(ctx context.Context, id string, u UserUpdate) error
 	getUserByID(ctx context.Context, id string) (*UserInfo, error)
+	deleteUserByID(ctx context.Context, id string) error
+	createDeletionJob(ctx context.Context, job DeletionJob) (string, error)
+	updateDeletionJob(ctx context.Context, job DeletionJob) error
+	getDueDeletionJobs(ctx context.Context, now time.Time) ([]DeletionJob, error)
+	createDataExport(ctx context.Context, export DataExport) (string, error)
+	updateDataExport(ctx context.Context, export DataExport) error
+	getDataExportByID(ctx context.Context, id string) (*DataExport, error)
+	deleteDataExportsByUserID(ctx context.Context, userID string) error
+	// claimDataExport returns a pending export that is due to be generated, and
+	// puts off its next attempt until leaseUntil so that no one else claims it
+	claimDataExport(ctx context.Context, now, leaseUntil time.Time) (*DataExport, error)
+	saveExportArchive(ctx context.Context, exportID string, archive []byte) error
+	openExportArchive(ctx context.Context, exportID string) (*ExportArchive, error)
+	useRecoveryCode(ctx context.Context, userID, codeHash string) error
+	useTwoFactorStep(ctx context.Context, userID string, step int64) error
+	getUserByIdentity(ctx context.Context, identity OIDCIdentity) (*UserInfo, error)
+	linkIdentity(ctx context.Context, userID string, identity OIDCIdentity) error
+	createOIDCLogin(ctx context.Context, login OIDCLogin) error
+	consumeOIDCLogin(ctx context.Context, state string) (*OIDCLogin, error)
+	searchUsers(ctx context.Context, s UserSearch) ([]UserInfo, int64, error)
+	createAuditLogEntry(ctx context.Context, entry AuditLogEntry) error
+	getDigestUsers(ctx context.Context, now time.Time) ([]UserInfo, error)
+	claimDigest(ctx context.Context, userID, date string) error
+	releaseDigest(ctx context.Context, userID, date, previousDate string) error
+}
+
+// emailCollation compares emails without case. Queries on emails must
+// use it, so that they can use the unique index that is created with it.
+var emailCollation = &options.Collation{Locale: "en", Strength: 2}
+
+// emailIndexName is the name of the unique index on users.email that ignores case
+const emailIndexName = "email_case_insensitive"
+
+// digestIndexName is the name of the index on users.digestEnabled, which
+// the digest job uses to find the users who want a daily digest
+const digestIndexName = "digest_enabled"
+
+// ttlIndexes are the indexes that delete documents once they have expired, by collection.
+// Without them, logins with an identity provider (which anyone can start) and expired
+// codes, tokens, exports and login attempts would be kept forever.
+var ttlIndexes = map[string]mongo.IndexModel{
+	"oidcLogins":    expiresAtIndex("expiresAt", 0),
+	"dataExports":   expiresAtIndex("expiresAt", 0),
+	"oauthCodes":    expiresAtIndex("expiresAt", 0),
+	"oauthTokens":   expiresAtIndex("expiresAt", 0),
+	"loginAttempts": expiresAtIndex("lastFailureAt", loginAttemptsExpiry),
+}
+
+// expiresAtIndex creates a ttl index that deletes a document after the time in a field
+func expiresAtIndex(field string, after time.Duration) mongo.IndexModel {
+	return mongo.IndexModel{
+		Keys:    bson.D{{Key: field, Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(after.Seconds())),
+	}
+}
+
+// EnsureIndexes creates the unique index on users.email that ignores case,
+// the index on users.digestEnabled and the ttl indexes. It fails if there are
+// users whose emails only differ by case, which can be found with the
+// emailmigration command (see cmd/emailmigration).
+func EnsureIndexes(ctx context.Context, client *mongo.Client, database string) error {
+	users := client.Database(database).Collection("users")
+
+	index := mongo.IndexModel{
+		Keys: bson.D{{Key: "email", Value: 1}},
+		Options: options.Index().
+			SetName(emailIndexName).
+			SetUnique(true).
+			SetCollation(emailCollation),
+	}
+
+	if _, err := users.Indexes().CreateOne(ctx, index); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("%w: run the emailmigration command to find them", ErrDuplicateEmails)
+		}
+		return err
+	}
+
+	digestIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "digestEnabled", Value: 1}},
+		Options: options.Index().SetName(digestIndexName),
+	}
+	if _, err := users.Indexes().CreateOne(ctx, digestIndex); err != nil {
+		return err
+	}
+
+	for collection, ttlIndex := range ttlIndexes {
+		if _, err := client.Database(database).Collection(collection).Indexes().CreateOne(ctx, ttlIndex); err != nil {
+			return fmt.Errorf("%s: %w", collection, err)
+		}
+	}
+
+	return nil
 }
 
 // repository implements Repository interface
 type repository struct {
-	client   *mongo.Client
-	database string
-	coll     *mongo.Collection
+	client       *mongo.Client
+	database     string
+	coll         *mongo.Collection
+	deletionJobs *mongo.Collection
+	dataExports  *mongo.Collection
+	oidcLogins   *mongo.Collection
+	auditLog     *mongo.Collection
 }
 
 func NewMongoClient(uri string, timeout int) (*mongo.Client, error) {
@@ -49,11 +149,19 @@ func NewMongoClient(uri string, timeout int) (*mongo.Client, error) {
 // Create a new user repository with the mongo client and database name
 func NewRepository(client *mongo.Client, database string) Repository {
 	usersCollection := client.Database(database).Collection("users")
+	deletionJobsCollection := client.Database(database).Collection("deletionJobs")
+	dataExportsCollection := client.Database(database).Collection("dataExports")
+	oidcLoginsCollection := client.Database(database).Collection("oidcLogins")
+	auditLogCollection := client.Database(database).Collection("auditLog")
 
 	m := repository{
-		client:   client,
-		database: database,
-		coll:     usersCollection,
+		client:       client,
+		database:     database,
+		coll:         usersCollection,
+		deletionJobs: deletionJobsCollection,
+		dataExports:  dataExportsCollection,
+		oidcLogins:   oidcLoginsCollection,
+		auditLog:     auditLogCollection,
 	}
 
 	return &m
@@ -65,10 +173,12 @@ func (r *repository) createUser(ctx context.Context, u UserRegistrationInfo) (st
 		FirstName:      u.FirstName,
 		LastName:       u.LastName,
 		Email:          u.Email,
+		Locale:         u.Locale,
 		HashedPassword: u.HashedPassword,
 		Activated:      u.Activated,
 		CreatedAt:      u.CreatedAt,
 		UpdatedAt:      u.UpdatedAt,
+		Identities:     newIdentityDocuments(u.Identities),
 	}
 	result, err := r.coll.InsertOne(ctx, &userDocument)
 	if err != nil {
@@ -81,27 +191,20 @@ func (r *repository) createUser(ctx context.Context, u UserRegistrationInfo) (st
 	return result.InsertedID.(primitive.ObjectID).Hex(), nil
 }
 
-// getUserByEmail Queries a user with their email
+// getUserByEmail Queries a user with their email. It ignores case, so that
+// users whose emails were stored before they were normalised are still found.
 func (r *repository) getUserByEmail(ctx context.Context, email string) (*UserInfo, error) {
 	var userDocument UserDocument
 	filter := bson.M{"email": email}
-	if err := r.coll.FindOne(ctx, filter).Decode(&userDocument); err != nil {
+	opts := options.FindOne().SetCollation(emailCollation)
+	if err := r.coll.FindOne(ctx, filter, opts).Decode(&userDocument); err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
 			return nil, fmt.Errorf("%w by email", ErrUserNotFound)
 		}
 		return nil, err
 	}
 
-	return &UserInfo{
-		ID:             userDocument.OID.Hex(),
-		FirstName:      userDocument.FirstName,
-		LastName:       userDocument.LastName,
-		Email:          userDocument.Email,
-		HashedPassword: userDocument.HashedPassword,
-		Activated:      userDocument.Activated,
-		CreatedAt:      userDocument.CreatedAt,
-		UpdatedAt:      userDocument.UpdatedAt,
-	}, nil
+	return newUserInfo(&userDocument), nil
 }
 
 func (r *repository) getUserByID(ctx context.Context, id string) (*UserInfo, error) {
@@ -118,40 +221,676 @@ func (r *repository) getUserByID(ctx context.Context, id string) (*UserInfo, err
 		return nil, err
 	}
 
+	return newUserInfo(&userDocument), nil
+}
+
+// updateUserByID sets the fields of a user that are not nil in the update
+func (r *repository) updateUserByID(ctx context.Context, id string, u UserUpdate) error {
+	userOID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"_id": userOID}
+	update := bson.M{
+		"$set": &UserUpdateDocument{
+			FirstName:      u.FirstName,
+			LastName:       u.LastName,
+			Email:          u.Email,
+			PendingEmail:   u.PendingEmail,
+			Locale:         u.Locale,
+			HashedPassword: u.HashedPassword,
+			Activated:      u.Activated,
+			Deactivated:    u.Deactivated,
+			UpdatedAt:      u.UpdatedAt,
+
+			TwoFactorEnabled:       u.TwoFactorEnabled,
+			TwoFactorSecret:        u.TwoFactorSecret,
+			TwoFactorPendingSecret: u.TwoFactorPendingSecret,
+			TwoFactorLastStep:      u.TwoFactorLastStep,
+			RecoveryCodeHashes:     u.RecoveryCodeHashes,
+
+			DigestEnabled: u.DigestEnabled,
+			Timezone:      u.Timezone,
+			DigestHour:    u.DigestHour,
+		},
+	}
+	result := r.coll.FindOneAndUpdate(ctx, filter, update)
+	if err := result.Err(); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return fmt.Errorf("unable to update by id: %w", ErrUserNotFound)
+		}
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrDuplicateUser
+		}
+		return err
+	}
+
+	return nil
+}
+
+// deleteUserByID removes a user's document
+func (r *repository) deleteUserByID(ctx context.Context, id string) error {
+	userOID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	result, err := r.coll.DeleteOne(ctx, bson.M{"_id": userOID})
+	if err != nil {
+		return err
+	}
+
+	// the mongo driver does not return 'mongo.ErrNoDocuments' if nothing is deleted
+	if result.DeletedCount == 0 {
+		return fmt.Errorf("unable to delete by id: %w", ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// createDeletionJob stores a new deletion job and returns its id
+func (r *repository) createDeletionJob(ctx context.Context, job DeletionJob) (string, error) {
+	jobDocument := newDeletionJobDocument(job)
+	result, err := r.deletionJobs.InsertOne(ctx, &jobDocument)
+	if err != nil {
+		return "", err
+	}
+
+	return result.InsertedID.(primitive.ObjectID).Hex(), nil
+}
+
+// updateDeletionJob replaces a deletion job with its latest progress
+func (r *repository) updateDeletionJob(ctx context.Context, job DeletionJob) error {
+	jobOID, err := primitive.ObjectIDFromHex(job.ID)
+	if err != nil {
+		return err
+	}
+
+	jobDocument := newDeletionJobDocument(job)
+	jobDocument.OID = jobOID
+
+	result, err := r.deletionJobs.ReplaceOne(ctx, bson.M{"_id": jobOID}, &jobDocument)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("deletion job %s was not found", job.ID)
+	}
+
+	return nil
+}
+
+// getDueDeletionJobs returns the deletion jobs that
+// have not been completed and are due to be retried
+func (r *repository) getDueDeletionJobs(ctx context.Context, now time.Time) ([]DeletionJob, error) {
+	filter := bson.M{
+		"completed":     false,
+		"nextAttemptAt": bson.M{"$lte": now},
+	}
+
+	cursor, err := r.deletionJobs.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var jobDocuments []DeletionJobDocument
+	if err := cursor.All(ctx, &jobDocuments); err != nil {
+		return nil, err
+	}
+
+	jobs := make([]DeletionJob, 0, len(jobDocuments))
+	for _, jobDocument := range jobDocuments {
+		jobs = append(jobs, DeletionJob{
+			ID:            jobDocument.OID.Hex(),
+			UserID:        jobDocument.UserID,
+			UserDeleted:   jobDocument.UserDeleted,
+			TasksDeleted:  jobDocument.TasksDeleted,
+			TokensDeleted: jobDocument.TokensDeleted,
+			Attempts:      jobDocument.Attempts,
+			LastError:     jobDocument.LastError,
+			NextAttemptAt: jobDocument.NextAttemptAt,
+			CreatedAt:     jobDocument.CreatedAt,
+			UpdatedAt:     jobDocument.UpdatedAt,
+		})
+	}
+
+	return jobs, nil
+}
+
+func newDeletionJobDocument(job DeletionJob) DeletionJobDocument {
+	return DeletionJobDocument{
+		UserID:        job.UserID,
+		UserDeleted:   job.UserDeleted,
+		TasksDeleted:  job.TasksDeleted,
+		TokensDeleted: job.TokensDeleted,
+		Completed:     job.completed(),
+		Attempts:      job.Attempts,
+		LastError:     job.LastError,
+		NextAttemptAt: job.NextAttemptAt,
+		CreatedAt:     job.CreatedAt,
+		UpdatedAt:     job.UpdatedAt,
+	}
+}
+
+// useRecoveryCode removes one of a user's recovery codes, so that it can only be
+// used once. It returns ErrInvalidTwoFactorCode if the user does not have the code.
+func (r *repository) useRecoveryCode(ctx context.Context, userID, codeHash string) error {
+	userOID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"_id": userOID, "recoveryCodeHashes": codeHash}
+	update := bson.M{"$pull": bson.M{"recoveryCodeHashes": codeHash}}
+
+	result, err := r.coll.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if result.ModifiedCount == 0 {
+		return ErrInvalidTwoFactorCode
+	}
+
+	return nil
+}
+
+// useTwoFactorStep records the time step of the last code that a user logged in with.
+// It only matches if the step is later than the one that is stored, so a code can't be
+// used twice even if it is sent more than once at the same time, and it returns
+// ErrInvalidTwoFactorCode if it is not.
+func (r *repository) useTwoFactorStep(ctx context.Context, userID string, step int64) error {
+	userOID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+
+	// the step is not stored until the user has used a code
+	filter := bson.M{
+		"_id": userOID,
+		"$or": bson.A{
+			bson.M{"twoFactorLastStep": bson.M{"$lt": step}},
+			bson.M{"twoFactorLastStep": bson.M{"$exists": false}},
+		},
+	}
+	update := bson.M{"$set": bson.M{"twoFactorLastStep": step}}
+
+	result, err := r.coll.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrInvalidTwoFactorCode
+	}
+
+	return nil
+}
+
+// getUserByIdentity finds the user who is linked to an account with an identity provider
+func (r *repository) getUserByIdentity(ctx context.Context, identity OIDCIdentity) (*UserInfo, error) {
+	filter := bson.M{"identities": bson.M{"$elemMatch": bson.M{
+		"provider": identity.Provider,
+		"subject":  identity.Subject,
+	}}}
+
+	var userDocument UserDocument
+	if err := r.coll.FindOne(ctx, filter).Decode(&userDocument); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("%w by identity", ErrUserNotFound)
+		}
+		return nil, err
+	}
+
+	return newUserInfo(&userDocument), nil
+}
+
+// linkIdentity lets a user log in with an account from an identity provider
+func (r *repository) linkIdentity(ctx context.Context, userID string, identity OIDCIdentity) error {
+	userOID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+
+	update := bson.M{"$addToSet": bson.M{"identities": IdentityDocument{
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+	}}}
+
+	result, err := r.coll.UpdateOne(ctx, bson.M{"_id": userOID}, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+func (r *repository) createOIDCLogin(ctx context.Context, login OIDCLogin) error {
+	loginDocument := OIDCLoginDocument{
+		State:        login.State,
+		Provider:     login.Provider,
+		Nonce:        login.Nonce,
+		CodeVerifier: login.CodeVerifier,
+		ExpiresAt:    login.ExpiresAt,
+		Reauth:       login.Reauth,
+	}
+
+	_, err := r.oidcLogins.InsertOne(ctx, &loginDocument)
+	return err
+}
+
+// consumeOIDCLogin finds and deletes a login in one step, so that it can only be used once
+func (r *repository) consumeOIDCLogin(ctx context.Context, state string) (*OIDCLogin, error) {
+	var loginDocument OIDCLoginDocument
+	if err := r.oidcLogins.FindOneAndDelete(ctx, bson.M{"_id": state}).Decode(&loginDocument); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrInvalidOIDCState
+		}
+		return nil, err
+	}
+
+	return &OIDCLogin{
+		State:        loginDocument.State,
+		Provider:     loginDocument.Provider,
+		Nonce:        loginDocument.Nonce,
+		CodeVerifier: loginDocument.CodeVerifier,
+		ExpiresAt:    loginDocument.ExpiresAt,
+		Reauth:       loginDocument.Reauth,
+	}, nil
+}
+
+func newIdentityDocuments(identities []OIDCIdentity) []IdentityDocument {
+	var identityDocuments []IdentityDocument
+	for _, identity := range identities {
+		identityDocuments = append(identityDocuments, IdentityDocument{
+			Provider: identity.Provider,
+			Subject:  identity.Subject,
+		})
+	}
+
+	return identityDocuments
+}
+
+// createDataExport stores a new data export and returns its id
+func (r *repository) createDataExport(ctx context.Context, export DataExport) (string, error) {
+	exportDocument := newDataExportDocument(export)
+	result, err := r.dataExports.InsertOne(ctx, &exportDocument)
+	if err != nil {
+		return "", err
+	}
+
+	return result.InsertedID.(primitive.ObjectID).Hex(), nil
+}
+
+// updateDataExport replaces a data export, which is done when it has been generated
+func (r *repository) updateDataExport(ctx context.Context, export DataExport) error {
+	exportOID, err := primitive.ObjectIDFromHex(export.ID)
+	if err != nil {
+		return err
+	}
+
+	exportDocument := newDataExportDocument(export)
+	exportDocument.OID = exportOID
+
+	result, err := r.dataExports.ReplaceOne(ctx, bson.M{"_id": exportOID}, &exportDocument)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrExportNotFound
+	}
+
+	return nil
+}
+
+func (r *repository) getDataExportByID(ctx context.Context, id string) (*DataExport, error) {
+	exportOID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrExportNotFound
+	}
+
+	var exportDocument DataExportDocument
+	if err := r.dataExports.FindOne(ctx, bson.M{"_id": exportOID}).Decode(&exportDocument); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrExportNotFound
+		}
+		return nil, err
+	}
+
+	return newDataExport(&exportDocument), nil
+}
+
+// deleteDataExportsByUserID removes every data export of a user and their archives
+func (r *repository) deleteDataExportsByUserID(ctx context.Context, userID string) error {
+	cursor, err := r.dataExports.Find(ctx, bson.M{"userId": userID}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+
+	var exportDocuments []DataExportDocument
+	if err := cursor.All(ctx, &exportDocuments); err != nil {
+		return err
+	}
+
+	bucket, err := r.exportArchives()
+	if err != nil {
+		return err
+	}
+
+	// the archives are deleted first, so that they can't be
+	// left behind without an export if this has to be retried
+	for _, exportDocument := range exportDocuments {
+		if err := bucket.DeleteContext(ctx, exportDocument.OID); err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+			return err
+		}
+	}
+
+	_, err = r.dataExports.DeleteMany(ctx, bson.M{"userId": userID})
+	return err
+}
+
+func (r *repository) claimDataExport(ctx context.Context, now, leaseUntil time.Time) (*DataExport, error) {
+	filter := bson.M{
+		"status":        string(ExportPending),
+		"nextAttemptAt": bson.M{"$lte": now},
+	}
+	update := bson.M{"$set": bson.M{"nextAttemptAt": leaseUntil}}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "nextAttemptAt", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var exportDocument DataExportDocument
+	if err := r.dataExports.FindOneAndUpdate(ctx, filter, update, opts).Decode(&exportDocument); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrExportNotFound
+		}
+		return nil, err
+	}
+
+	return newDataExport(&exportDocument), nil
+}
+
+// saveExportArchive stores the archive of an export in gridfs, with the export's id as its file id.
+// An archive that was stored by an earlier attempt that failed afterwards is replaced.
+func (r *repository) saveExportArchive(ctx context.Context, exportID string, archive []byte) error {
+	exportOID, err := primitive.ObjectIDFromHex(exportID)
+	if err != nil {
+		return err
+	}
+
+	bucket, err := r.exportArchives()
+	if err != nil {
+		return err
+	}
+
+	if err := bucket.DeleteContext(ctx, exportOID); err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+		return err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := bucket.SetWriteDeadline(deadline); err != nil {
+			return err
+		}
+	}
+
+	return bucket.UploadFromStreamWithID(exportOID, exportID+".zip", bytes.NewReader(archive))
+}
+
+// openExportArchive opens the archive of an export to stream it to the user.
+// The caller must close it.
+func (r *repository) openExportArchive(ctx context.Context, exportID string) (*ExportArchive, error) {
+	exportOID, err := primitive.ObjectIDFromHex(exportID)
+	if err != nil {
+		return nil, ErrExportNotFound
+	}
+
+	bucket, err := r.exportArchives()
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := bucket.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+
+	stream, err := bucket.OpenDownloadStream(exportOID)
+	if err != nil {
+		if errors.Is(err, gridfs.ErrFileNotFound) {
+			return nil, ErrExportNotFound
+		}
+		return nil, err
+	}
+
+	return &ExportArchive{ReadCloser: stream, Size: stream.GetFile().Length}, nil
+}
+
+// exportArchives returns the gridfs bucket that export archives are stored in.
+// A new one is created every time, because a bucket can't be used concurrently.
+func (r *repository) exportArchives() (*gridfs.Bucket, error) {
+	return gridfs.NewBucket(r.client.Database(r.database), options.GridFSBucket().SetName("exportArchives"))
+}
+
+func newDataExportDocument(export DataExport) DataExportDocument {
+	return DataExportDocument{
+		UserID:            export.UserID,
+		Status:            string(export.Status),
+		Error:             export.Error,
+		DownloadTokenHash: export.DownloadTokenHash,
+		Attempts:          export.Attempts,
+		NextAttemptAt:     export.NextAttemptAt,
+		CreatedAt:         export.CreatedAt,
+		CompletedAt:       export.CompletedAt,
+		ExpiresAt:         export.ExpiresAt,
+	}
+}
+
+func newDataExport(exportDocument *DataExportDocument) *DataExport {
+	return &DataExport{
+		ID:                exportDocument.OID.Hex(),
+		UserID:            exportDocument.UserID,
+		Status:            ExportStatus(exportDocument.Status),
+		Error:             exportDocument.Error,
+		DownloadTokenHash: exportDocument.DownloadTokenHash,
+		Attempts:          exportDocument.Attempts,
+		NextAttemptAt:     exportDocument.NextAttemptAt,
+		CreatedAt:         exportDocument.CreatedAt,
+		CompletedAt:       exportDocument.CompletedAt,
+		ExpiresAt:         exportDocument.ExpiresAt,
+	}
+}
+
+// newUserInfo converts a user document from mongo into a UserInfo
+func newUserInfo(userDocument *UserDocument) *UserInfo {
 	return &UserInfo{
 		ID:             userDocument.OID.Hex(),
 		FirstName:      userDocument.FirstName,
 		LastName:       userDocument.LastName,
 		Email:          userDocument.Email,
+		PendingEmail:   userDocument.PendingEmail,
+		Locale:         userDocument.Locale,
 		HashedPassword: userDocument.HashedPassword,
 		Activated:      userDocument.Activated,
+		Deactivated:    userDocument.Deactivated,
+		Roles:          userDocument.Roles,
 		CreatedAt:      userDocument.CreatedAt,
 		UpdatedAt:      userDocument.UpdatedAt,
-	}, nil
+
+		TwoFactorEnabled:       userDocument.TwoFactorEnabled,
+		TwoFactorSecret:        userDocument.TwoFactorSecret,
+		TwoFactorPendingSecret: userDocument.TwoFactorPendingSecret,
+		TwoFactorLastStep:      userDocument.TwoFactorLastStep,
+		RecoveryCodeHashes:     userDocument.RecoveryCodeHashes,
+		Identities:             newIdentities(userDocument.Identities),
+
+		DigestSettings: DigestSettings{
+			DigestEnabled: userDocument.DigestEnabled,
+			Timezone:      userDocument.Timezone,
+			DigestHour:    userDocument.DigestHour,
+		},
+		DigestLastSentOn: userDocument.DigestLastSentOn,
+	}
+}
+
+func newIdentities(identityDocuments []IdentityDocument) []OIDCIdentity {
+	var identities []OIDCIdentity
+	for _, identityDocument := range identityDocuments {
+		identities = append(identities, OIDCIdentity{
+			Provider: identityDocument.Provider,
+			Subject:  identityDocument.Subject,
+		})
+	}
+
+	return identities
 }
 
-// updateUserByID updates a user's info based on their id
-// ! It's currently only set up to update a user's activation status, but this will change
-func (r *repository) updateUserByID(ctx context.Context, u UserInfo) error {
-	userOID, err := primitive.ObjectIDFromHex(u.ID)
+// searchUsers returns a page of the users that match a search, newest
+// first, and the number of users that match it across every page
+func (r *repository) searchUsers(ctx context.Context, s UserSearch) ([]UserInfo, int64, error) {
+	filter := bson.M{}
+	if s.Query != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(s.Query), Options: "i"}
+		filter["$or"] = bson.A{
+			bson.M{"email": pattern},
+			bson.M{"firstName": pattern},
+			bson.M{"lastName": pattern},
+		}
+	}
+	if s.Role != "" {
+		filter["roles"] = s.Role
+	}
+
+	total, err := r.coll.CountDocuments(ctx, filter)
 	if err != nil {
-		return err
+		return nil, 0, err
 	}
 
-	filter := bson.M{"_id": userOID}
-	update := bson.M{
-		"$set": bson.M{
-			"activated": u.Activated,
-			"updatedAt": u.UpdatedAt,
-		},
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64(s.Offset)).
+		SetLimit(int64(s.Limit))
+
+	cursor, err := r.coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
 	}
-	result := r.coll.FindOneAndUpdate(ctx, filter, update)
-	if err := result.Err(); err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
-			return fmt.Errorf("unable to update by id: %w", ErrUserNotFound)
+	defer cursor.Close(ctx)
+
+	var users []UserInfo
+	for cursor.Next(ctx) {
+		var userDocument UserDocument
+		if err := cursor.Decode(&userDocument); err != nil {
+			return nil, 0, err
 		}
+		users = append(users, *newUserInfo(&userDocument))
+	}
+
+	return users, total, cursor.Err()
+}
+
+// createAuditLogEntry stores an action that an admin took
+func (r *repository) createAuditLogEntry(ctx context.Context, entry AuditLogEntry) error {
+	_, err := r.auditLog.InsertOne(ctx, newAuditLogDocument(entry))
+	return err
+}
+
+func newAuditLogDocument(entry AuditLogEntry) AuditLogDocument {
+	return AuditLogDocument{
+		ActorID:   entry.ActorID,
+		Action:    entry.Action,
+		TargetID:  entry.TargetID,
+		Reason:    entry.Reason,
+		IPAddress: entry.IPAddress,
+		CreatedAt: entry.CreatedAt,
+	}
+}
+
+// digestTimezone is a user's timezone in the digest query. Mongo rejects an empty
+// timezone (and Go's "Local"), which users could have stored before they were
+// turned away, so they are treated as UTC like sendDigest does.
+var digestTimezone = bson.M{"$cond": bson.A{
+	bson.M{"$in": bson.A{bson.M{"$ifNull": bson.A{"$timezone", ""}}, bson.A{"", "Local"}}},
+	"UTC",
+	"$timezone",
+}}
+
+// getDigestUsers returns every active user who has turned on daily digests, and hasn't been sent
+// one today where they are. Today is worked out in each user's timezone (which is UTC if it is empty).
+func (r *repository) getDigestUsers(ctx context.Context, now time.Time) ([]UserInfo, error) {
+	today := bson.M{"$dateToString": bson.M{
+		"format":   "%Y-%m-%d",
+		"date":     now,
+		"timezone": digestTimezone,
+	}}
+	filter := bson.M{
+		"digestEnabled": true,
+		"activated":     true,
+		"deactivated":   bson.M{"$ne": true},
+		"$expr":         bson.M{"$ne": bson.A{"$digestLastSentOn", today}},
+	}
+	cursor, err := r.coll.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var userDocuments []UserDocument
+	if err := cursor.All(ctx, &userDocuments); err != nil {
+		return nil, err
+	}
+
+	users := make([]UserInfo, 0, len(userDocuments))
+	for i := range userDocuments {
+		users = append(users, *newUserInfo(&userDocuments[i]))
+	}
+
+	return users, nil
+}
+
+// claimDigest records that a user's digest is being sent for a date. It only succeeds
+// once for each date, so that the digest is not sent twice if the job restarts
+// (or by more than one instance of this service), and returns ErrDigestAlreadySent after that.
+func (r *repository) claimDigest(ctx context.Context, userID, date string) error {
+	userOID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"_id": userOID, "digestLastSentOn": bson.M{"$ne": date}}
+	update := bson.M{"$set": bson.M{"digestLastSentOn": date}}
+	result, err := r.coll.UpdateOne(ctx, filter, update)
+	if err != nil {
 		return err
 	}
 
+	if result.MatchedCount == 0 {
+		return ErrDigestAlreadySent
+	}
+
 	return nil
 }
+
+// releaseDigest undoes claimDigest when the digest could not be sent, so that it is tried again
+func (r *repository) releaseDigest(ctx context.Context, userID, date, previousDate string) error {
+	userOID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+
+	update := bson.M{"$set": bson.M{"digestLastSentOn": previousDate}}
+	if previousDate == "" {
+		update = bson.M{"$unset": bson.M{"digestLastSentOn": ""}}
+	}
+
+	_, err = r.coll.UpdateOne(ctx, bson.M{"_id": userOID, "digestLastSentOn": date}, update)
+	return err
+}
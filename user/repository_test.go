@@ -0,0 +1,89 @@
+package user
+
+import (
+	"context"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// setupRepo connects to mongo and returns a repository that uses a new database,
+// and a function that drops it. It requires a mongodb server to be running and its
+// uri to be set with the environment variable 'MONGODB_URI', and skips the test otherwise.
+func setupRepo(t testing.TB) (*repository, func()) {
+	uri := os.Getenv("MONGODB_URI")
+	if uri == "" {
+		t.Skip("MONGODB_URI is not set")
+	}
+
+	client, err := NewMongoClient(uri, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dbname := uuid.New().String()
+	r := NewRepository(client, dbname).(*repository)
+
+	return r, func() {
+		if err := client.Database(dbname).Drop(context.Background()); err != nil {
+			log.Println("unable to drop database", err)
+		}
+		if err := client.Disconnect(context.Background()); err != nil {
+			log.Println("problem disconnecting from mongo", err)
+		}
+	}
+}
+
+func TestRepositoryGetDigestUsers(t *testing.T) {
+	r, teardown := setupRepo(t)
+	defer teardown()
+
+	// 07:30 in UTC, which is 09:30 in Paris
+	now := time.Date(2023, 5, 2, 7, 30, 0, 0, time.UTC)
+	_, err := r.coll.InsertMany(context.Background(), []any{
+		// users who cleared their timezone before it was stored as UTC have an empty one
+		bson.M{"email": "michaelscott@dundermifflin.com", "activated": true, "digestEnabled": true, "timezone": ""},
+		bson.M{"email": "dwightschrute@dundermifflin.com", "activated": true, "digestEnabled": true, "timezone": "Europe/Paris"},
+		bson.M{"email": "jimhalpert@dundermifflin.com", "activated": true, "digestEnabled": true, "timezone": "Europe/Paris", "digestLastSentOn": "2023-05-02"},
+		bson.M{"email": "pambeesly@dundermifflin.com", "activated": true, "digestEnabled": false},
+	})
+	require.NoError(t, err)
+
+	users, err := r.getDigestUsers(context.Background(), now)
+	require.NoError(t, err)
+
+	emails := make([]string, 0, len(users))
+	for _, u := range users {
+		emails = append(emails, u.Email)
+	}
+	assert.ElementsMatch(t, []string{"michaelscott@dundermifflin.com", "dwightschrute@dundermifflin.com"}, emails)
+}
+
+func TestEnsureIndexesTTL(t *testing.T) {
+	r, teardown := setupRepo(t)
+	defer teardown()
+
+	require.NoError(t, EnsureIndexes(context.Background(), r.client, r.database))
+
+	for collection := range ttlIndexes {
+		cursor, err := r.client.Database(r.database).Collection(collection).Indexes().List(context.Background())
+		require.NoError(t, err)
+
+		var indexes []bson.M
+		require.NoError(t, cursor.All(context.Background(), &indexes))
+
+		var hasTTL bool
+		for _, index := range indexes {
+			if _, ok := index["expireAfterSeconds"]; ok {
+				hasTTL = true
+			}
+		}
+		assert.True(t, hasTTL, "%s has no ttl index", collection)
+	}
+}
@@ -7,6 +7,7 @@ import (
 
 	"time"
 
+	"github.com/ricxi/flat-list/shared/authz"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -15,7 +16,46 @@ type Service interface {
 	loginUser(ctx context.Context, user UserLoginInfo) (*UserInfo, error)
 	activateUser(ctx context.Context, activationToken string) error
 	restartActivation(ctx context.Context, u UserLoginInfo) error
-	authenticate(ctx context.Context, signedJWT string) (string, error)
+	authenticate(ctx context.Context, signedJWT string) (*authz.Principal, error)
+	getProfile(ctx context.Context, userID string) (*UserInfo, error)
+	updateProfile(ctx context.Context, userID string, p ProfileUpdate) (*UserInfo, error)
+	changePassword(ctx context.Context, userID string, p PasswordChangeInfo) error
+	requestEmailChange(ctx context.Context, userID string, e EmailChangeInfo) error
+	confirmEmailChange(ctx context.Context, token string) error
+	deleteAccount(ctx context.Context, userID string, d AccountDeletionInfo) (*DeletionJob, error)
+	retryDeletionJobs(ctx context.Context) error
+	sendDigests(ctx context.Context, now time.Time) error
+	exportData(ctx context.Context, userID string) (*DataExport, error)
+	getDataExport(ctx context.Context, userID, exportID string) (*DataExport, error)
+	runDataExports(ctx context.Context) error
+	downloadDataExport(ctx context.Context, exportID, downloadToken string) (*ExportArchive, error)
+	enrollTwoFactor(ctx context.Context, userID string) (*TwoFactorEnrollment, error)
+	confirmTwoFactor(ctx context.Context, userID string, c TwoFactorCode) ([]string, error)
+	disableTwoFactor(ctx context.Context, userID string, p PasswordConfirmation) error
+	regenerateRecoveryCodes(ctx context.Context, userID string, p PasswordConfirmation) ([]string, error)
+	loginTwoFactor(ctx context.Context, l TwoFactorLoginInfo) (*UserInfo, error)
+	startOIDCLogin(ctx context.Context, provider string, reauth bool) (string, string, error)
+	finishOIDCLogin(ctx context.Context, c OIDCCallbackInfo) (*UserInfo, error)
+	searchUsers(ctx context.Context, s UserSearch) (*UserSearchResult, error)
+	deactivateUser(ctx context.Context, a AdminAction) error
+	reactivateUser(ctx context.Context, a AdminAction) error
+	resendActivation(ctx context.Context, a AdminAction) error
+	impersonateUser(ctx context.Context, a AdminAction) (*Impersonation, error)
+	createPersonalAccessToken(ctx context.Context, userID string, info PersonalAccessTokenInfo) (*NewPersonalAccessToken, error)
+	listPersonalAccessTokens(ctx context.Context, userID string) ([]PersonalAccessToken, error)
+	revokePersonalAccessToken(ctx context.Context, userID, tokenID string) error
+	authenticatePersonalAccessToken(ctx context.Context, token string) (*authz.Principal, error)
+	registerOAuthClient(ctx context.Context, ownerID string, info OAuthClientInfo) (*NewOAuthClient, error)
+	listOAuthClients(ctx context.Context, ownerID string) ([]OAuthClient, error)
+	deleteOAuthClient(ctx context.Context, ownerID, clientID string) error
+	getAuthorizationPrompt(ctx context.Context, userID string, a AuthorizationRequest) (*AuthorizationPrompt, error)
+	authorize(ctx context.Context, userID string, a AuthorizationRequest, approved bool) (string, error)
+	exchangeOAuthToken(ctx context.Context, t OAuthTokenRequest) (*OAuthTokenResponse, error)
+	introspectOAuthToken(ctx context.Context, c OAuthClientCredentials, token string) (*OAuthTokenIntrospection, error)
+	revokeOAuthToken(ctx context.Context, c OAuthClientCredentials, token string) error
+	listOAuthConsents(ctx context.Context, userID string) ([]OAuthConsent, error)
+	revokeOAuthConsent(ctx context.Context, userID, clientID string) error
+	authenticateOAuthAccessToken(ctx context.Context, token string) (*authz.Principal, error)
 }
 
 // service is instantiated using a builder (see builder.go file)
@@ -25,6 +65,10 @@ type service struct {
 	password   PasswordManager
 	validate   Validator
 	token      TokenClient
+	task       TaskClient
+	attempts   AttemptStore
+	oidc       map[string]*OIDCProvider
+	oauth      OAuthStore
 }
 
 type ServiceOption func(s *service)
@@ -59,6 +103,37 @@ func WithTokenClient(t TokenClient) ServiceOption {
 	}
 }
 
+func WithTaskClient(t TaskClient) ServiceOption {
+	return func(s *service) {
+		s.task = t
+	}
+}
+
+// WithAttemptStore turns on brute-force protection for logins
+func WithAttemptStore(a AttemptStore) ServiceOption {
+	return func(s *service) {
+		s.attempts = a
+	}
+}
+
+// WithOIDCProviders lets users log in with identity providers
+func WithOIDCProviders(providers ...*OIDCProvider) ServiceOption {
+	return func(s *service) {
+		s.oidc = make(map[string]*OIDCProvider)
+		for _, p := range providers {
+			s.oidc[p.Name()] = p
+		}
+	}
+}
+
+// WithOAuthStore turns on the oauth authorization server,
+// which lets third-party apps access users' tasks
+func WithOAuthStore(o OAuthStore) ServiceOption {
+	return func(s *service) {
+		s.oauth = o
+	}
+}
+
 func WithPasswordManager(p PasswordManager) ServiceOption {
 	return func(s *service) {
 		s.password = p
@@ -70,6 +145,12 @@ func (s *service) registerUser(ctx context.Context, u UserRegistrationInfo) (str
 		return "", err
 	}
 
+	email, err := s.validate.Email("email", u.Email)
+	if err != nil {
+		return "", err
+	}
+	u.Email = email
+
 	hashedPassword, err := s.password.GenerateHash(u.Password)
 	if err != nil {
 		log.Println(err)
@@ -114,7 +195,7 @@ func (s *service) registerUser(ctx context.Context, u UserRegistrationInfo) (str
 		// send an activation email if a token is successfully generated
 		activationToken := <-activationTokenChan
 
-		if err := s.mailer.sendActivationEmail(ctx, u.Email, u.FirstName, activationToken); err != nil {
+		if err := s.mailer.sendActivationEmail(ctx, u.Email, u.FirstName, u.Locale, activationToken); err != nil {
 			log.Println(err)
 			errChan <- err
 			return
@@ -138,10 +219,22 @@ func (s *service) loginUser(ctx context.Context, u UserLoginInfo) (*UserInfo, er
 		return nil, err
 	}
 
+	// attempts are tracked and users are found by their normalised email
+	email, err := s.validate.Email("email", u.Email)
+	if err != nil {
+		return nil, err
+	}
+	u.Email = email
+
+	if err := s.checkLoginAttempts(ctx, u); err != nil {
+		return nil, err
+	}
+
 	uInfo, err := s.repository.getUserByEmail(ctx, u.Email)
 	if err != nil {
 		// Why did I do this?
 		if errors.Is(err, ErrUserNotFound) {
+			s.recordLoginFailure(ctx, u, nil)
 			return nil, ErrInvalidEmail
 		}
 		log.Println(err)
@@ -154,13 +247,36 @@ func (s *service) loginUser(ctx context.Context, u UserLoginInfo) (*UserInfo, er
 
 	// Should I compare the password before checking if the user has activated their account?
 	if err := s.password.CompareHashWith(uInfo.HashedPassword, u.Password); err != nil {
+		if errors.Is(err, ErrInvalidPassword) {
+			s.recordLoginFailure(ctx, u, uInfo)
+		}
 		return nil, err
 	}
 
+	// this is checked after the password, so that it does not
+	// tell anyone without it that an account was deactivated
+	if uInfo.Deactivated {
+		return nil, ErrUserDeactivated
+	}
+
+	// the user must provide their two-factor code before they get a jwt,
+	// and attempts are not reset until they do
+	if uInfo.TwoFactorEnabled {
+		challengeToken, err := generateChallengeJWT(uInfo.ID)
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+
+		return &UserInfo{ID: uInfo.ID, ChallengeToken: challengeToken, TwoFactorEnabled: true}, nil
+	}
+
+	s.resetLoginAttempts(ctx, u)
+
 	uInfo.Password = ""
 	uInfo.HashedPassword = ""
 
-	token, err := generateUserJWT(uInfo.ID)
+	token, err := generateUserJWT(uInfo.ID, uInfo.Roles)
 	if err != nil {
 		log.Println(err)
 		return nil, err
@@ -177,14 +293,14 @@ func (s *service) activateUser(ctx context.Context, activationToken string) erro
 		return err
 	}
 
-	var userUpdate UserInfo
-
+	activated := true
 	updateTime := time.Now().In(time.UTC)
-	userUpdate.ID = userID
-	userUpdate.Activated = true
-	userUpdate.UpdatedAt = &updateTime
+	userUpdate := UserUpdate{
+		Activated: &activated,
+		UpdatedAt: &updateTime,
+	}
 
-	if err := s.repository.updateUserByID(ctx, userUpdate); err != nil {
+	if err := s.repository.updateUserByID(ctx, userID, userUpdate); err != nil {
 		log.Println(err)
 		return err
 	}
@@ -201,9 +317,21 @@ func (s *service) restartActivation(ctx context.Context, u UserLoginInfo) error
 		return err
 	}
 
+	// attempts are tracked and users are found by their normalised email
+	email, err := s.validate.Email("email", u.Email)
+	if err != nil {
+		return err
+	}
+	u.Email = email
+
+	if err := s.checkLoginAttempts(ctx, u); err != nil {
+		return err
+	}
+
 	uInfo, err := s.repository.getUserByEmail(ctx, u.Email)
 	if err != nil {
 		if errors.Is(err, ErrUserNotFound) {
+			s.recordLoginFailure(ctx, u, nil)
 			return ErrInvalidEmail
 		}
 		log.Println(err)
@@ -211,7 +339,8 @@ func (s *service) restartActivation(ctx context.Context, u UserLoginInfo) error
 	}
 
 	if err := s.password.CompareHashWith(uInfo.HashedPassword, u.Password); err != nil {
-		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) || errors.Is(err, ErrInvalidPassword) {
+			s.recordLoginFailure(ctx, u, uInfo)
 			return ErrInvalidPassword
 		}
 
@@ -219,6 +348,8 @@ func (s *service) restartActivation(ctx context.Context, u UserLoginInfo) error
 		return err
 	}
 
+	s.resetLoginAttempts(ctx, u)
+
 	activationToken, err := s.token.CreateActivationToken(context.Background(), uInfo.ID)
 	if err != nil {
 		log.Println(err)
@@ -227,7 +358,7 @@ func (s *service) restartActivation(ctx context.Context, u UserLoginInfo) error
 
 	errChan := make(chan error)
 	go func() {
-		if err := s.mailer.sendActivationEmail(ctx, uInfo.Email, uInfo.FirstName, activationToken); err != nil {
+		if err := s.mailer.sendActivationEmail(ctx, uInfo.Email, uInfo.FirstName, uInfo.Locale, activationToken); err != nil {
 			log.Println(err)
 			errChan <- err
 		}
@@ -238,26 +369,233 @@ func (s *service) restartActivation(ctx context.Context, u UserLoginInfo) error
 
 // authenticate receives a signed jwt, extracts user data from it, verifies the
 // jwt, then checks that the user exists in the database and if their account
-// has been activated. It returns the user's ID if everything is successful.
-func (s *service) authenticate(ctx context.Context, signedJWT string) (string, error) {
+// has been activated and not deactivated. It returns the user's ID and roles
+// if everything is successful. The roles are read from the database, so that
+// a role that is taken away can't be used until the jwt expires.
+func (s *service) authenticate(ctx context.Context, signedJWT string) (*authz.Principal, error) {
 	if err := s.validate.NonEmptyString("jwt", signedJWT); err != nil {
-		return "", err
+		return nil, err
 	}
 
 	// Should I add validation for UserClaims?
 	var userClaims UserClaims
 	if err := verifyUserJWT(signedJWT, &userClaims); err != nil {
-		return "", err
+		return nil, err
+	}
+
+	// tokens with a purpose (ie. a two-factor challenge) cannot access an account
+	if userClaims.Purpose != "" {
+		return nil, ErrInvalidJWT
 	}
 
 	uInfo, err := s.repository.getUserByID(ctx, userClaims.UserID)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	if !uInfo.Activated {
-		return "", ErrUserNotActivated
+		return nil, ErrUserNotActivated
+	}
+
+	if uInfo.Deactivated {
+		return nil, ErrUserDeactivated
+	}
+
+	principal := &authz.Principal{UserID: uInfo.ID, Roles: uInfo.Roles}
+
+	// an admin can only act as a user while they are still an admin
+	if userClaims.ImpersonatorID != "" {
+		admin, err := s.repository.getUserByID(ctx, userClaims.ImpersonatorID)
+		if err != nil {
+			if errors.Is(err, ErrUserNotFound) {
+				return nil, ErrInvalidJWT
+			}
+			return nil, err
+		}
+
+		p := authz.Principal{Roles: admin.Roles}
+		if admin.Deactivated || !p.HasRole(authz.RoleAdmin) {
+			return nil, ErrInvalidJWT
+		}
+
+		principal.ImpersonatorID = admin.ID
+	}
+
+	return principal, nil
+}
+
+// getProfile returns the profile of the user with the given id
+func (s *service) getProfile(ctx context.Context, userID string) (*UserInfo, error) {
+	uInfo, err := s.repository.getUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	uInfo.HashedPassword = ""
+
+	return uInfo, nil
+}
+
+// updateProfile changes a user's first and/or last name, their preferred locale
+// and their daily digest settings, and returns their profile after it has been updated.
+func (s *service) updateProfile(ctx context.Context, userID string, p ProfileUpdate) (*UserInfo, error) {
+	if err := s.validate.ProfileUpdate(p); err != nil {
+		return nil, err
+	}
+
+	// an empty timezone is stored as UTC, which is what it means
+	if p.Timezone != nil && *p.Timezone == "" {
+		utc := "UTC"
+		p.Timezone = &utc
+	}
+
+	updateTime := time.Now().In(time.UTC)
+	userUpdate := UserUpdate{
+		FirstName:     p.FirstName,
+		LastName:      p.LastName,
+		Locale:        p.Locale,
+		DigestEnabled: p.DigestEnabled,
+		Timezone:      p.Timezone,
+		DigestHour:    p.DigestHour,
+		UpdatedAt:     &updateTime,
+	}
+
+	if err := s.repository.updateUserByID(ctx, userID, userUpdate); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return s.getProfile(ctx, userID)
+}
+
+// changePassword replaces a user's password with a new
+// one if they can provide their current password.
+func (s *service) changePassword(ctx context.Context, userID string, p PasswordChangeInfo) error {
+	if err := s.validate.NonEmptyString("currentPassword", p.CurrentPassword); err != nil {
+		return err
+	}
+
+	if err := s.validate.NonEmptyString("newPassword", p.NewPassword); err != nil {
+		return err
+	}
+
+	uInfo, err := s.repository.getUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.validate.NewPassword("newPassword", p.NewPassword, uInfo.Email, uInfo.FirstName, uInfo.LastName); err != nil {
+		return err
+	}
+
+	if err := s.password.CompareHashWith(uInfo.HashedPassword, p.CurrentPassword); err != nil {
+		return err
+	}
+
+	hashedPassword, err := s.password.GenerateHash(p.NewPassword)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	updateTime := time.Now().In(time.UTC)
+	userUpdate := UserUpdate{
+		HashedPassword: &hashedPassword,
+		UpdatedAt:      &updateTime,
+	}
+
+	if err := s.repository.updateUserByID(ctx, userID, userUpdate); err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+// requestEmailChange stores the email a user wants to change to as pending,
+// then sends a confirmation email to that address with a token from the
+// token service. The user's email is not changed until they confirm it.
+func (s *service) requestEmailChange(ctx context.Context, userID string, e EmailChangeInfo) error {
+	email, err := s.validate.Email("email", e.Email)
+	if err != nil {
+		return err
+	}
+	e.Email = email
+
+	uInfo, err := s.repository.getUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.confirmPassword(uInfo, e.Password, e.ReauthToken); err != nil {
+		return err
+	}
+
+	// the unique index on email would also catch this, but
+	// only after the user has followed the link in their email
+	if _, err := s.repository.getUserByEmail(ctx, e.Email); err == nil {
+		return ErrDuplicateUser
+	} else if !errors.Is(err, ErrUserNotFound) {
+		log.Println(err)
+		return err
+	}
+
+	updateTime := time.Now().In(time.UTC)
+	userUpdate := UserUpdate{
+		PendingEmail: &e.Email,
+		UpdatedAt:    &updateTime,
+	}
+
+	if err := s.repository.updateUserByID(ctx, userID, userUpdate); err != nil {
+		log.Println(err)
+		return err
+	}
+
+	token, err := s.token.CreateEmailChangeToken(ctx, userID)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if err := s.mailer.sendEmailChangeEmail(ctx, e.Email, uInfo.FirstName, uInfo.Locale, token); err != nil {
+		log.Println(err)
+		return err
 	}
 
-	return uInfo.ID, nil
+	return nil
+}
+
+// confirmEmailChange replaces a user's email with
+// their pending email after they follow the link
+// that was sent to it by requestEmailChange.
+func (s *service) confirmEmailChange(ctx context.Context, token string) error {
+	userID, err := s.token.ValidateEmailChangeToken(ctx, token)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	uInfo, err := s.repository.getUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if uInfo.PendingEmail == "" {
+		return ErrNoPendingEmail
+	}
+
+	noPendingEmail := ""
+	updateTime := time.Now().In(time.UTC)
+	userUpdate := UserUpdate{
+		Email:        &uInfo.PendingEmail,
+		PendingEmail: &noPendingEmail,
+		UpdatedAt:    &updateTime,
+	}
+
+	if err := s.repository.updateUserByID(ctx, userID, userUpdate); err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
 }
@@ -489,7 +489,7 @@ func Test_Service_Authenticate(t *testing.T) {
 				},
 			},
 			generateUserJWT: func(userID string) (string, error) {
-				return generateUserJWT(userID)
+				return generateUserJWT(userID, nil)
 			},
 			args: args{
 				userID: "5ef7fdd91c19e3222b41b839",
@@ -506,7 +506,7 @@ func Test_Service_Authenticate(t *testing.T) {
 				},
 			},
 			generateUserJWT: func(userID string) (string, error) {
-				return generateUserJWT(userID)
+				return generateUserJWT(userID, nil)
 			},
 			args: args{
 				userID: "5ef7fdd91c19e3222b41b839",
@@ -524,7 +524,7 @@ func Test_Service_Authenticate(t *testing.T) {
 				},
 			},
 			generateUserJWT: func(userID string) (string, error) {
-				signedJWT, err := generateUserJWT(userID)
+				signedJWT, err := generateUserJWT(userID, nil)
 				return signedJWT + "tamperedWith", err
 			},
 			args: args{
@@ -541,7 +541,7 @@ func Test_Service_Authenticate(t *testing.T) {
 				err:  ErrUserNotFound,
 			},
 			generateUserJWT: func(userID string) (string, error) {
-				return generateUserJWT(userID)
+				return generateUserJWT(userID, nil)
 			},
 			args: args{
 				userID: "5ef7fdd91c19e3222b41b839",
@@ -581,13 +581,313 @@ func Test_Service_Authenticate(t *testing.T) {
 			signedJWT, err := tt.generateUserJWT(tt.args.userID)
 			require.NoError(err)
 
-			actualUserID, err := s.authenticate(tt.args.ctx, signedJWT)
+			principal, err := s.authenticate(tt.args.ctx, signedJWT)
 			if err != nil {
 				require.Error(err)
 				assert.EqualError(err, tt.expErr)
 			} else {
 				require.NoError(err)
-				assert.Equal(tt.expUserID, actualUserID)
+				assert.Equal(tt.expUserID, principal.UserID)
+			}
+		})
+	}
+}
+
+func Test_Service_UpdateProfile(t *testing.T) {
+	firstName := "Mike"
+	invalidLocale := "french please"
+	invalidTimezone := "Scranton"
+	localTimezone := "Local"
+	invalidDigestHour := 24
+
+	tests := []struct {
+		name       string
+		repository Repository
+		p          ProfileUpdate
+		expUser    *UserInfo
+		expErr     string
+	}{
+		{
+			name: "Success",
+			repository: &mockRepository{
+				user: &UserInfo{
+					ID:             "5ef7fdd91c19e3222b41b839",
+					FirstName:      "Mike",
+					LastName:       "Scott",
+					Email:          "michaelscott@dundermifflin.com",
+					HashedPassword: "hashedpassword",
+				},
+			},
+			p: ProfileUpdate{FirstName: &firstName},
+			expUser: &UserInfo{
+				ID:        "5ef7fdd91c19e3222b41b839",
+				FirstName: "Mike",
+				LastName:  "Scott",
+				Email:     "michaelscott@dundermifflin.com",
+			},
+		},
+		{
+			name:       "FailNoFieldsToUpdate",
+			repository: &mockRepository{},
+			p:          ProfileUpdate{},
+			expErr:     "no fields to update were provided",
+		},
+		{
+			name:       "FailUserNotFound",
+			repository: &mockRepository{err: ErrUserNotFound},
+			p:          ProfileUpdate{FirstName: &firstName},
+			expErr:     "user not found",
+		},
+		{
+			name:       "FailInvalidLocale",
+			repository: &mockRepository{},
+			p:          ProfileUpdate{Locale: &invalidLocale},
+			expErr:     "locale must be a language tag, such as en or fr-CA",
+		},
+		{
+			name:       "FailInvalidTimezone",
+			repository: &mockRepository{},
+			p:          ProfileUpdate{Timezone: &invalidTimezone},
+			expErr:     "timezone must be an IANA timezone, such as Europe/Paris",
+		},
+		{
+			name:       "FailLocalTimezone",
+			repository: &mockRepository{},
+			p:          ProfileUpdate{Timezone: &localTimezone},
+			expErr:     "timezone must be an IANA timezone, such as Europe/Paris",
+		},
+		{
+			name:       "FailInvalidDigestHour",
+			repository: &mockRepository{},
+			p:          ProfileUpdate{DigestHour: &invalidDigestHour},
+			expErr:     "digestHour must be an hour of the day, from 0 to 23",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &service{
+				repository: tt.repository,
+				validate:   &validator{},
+			}
+
+			actualUser, err := s.updateProfile(context.Background(), "5ef7fdd91c19e3222b41b839", tt.p)
+			if tt.expErr != "" {
+				assert.EqualError(t, err, tt.expErr)
+				assert.Nil(t, actualUser)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tt.expUser, actualUser)
+			}
+		})
+	}
+
+	t.Run("SuccessEmptyTimezoneIsUTC", func(t *testing.T) {
+		repository := &mockRepository{user: &UserInfo{ID: "5ef7fdd91c19e3222b41b839"}}
+		s := &service{
+			repository: repository,
+			validate:   &validator{},
+		}
+		emptyTimezone := ""
+
+		_, err := s.updateProfile(context.Background(), "5ef7fdd91c19e3222b41b839", ProfileUpdate{Timezone: &emptyTimezone})
+		require.NoError(t, err)
+		require.Len(t, repository.userUpdates, 1)
+		assert.Equal(t, "UTC", *repository.userUpdates[0].Timezone)
+	})
+}
+
+func Test_Service_ChangePassword(t *testing.T) {
+	tests := []struct {
+		name     string
+		password PasswordManager
+		p        PasswordChangeInfo
+		expErr   string
+	}{
+		{
+			name:     "Success",
+			password: &mockPasswordManager{hashedPassword: "newhashedpassword"},
+			p: PasswordChangeInfo{
+				CurrentPassword: "1234",
+				NewPassword:     "5678",
+			},
+		},
+		{
+			name:     "FailWrongCurrentPassword",
+			password: &mockPasswordManager{err: ErrInvalidPassword},
+			p: PasswordChangeInfo{
+				CurrentPassword: "4321",
+				NewPassword:     "5678",
+			},
+			expErr: "invalid password provided",
+		},
+		{
+			name:     "FailMissingNewPassword",
+			password: &mockPasswordManager{},
+			p: PasswordChangeInfo{
+				CurrentPassword: "1234",
+			},
+			expErr: "missing field is required: newPassword",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &service{
+				repository: &mockRepository{
+					user: &UserInfo{
+						ID:             "5ef7fdd91c19e3222b41b839",
+						HashedPassword: "hashedpassword",
+					},
+				},
+				password: tt.password,
+				validate: &validator{},
+			}
+
+			err := s.changePassword(context.Background(), "5ef7fdd91c19e3222b41b839", tt.p)
+			if tt.expErr != "" {
+				assert.EqualError(t, err, tt.expErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func Test_Service_RequestEmailChange(t *testing.T) {
+	tests := []struct {
+		name       string
+		repository Repository
+		password   PasswordManager
+		mailer     MailerClient
+		e          EmailChangeInfo
+		expErr     string
+	}{
+		{
+			name: "Success",
+			repository: &mockRepository{
+				user:     &UserInfo{ID: "5ef7fdd91c19e3222b41b839", FirstName: "Michael"},
+				emailErr: ErrUserNotFound,
+			},
+			password: &mockPasswordManager{},
+			mailer:   &mockMailerClient{},
+			e: EmailChangeInfo{
+				Email:    "michael.scott@dundermifflin.com",
+				Password: "1234",
+			},
+		},
+		{
+			name: "FailEmailBelongsToAnotherUser",
+			repository: &mockRepository{
+				user: &UserInfo{ID: "5ef7fdd91c19e3222b41b839", FirstName: "Michael"},
+			},
+			password: &mockPasswordManager{},
+			mailer:   &mockMailerClient{},
+			e: EmailChangeInfo{
+				Email:    "dwightschrute@dundermifflin.com",
+				Password: "1234",
+			},
+			expErr: "user already exists",
+		},
+		{
+			name: "FailWrongPassword",
+			repository: &mockRepository{
+				user:     &UserInfo{ID: "5ef7fdd91c19e3222b41b839", FirstName: "Michael"},
+				emailErr: ErrUserNotFound,
+			},
+			password: &mockPasswordManager{err: ErrInvalidPassword},
+			mailer:   &mockMailerClient{},
+			e: EmailChangeInfo{
+				Email:    "michael.scott@dundermifflin.com",
+				Password: "4321",
+			},
+			expErr: "invalid password provided",
+		},
+		{
+			name: "FailMailerError",
+			repository: &mockRepository{
+				user:     &UserInfo{ID: "5ef7fdd91c19e3222b41b839", FirstName: "Michael"},
+				emailErr: ErrUserNotFound,
+			},
+			password: &mockPasswordManager{},
+			mailer:   &mockMailerClient{err: errors.New("dummy error")},
+			e: EmailChangeInfo{
+				Email:    "michael.scott@dundermifflin.com",
+				Password: "1234",
+			},
+			expErr: "dummy error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &service{
+				repository: tt.repository,
+				mailer:     tt.mailer,
+				password:   tt.password,
+				validate:   &validator{},
+				token:      &mockTokenClient{mockActivationToken: "token"},
+			}
+
+			err := s.requestEmailChange(context.Background(), "5ef7fdd91c19e3222b41b839", tt.e)
+			if tt.expErr != "" {
+				assert.EqualError(t, err, tt.expErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func Test_Service_ConfirmEmailChange(t *testing.T) {
+	tests := []struct {
+		name       string
+		repository Repository
+		token      TokenClient
+		expErr     string
+	}{
+		{
+			name: "Success",
+			repository: &mockRepository{
+				user: &UserInfo{
+					ID:           "5ef7fdd91c19e3222b41b839",
+					Email:        "michaelscott@dundermifflin.com",
+					PendingEmail: "michael.scott@dundermifflin.com",
+				},
+			},
+			token: &mockTokenClient{mockUserID: "5ef7fdd91c19e3222b41b839"},
+		},
+		{
+			name: "FailNoPendingEmail",
+			repository: &mockRepository{
+				user: &UserInfo{
+					ID:    "5ef7fdd91c19e3222b41b839",
+					Email: "michaelscott@dundermifflin.com",
+				},
+			},
+			token:  &mockTokenClient{mockUserID: "5ef7fdd91c19e3222b41b839"},
+			expErr: "user has no pending email change",
+		},
+		{
+			name:       "FailInvalidToken",
+			repository: &mockRepository{},
+			token:      &mockTokenClient{err: errors.New("invalid token")},
+			expErr:     "invalid token",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &service{
+				repository: tt.repository,
+				token:      tt.token,
+			}
+
+			err := s.confirmEmailChange(context.Background(), "token")
+			if tt.expErr != "" {
+				assert.EqualError(t, err, tt.expErr)
+			} else {
+				assert.NoError(t, err)
 			}
 		})
 	}
@@ -0,0 +1,184 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// TaskClient is used by Service to make
+// calls to the task service's internal api
+type TaskClient interface {
+	getUserTasks(ctx context.Context, userID string) ([]TaskData, error)
+	countUserTasks(ctx context.Context, userID string) (int64, error)
+	deleteUserTasks(ctx context.Context, userID string) error
+	// getDueTasks returns the tasks of a user that are due on or before a date (eg. 2023-05-01)
+	getDueTasks(ctx context.Context, userID, date string) ([]TaskData, error)
+}
+
+// internalSecretHeader is the header that the task service's internal api expects its shared secret in
+const internalSecretHeader = "X-Internal-Secret"
+
+type httpTaskClient struct {
+	taskEndpointURL url.URL
+	secret          string
+}
+
+// NewHTTPTaskClient receives the base url of the task service's internal
+// api (ie. http://localhost:5007), and the secret that it shares with it
+func NewHTTPTaskClient(taskEndpoint, secret string) (*httpTaskClient, error) {
+	taskEndpointURL, err := url.Parse(taskEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	return &httpTaskClient{
+		taskEndpointURL: *taskEndpointURL,
+		secret:          secret,
+	}, nil
+}
+
+// newRequest creates a request to the internal api that has the shared secret
+func (h *httpTaskClient) newRequest(ctx context.Context, method string, endpoint *url.URL) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, endpoint.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(internalSecretHeader, h.secret)
+
+	return req, nil
+}
+
+// getUserTasks asks the task service for every task that belongs to a user
+func (h *httpTaskClient) getUserTasks(ctx context.Context, userID string) ([]TaskData, error) {
+	endpoint := h.taskEndpointURL.JoinPath("/v1/internal/task/user", userID)
+	req, err := h.newRequest(ctx, http.MethodGet, endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	c := http.Client{Timeout: 30 * time.Second}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeTaskServiceError(resp)
+	}
+
+	body := struct {
+		Tasks []TaskData `json:"tasks"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+
+	return body.Tasks, nil
+}
+
+// countUserTasks asks the task service how many tasks belong to a user
+func (h *httpTaskClient) countUserTasks(ctx context.Context, userID string) (int64, error) {
+	endpoint := h.taskEndpointURL.JoinPath("/v1/internal/task/user", userID, "count")
+	req, err := h.newRequest(ctx, http.MethodGet, endpoint)
+	if err != nil {
+		return 0, err
+	}
+
+	c := http.Client{Timeout: 5 * time.Second}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, decodeTaskServiceError(resp)
+	}
+
+	body := struct {
+		Count int64 `json:"count"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return 0, err
+	}
+
+	return body.Count, nil
+}
+
+// getDueTasks asks the task service for the tasks of a user that are due on or before a date
+func (h *httpTaskClient) getDueTasks(ctx context.Context, userID, date string) ([]TaskData, error) {
+	endpoint := h.taskEndpointURL.JoinPath("/v1/internal/task/user", userID, "due")
+	endpoint.RawQuery = url.Values{"date": {date}}.Encode()
+	req, err := h.newRequest(ctx, http.MethodGet, endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	c := http.Client{Timeout: 5 * time.Second}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeTaskServiceError(resp)
+	}
+
+	body := struct {
+		Tasks []TaskData `json:"tasks"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+
+	return body.Tasks, nil
+}
+
+// deleteUserTasks asks the task service to delete every task that belongs to a user
+func (h *httpTaskClient) deleteUserTasks(ctx context.Context, userID string) error {
+	endpoint := h.taskEndpointURL.JoinPath("/v1/internal/task/user", userID)
+	req, err := h.newRequest(ctx, http.MethodDelete, endpoint)
+	if err != nil {
+		return err
+	}
+
+	c := http.Client{Timeout: 5 * time.Second}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return decodeTaskServiceError(resp)
+	}
+
+	return nil
+}
+
+// decodeTaskServiceError gets the error from an unsuccessful response from the task service
+func decodeTaskServiceError(resp *http.Response) error {
+	errs := struct {
+		ErrStr string `json:"error"`
+	}{}
+
+	if err := json.NewDecoder(resp.Body).Decode(&errs); err != nil {
+		return err
+	}
+
+	if errs.ErrStr != "" {
+		return errors.New(errs.ErrStr)
+	}
+
+	return errors.New("unknown error occurred when accessing the task service")
+}
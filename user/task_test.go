@@ -0,0 +1,33 @@
+package user
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	res "github.com/ricxi/flat-list/shared/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_httpTaskClient_getDueTasks(t *testing.T) {
+	var header http.Header
+	var query string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		query = r.URL.RawQuery
+		res.SendJSON(w, map[string]any{"success": true, "tasks": []TaskData{{Name: "Laundry", DueDate: "2023-05-01"}}}, http.StatusOK, nil)
+	}))
+	defer ts.Close()
+
+	c, err := NewHTTPTaskClient(ts.URL, "shh")
+	require.NoError(t, err)
+
+	tasks, err := c.getDueTasks(context.Background(), "5ef7fdd91c19e3222b41b839", "2023-05-01")
+	require.NoError(t, err)
+	assert.Equal(t, []TaskData{{Name: "Laundry", DueDate: "2023-05-01"}}, tasks)
+	assert.Equal(t, "date=2023-05-01", query)
+	// the task service's internal api refuses requests without its shared secret
+	assert.Equal(t, "shh", header.Get(internalSecretHeader))
+}
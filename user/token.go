@@ -2,15 +2,28 @@ package user
 
 import (
 	"context"
+	"time"
 
 	tservice "github.com/ricxi/flat-list/token/pb"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 )
 
 type TokenClient interface {
 	CreateActivationToken(ctx context.Context, userID string) (string, error)
 	ValidateActivationToken(ctx context.Context, activationToken string) (string, error)
+	// CreateEmailChangeToken creates a token that confirms a user's new email, which
+	// ValidateActivationToken doesn't accept, and that can't be used to activate a user
+	CreateEmailChangeToken(ctx context.Context, userID string) (string, error)
+	ValidateEmailChangeToken(ctx context.Context, token string) (string, error)
+	DeleteUserTokens(ctx context.Context, userID string) error
+	CreatePersonalAccessToken(ctx context.Context, userID, name string, scopes []string, expiresAt time.Time) (*NewPersonalAccessToken, error)
+	ListPersonalAccessTokens(ctx context.Context, userID string) ([]PersonalAccessToken, error)
+	RevokePersonalAccessToken(ctx context.Context, userID, tokenID string) error
+	ValidatePersonalAccessToken(ctx context.Context, token string) (*PersonalAccessToken, error)
 }
 
 // tokenClient contains methods to call
@@ -33,7 +46,23 @@ func NewTokenClient(port string) (TokenClient, error) {
 }
 
 func (tc *tokenClient) CreateActivationToken(ctx context.Context, userID string) (string, error) {
-	in := tservice.CreateTokenRequest{UserId: userID}
+	return tc.createToken(ctx, userID, tservice.TokenPurpose_TOKEN_PURPOSE_ACTIVATION)
+}
+
+func (tc *tokenClient) ValidateActivationToken(ctx context.Context, activationToken string) (string, error) {
+	return tc.validateToken(ctx, activationToken, tservice.TokenPurpose_TOKEN_PURPOSE_ACTIVATION)
+}
+
+func (tc *tokenClient) CreateEmailChangeToken(ctx context.Context, userID string) (string, error) {
+	return tc.createToken(ctx, userID, tservice.TokenPurpose_TOKEN_PURPOSE_EMAIL_CHANGE)
+}
+
+func (tc *tokenClient) ValidateEmailChangeToken(ctx context.Context, token string) (string, error) {
+	return tc.validateToken(ctx, token, tservice.TokenPurpose_TOKEN_PURPOSE_EMAIL_CHANGE)
+}
+
+func (tc *tokenClient) createToken(ctx context.Context, userID string, purpose tservice.TokenPurpose) (string, error) {
+	in := tservice.CreateTokenRequest{UserId: userID, Purpose: purpose}
 	out, err := tc.c.CreateActivationToken(ctx, &in)
 	if err != nil {
 		return "", err
@@ -42,12 +71,126 @@ func (tc *tokenClient) CreateActivationToken(ctx context.Context, userID string)
 	return out.ActivationToken, nil
 }
 
-func (tc *tokenClient) ValidateActivationToken(ctx context.Context, activationToken string) (string, error) {
-	in := tservice.ValidateTokenRequest{ActivationToken: activationToken}
-	out, err := tc.c.ValidateActivationToken(context.Background(), &in)
+// validateToken returns the id of the user that a token belongs to, if it was created for the
+// same purpose. The token service doesn't find tokens that were created for another purpose.
+func (tc *tokenClient) validateToken(ctx context.Context, token string, purpose tservice.TokenPurpose) (string, error) {
+	in := tservice.ValidateTokenRequest{ActivationToken: token, Purpose: purpose}
+	out, err := tc.c.ValidateActivationToken(ctx, &in)
 	if err != nil {
+		switch status.Code(err) {
+		case codes.NotFound:
+			return "", ErrActivationTokenNotFound
+		case codes.FailedPrecondition:
+			if errorReason(err) == "TOKEN_USED" {
+				return "", ErrActivationTokenUsed
+			}
+			return "", ErrActivationTokenExpired
+		}
 		return "", err
 	}
 
 	return out.UserId, nil
 }
+
+// errorReason returns the reason in the ErrorInfo detail
+// of an error from the token service, if it has one
+func errorReason(err error) string {
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok {
+			return info.Reason
+		}
+	}
+
+	return ""
+}
+
+// DeleteUserTokens removes every token that belongs to a user
+func (tc *tokenClient) DeleteUserTokens(ctx context.Context, userID string) error {
+	in := tservice.DeleteUserTokensRequest{UserId: userID}
+	if _, err := tc.c.DeleteUserTokens(ctx, &in); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (tc *tokenClient) CreatePersonalAccessToken(ctx context.Context, userID, name string, scopes []string, expiresAt time.Time) (*NewPersonalAccessToken, error) {
+	in := tservice.CreatePersonalAccessTokenRequest{
+		UserId:    userID,
+		Name:      name,
+		Scopes:    scopes,
+		ExpiresAt: expiresAt.Unix(),
+	}
+	out, err := tc.c.CreatePersonalAccessToken(ctx, &in)
+	if err != nil {
+		if status.Code(err) == codes.AlreadyExists {
+			return nil, ErrDuplicateTokenName
+		}
+		return nil, err
+	}
+
+	return &NewPersonalAccessToken{
+		Token:               out.Token,
+		PersonalAccessToken: newPersonalAccessToken(out.PersonalAccessToken),
+	}, nil
+}
+
+func (tc *tokenClient) ListPersonalAccessTokens(ctx context.Context, userID string) ([]PersonalAccessToken, error) {
+	in := tservice.ListPersonalAccessTokensRequest{UserId: userID}
+	out, err := tc.c.ListPersonalAccessTokens(ctx, &in)
+	if err != nil {
+		return nil, err
+	}
+
+	pats := make([]PersonalAccessToken, 0, len(out.PersonalAccessTokens))
+	for _, pat := range out.PersonalAccessTokens {
+		pats = append(pats, newPersonalAccessToken(pat))
+	}
+
+	return pats, nil
+}
+
+func (tc *tokenClient) RevokePersonalAccessToken(ctx context.Context, userID, tokenID string) error {
+	in := tservice.RevokePersonalAccessTokenRequest{UserId: userID, Id: tokenID}
+	if _, err := tc.c.RevokePersonalAccessToken(ctx, &in); err != nil {
+		if status.Code(err) == codes.NotFound {
+			return ErrPersonalAccessTokenNotFound
+		}
+		return err
+	}
+
+	return nil
+}
+
+// ValidatePersonalAccessToken returns the token that matches, and records that it was used
+func (tc *tokenClient) ValidatePersonalAccessToken(ctx context.Context, token string) (*PersonalAccessToken, error) {
+	in := tservice.ValidatePersonalAccessTokenRequest{Token: token}
+	out, err := tc.c.ValidatePersonalAccessToken(ctx, &in)
+	if err != nil {
+		if status.Code(err) == codes.Unauthenticated {
+			return nil, ErrInvalidPersonalAccessToken
+		}
+		return nil, err
+	}
+
+	pat := newPersonalAccessToken(out.PersonalAccessToken)
+	return &pat, nil
+}
+
+// newPersonalAccessToken converts a token from the token service, whose times are unix seconds
+func newPersonalAccessToken(pat *tservice.PersonalAccessToken) PersonalAccessToken {
+	converted := PersonalAccessToken{
+		ID:        pat.GetId(),
+		UserID:    pat.GetUserId(),
+		Name:      pat.GetName(),
+		Scopes:    pat.GetScopes(),
+		CreatedAt: time.Unix(pat.GetCreatedAt(), 0).UTC(),
+		ExpiresAt: time.Unix(pat.GetExpiresAt(), 0).UTC(),
+	}
+	if pat.GetLastUsedAt() != 0 {
+		lastUsedAt := time.Unix(pat.GetLastUsedAt(), 0).UTC()
+		converted.LastUsedAt = &lastUsedAt
+	}
+
+	return converted
+}
@@ -0,0 +1,93 @@
+package user
+
+import (
+	"context"
+	"testing"
+
+	tservice "github.com/ricxi/flat-list/token/pb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// mockGrpcTokenClient is used in place of the generated grpc client
+type mockGrpcTokenClient struct {
+	tservice.TokenClient
+	err error
+	// purpose is the purpose of the last token that was created or validated
+	purpose tservice.TokenPurpose
+}
+
+func (m *mockGrpcTokenClient) CreateActivationToken(ctx context.Context, in *tservice.CreateTokenRequest, opts ...grpc.CallOption) (*tservice.CreateTokenResponse, error) {
+	m.purpose = in.Purpose
+	return &tservice.CreateTokenResponse{ActivationToken: "token_placeholder"}, m.err
+}
+
+func (m *mockGrpcTokenClient) ValidateActivationToken(ctx context.Context, in *tservice.ValidateTokenRequest, opts ...grpc.CallOption) (*tservice.ValidateTokenResponse, error) {
+	m.purpose = in.Purpose
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &tservice.ValidateTokenResponse{UserId: "5ef7fdd91c19e3222b41b839"}, nil
+}
+
+// tokenServiceError creates a FailedPrecondition error with a reason, like the token service does
+func tokenServiceError(t *testing.T, msg, reason string) error {
+	t.Helper()
+
+	st, err := status.New(codes.FailedPrecondition, msg).WithDetails(&errdetails.ErrorInfo{Reason: reason, Domain: "token.flat-list"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return st.Err()
+}
+
+func Test_tokenClient_ValidateActivationToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		expErr error
+	}{
+		{name: "Success"},
+		{name: "NotFound", err: status.Error(codes.NotFound, "activation token not found"), expErr: ErrActivationTokenNotFound},
+		{name: "Expired", err: tokenServiceError(t, "activation token has expired", "TOKEN_EXPIRED"), expErr: ErrActivationTokenExpired},
+		{name: "AlreadyUsed", err: tokenServiceError(t, "activation token has already been used", "TOKEN_USED"), expErr: ErrActivationTokenUsed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := tokenClient{c: &mockGrpcTokenClient{err: tt.err}}
+
+			userID, err := tc.ValidateActivationToken(context.Background(), "activation_token_placeholder")
+			if tt.expErr != nil {
+				assert.ErrorIs(t, err, tt.expErr)
+				assert.Empty(t, userID)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, "5ef7fdd91c19e3222b41b839", userID)
+		})
+	}
+}
+
+func Test_tokenClient_TokenPurpose(t *testing.T) {
+	assert := assert.New(t)
+	m := &mockGrpcTokenClient{}
+	tc := tokenClient{c: m}
+
+	_, err := tc.CreateEmailChangeToken(context.Background(), "5ef7fdd91c19e3222b41b839")
+	assert.NoError(err)
+	assert.Equal(tservice.TokenPurpose_TOKEN_PURPOSE_EMAIL_CHANGE, m.purpose)
+
+	_, err = tc.ValidateEmailChangeToken(context.Background(), "token_placeholder")
+	assert.NoError(err)
+	assert.Equal(tservice.TokenPurpose_TOKEN_PURPOSE_EMAIL_CHANGE, m.purpose)
+
+	_, err = tc.ValidateActivationToken(context.Background(), "token_placeholder")
+	assert.NoError(err)
+	assert.Equal(tservice.TokenPurpose_TOKEN_PURPOSE_ACTIVATION, m.purpose)
+}
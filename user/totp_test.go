@@ -0,0 +1,75 @@
+package user
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfcTestSecret is the base32 encoding of the SHA-1 secret used by the test vectors in RFC 6238
+const rfcTestSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// the last 6 digits of the 8 digit codes in RFC 6238 appendix B
+	tests := []struct {
+		unix     int64
+		expected string
+	}{
+		{unix: 59, expected: "287082"},
+		{unix: 1111111109, expected: "081804"},
+		{unix: 1234567890, expected: "005924"},
+		{unix: 2000000000, expected: "279037"},
+	}
+
+	for _, tt := range tests {
+		code, err := totpCode(rfcTestSecret, totpStep(time.Unix(tt.unix, 0)))
+		require.NoError(t, err)
+		assert.Equal(t, tt.expected, code, "unix time: %d", tt.unix)
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := totpStep(now)
+
+	code, err := totpCode(rfcTestSecret, current)
+	require.NoError(t, err)
+
+	step, ok := validateTOTP(rfcTestSecret, code, now, 0)
+	assert.True(t, ok)
+	assert.Equal(t, current, step)
+
+	// a code from the previous step is accepted in case of clock drift
+	previousCode, err := totpCode(rfcTestSecret, current-1)
+	require.NoError(t, err)
+	_, ok = validateTOTP(rfcTestSecret, previousCode, now, 0)
+	assert.True(t, ok)
+
+	// a code cannot be used again once its step has been used
+	_, ok = validateTOTP(rfcTestSecret, code, now, current)
+	assert.False(t, ok)
+
+	_, ok = validateTOTP(rfcTestSecret, "000000", now, 0)
+	assert.False(t, ok)
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := totpURI(rfcTestSecret, "michaelscott@dundermifflin.com")
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/flat-list:michaelscott@dundermifflin.com?"))
+	assert.Contains(t, uri, "secret="+rfcTestSecret)
+	assert.Contains(t, uri, "issuer=flat-list")
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, hashes, err := generateRecoveryCodes()
+	require.NoError(t, err)
+	require.Len(t, codes, recoveryCodeCount)
+	require.Len(t, hashes, recoveryCodeCount)
+
+	// codes typed in without the dash or in upper case still match
+	typed := strings.ToUpper(strings.Replace(codes[0], "-", "", 1))
+	assert.Equal(t, hashes[0], hashRecoveryCode(typed))
+}
@@ -0,0 +1,253 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/ricxi/flat-list/shared/validation"
+)
+
+// enrollTwoFactor generates a new secret for a user's authenticator app. Two-factor
+// authentication is not enabled until the user confirms it with a code from the app.
+func (s *service) enrollTwoFactor(ctx context.Context, userID string) (*TwoFactorEnrollment, error) {
+	uInfo, err := s.repository.getUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if uInfo.TwoFactorEnabled {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	updatedAt := time.Now().In(time.UTC)
+	userUpdate := UserUpdate{
+		TwoFactorPendingSecret: &secret,
+		UpdatedAt:              &updatedAt,
+	}
+	if err := s.repository.updateUserByID(ctx, userID, userUpdate); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return &TwoFactorEnrollment{
+		Secret:     secret,
+		OTPAuthURI: totpURI(secret, uInfo.Email),
+	}, nil
+}
+
+// confirmTwoFactor enables two-factor authentication if the code was generated
+// with the pending secret, and returns the user's recovery codes. This is the
+// only time the recovery codes can be seen, since only their hashes are stored.
+func (s *service) confirmTwoFactor(ctx context.Context, userID string, c TwoFactorCode) ([]string, error) {
+	if err := s.validate.NonEmptyString("code", c.Code); err != nil {
+		return nil, err
+	}
+
+	uInfo, err := s.repository.getUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if uInfo.TwoFactorEnabled {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	if uInfo.TwoFactorPendingSecret == "" {
+		return nil, ErrNoPendingTwoFactor
+	}
+
+	step, ok := validateTOTP(uInfo.TwoFactorPendingSecret, c.Code, time.Now(), 0)
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	enabled := true
+	noPendingSecret := ""
+	updatedAt := time.Now().In(time.UTC)
+	userUpdate := UserUpdate{
+		TwoFactorEnabled:       &enabled,
+		TwoFactorSecret:        &uInfo.TwoFactorPendingSecret,
+		TwoFactorPendingSecret: &noPendingSecret,
+		TwoFactorLastStep:      &step,
+		RecoveryCodeHashes:     &hashes,
+		UpdatedAt:              &updatedAt,
+	}
+	if err := s.repository.updateUserByID(ctx, userID, userUpdate); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// disableTwoFactor turns off two-factor authentication after the user
+// confirms their password, and removes their secret and recovery codes.
+func (s *service) disableTwoFactor(ctx context.Context, userID string, p PasswordConfirmation) error {
+	if _, err := s.confirmPasswordWithTwoFactor(ctx, userID, p); err != nil {
+		return err
+	}
+
+	disabled := false
+	noSecret := ""
+	var noStep int64
+	noHashes := []string{}
+	updatedAt := time.Now().In(time.UTC)
+	userUpdate := UserUpdate{
+		TwoFactorEnabled:   &disabled,
+		TwoFactorSecret:    &noSecret,
+		TwoFactorLastStep:  &noStep,
+		RecoveryCodeHashes: &noHashes,
+		UpdatedAt:          &updatedAt,
+	}
+	if err := s.repository.updateUserByID(ctx, userID, userUpdate); err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+// regenerateRecoveryCodes replaces all of a user's recovery codes
+// after they confirm their password, and returns the new ones.
+func (s *service) regenerateRecoveryCodes(ctx context.Context, userID string, p PasswordConfirmation) ([]string, error) {
+	if _, err := s.confirmPasswordWithTwoFactor(ctx, userID, p); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	updatedAt := time.Now().In(time.UTC)
+	userUpdate := UserUpdate{
+		RecoveryCodeHashes: &hashes,
+		UpdatedAt:          &updatedAt,
+	}
+	if err := s.repository.updateUserByID(ctx, userID, userUpdate); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// confirmPasswordWithTwoFactor checks the password (or reauthentication token)
+// of a user who must already have two-factor authentication enabled
+func (s *service) confirmPasswordWithTwoFactor(ctx context.Context, userID string, p PasswordConfirmation) (*UserInfo, error) {
+	uInfo, err := s.repository.getUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.confirmPassword(uInfo, p.Password, p.ReauthToken); err != nil {
+		return nil, err
+	}
+
+	if !uInfo.TwoFactorEnabled {
+		return nil, ErrTwoFactorNotEnabled
+	}
+
+	return uInfo, nil
+}
+
+// loginTwoFactor is the second step of logging in for users with two-factor
+// authentication. It verifies the challenge token that was returned by loginUser,
+// then a code from the user's authenticator app or one of their recovery codes.
+func (s *service) loginTwoFactor(ctx context.Context, l TwoFactorLoginInfo) (*UserInfo, error) {
+	if err := s.validate.NonEmptyString("challengeToken", l.ChallengeToken); err != nil {
+		return nil, err
+	}
+
+	if l.Code == "" && l.RecoveryCode == "" {
+		return nil, validation.Required("code")
+	}
+
+	var userClaims UserClaims
+	if err := verifyUserJWT(l.ChallengeToken, &userClaims); err != nil {
+		return nil, err
+	}
+
+	if userClaims.Purpose != challengePurpose {
+		return nil, ErrInvalidJWT
+	}
+
+	uInfo, err := s.repository.getUserByID(ctx, userClaims.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !uInfo.TwoFactorEnabled {
+		return nil, ErrTwoFactorNotEnabled
+	}
+
+	if uInfo.Deactivated {
+		return nil, ErrUserDeactivated
+	}
+
+	// attempts are tracked in the same way as they are for passwords
+	loginInfo := UserLoginInfo{Email: uInfo.Email, IPAddress: l.IPAddress}
+	if err := s.checkLoginAttempts(ctx, loginInfo); err != nil {
+		return nil, err
+	}
+
+	if err := s.verifySecondFactor(ctx, uInfo, l); err != nil {
+		if errors.Is(err, ErrInvalidTwoFactorCode) {
+			s.recordLoginFailure(ctx, loginInfo, uInfo)
+		}
+		return nil, err
+	}
+
+	s.resetLoginAttempts(ctx, loginInfo)
+
+	token, err := generateUserJWT(uInfo.ID, uInfo.Roles)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return &UserInfo{
+		ID:               uInfo.ID,
+		FirstName:        uInfo.FirstName,
+		LastName:         uInfo.LastName,
+		Email:            uInfo.Email,
+		Roles:            uInfo.Roles,
+		TwoFactorEnabled: true,
+		Token:            token,
+	}, nil
+}
+
+// verifySecondFactor checks a code from the user's authenticator app, or uses up one
+// of their recovery codes. Either way, the code cannot be used again to log in.
+func (s *service) verifySecondFactor(ctx context.Context, uInfo *UserInfo, l TwoFactorLoginInfo) error {
+	if l.RecoveryCode != "" {
+		return s.repository.useRecoveryCode(ctx, uInfo.ID, hashRecoveryCode(l.RecoveryCode))
+	}
+
+	step, ok := validateTOTP(uInfo.TwoFactorSecret, l.Code, time.Now(), uInfo.TwoFactorLastStep)
+	if !ok {
+		return ErrInvalidTwoFactorCode
+	}
+
+	// another login may have used the same code since the user was read
+	if err := s.repository.useTwoFactorStep(ctx, uInfo.ID, step); err != nil {
+		if !errors.Is(err, ErrInvalidTwoFactorCode) {
+			log.Println(err)
+		}
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package user
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Service_ConfirmTwoFactor(t *testing.T) {
+	code, err := totpCode(rfcTestSecret, totpStep(time.Now()))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		user   *UserInfo
+		code   string
+		expErr error
+	}{
+		{
+			name: "Success",
+			user: &UserInfo{ID: "5ef7fdd91c19e3222b41b839", TwoFactorPendingSecret: rfcTestSecret},
+			code: code,
+		},
+		{
+			name:   "FailInvalidCode",
+			user:   &UserInfo{ID: "5ef7fdd91c19e3222b41b839", TwoFactorPendingSecret: rfcTestSecret},
+			code:   "000000",
+			expErr: ErrInvalidTwoFactorCode,
+		},
+		{
+			name:   "FailNotEnrolled",
+			user:   &UserInfo{ID: "5ef7fdd91c19e3222b41b839"},
+			code:   code,
+			expErr: ErrNoPendingTwoFactor,
+		},
+		{
+			name:   "FailAlreadyEnabled",
+			user:   &UserInfo{ID: "5ef7fdd91c19e3222b41b839", TwoFactorEnabled: true},
+			code:   code,
+			expErr: ErrTwoFactorEnabled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &service{
+				repository: &mockRepository{user: tt.user},
+				validate:   &validator{},
+			}
+
+			recoveryCodes, err := s.confirmTwoFactor(context.Background(), tt.user.ID, TwoFactorCode{Code: tt.code})
+			if tt.expErr != nil {
+				assert.ErrorIs(t, err, tt.expErr)
+				assert.Nil(t, recoveryCodes)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Len(t, recoveryCodes, recoveryCodeCount)
+		})
+	}
+}
+
+func Test_Service_LoginTwoFactor(t *testing.T) {
+	t.Setenv("JWT_SECRET_KEY", jwtSecretTestKey)
+
+	uInfo := &UserInfo{
+		ID:               "5ef7fdd91c19e3222b41b839",
+		Email:            "michaelscott@dundermifflin.com",
+		HashedPassword:   "hashedpassword",
+		Activated:        true,
+		TwoFactorEnabled: true,
+		TwoFactorSecret:  rfcTestSecret,
+	}
+	s := &service{
+		repository: &mockRepository{user: uInfo},
+		password:   &mockPasswordManager{},
+		validate:   &validator{},
+		mailer:     &mockMailerClient{},
+		attempts:   NewMemoryAttemptStore(),
+	}
+
+	loggedIn, err := s.loginUser(context.Background(), UserLoginInfo{Email: uInfo.Email, Password: "1234"})
+	require.NoError(t, err)
+	require.NotEmpty(t, loggedIn.ChallengeToken)
+	assert.Empty(t, loggedIn.Token)
+
+	// the challenge token cannot be used to access the account
+	_, err = s.authenticate(context.Background(), loggedIn.ChallengeToken)
+	assert.ErrorIs(t, err, ErrInvalidJWT)
+
+	_, err = s.loginTwoFactor(context.Background(), TwoFactorLoginInfo{ChallengeToken: loggedIn.ChallengeToken, Code: "000000"})
+	assert.ErrorIs(t, err, ErrInvalidTwoFactorCode)
+
+	code, err := totpCode(rfcTestSecret, totpStep(time.Now()))
+	require.NoError(t, err)
+
+	verified, err := s.loginTwoFactor(context.Background(), TwoFactorLoginInfo{ChallengeToken: loggedIn.ChallengeToken, Code: code})
+	require.NoError(t, err)
+	assert.NotEmpty(t, verified.Token)
+
+	// a regular jwt cannot be used as a challenge token
+	_, err = s.loginTwoFactor(context.Background(), TwoFactorLoginInfo{ChallengeToken: verified.Token, Code: code})
+	assert.ErrorIs(t, err, ErrInvalidJWT)
+
+	// the code is rejected by the repository even if the user was read before it was used
+	loggedIn, err = s.loginUser(context.Background(), UserLoginInfo{Email: uInfo.Email, Password: "1234"})
+	require.NoError(t, err)
+	_, err = s.loginTwoFactor(context.Background(), TwoFactorLoginInfo{ChallengeToken: loggedIn.ChallengeToken, Code: code})
+	assert.ErrorIs(t, err, ErrInvalidTwoFactorCode)
+}
@@ -1,34 +1,72 @@
 package user
 
 import (
+	"io"
 	"time"
 )
 
 // UserInfo is sent out as a response
 type UserInfo struct {
-	ID             string     `json:"id"`
-	FirstName      string     `json:"firstName"`
-	LastName       string     `json:"lastName"`
-	Email          string     `json:"email"`
+	ID           string `json:"id"`
+	FirstName    string `json:"firstName"`
+	LastName     string `json:"lastName"`
+	Email        string `json:"email"`
+	PendingEmail string `json:"pendingEmail,omitempty"`
+	// Locale is the language tag (eg. fr-CA) that emails are sent to the user in
+	Locale         string     `json:"locale,omitempty"`
 	Password       string     `json:"-"`
 	HashedPassword string     `json:"-"`
 	Activated      bool       `json:"-"`
+	Deactivated    bool       `json:"-"`
+	Roles          []string   `json:"roles,omitempty"`
 	CreatedAt      *time.Time `json:"-"`
 	UpdatedAt      *time.Time `json:"-"`
-	Token          string     `json:"token"`
+	Token          string     `json:"token,omitempty"`
+	// ChallengeToken is returned by login instead of Token when the
+	// user has two-factor authentication enabled
+	ChallengeToken string `json:"-"`
+	// ReauthToken is returned instead of Token when the user logs in with
+	// their identity provider again to confirm an action (see generateReauthJWT)
+	ReauthToken            string   `json:"-"`
+	TwoFactorEnabled       bool     `json:"twoFactorEnabled,omitempty"`
+	TwoFactorSecret        string   `json:"-"`
+	TwoFactorPendingSecret string   `json:"-"`
+	TwoFactorLastStep      int64    `json:"-"`
+	RecoveryCodeHashes     []string `json:"-"`
+	// Identities are the identity providers the user can log in with
+	Identities []OIDCIdentity `json:"identities,omitempty"`
+
+	DigestSettings
+	// DigestLastSentOn is the date, where the user is, that their last daily digest was sent on
+	DigestLastSentOn string `json:"-"`
+}
+
+// DigestSettings are how a user gets their daily digest, which is an email of
+// their tasks that are due that day or overdue (see SendDigests)
+type DigestSettings struct {
+	DigestEnabled bool `json:"digestEnabled,omitempty"`
+	// Timezone is an IANA time zone (eg. America/Toronto), which is UTC if it is empty
+	Timezone string `json:"timezone,omitempty"`
+	// DigestHour is the hour (0-23) in the user's timezone that their digest is sent at,
+	// which is defaultDigestHour if it is nil
+	DigestHour *int `json:"digestHour,omitempty"`
 }
 
 // UserRegistrationInfo stores request
 // data for registering a new user
 type UserRegistrationInfo struct {
-	FirstName      string     `json:"firstName"`
-	LastName       string     `json:"lastName"`
-	Email          string     `json:"email"`
-	Password       string     `json:"password"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Email     string `json:"email"`
+	Password  string `json:"password"`
+	// Locale is set from the Accept-Language header if the request doesn't have one
+	Locale         string     `json:"locale"`
 	HashedPassword string     `json:"-"`
 	Activated      bool       `json:"activated"`
 	CreatedAt      *time.Time `json:"createdAt"`
 	UpdatedAt      *time.Time `json:"updatedAt"`
+	// Identities is set for users who register by logging in with an identity provider
+	Identities []OIDCIdentity `json:"-"`
 }
 
 // UserLoginInfo stores request data
@@ -36,4 +74,195 @@ type UserRegistrationInfo struct {
 type UserLoginInfo struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	// IPAddress is set by the handler, and is used to limit failed attempts
+	IPAddress string `json:"-"`
+}
+
+// UserUpdate stores the fields of a user that should be
+// changed. A nil field is left untouched by an update.
+type UserUpdate struct {
+	FirstName      *string
+	LastName       *string
+	Email          *string
+	PendingEmail   *string
+	Locale         *string
+	HashedPassword *string
+	Activated      *bool
+	Deactivated    *bool
+	UpdatedAt      *time.Time
+
+	TwoFactorEnabled       *bool
+	TwoFactorSecret        *string
+	TwoFactorPendingSecret *string
+	TwoFactorLastStep      *int64
+	RecoveryCodeHashes     *[]string
+
+	DigestEnabled *bool
+	Timezone      *string
+	DigestHour    *int
+}
+
+// ProfileUpdate stores request data for changing a user's name, preferred
+// locale or daily digest settings; a missing field is not changed
+type ProfileUpdate struct {
+	FirstName *string `json:"firstName"`
+	LastName  *string `json:"lastName"`
+	// Locale can be set to an empty string to send emails in the default locale again
+	Locale *string `json:"locale"`
+	// the daily digest settings (see DigestSettings)
+	DigestEnabled *bool   `json:"digestEnabled"`
+	Timezone      *string `json:"timezone"`
+	DigestHour    *int    `json:"digestHour"`
+}
+
+// PasswordChangeInfo stores request data
+// for changing a user's password
+type PasswordChangeInfo struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+// EmailChangeInfo stores request data for changing a user's
+// email, which is only used after the user confirms it
+type EmailChangeInfo struct {
+	Email       string `json:"email"`
+	Password    string `json:"password"`
+	ReauthToken string `json:"reauthToken"`
+}
+
+// PasswordConfirmation stores request data for actions that a user must confirm with
+// their password, or a reauthentication token if they don't have a password
+type PasswordConfirmation struct {
+	Password    string `json:"password"`
+	ReauthToken string `json:"reauthToken"`
+}
+
+// TwoFactorEnrollment is sent to a user when they start enrolling in two-factor
+// authentication. The URI is shown as a QR code so it can be scanned by an
+// authenticator app, or the secret can be entered into the app by hand.
+type TwoFactorEnrollment struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauthUri"`
+}
+
+// TwoFactorCode stores request data for
+// confirming two-factor authentication
+type TwoFactorCode struct {
+	Code string `json:"code"`
+}
+
+// TwoFactorLoginInfo stores request data for the second step of logging in.
+// The user provides the code from their authenticator app, or one of their
+// recovery codes if they cannot access it.
+type TwoFactorLoginInfo struct {
+	ChallengeToken string `json:"challengeToken"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recoveryCode"`
+	// IPAddress is set by the handler, and is used to limit failed attempts
+	IPAddress string `json:"-"`
+}
+
+// OIDCIdentity is a user's account with an identity provider
+type OIDCIdentity struct {
+	Provider string `json:"provider"`
+	Subject  string `json:"-"`
+}
+
+// OIDCLogin stores a login with an identity provider that has been started,
+// so that it can be checked when the provider redirects the user back
+type OIDCLogin struct {
+	State        string
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    *time.Time
+	// Reauth is set when a user logs in again to confirm an action, rather than to log in
+	Reauth bool
+}
+
+// OIDCCallbackInfo stores request data from an identity
+// provider's redirect back to the user service
+type OIDCCallbackInfo struct {
+	Provider string
+	Code     string
+	State    string
+	// StateCookie is the state that was stored in the user's browser when they
+	// started the login, which stops an attacker from logging a user in as them
+	StateCookie string
+}
+
+// AccountDeletionInfo stores request data for
+// deleting a user's account, which must be confirmed
+// with their password (or a reauthentication token)
+type AccountDeletionInfo struct {
+	Password    string `json:"password"`
+	ReauthToken string `json:"reauthToken"`
+}
+
+// DeletionJob records the progress of deleting a user's
+// data from every service that stores it, so that it can
+// be retried until each of them confirms the deletion.
+type DeletionJob struct {
+	ID            string
+	UserID        string
+	UserDeleted   bool
+	TasksDeleted  bool
+	TokensDeleted bool
+	Attempts      int
+	LastError     string
+	NextAttemptAt *time.Time
+	CreatedAt     *time.Time
+	UpdatedAt     *time.Time
+}
+
+// completed reports whether every service has deleted the user's data
+func (j *DeletionJob) completed() bool {
+	return j.UserDeleted && j.TasksDeleted && j.TokensDeleted
+}
+
+// ExportStatus is the state of a data export
+type ExportStatus string
+
+const (
+	ExportPending ExportStatus = "pending"
+	ExportReady   ExportStatus = "ready"
+	ExportFailed  ExportStatus = "failed"
+)
+
+// DataExport is a copy of a user's data that they can download.
+// Large exports are generated by a background job (see RunDataExports),
+// so the user can check its status until it is ready.
+type DataExport struct {
+	ID     string       `json:"id"`
+	UserID string       `json:"-"`
+	Status ExportStatus `json:"status"`
+	Error  string       `json:"-"`
+	// Archive is only set for exports that are generated right away,
+	// since the archives of large exports are stored separately
+	Archive           []byte     `json:"-"`
+	DownloadTokenHash string     `json:"-"`
+	Attempts          int        `json:"-"`
+	NextAttemptAt     *time.Time `json:"-"`
+	CreatedAt         *time.Time `json:"createdAt"`
+	CompletedAt       *time.Time `json:"completedAt,omitempty"`
+	ExpiresAt         *time.Time `json:"expiresAt,omitempty"`
+}
+
+// ExportArchive is the zip archive of a data export that is being downloaded
+type ExportArchive struct {
+	io.ReadCloser
+	Size int64
+}
+
+// TaskData stores a task that is
+// received from the task service
+type TaskData struct {
+	ID        string     `json:"taskId"`
+	Name      string     `json:"name"`
+	Details   string     `json:"details,omitempty"`
+	Priority  string     `json:"priority,omitempty"`
+	Category  string     `json:"category,omitempty"`
+	DueDate   string     `json:"dueDate,omitempty"`
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
 }
@@ -1,51 +1,170 @@
 package user
 
 import (
-	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/ricxi/flat-list/shared/validation"
 )
 
+// localePattern matches language tags such as en, fr-CA or zh-Hant-TW (RFC 5646),
+// which is what the mailer accepts as the locale of an email
+var localePattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
 type Validator interface {
 	Registration(u UserRegistrationInfo) error
 	Login(u UserLoginInfo) error
 	NonEmptyString(name, field string) error
+	ProfileUpdate(p ProfileUpdate) error
+	// NewPassword checks a password that a user is setting against the password
+	// policy. The personal values are the user's email and name.
+	NewPassword(field, password string, personal ...string) error
+	// Email returns the normalised form of an email, which is
+	// the form that emails are stored and looked up in
+	Email(field, email string) (string, error)
+	PersonalAccessToken(info PersonalAccessTokenInfo) error
+	OAuthClient(info OAuthClientInfo) error
+}
+
+type ValidatorOption func(v *validator)
+
+// WithEmailPolicy replaces the default email policy
+func WithEmailPolicy(p EmailPolicy) ValidatorOption {
+	return func(v *validator) {
+		v.email = p
+	}
 }
 
-func NewValidator() *validator {
-	return &validator{}
+// WithPasswordPolicy replaces the default password policy
+func WithPasswordPolicy(p PasswordPolicy) ValidatorOption {
+	return func(v *validator) {
+		v.policy = &p
+	}
 }
 
-type validator struct{}
+// NewValidator creates a validator that uses the default
+// password and email policies, unless they are replaced
+func NewValidator(opts ...ValidatorOption) *validator {
+	policy := DefaultPasswordPolicy()
+	v := &validator{policy: &policy, email: DefaultEmailPolicy()}
 
-func (v *validator) Registration(u UserRegistrationInfo) error {
-	if u.Email == "" {
-		return fmt.Errorf("%w: email", ErrMissingField)
+	for _, opt := range opts {
+		opt(v)
 	}
 
+	return v
+}
+
+// validator only checks that passwords are not empty if it has no password
+// policy, and only lowercases the domain of emails if it has no email policy
+type validator struct {
+	policy *PasswordPolicy
+	email  EmailPolicy
+}
+
+// Registration returns a validation.Error with every missing field, an
+// email that is not valid and every rule of the password policy that the password breaks
+func (v *validator) Registration(u UserRegistrationInfo) error {
+	var vErr validation.Error
+
+	v.email.check(&vErr, "email", u.Email)
+
 	if u.Password == "" {
-		return fmt.Errorf("%w: password", ErrMissingField)
+		vErr.Required("password")
+	} else {
+		v.checkPassword(&vErr, "password", u.Password, u.Email, u.FirstName, u.LastName)
 	}
 
-	return nil
+	checkLocale(&vErr, u.Locale)
+
+	return vErr.Err()
 }
 
 func (v *validator) Login(u UserLoginInfo) error {
+	var vErr validation.Error
+
 	if u.Email == "" {
-		return fmt.Errorf("%w: email", ErrMissingField)
+		vErr.Required("email")
 	}
 
 	if u.Password == "" {
-		return fmt.Errorf("%w: password", ErrMissingField)
+		vErr.Required("password")
 	}
 
-	return nil
+	return vErr.Err()
 }
 
 // NonEmptyString checks if the given string field is not empty.
 // If it is, then it returns an error message with the field's name.
 func (v *validator) NonEmptyString(name, field string) error {
 	if field == "" {
-		return fmt.Errorf("%w: %s", ErrMissingField, name)
+		return validation.Required(name)
 	}
 
 	return nil
 }
+
+// ProfileUpdate checks that at least one field is being updated, that the locale
+// is a language tag, the timezone is an IANA timezone (such as Europe/Paris)
+// and the digest hour is an hour of the day, if they are being set.
+func (v *validator) ProfileUpdate(p ProfileUpdate) error {
+	if p.FirstName == nil && p.LastName == nil && p.Locale == nil &&
+		p.DigestEnabled == nil && p.Timezone == nil && p.DigestHour == nil {
+		return ErrNoFieldsToUpdate
+	}
+
+	var vErr validation.Error
+	if p.Locale != nil {
+		checkLocale(&vErr, *p.Locale)
+	}
+
+	// an empty timezone is UTC. "Local" is the server's timezone to Go, which isn't one that mongo knows.
+	if p.Timezone != nil && *p.Timezone != "" {
+		if _, err := time.LoadLocation(*p.Timezone); err != nil || *p.Timezone == "Local" {
+			vErr.Add("timezone", "invalid_timezone", "timezone must be an IANA timezone, such as Europe/Paris")
+		}
+	}
+
+	if p.DigestHour != nil && (*p.DigestHour < 0 || *p.DigestHour > 23) {
+		vErr.Add("digestHour", "invalid_digest_hour", "digestHour must be an hour of the day, from 0 to 23")
+	}
+
+	return vErr.Err()
+}
+
+// checkLocale adds an error if a locale is not empty and not a language tag
+func checkLocale(vErr *validation.Error, locale string) {
+	if locale != "" && !localePattern.MatchString(locale) {
+		vErr.Add("locale", "invalid_locale", "locale must be a language tag, such as en or fr-CA")
+	}
+}
+
+// NewPassword returns a validation.Error with every rule
+// of the password policy that the password breaks
+func (v *validator) NewPassword(field, password string, personal ...string) error {
+	if password == "" {
+		return validation.Required(field)
+	}
+
+	var vErr validation.Error
+	v.checkPassword(&vErr, field, password, personal...)
+
+	return vErr.Err()
+}
+
+func (v *validator) checkPassword(vErr *validation.Error, field, password string, personal ...string) {
+	if v.policy != nil {
+		v.policy.check(vErr, field, password, personal...)
+	}
+}
+
+// Email returns a validation.Error if an email is missing or not valid
+func (v *validator) Email(field, email string) (string, error) {
+	var vErr validation.Error
+	v.email.check(&vErr, field, email)
+	if err := vErr.Err(); err != nil {
+		return "", err
+	}
+
+	return v.email.Normalize(email)
+}